@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// catalogEntry describes one file of an adopted library, or one recorded
+// by -manifest or -classifier; Verified is when its hash was last checked
+// by scrub, and Labels are the classifier's labels if Classified is set
+type catalogEntry struct {
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mod_time"`
+	SHA256     string    `json:"sha256"`
+	Verified   time.Time `json:"verified"`
+	Labels     []string  `json:"labels,omitempty"`
+	Classified bool      `json:"classified,omitempty"`
+}
+
+// catalogPath returns where the file catalog of a library is kept
+func catalogPath(root string) string {
+	return filepath.Join(root, stateDirName, "catalog.json")
+}
+
+// loadCatalog reads the file catalog of a library, keyed by slash-separated
+// paths relative to the library; a missing catalog is empty
+func loadCatalog(root string) (map[string]catalogEntry, error) {
+	catalog := make(map[string]catalogEntry)
+	data, err := os.ReadFile(catalogPath(root))
+	if os.IsNotExist(err) {
+		return catalog, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil, fmt.Errorf("invalid catalog: %v", err)
+	}
+	return catalog, nil
+}
+
+// runAdopt implements the "adopt" subcommand, which takes over an existing,
+// already organized library: it infers the folder layout, catalogs the
+// files, and records the layout so later imports follow it
+func runAdopt(args []string) {
+	fs := flag.NewFlagSet("adopt", flag.ExitOnError)
+	destDir := fs.String("dest", "", "Existing photo library")
+	layout := fs.String("layout", "", "Use this folder layout instead of inferring it (Go time layout, e.g. '2006/2006-01-02', or a preset such as preset:week)")
+	monthNameList := fs.String("month-names", "", "Month names used in the library's folders: a language code such as 'de' or twelve comma-separated names (default English)")
+	dryRun := fs.Bool("dry-run", false, "Only report the inferred layout")
+	logOpts := addLogFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s adopt -dest DIR [-layout LAYOUT] [-month-names NAMES] [-dry-run]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	parseInterspersed(fs, args)
+	if *destDir == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := logOpts.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if stat, err := os.Stat(*destDir); err != nil || !stat.IsDir() {
+		fatal("Library does not exist or is not a directory", "path", *destDir)
+	}
+	if *monthNameList != "" {
+		var err error
+		if monthNames, err = parseMonthNames(*monthNameList); err != nil {
+			fatal(err.Error())
+		}
+	}
+
+	inferred, matched, dated, err := inferLayout(*destDir)
+	if err != nil {
+		fatal("Failed to scan library", "error", err)
+	}
+	config := libraryConfig{Layout: inferred, MonthNames: monthNames, Adopted: time.Now(), Files: dated, Matched: matched}
+	if dated > 0 {
+		slog.Info("Inferred folder layout", "layout", inferred, "example", folderFor(inferred, time.Date(2019, 7, 14, 0, 0, 0, 0, time.Local)), "matched", matched, "dated_files", dated, "percent", matched*100/dated)
+	} else {
+		slog.Warn("No dated photos found, using the default layout", "layout", inferred)
+	}
+	if *layout != "" {
+		if config.Layout, err = resolveLayout(*layout); err != nil {
+			fatal(err.Error())
+		}
+		slog.Info("Using the given layout", "layout", config.Layout)
+	}
+	if dated > 0 && config.Matched*2 < dated && *layout == "" {
+		slog.Warn("Less than half of the photos follow the inferred layout; check it, pass -layout, or run 'verify' after adopting")
+	}
+	if *dryRun {
+		return
+	}
+
+	// Catalog the files so later -dedupe runs need not hash the library again
+	catalog := make(map[string]catalogEntry)
+	err = filepath.Walk(*destDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == stateDirName || info.Name() == previewsDirName {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		sum, err := hashFile(localFS, path)
+		if err != nil {
+			slog.Warn("Could not read file", "path", path, "error", err)
+			return nil
+		}
+		rel, _ := filepath.Rel(*destDir, path)
+		catalog[filepath.ToSlash(rel)] = catalogEntry{Size: info.Size(), ModTime: info.ModTime(), SHA256: sum, Verified: time.Now()}
+		return nil
+	})
+	if err != nil {
+		fatal("Failed to catalog library", "error", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(*destDir, stateDirName), 0755); err != nil {
+		fatal("Failed to create state folder", "error", err)
+	}
+	if err := writeJSONFile(catalogPath(*destDir), catalog); err != nil {
+		fatal("Failed to write catalog", "error", err)
+	}
+	if err := writeJSONFile(libraryConfigPath(*destDir), config); err != nil {
+		fatal("Failed to write library configuration", "error", err)
+	}
+	slog.Info("Adopted library", "path", *destDir, "layout", config.Layout, "cataloged", len(catalog))
+}
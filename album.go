@@ -0,0 +1,28 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// cameraFolderPattern matches the folders cameras and phones create, such
+// as "100CANON", "101_PANA", "DCIM", or "Camera", which say nothing about
+// the photos in them
+var cameraFolderPattern = regexp.MustCompile(`(?i)^(\d{3}[a-z0-9_]{5}|dcim|camera|camera roll|pictures|photos|images)$`)
+
+// albumName returns the name of a file's folder below its source, to keep
+// as an album folder below the date folder: "Italy Trip/IMG_1234.jpg" goes
+// to "2023/07/Italy Trip/". Files at the top of a source and files in
+// camera-generated folders have no album.
+func (s *sorter) albumName(path string) string {
+	rel := s.sourceRelDir(path)
+	if rel == "" {
+		return ""
+	}
+	name := filepath.Base(rel)
+	if cameraFolderPattern.MatchString(name) {
+		return ""
+	}
+	return strings.TrimSpace(sanitizeFolderName(name))
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// readProfile reads an import profile: the import-card options to use for
+// every card, one per line as "-flag" or "-flag value", where the value is
+// the rest of the line and may contain spaces. Empty lines and lines
+// starting with # are ignored.
+func readProfile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var args []string
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, _ := strings.Cut(text, " ")
+		if !strings.HasPrefix(name, "-") {
+			return nil, fmt.Errorf("%s:%d: expected an option such as '-dest /photos', got %q", path, line, text)
+		}
+		args = append(args, name)
+		if value = strings.TrimSpace(value); value != "" {
+			args = append(args, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for _, arg := range args {
+		if arg == "-dest" || arg == "--dest" || strings.HasPrefix(arg, "-dest=") || strings.HasPrefix(arg, "--dest=") {
+			return args, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: the profile must set -dest", path)
+}
+
+// hasDCIM reports whether a volume holds camera media in a DCIM folder
+func hasDCIM(volume string) bool {
+	info, err := os.Stat(filepath.Join(volume, "DCIM"))
+	return err == nil && info.IsDir()
+}
+
+// runAutoImport implements the "auto-import" subcommand, which waits for
+// memory cards to be mounted and imports each one with "import-card" and
+// the options of a profile, so a headless machine can work as an ingest
+// station: insert a card, wait for it to be ejected, take it out.
+func runAutoImport(args []string) {
+	fs := flag.NewFlagSet("auto-import", flag.ExitOnError)
+	profile := fs.String("profile", "", "File with the import-card options to import every card with, one per line (e.g., '-dest /srv/photos')")
+	pollInterval := fs.Duration("poll-interval", 2*time.Second, "How often to check for newly mounted volumes")
+	importMounted := fs.Bool("import-mounted", false, "Also import cards that are already mounted at start")
+	logOpts := addLogFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s auto-import -profile FILE [options]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	positional := parseInterspersed(fs, args)
+	if *profile == "" || len(positional) != 0 {
+		fs.Usage()
+		os.Exit(exitFatal)
+	}
+	if err := logOpts.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitFatal)
+	}
+	if *pollInterval <= 0 {
+		fatal("-poll-interval must be positive")
+	}
+	importArgs, err := readProfile(*profile)
+	if err != nil {
+		fatal("Invalid profile", "error", err)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		fatal("Could not find the gopicsort executable", "error", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Volumes mounted at start count as seen unless asked otherwise
+	known := make(map[string]bool)
+	if !*importMounted {
+		mounts, err := listMounts()
+		if err != nil {
+			fatal("Could not list mounted volumes", "error", err)
+		}
+		for _, mount := range mounts {
+			known[mount] = true
+		}
+	}
+	slog.Info("Waiting for memory cards", "profile", *profile, "interval", *pollInterval)
+
+	ticker := time.NewTicker(*pollInterval)
+	defer ticker.Stop()
+	for {
+		mounts, err := listMounts()
+		if err != nil {
+			fatal("Could not list mounted volumes", "error", err)
+		}
+		mounted := make(map[string]bool, len(mounts))
+		for _, mount := range mounts {
+			mounted[mount] = true
+			if known[mount] {
+				continue
+			}
+			known[mount] = true
+			if !hasDCIM(mount) {
+				continue
+			}
+			importCard(ctx, executable, importArgs, mount)
+		}
+		// A card taken out and inserted again is imported again
+		for mount := range known {
+			if !mounted[mount] {
+				delete(known, mount)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Info("Stopped waiting for memory cards")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// importCard runs "import-card" for a newly mounted card in a process of its
+// own, so a failed import is logged and the next card is still imported
+func importCard(ctx context.Context, executable string, importArgs []string, card string) {
+	slog.Info("Memory card mounted, importing", "card", card)
+	cmd := exec.CommandContext(ctx, executable, append(append([]string{"import-card"}, importArgs...), card)...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	// A pipe rather than a terminal, so clearing the card is never asked
+	// about and only happens with -clear in the profile
+	cmd.Stdin = strings.NewReader("")
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		slog.Info("Imported card", "card", card)
+	case errors.As(err, &exitErr):
+		slog.Error("Card import failed", "card", card, "exit_code", exitErr.ExitCode())
+	default:
+		slog.Error("Could not run card import", "card", card, "error", err)
+	}
+}
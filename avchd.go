@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"time"
+)
+
+// avchdScan bounds how much of an AVCHD clip is searched for its recording
+// time, which camcorders write with the first frames
+const avchdScan = 4 << 20
+
+// tsPacketSize is the size of an MPEG transport stream packet; AVCHD's .mts
+// and .m2ts files prefix each with a 4-byte time code
+const tsPacketSize = 188
+
+// mdpmUUID starts the H.264 user data in which AVCHD camcorders record the
+// recording time and camera settings, the Modified DV Pack Metadata (MDPM)
+var mdpmUUID = []byte{0x17, 0xee, 0x8c, 0x60, 0xf8, 0x4d, 0x11, 0xd9, 0x8c, 0xd6, 0x08, 0x00, 0x20, 0x0c, 0x9a, 0x66}
+
+// Tags of the MDPM entries that hold the recording time, in BCD
+const (
+	// mdpmDateTag holds a time zone byte, the year, and the month
+	mdpmDateTag = 0x18
+	// mdpmTimeTag holds the day, hour, minute, and second
+	mdpmTimeTag = 0x19
+)
+
+// avchdRecordingTime reads the recording time of an AVCHD clip, as found in
+// the PRIVATE/AVCHD/BDMV/STREAM folder of Panasonic, Sony, and Canon
+// camcorders, from the MDPM of its video stream. The clip information and
+// playlist files next to the stream only hold playback data. Like EXIF dates,
+// the camcorder's clock time is taken as local time.
+func avchdRecordingTime(path string) (time.Time, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, avchdScan))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// The user data may be split over packets, so each stream's payload is
+	// put back together before searching it
+	for _, payload := range transportPayloads(data) {
+		for rest := payload; ; {
+			i := bytes.Index(rest, mdpmUUID)
+			if i < 0 {
+				break
+			}
+			rest = rest[i+len(mdpmUUID):]
+			if date, ok := parseMDPM(unescapeNAL(rest[:min(len(rest), 512)])); ok {
+				return date, nil
+			}
+		}
+	}
+	return time.Time{}, errNoVideoDate
+}
+
+// transportPayloads joins the payloads of the transport stream packets in
+// data by packet ID, with or without the 4-byte AVCHD time code prefix
+func transportPayloads(data []byte) map[uint16][]byte {
+	start, size := 0, tsPacketSize
+	switch {
+	case len(data) > tsPacketSize+8 && data[4] == 0x47 && data[tsPacketSize+8] == 0x47:
+		start, size = 4, tsPacketSize+4
+	case len(data) > tsPacketSize && data[0] == 0x47 && data[tsPacketSize] == 0x47:
+	default:
+		return nil
+	}
+	payloads := make(map[uint16][]byte)
+	for pos := start; pos+tsPacketSize <= len(data); pos += size {
+		packet := data[pos : pos+tsPacketSize]
+		if packet[0] != 0x47 {
+			break
+		}
+		pid := uint16(packet[1]&0x1f)<<8 | uint16(packet[2])
+		payload := 4
+		switch packet[3] >> 4 & 0x3 {
+		case 0x1:
+		case 0x3:
+			payload += 1 + int(packet[4])
+		default:
+			continue
+		}
+		if payload < tsPacketSize {
+			payloads[pid] = append(payloads[pid], packet[payload:]...)
+		}
+	}
+	return payloads
+}
+
+// unescapeNAL removes the emulation prevention bytes H.264 inserts after two
+// zero bytes
+func unescapeNAL(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// parseMDPM reads the recording time from MDPM data: "MDPM", the number of
+// entries, and entries of a tag byte and four data bytes
+func parseMDPM(data []byte) (time.Time, bool) {
+	if !bytes.HasPrefix(data, []byte("MDPM")) || len(data) < 5 {
+		return time.Time{}, false
+	}
+	count := int(data[4])
+	entries := data[5:]
+	var date, clock []byte
+	for i := 0; i < count && len(entries) >= 5; i++ {
+		switch entries[0] {
+		case mdpmDateTag:
+			date = entries[1:5]
+		case mdpmTimeTag:
+			clock = entries[1:5]
+		}
+		entries = entries[5:]
+	}
+	if date == nil || clock == nil {
+		return time.Time{}, false
+	}
+	var n [7]int
+	for i, b := range append(append([]byte{}, date[1:]...), clock...) {
+		hi, lo := int(b>>4), int(b&0x0f)
+		if hi > 9 || lo > 9 {
+			return time.Time{}, false
+		}
+		n[i] = hi*10 + lo
+	}
+	year := n[0]*100 + n[1]
+	t := time.Date(year, time.Month(n[2]), n[3], n[4], n[5], n[6], 0, time.Local)
+	if t.Year() != year || int(t.Month()) != n[2] || t.Day() != n[3] || t.Hour() != n[4] || year < 1990 {
+		return time.Time{}, false
+	}
+	return t, true
+}
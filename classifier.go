@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// classifier returns descriptive labels (e.g., "beach", "dog") for an image.
+// Classification models are kept out of the binary: they run behind an HTTP
+// endpoint or an external command, such as a small ONNX runtime wrapper.
+type classifier interface {
+	Classify(path string) ([]string, error)
+}
+
+// newClassifier creates a classifier from the -classifier flag: an http(s)
+// URL receives the image bytes as a POST body, anything else is run as a
+// command with the image path appended as its last argument
+func newClassifier(spec string, minScore float64) classifier {
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		return &httpClassifier{url: spec, minScore: minScore, client: &http.Client{Timeout: 60 * time.Second}}
+	}
+	return &commandClassifier{args: strings.Fields(spec), minScore: minScore}
+}
+
+// labelIndex holds the labels recorded in a library's catalog, so files
+// classified by an earlier run are not sent to the classifier again
+type labelIndex struct {
+	root    string
+	catalog map[string]catalogEntry
+	// byHash holds the labels of every classified file by SHA-256, so copies
+	// of a cataloged file and files seen earlier in the run are found too
+	byHash map[string][]string
+}
+
+// loadLabelIndex reads the labels from the catalog of the library at root
+func loadLabelIndex(root string) (*labelIndex, error) {
+	catalog, err := loadCatalog(root)
+	if err != nil {
+		return nil, err
+	}
+	ix := &labelIndex{root: root, catalog: catalog, byHash: make(map[string][]string)}
+	for _, entry := range catalog {
+		if entry.Classified && entry.SHA256 != "" {
+			ix.byHash[entry.SHA256] = entry.Labels
+		}
+	}
+	return ix, nil
+}
+
+// classify returns the labels of an image, from the catalog if it was
+// classified before and from the classifier otherwise. Files without labels
+// get an empty, non-nil list.
+func (s *sorter) classify(path string, info os.FileInfo) ([]string, error) {
+	if s.labels != nil && isWithin(s.labels.root, path) {
+		if rel, err := filepath.Rel(s.labels.root, path); err == nil {
+			if entry, ok := s.labels.catalog[filepath.ToSlash(rel)]; ok && entry.Classified && entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime()) {
+				return nonNilLabels(entry.Labels), nil
+			}
+		}
+	}
+	var sum string
+	if s.labels != nil {
+		if sum, _ = hashFile(s.fsys, longPath(path)); sum != "" {
+			if labels, ok := s.labels.byHash[sum]; ok {
+				return nonNilLabels(labels), nil
+			}
+		}
+	}
+	labels, err := s.labeler.Classify(path)
+	if err != nil {
+		return nil, err
+	}
+	labels = nonNilLabels(labels)
+	if s.labels != nil && sum != "" {
+		s.labels.byHash[sum] = labels
+	}
+	return labels, nil
+}
+
+// nonNilLabels returns labels, or an empty list for none, so classified
+// files can be told from those never classified
+func nonNilLabels(labels []string) []string {
+	if labels == nil {
+		return []string{}
+	}
+	return labels
+}
+
+// httpClassifier posts images to a classification endpoint
+type httpClassifier struct {
+	url      string
+	minScore float64
+	client   *http.Client
+}
+
+func (c *httpClassifier) Classify(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	req, err := http.NewRequest(http.MethodPost, c.url, file)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Filename", strings.ReplaceAll(path, "\n", " "))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("classifier returned %s", resp.Status)
+	}
+	return parseLabels(body, c.minScore)
+}
+
+// commandClassifier runs an external program per image
+type commandClassifier struct {
+	args     []string
+	minScore float64
+}
+
+func (c *commandClassifier) Classify(path string) ([]string, error) {
+	if len(c.args) == 0 {
+		return nil, fmt.Errorf("empty classifier command")
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(c.args[0], append(c.args[1:], path)...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseLabels(out, c.minScore)
+}
+
+// scoredLabel is a label with the classifier's confidence
+type scoredLabel struct {
+	Label string  `json:"label"`
+	Score float64 `json:"score"`
+}
+
+// parseLabels accepts a JSON array of labels, a JSON array of
+// {"label", "score"} objects, either wrapped as {"labels": [...]}, or plain
+// text with one label per line. Scored labels below minScore are dropped.
+func parseLabels(data []byte, minScore float64) ([]string, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, nil
+	}
+
+	if trimmed[0] != '[' && trimmed[0] != '{' {
+		var labels []string
+		for _, line := range strings.Split(string(trimmed), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				labels = append(labels, line)
+			}
+		}
+		return labels, nil
+	}
+
+	if trimmed[0] == '{' {
+		var wrapper struct {
+			Labels json.RawMessage `json:"labels"`
+		}
+		if err := json.Unmarshal(trimmed, &wrapper); err != nil {
+			return nil, fmt.Errorf("invalid classifier response: %v", err)
+		}
+		trimmed = wrapper.Labels
+		if len(trimmed) == 0 {
+			return nil, nil
+		}
+	}
+
+	var plain []string
+	if err := json.Unmarshal(trimmed, &plain); err == nil {
+		return plain, nil
+	}
+	var scored []scoredLabel
+	if err := json.Unmarshal(trimmed, &scored); err != nil {
+		return nil, fmt.Errorf("invalid classifier response: %v", err)
+	}
+	var labels []string
+	for _, l := range scored {
+		if l.Label != "" && l.Score >= minScore {
+			labels = append(labels, l.Label)
+		}
+	}
+	return labels, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeClassifier returns fixed labels, or err, and counts its calls
+type fakeClassifier struct {
+	labels []string
+	err    error
+	calls  int
+}
+
+func (c *fakeClassifier) Classify(path string) ([]string, error) {
+	c.calls++
+	return c.labels, c.err
+}
+
+// newLabelSorter returns a sorter dating files by name that classifies them
+// with c and keeps the labels in dest's catalog
+func newLabelSorter(t *testing.T, c classifier, dest, src string) *sorter {
+	t.Helper()
+	s := newTestSorter(t, localFS, dest, src)
+	s.dateSources = []string{dateSourceFilename}
+	s.labeler = c
+	labels, err := loadLabelIndex(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.labels = labels
+	return s
+}
+
+func TestClassifierErrorFailsFile(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+	writeTestFile(t, filepath.Join(src, "IMG_20210506_070809.jpg"), []byte("photo"))
+	s := newLabelSorter(t, &fakeClassifier{err: errors.New("model not loaded")}, dest, src)
+	s.labelFilter = []string{"dog"}
+	if err := s.run(); err != nil {
+		t.Fatal(err)
+	}
+	if s.counts[outcomeFailed] != 1 || s.counts[outcomeFiltered] != 0 {
+		t.Errorf("counts are %v, want one failed file", s.counts)
+	}
+	if s.failures() != 1 {
+		t.Errorf("run has %d failures, want 1", s.failures())
+	}
+}
+
+func TestClassifierLabelsKeptInCatalog(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+	writeTestFile(t, filepath.Join(src, "IMG_20210506_070809.jpg"), []byte("dog photo"))
+	writeTestFile(t, filepath.Join(src, "IMG_20210507_070809.jpg"), []byte("other photo"))
+	first := &fakeClassifier{labels: []string{"dog"}}
+	if err := newLabelSorter(t, first, dest, src).run(); err != nil {
+		t.Fatal(err)
+	}
+	if first.calls != 2 {
+		t.Fatalf("classifier called %d times, want 2", first.calls)
+	}
+	catalog, err := loadCatalog(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := catalog["2021/05/IMG_20210506_070809.jpg"]
+	if !entry.Classified || len(entry.Labels) != 1 || entry.Labels[0] != "dog" {
+		t.Fatalf("catalog entry is %+v, want the label dog", entry)
+	}
+
+	// Re-sorting the library and importing a copy both reuse the labels
+	writeTestFile(t, filepath.Join(src, "copy", "IMG_20210506_070809.jpg"), []byte("dog photo"))
+	os.Remove(filepath.Join(src, "IMG_20210506_070809.jpg"))
+	os.Remove(filepath.Join(src, "IMG_20210507_070809.jpg"))
+	for _, source := range []string{dest, src} {
+		again := &fakeClassifier{err: errors.New("classifier called again")}
+		s := newLabelSorter(t, again, dest, source)
+		s.labelFilter = []string{"dog"}
+		if source == dest {
+			s.moveFiles = true
+		}
+		if err := s.run(); err != nil {
+			t.Fatal(err)
+		}
+		if again.calls != 0 || s.failures() != 0 {
+			t.Errorf("sorting %s called the classifier %d times, want none", source, again.calls)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// subcommand describes a subcommand for shell completion and the man page
+type subcommand struct {
+	name    string
+	summary string
+}
+
+// subcommands lists the user-facing subcommands in the order of the
+// dispatch in main; the internal decode-worker is left out
+var subcommands = []subcommand{
+	{"plan", "Write the transfers a sort run would make to a plan file for review"},
+	{"apply", "Execute the transfers of a plan written by plan"},
+	{"yearbook", "Summarize a year of the library as an HTML page"},
+	{"import-card", "Import a memory card, verify every copy, and optionally clear and eject it"},
+	{"auto-import", "Wait for memory cards and import each one with import-card"},
+	{"import-device", "Download and sort the photos of a camera or phone connected over MTP or PTP"},
+	{"unlock", "Make locked month folders writable again"},
+	{"lint", "Check a library against its folder layout"},
+	{"history", "List the runs of every machine that wrote to a library"},
+	{"hold", "Put files under retention, list them, or release them"},
+	{"stats", "Show how a library is distributed over months, cameras, and formats"},
+	{"fix-tz", "Rewrite capture times taken with the camera clock in the wrong time zone"},
+	{"verify", "Report, and with -repair move, files in the wrong month folder"},
+	{"consolidate", "Replace byte-identical files in a library with hard links"},
+	{"dedupe", "Report, link, move, or delete byte-identical files in a library"},
+	{"adopt", "Take over an existing library, inferring its layout and cataloging its files"},
+	{"scrub", "Re-hash a library against its catalog to detect bit rot"},
+	{"diff", "List the files two libraries do not have in common"},
+	{"service", "Print a systemd unit for a watch, or install it as a service"},
+	{"completion", "Print a shell completion script for bash, zsh, fish, or powershell"},
+	{"man", "Print the manual page in roff format"},
+	{"version", "Print the version, commit, build date, and supported formats and features"},
+}
+
+// usageFlag is one flag as listed by a command's -h output
+type usageFlag struct {
+	name string
+	// arg is the kind of value the flag takes, empty for switches
+	arg   string
+	usage string
+}
+
+// commandUsage is the -h output of the sort command or a subcommand
+type commandUsage struct {
+	synopsis string
+	flags    []usageFlag
+}
+
+var (
+	usageFlagLine = regexp.MustCompile(`^  -(\S+)(?: (\S+))?$`)
+	usageSynopsis = regexp.MustCompile(`^Usage: \S+ (.*)$`)
+)
+
+// readCommandUsage runs this executable with -h for command, or for the
+// sort command if it is empty, and parses the flags it lists. Asking the
+// binary itself keeps completions and the man page in step with the flags
+// each command defines.
+func readCommandUsage(command string) (commandUsage, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return commandUsage{}, err
+	}
+	args := []string{"-h"}
+	if command != "" {
+		args = []string{command, "-h"}
+	}
+	var out bytes.Buffer
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout, cmd.Stderr = &out, &out
+	// -h exits with an error status for subcommands, so only the output counts
+	cmd.Run()
+
+	var usage commandUsage
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := usageSynopsis.FindStringSubmatch(line); m != nil {
+			usage.synopsis = m[1]
+			continue
+		}
+		if m := usageFlagLine.FindStringSubmatch(line); m != nil {
+			usage.flags = append(usage.flags, usageFlag{name: m[1], arg: m[2]})
+			continue
+		}
+		if text := strings.TrimSpace(line); text != "" && len(usage.flags) > 0 && strings.HasPrefix(line, "    ") {
+			last := &usage.flags[len(usage.flags)-1]
+			last.usage = strings.TrimSpace(last.usage + " " + text)
+		}
+	}
+	if len(usage.flags) == 0 {
+		return commandUsage{}, fmt.Errorf("no flags found in the usage of %q", command)
+	}
+	return usage, nil
+}
+
+// readAllUsage reads the usage of the sort command, keyed "", and of every
+// subcommand that takes flags
+func readAllUsage() (map[string]commandUsage, error) {
+	usages := make(map[string]commandUsage)
+	for _, command := range append([]string{""}, subcommandNames()...) {
+		if command == "completion" || command == "man" {
+			continue
+		}
+		usage, err := readCommandUsage(command)
+		if err != nil {
+			return nil, err
+		}
+		usages[command] = usage
+	}
+	return usages, nil
+}
+
+// subcommandNames returns the names of the subcommands
+func subcommandNames() []string {
+	names := make([]string, len(subcommands))
+	for i, c := range subcommands {
+		names[i] = c.name
+	}
+	return names
+}
+
+// runCompletion implements the "completion" subcommand, which prints a
+// completion script for a shell
+func runCompletion(args []string) {
+	fs := flag.NewFlagSet("completion", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s completion bash|zsh|fish|powershell\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	positional := parseInterspersed(fs, args)
+	if len(positional) != 1 {
+		fs.Usage()
+		os.Exit(exitFatal)
+	}
+
+	var write func(io.Writer, map[string]commandUsage)
+	switch positional[0] {
+	case "bash":
+		write = writeBashCompletion
+	case "zsh":
+		write = writeZshCompletion
+	case "fish":
+		write = writeFishCompletion
+	case "powershell":
+		write = writePowerShellCompletion
+	default:
+		fatal("Unsupported shell, expected bash, zsh, fish, or powershell", "shell", positional[0])
+	}
+	usages, err := readAllUsage()
+	if err != nil {
+		fatal("Failed to read command flags", "error", err)
+	}
+	out := bufio.NewWriter(os.Stdout)
+	write(out, usages)
+	out.Flush()
+}
+
+// flagNames returns the flags of a command as "-name" words
+func flagNames(usage commandUsage) []string {
+	names := make([]string, len(usage.flags))
+	for i, f := range usage.flags {
+		names[i] = "-" + f.name
+	}
+	return names
+}
+
+// commandsWithFlags returns the subcommands that have usage, in order
+func commandsWithFlags(usages map[string]commandUsage) []string {
+	var names []string
+	for _, name := range subcommandNames() {
+		if _, ok := usages[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func writeBashCompletion(w io.Writer, usages map[string]commandUsage) {
+	fmt.Fprintln(w, "# bash completion for gopicsort; load with: source <(gopicsort completion bash)")
+	fmt.Fprintln(w, "_gopicsort() {")
+	fmt.Fprintln(w, `	local cur="${COMP_WORDS[COMP_CWORD]}" words=""`)
+	fmt.Fprintln(w, `	case "${COMP_WORDS[1]}" in`)
+	for _, name := range commandsWithFlags(usages) {
+		fmt.Fprintf(w, "	%s) words=%q ;;\n", name, strings.Join(flagNames(usages[name]), " "))
+	}
+	fmt.Fprintln(w, `	completion) words="bash zsh fish powershell" ;;`)
+	fmt.Fprintf(w, "	*) words=%q ;;\n", strings.Join(flagNames(usages[""]), " "))
+	fmt.Fprintln(w, "	esac")
+	fmt.Fprintln(w, `	if [[ $COMP_CWORD -eq 1 && "$cur" != -* ]]; then`)
+	fmt.Fprintf(w, "		words=%q\n", strings.Join(subcommandNames(), " "))
+	fmt.Fprintln(w, `	elif [[ "$cur" != -* && "${COMP_WORDS[1]}" != completion ]]; then`)
+	fmt.Fprintln(w, "		return")
+	fmt.Fprintln(w, "	fi")
+	fmt.Fprintln(w, `	COMPREPLY=($(compgen -W "$words" -- "$cur"))`)
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -o default -F _gopicsort gopicsort")
+}
+
+// zshFlagSpec formats a flag as an _arguments specification
+func zshFlagSpec(f usageFlag) string {
+	desc := strings.NewReplacer("[", `\[`, "]", `\]`, ":", `\:`, "'", `'\''`).Replace(f.usage)
+	spec := "*-" + f.name + "[" + desc + "]"
+	if f.arg != "" {
+		spec += ":" + f.name + ":_files"
+	}
+	return "'" + spec + "'"
+}
+
+func writeZshCompletion(w io.Writer, usages map[string]commandUsage) {
+	fmt.Fprintln(w, "#compdef gopicsort")
+	fmt.Fprintln(w, "# zsh completion for gopicsort; save as _gopicsort in a folder on $fpath")
+	fmt.Fprintln(w, "_gopicsort() {")
+	fmt.Fprintln(w, "	local -a commands")
+	fmt.Fprintln(w, "	commands=(")
+	for _, c := range subcommands {
+		fmt.Fprintf(w, "		'%s:%s'\n", c.name, strings.ReplaceAll(c.summary, "'", `'\''`))
+	}
+	fmt.Fprintln(w, "	)")
+	fmt.Fprintln(w, "	if (( CURRENT == 2 )) && [[ $words[2] != -* ]]; then")
+	fmt.Fprintln(w, "		_describe 'command' commands")
+	fmt.Fprintln(w, "		return")
+	fmt.Fprintln(w, "	fi")
+	fmt.Fprintln(w, "	case $words[2] in")
+	for _, name := range commandsWithFlags(usages) {
+		fmt.Fprintf(w, "	%s)\n		shift words; (( CURRENT-- ))\n		_arguments \\\n", name)
+		for _, f := range usages[name].flags {
+			fmt.Fprintf(w, "			%s \\\n", zshFlagSpec(f))
+		}
+		fmt.Fprintln(w, "			'*:file:_files' ;;")
+	}
+	fmt.Fprintln(w, "	completion)")
+	fmt.Fprintln(w, "		_values 'shell' bash zsh fish powershell ;;")
+	fmt.Fprintln(w, "	*)")
+	fmt.Fprintln(w, "		_arguments \\")
+	for _, f := range usages[""].flags {
+		fmt.Fprintf(w, "			%s \\\n", zshFlagSpec(f))
+	}
+	fmt.Fprintln(w, "			'*:file:_files' ;;")
+	fmt.Fprintln(w, "	esac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, `_gopicsort "$@"`)
+}
+
+// fishQuote quotes a string for fish
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+func writeFishCompletion(w io.Writer, usages map[string]commandUsage) {
+	fmt.Fprintln(w, "# fish completion for gopicsort; save as ~/.config/fish/completions/gopicsort.fish")
+	names := strings.Join(subcommandNames(), " ")
+	for _, c := range subcommands {
+		fmt.Fprintf(w, "complete -c gopicsort -f -n '__fish_use_subcommand' -a %s -d %s\n", c.name, fishQuote(c.summary))
+	}
+	writeFlags := func(condition string, usage commandUsage) {
+		for _, f := range usage.flags {
+			line := fmt.Sprintf("complete -c gopicsort -n %s -o %s -d %s", fishQuote(condition), f.name, fishQuote(f.usage))
+			if f.arg != "" {
+				line += " -r -F"
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+	writeFlags("not __fish_seen_subcommand_from "+names, usages[""])
+	for _, name := range commandsWithFlags(usages) {
+		writeFlags("__fish_seen_subcommand_from "+name, usages[name])
+	}
+	fmt.Fprintln(w, "complete -c gopicsort -f -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish powershell'")
+}
+
+// powerShellList formats words as a PowerShell array
+func powerShellList(words []string) string {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = "'" + strings.ReplaceAll(word, "'", "''") + "'"
+	}
+	return "@(" + strings.Join(quoted, ", ") + ")"
+}
+
+func writePowerShellCompletion(w io.Writer, usages map[string]commandUsage) {
+	fmt.Fprintln(w, "# PowerShell completion for gopicsort; add to $PROFILE: gopicsort completion powershell | Out-String | Invoke-Expression")
+	fmt.Fprintln(w, "Register-ArgumentCompleter -Native -CommandName gopicsort, gopicsort.exe -ScriptBlock {")
+	fmt.Fprintln(w, "    param($wordToComplete, $commandAst, $cursorPosition)")
+	fmt.Fprintf(w, "    $commands = %s\n", powerShellList(subcommandNames()))
+	fmt.Fprintln(w, "    $flags = @{")
+	fmt.Fprintf(w, "        '' = %s\n", powerShellList(flagNames(usages[""])))
+	for _, name := range commandsWithFlags(usages) {
+		fmt.Fprintf(w, "        '%s' = %s\n", name, powerShellList(flagNames(usages[name])))
+	}
+	fmt.Fprintf(w, "        'completion' = %s\n", powerShellList([]string{"bash", "zsh", "fish", "powershell"}))
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintln(w, "    $elements = @($commandAst.CommandElements | ForEach-Object { $_.ToString() })")
+	fmt.Fprintln(w, "    $command = ''")
+	fmt.Fprintln(w, "    if ($elements.Count -gt 1 -and $commands -contains $elements[1]) { $command = $elements[1] }")
+	fmt.Fprintln(w, "    if ($wordToComplete -like '-*' -or $command -eq 'completion') {")
+	fmt.Fprintln(w, "        $candidates = $flags[$command]")
+	fmt.Fprintln(w, "    } elseif ($command -eq '' -and $elements.Count -le 2) {")
+	fmt.Fprintln(w, "        $candidates = $commands")
+	fmt.Fprintln(w, "    } else {")
+	fmt.Fprintln(w, "        return")
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintln(w, "    $candidates | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {")
+	fmt.Fprintln(w, "        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)")
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintln(w, "}")
+}
+
+// runMan implements the "man" subcommand, which prints the manual page,
+// built from the flags every command defines, in roff format
+func runMan(args []string) {
+	fs := flag.NewFlagSet("man", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s man > gopicsort.1\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	parseInterspersed(fs, args)
+	usages, err := readAllUsage()
+	if err != nil {
+		fatal("Failed to read command flags", "error", err)
+	}
+	out := bufio.NewWriter(os.Stdout)
+	writeManPage(out, usages)
+	out.Flush()
+}
+
+// roff escapes text for a roff line
+func roff(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+// writeManFlags writes the flags of a command as a roff tagged list
+func writeManFlags(w io.Writer, flags []usageFlag) {
+	for _, f := range flags {
+		fmt.Fprintln(w, ".TP")
+		if f.arg != "" {
+			fmt.Fprintf(w, `\fB\-%s\fR \fI%s\fR`+"\n", roff(f.name), roff(f.arg))
+		} else {
+			fmt.Fprintf(w, `\fB\-%s\fR`+"\n", roff(f.name))
+		}
+		fmt.Fprintln(w, roff(f.usage))
+	}
+}
+
+func writeManPage(w io.Writer, usages map[string]commandUsage) {
+	fmt.Fprintln(w, `.TH GOPICSORT 1 "" "gopicsort" "User Commands"`)
+	fmt.Fprintln(w, ".SH NAME")
+	fmt.Fprintln(w, `gopicsort \- sort photos and videos into folders by capture date`)
+	fmt.Fprintln(w, ".SH SYNOPSIS")
+	fmt.Fprintln(w, `.B gopicsort`)
+	fmt.Fprintln(w, `[\fIoptions\fR] \fB\-source\fR \fIDIR\fR \fB\-dest\fR \fIDIR\fR`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B gopicsort`)
+	fmt.Fprintln(w, `\fICOMMAND\fR [\fIoptions\fR]`)
+	fmt.Fprintln(w, ".SH DESCRIPTION")
+	fmt.Fprintln(w, "GoPicSort copies or moves photos and videos from the source folders into a library in the destination, in folders named after their capture date, yyyy/mm by default. The date is read from EXIF and the other sources listed by \\fB\\-date\\-sources\\fR.")
+	fmt.Fprintln(w, ".SH OPTIONS")
+	writeManFlags(w, usages[""].flags)
+	fmt.Fprintln(w, ".SH COMMANDS")
+	for _, c := range subcommands {
+		fmt.Fprintf(w, ".SS %s\n", c.name)
+		usage, ok := usages[c.name]
+		switch {
+		case c.name == "completion":
+			fmt.Fprintln(w, `\fBgopicsort completion\fR \fBbash\fR|\fBzsh\fR|\fBfish\fR|\fBpowershell\fR`)
+		case c.name == "man":
+			fmt.Fprintln(w, `\fBgopicsort man\fR`)
+		case ok && usage.synopsis != "":
+			fmt.Fprintf(w, "\\fBgopicsort %s\\fR\n", roff(usage.synopsis))
+		}
+		fmt.Fprintln(w, ".PP")
+		fmt.Fprintln(w, roff(c.summary)+".")
+		if ok {
+			writeManFlags(w, usage.flags)
+		}
+	}
+	fmt.Fprintln(w, ".SH EXIT STATUS")
+	fmt.Fprintln(w, ".TP\n0\nAll files were sorted or skipped on purpose.")
+	fmt.Fprintln(w, ".TP\n1\nSome files could not be sorted, or a checking command found issues.")
+	fmt.Fprintln(w, ".TP\n2\nThe run could not start or was aborted.")
+	fmt.Fprintln(w, ".SH ENVIRONMENT")
+	fmt.Fprintln(w, ".TP\nNO_COLOR\nTurns off colors in the console log format.")
+	fmt.Fprintln(w, ".TP\nAWS_ENDPOINT_URL\nDefault endpoint for s3:// destinations.")
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ANSI styles of the console log format
+const (
+	styleReset  = "\x1b[0m"
+	styleBold   = "\x1b[1m"
+	styleDim    = "\x1b[2m"
+	styleRed    = "\x1b[31m"
+	styleGreen  = "\x1b[32m"
+	styleYellow = "\x1b[33m"
+	styleCyan   = "\x1b[36m"
+)
+
+// consoleActions maps the messages logged for each transferred file to the
+// word and style of their compact console line
+var consoleActions = map[string]struct{ word, style string }{
+	"Copied":    {"copied", styleGreen},
+	"Moved":     {"moved", styleCyan},
+	"Linked":    {"linked", styleCyan},
+	"Converted": {"converted", styleGreen},
+	"Uploaded":  {"uploaded", styleGreen},
+}
+
+// consoleHandler writes logs for people watching an interactive run: one
+// short line per file, such as "copied  /photos/IMG_1.jpg → /sorted/2023/05/IMG_1.jpg",
+// colored by what happened when color is set. With verbose, every line
+// starts with the time and keeps all its fields.
+type consoleHandler struct {
+	out     io.Writer
+	mu      *sync.Mutex
+	level   slog.Leveler
+	color   bool
+	verbose bool
+	attrs   []slog.Attr
+	group   string
+}
+
+func newConsoleHandler(out io.Writer, level slog.Leveler, color, verbose bool) *consoleHandler {
+	return &consoleHandler{out: out, mu: new(sync.Mutex), level: level, color: color, verbose: verbose}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := make(map[string]string)
+	var order []string
+	add := func(key, value string) {
+		if _, ok := fields[key]; !ok {
+			order = append(order, key)
+		}
+		fields[key] = value
+	}
+	for _, a := range h.attrs {
+		flattenAttr("", a, add)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		flattenAttr(h.group, a, add)
+		return true
+	})
+
+	var b strings.Builder
+	if h.verbose {
+		b.WriteString(h.style(styleDim, r.Time.Format("15:04:05")) + " ")
+	}
+	shown := make(map[string]bool)
+	action, isAction := consoleActions[r.Message]
+	_, hasSource := fields["source"]
+	_, hasDest := fields["dest"]
+	switch {
+	case isAction && hasSource && hasDest && r.Level < slog.LevelWarn:
+		b.WriteString(h.style(action.style, padRight(action.word, 9)) + " " + fields["source"] + h.style(styleDim, " → ") + fields["dest"])
+		shown["source"], shown["dest"] = true, true
+	case strings.HasPrefix(r.Message, "Skipping") && r.Level < slog.LevelWarn:
+		reason := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(r.Message, "Skipping"), ":"))
+		b.WriteString(h.style(styleDim, padRight("skipped", 9)))
+		if path, ok := fields["path"]; ok {
+			b.WriteString(" " + path)
+			shown["path"] = true
+		}
+		if reason != "" {
+			b.WriteString(h.style(styleDim, " ("+reason+")"))
+		}
+	default:
+		switch {
+		case r.Level >= slog.LevelError:
+			b.WriteString(h.style(styleRed+styleBold, "error") + " ")
+		case r.Level >= slog.LevelWarn:
+			b.WriteString(h.style(styleYellow, "warning") + " ")
+		case r.Level < slog.LevelInfo:
+			b.WriteString(h.style(styleDim, "debug") + " ")
+		}
+		b.WriteString(plainText(r.Message))
+	}
+	// Compact lines leave out the details unless verbose
+	compact := len(shown) > 0 && !h.verbose
+	for _, key := range order {
+		if shown[key] || compact {
+			continue
+		}
+		b.WriteString(" " + h.style(styleDim, key+"=") + plainText(fields[key]))
+	}
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.out, b.String())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		if h.group != "" {
+			a.Key = h.group + "." + a.Key
+		}
+		clone.attrs = append(clone.attrs, a)
+	}
+	return &clone
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	if clone.group != "" {
+		name = clone.group + "." + name
+	}
+	clone.group = name
+	return &clone
+}
+
+// style wraps text in an ANSI style when the handler uses color
+func (h *consoleHandler) style(style, text string) string {
+	if !h.color {
+		return text
+	}
+	return style + text + styleReset
+}
+
+// flattenAttr calls add with the key and value of an attribute, flattening
+// groups into dotted keys
+func flattenAttr(prefix string, a slog.Attr, add func(key, value string)) {
+	a.Value = a.Value.Resolve()
+	key := a.Key
+	if prefix != "" && key != "" {
+		key = prefix + "." + key
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		for _, member := range a.Value.Group() {
+			flattenAttr(key, member, add)
+		}
+		return
+	}
+	if key != "" {
+		add(key, a.Value.String())
+	}
+}
+
+// padRight pads s with spaces to width characters
+func padRight(s string, width int) string {
+	if len(s) >= width {
+		return s
+	}
+	return s + strings.Repeat(" ", width-len(s))
+}
+
+// isTerminal reports whether a log destination is an interactive terminal
+func isTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	stat, err := file.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+// colorAllowed reports whether the environment allows colored output; see
+// https://no-color.org
+func colorAllowed() bool {
+	return os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+}
+
+// verbosity is the -verbose flag: each -verbose raises it by one, and
+// -verbose=N sets it
+type verbosity int
+
+func (v *verbosity) String() string {
+	return strconv.Itoa(int(*v))
+}
+
+func (v *verbosity) Set(value string) error {
+	switch value {
+	case "true":
+		*v++
+		return nil
+	case "false":
+		*v = 0
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return strconv.ErrSyntax
+	}
+	*v = verbosity(n)
+	return nil
+}
+
+func (v *verbosity) IsBoolFlag() bool {
+	return true
+}
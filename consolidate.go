@@ -0,0 +1,173 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// duplicateGroup is a set of files in a library with identical contents
+type duplicateGroup struct {
+	keep   string
+	dupes  []string // files not yet sharing the kept file's data
+	linked []string // files that are already hard links to the kept file
+	size   int64
+}
+
+// runConsolidate implements the "consolidate" subcommand, which finds
+// byte-identical files in a library, for example from overlapping imports
+// made before -dedupe existed, and replaces the copies with hard links
+func runConsolidate(args []string) {
+	fs := flag.NewFlagSet("consolidate", flag.ExitOnError)
+	destDir := fs.String("dest", "", "Sorted photo library")
+	apply := fs.Bool("apply", false, "Replace duplicates with hard links to one copy (default only reports reclaimable space)")
+	logOpts := addLogFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s consolidate -dest DIR [-apply]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	parseInterspersed(fs, args)
+	if *destDir == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := logOpts.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	groups, err := findDuplicates(*destDir)
+	if err != nil {
+		fatal("Failed to scan library", "error", err)
+	}
+	var files int
+	var reclaimable int64
+	for _, group := range groups {
+		for _, dupe := range group.dupes {
+			slog.Info("Duplicate", "path", dupe, "same_as", group.keep)
+		}
+		files += len(group.dupes)
+		reclaimable += int64(len(group.dupes)) * group.size
+	}
+	if !*apply {
+		slog.Info("Scan finished, run with -apply to link duplicates", "duplicates", files, "reclaimable", formatSize(reclaimable))
+		return
+	}
+
+	catalog, err := loadRetention(*destDir)
+	if err != nil {
+		fatal("Failed to read retention catalog", "error", err)
+	}
+	started := time.Now()
+	linked := 0
+	var reclaimed int64
+	for _, group := range groups {
+		for _, dupe := range group.dupes {
+			if err := catalog.check(dupe); err != nil {
+				slog.Warn("Not linking file", "path", dupe, "error", err)
+				continue
+			}
+			if isLocked(filepath.Dir(dupe)) {
+				slog.Warn("Not linking file in a locked folder, run 'gopicsort unlock' first", "path", dupe)
+				continue
+			}
+			if err := replaceWithLink(group.keep, dupe); err != nil {
+				slog.Warn("Could not link file", "path", dupe, "error", err)
+				continue
+			}
+			slog.Info("Linked", "path", dupe, "to", group.keep)
+			linked++
+			reclaimed += group.size
+		}
+	}
+
+	record := runRecord{
+		RunID:    newRunID(),
+		Machine:  machineName(),
+		Command:  "consolidate",
+		Started:  started,
+		Finished: time.Now(),
+		Dest:     *destDir,
+		Files:    linked,
+	}
+	if err := appendRunHistory(*destDir, record); err != nil {
+		slog.Warn("Could not record run history", "error", err)
+	}
+	slog.Info("Consolidation finished", "linked", linked, "reclaimed", formatSize(reclaimed))
+}
+
+// findDuplicates groups the files of a library by contents. The first path
+// of each group in sorted order is kept; files that are already hard links
+// to it are listed separately from the duplicates.
+func findDuplicates(destDir string) ([]duplicateGroup, error) {
+	ix, err := newContentIndex(localFS, destDir)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make([]int64, 0, len(ix.bySize))
+	for size, paths := range ix.bySize {
+		if size > 0 && len(paths) > 1 {
+			sizes = append(sizes, size)
+		}
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+
+	var groups []duplicateGroup
+	for _, size := range sizes {
+		byHash := make(map[string][]string)
+		for _, path := range ix.bySize[size] {
+			h, err := ix.hash(path)
+			if err != nil {
+				slog.Warn("Could not read file", "path", path, "error", err)
+				continue
+			}
+			byHash[h] = append(byHash[h], path)
+		}
+		for _, paths := range byHash {
+			if len(paths) < 2 {
+				continue
+			}
+			sort.Strings(paths)
+			keep, err := os.Stat(paths[0])
+			if err != nil {
+				continue
+			}
+			group := duplicateGroup{keep: paths[0], size: size}
+			for _, path := range paths[1:] {
+				info, err := os.Stat(path)
+				switch {
+				case err != nil:
+				case os.SameFile(keep, info):
+					group.linked = append(group.linked, path)
+				default:
+					group.dupes = append(group.dupes, path)
+				}
+			}
+			if len(group.dupes) > 0 || len(group.linked) > 0 {
+				groups = append(groups, group)
+			}
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].keep < groups[j].keep })
+	return groups, nil
+}
+
+// replaceWithLink atomically replaces dupe with a hard link to keep, so the
+// duplicate's name never disappears even if the run is interrupted
+func replaceWithLink(keep, dupe string) error {
+	temp := filepath.Join(filepath.Dir(dupe), ".gopicsort-link-"+filepath.Base(dupe))
+	os.Remove(temp)
+	if err := os.Link(keep, temp); err != nil {
+		return err
+	}
+	if err := os.Rename(temp, dupe); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConsolidateLinksDuplicates(t *testing.T) {
+	lib := t.TempDir()
+	keep := filepath.Join(lib, "2021", "05", "IMG_0001.jpg")
+	dupe := filepath.Join(lib, "2021", "06", "IMG_0001 copy.jpg")
+	writeTestFile(t, keep, []byte("photo"))
+	writeTestFile(t, dupe, []byte("photo"))
+	writeTestFile(t, filepath.Join(lib, "2021", "06", "IMG_0002.jpg"), []byte("other"))
+
+	groups, err := findDuplicates(lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].keep != keep || len(groups[0].dupes) != 1 || groups[0].dupes[0] != dupe {
+		t.Fatalf("groups are %+v, want %s kept and %s duplicated", groups, keep, dupe)
+	}
+	if err := replaceWithLink(keep, dupe); err != nil {
+		t.Skipf("hard links not supported here: %v", err)
+	}
+	keepInfo, _ := os.Stat(keep)
+	dupeInfo, err := os.Stat(dupe)
+	if err != nil || !os.SameFile(keepInfo, dupeInfo) {
+		t.Fatalf("%s is not a link to %s", dupe, keep)
+	}
+
+	// Linked files are not reported as duplicates again
+	if groups, err = findDuplicates(lib); err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || len(groups[0].dupes) != 0 || len(groups[0].linked) != 1 {
+		t.Errorf("groups after linking are %+v, want one linked file", groups)
+	}
+}
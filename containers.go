@@ -0,0 +1,504 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// errNoExif is returned for containers that carry no EXIF block
+var errNoExif = errors.New("no EXIF metadata")
+
+// maxMetadataBox bounds the container boxes and chunks read into memory
+// while looking for metadata
+const maxMetadataBox = 16 << 20
+
+// metadataFile is a file the container parsers read metadata from: an open
+// *os.File, or the contents of a file on another fileSystem
+type metadataFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+// containerExif returns a reader for the EXIF embedded in a file whose
+// container exif.Decode cannot read itself: PNG, WebP, and HEIF/AVIF chunks
+// and boxes, the JPEG preview of Fujifilm RAF files, and the TIFF structure
+// of Olympus ORF and Panasonic RW2 files, which have their own magic
+// numbers. The reader yields raw TIFF data, optionally preceded by
+// "Exif\0\0", or a JPEG. ok is false for other formats.
+func containerExif(file metadataFile, size int64) (r io.Reader, ok bool, err error) {
+	header := make([]byte, sniffLength)
+	n, _ := io.ReadFull(file, header)
+	var data []byte
+	switch detectFormat(header[:n]) {
+	case ".png":
+		data, err = pngExif(file)
+	case ".webp":
+		data, err = webpExif(file)
+	case ".heic", ".heif", ".avif":
+		data, err = heifExif(file)
+	case ".raf":
+		r, err = rafPreview(file)
+		return r, true, err
+	case ".orf", ".rw2":
+		// Both are TIFF with a different magic number after the byte order
+		magic := "II*\x00"
+		if string(header[0:2]) == "MM" {
+			magic = "MM\x00*"
+		}
+		if _, err := file.Seek(4, io.SeekStart); err != nil {
+			return nil, true, err
+		}
+		// Only the metadata is read, not the image data after it
+		if extent, ok := tiffExtent(file, size); ok {
+			return io.MultiReader(strings.NewReader(magic), io.NewSectionReader(file, 4, extent-4)), true, nil
+		}
+		return io.MultiReader(strings.NewReader(magic), file), true, nil
+	default:
+		return nil, false, nil
+	}
+	return bytes.NewReader(data), true, err
+}
+
+// rafPreview returns the embedded JPEG preview of a Fujifilm RAF file, which
+// carries the camera's EXIF. Its offset and length are stored big-endian at
+// byte 84 of the header.
+func rafPreview(file metadataFile) (io.Reader, error) {
+	var header [8]byte
+	if _, err := file.ReadAt(header[:], 84); err != nil {
+		return nil, err
+	}
+	offset := binary.BigEndian.Uint32(header[0:4])
+	length := binary.BigEndian.Uint32(header[4:8])
+	if offset == 0 || length == 0 {
+		return nil, errNoExif
+	}
+	return io.NewSectionReader(file, int64(offset), int64(length)), nil
+}
+
+// pngChunk is one chunk of a PNG file
+type pngChunk struct {
+	kind string
+	data []byte
+}
+
+// readPNGChunks calls fn for every chunk of a PNG file whose type is in
+// kinds, skipping over the others (such as image data) without reading them
+func readPNGChunks(file metadataFile, kinds map[string]bool, fn func(pngChunk) bool) error {
+	if _, err := file.Seek(8, io.SeekStart); err != nil {
+		return err
+	}
+	head := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(file, head); err != nil {
+			return err
+		}
+		length := int64(binary.BigEndian.Uint32(head[0:4]))
+		kind := string(head[4:8])
+		if kind == "IEND" {
+			return nil
+		}
+		if !kinds[kind] || length > maxMetadataBox {
+			if _, err := file.Seek(length+4, io.SeekCurrent); err != nil {
+				return err
+			}
+			continue
+		}
+		data := make([]byte, length+4) // with the CRC
+		if _, err := io.ReadFull(file, data); err != nil {
+			return err
+		}
+		if !fn(pngChunk{kind, data[:length]}) {
+			return nil
+		}
+	}
+}
+
+// pngText returns the keyword and text of a tEXt, zTXt, or iTXt chunk
+func pngText(chunk pngChunk) (string, string, bool) {
+	keyword, rest, ok := bytes.Cut(chunk.data, []byte{0})
+	if !ok {
+		return "", "", false
+	}
+	switch chunk.kind {
+	case "tEXt":
+		return string(keyword), string(rest), true
+	case "zTXt":
+		if len(rest) < 1 {
+			return "", "", false
+		}
+		text, err := inflate(rest[1:])
+		return string(keyword), string(text), err == nil
+	case "iTXt":
+		// Compression flag and method, then language and translated keyword
+		if len(rest) < 2 {
+			return "", "", false
+		}
+		compressed := rest[0] == 1
+		parts := bytes.SplitN(rest[2:], []byte{0}, 3)
+		if len(parts) != 3 {
+			return "", "", false
+		}
+		if !compressed {
+			return string(keyword), string(parts[2]), true
+		}
+		text, err := inflate(parts[2])
+		return string(keyword), string(text), err == nil
+	}
+	return "", "", false
+}
+
+// inflate decompresses zlib data, bounded by maxMetadataBox
+func inflate(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(io.LimitReader(r, maxMetadataBox))
+}
+
+// pngExif returns the EXIF of a PNG file from its eXIf chunk, or from the
+// hex-encoded "Raw profile type exif" text chunks written by ImageMagick and
+// older tools before eXIf existed
+func pngExif(file metadataFile) ([]byte, error) {
+	var data []byte
+	kinds := map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true}
+	err := readPNGChunks(file, kinds, func(chunk pngChunk) bool {
+		if chunk.kind == "eXIf" {
+			data = chunk.data
+			return false
+		}
+		keyword, text, ok := pngText(chunk)
+		if ok && (keyword == "Raw profile type exif" || keyword == "Raw profile type APP1") && data == nil {
+			data = decodeRawProfile(text)
+		}
+		return true
+	})
+	if data == nil {
+		if err == nil || err == io.EOF {
+			err = errNoExif
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// decodeRawProfile decodes an ImageMagick raw profile: a name line, the
+// length, and the data as lines of hex digits
+func decodeRawProfile(text string) []byte {
+	fields := strings.Fields(text)
+	if len(fields) < 3 {
+		return nil
+	}
+	data, err := hex.DecodeString(strings.Join(fields[2:], ""))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// pngCreationTimeLayouts are the forms of the PNG "Creation Time" keyword:
+// RFC 1123 as the PNG specification suggests, and forms written in practice
+var pngCreationTimeLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	exifDateFormat,
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+// pngCreationTime returns the "Creation Time" recorded in a PNG file's text
+// chunks, as the wall clock time like EXIF dates
+func pngCreationTime(path string) (time.Time, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer file.Close()
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(file, header); err != nil || detectFormat(header) != ".png" {
+		return time.Time{}, false
+	}
+
+	var date time.Time
+	found := false
+	kinds := map[string]bool{"tEXt": true, "zTXt": true, "iTXt": true}
+	readPNGChunks(file, kinds, func(chunk pngChunk) bool {
+		keyword, text, ok := pngText(chunk)
+		if !ok || keyword != "Creation Time" {
+			return true
+		}
+		for _, layout := range pngCreationTimeLayouts {
+			if t, err := time.Parse(layout, strings.TrimSpace(text)); err == nil {
+				date = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local)
+				found = true
+				return false
+			}
+		}
+		return true
+	})
+	return date, found
+}
+
+// webpExif returns the EXIF chunk of a WebP file
+func webpExif(file metadataFile) ([]byte, error) {
+	if _, err := file.Seek(12, io.SeekStart); err != nil {
+		return nil, err
+	}
+	head := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(file, head); err != nil {
+			if err == io.EOF {
+				err = errNoExif
+			}
+			return nil, err
+		}
+		size := int64(binary.LittleEndian.Uint32(head[4:8]))
+		padded := size + size&1
+		if string(head[0:4]) != "EXIF" || size > maxMetadataBox {
+			if _, err := file.Seek(padded, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(file, data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+}
+
+// bmffBox is a box of an ISO base media file (HEIF, AVIF, MP4)
+type bmffBox struct {
+	kind string
+	data []byte
+}
+
+// bmffBoxes splits data into the boxes it contains
+func bmffBoxes(data []byte) []bmffBox {
+	var boxes []bmffBox
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		kind := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return boxes
+			}
+			size, header = binary.BigEndian.Uint64(data[8:16]), 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return boxes
+		}
+		boxes = append(boxes, bmffBox{kind, data[header:size]})
+		data = data[size:]
+	}
+	return boxes
+}
+
+// heifExif returns the Exif item of a HEIF or AVIF file, located through
+// the item information (iinf) and item location (iloc) boxes of its meta box
+func heifExif(file metadataFile) ([]byte, error) {
+	meta, err := findTopLevelBox(file, "meta")
+	if err != nil {
+		return nil, err
+	}
+	if len(meta) < 4 {
+		return nil, errNoExif
+	}
+	// meta is a full box: skip version and flags
+	var iinf, iloc []byte
+	for _, box := range bmffBoxes(meta[4:]) {
+		switch box.kind {
+		case "iinf":
+			iinf = box.data
+		case "iloc":
+			iloc = box.data
+		}
+	}
+	id, ok := exifItemID(iinf)
+	if !ok {
+		return nil, errNoExif
+	}
+	data, err := readItem(file, iloc, id)
+	if err != nil {
+		return nil, err
+	}
+	// The item starts with the offset of the TIFF header
+	if len(data) < 4 {
+		return nil, errNoExif
+	}
+	offset := binary.BigEndian.Uint32(data[0:4])
+	if uint64(offset)+4 > uint64(len(data)) {
+		return nil, errNoExif
+	}
+	return data[4+offset:], nil
+}
+
+// findTopLevelBox reads the contents of the first top-level box of a kind
+func findTopLevelBox(file metadataFile, kind string) ([]byte, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	size, err := seekBox(file, kind, -1)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxMetadataBox {
+		return nil, errNoExif
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(file, data)
+	return data, err
+}
+
+// seekBox skips boxes from the current position of file until one of a kind,
+// leaving the file at that box's contents and returning their size. limit
+// bounds how many bytes are searched, or is negative to search to the end.
+func seekBox(file metadataFile, kind string, limit int64) (int64, error) {
+	head := make([]byte, 16)
+	for limit < 0 || limit >= 8 {
+		if _, err := io.ReadFull(file, head[:8]); err != nil {
+			if err == io.EOF {
+				err = errNoExif
+			}
+			return 0, err
+		}
+		size := int64(binary.BigEndian.Uint32(head[0:4]))
+		header := int64(8)
+		if size == 1 {
+			if _, err := io.ReadFull(file, head[8:16]); err != nil {
+				return 0, err
+			}
+			size, header = int64(binary.BigEndian.Uint64(head[8:16])), 16
+		}
+		if size == 0 || size < header {
+			return 0, errNoExif
+		}
+		if string(head[4:8]) == kind {
+			return size - header, nil
+		}
+		if _, err := file.Seek(size-header, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+		if limit >= 0 {
+			limit -= size
+		}
+	}
+	return 0, errNoExif
+}
+
+// exifItemID finds the ID of the item of type "Exif" in an iinf box
+func exifItemID(iinf []byte) (uint32, bool) {
+	if len(iinf) < 6 {
+		return 0, false
+	}
+	entries := iinf[6:]
+	if iinf[0] != 0 {
+		entries = iinf[8:]
+	}
+	for _, box := range bmffBoxes(entries) {
+		if box.kind != "infe" || len(box.data) < 4 {
+			continue
+		}
+		version, d := box.data[0], box.data[4:]
+		var id uint32
+		switch {
+		case version == 2 && len(d) >= 8:
+			id, d = uint32(binary.BigEndian.Uint16(d[0:2])), d[4:]
+		case version == 3 && len(d) >= 10:
+			id, d = binary.BigEndian.Uint32(d[0:4]), d[6:]
+		default:
+			continue
+		}
+		if string(d[0:4]) == "Exif" {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// readItem reads the data of an item from the extents in an iloc box
+func readItem(file metadataFile, iloc []byte, id uint32) ([]byte, error) {
+	r := &byteReader{data: iloc}
+	version := r.uint(1)
+	r.uint(3) // flags
+	sizes := r.uint(2)
+	offsetSize, lengthSize := int(sizes>>12&0xF), int(sizes>>8&0xF)
+	baseOffsetSize, indexSize := int(sizes>>4&0xF), int(sizes&0xF)
+	if version == 0 {
+		indexSize = 0
+	}
+	count := r.uint(2)
+	if version == 2 {
+		count = r.uint(4)
+	}
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		itemID := r.uint(2)
+		if version == 2 {
+			itemID = r.uint(4)
+		}
+		method := uint64(0)
+		if version == 1 || version == 2 {
+			method = r.uint(2) & 0xF
+		}
+		r.uint(2) // data reference index
+		base := r.uint(baseOffsetSize)
+		extents := r.uint(2)
+		var data []byte
+		for e := uint64(0); e < extents && r.err == nil; e++ {
+			r.uint(indexSize)
+			offset, length := r.uint(offsetSize), r.uint(lengthSize)
+			if uint32(itemID) != id {
+				continue
+			}
+			if method != 0 || length > maxMetadataBox || uint64(len(data))+length > maxMetadataBox {
+				return nil, errNoExif
+			}
+			extent := make([]byte, length)
+			if _, err := file.ReadAt(extent, int64(base+offset)); err != nil {
+				return nil, err
+			}
+			data = append(data, extent...)
+		}
+		if uint32(itemID) == id && r.err == nil {
+			return data, nil
+		}
+	}
+	return nil, errNoExif
+}
+
+// byteReader reads big-endian integers of varying sizes, remembering the
+// first read past the end
+type byteReader struct {
+	data []byte
+	err  error
+}
+
+// uint reads an unsigned integer of size bytes; a size of 0 reads nothing
+func (r *byteReader) uint(size int) uint64 {
+	if size > len(r.data) || size > 8 {
+		r.err = io.ErrUnexpectedEOF
+		r.data = nil
+		return 0
+	}
+	var v uint64
+	for _, b := range r.data[:size] {
+		v = v<<8 | uint64(b)
+	}
+	r.data = r.data[size:]
+	return v
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// controlHistory is how many recent imports, problems, and duplicates the
+// control API keeps for the dashboard
+const controlHistory = 200
+
+// controlCookie may hold the control token for front ends in a browser; the
+// API never sets it
+const controlCookie = "gopicsort_control"
+
+// Watch states reported by the control API
+const (
+	controlWatching = "watching"
+	controlSorting  = "sorting"
+	controlPaused   = "paused"
+)
+
+// watchControl lets the control API enabled by -control-addr drive a
+// long-running watch: trigger a poll, pause and resume sorting, and follow
+// its progress. The watch loop updates it while the API reads it, so every
+// access holds mu.
+type watchControl struct {
+	mu       sync.Mutex
+	paused   bool
+	current  string
+	counts   map[string]int
+	queue    int
+	lastPoll time.Time
+	started  time.Time
+	// trigger wakes the watch loop for a poll before the interval is up
+	trigger chan struct{}
+	// sandbox decodes the thumbnails served to the dashboard
+	sandbox *decoderSandbox
+
+	// The latest imports, problem files, and skipped duplicates, oldest
+	// first, and the problem files to try again on the next poll
+	imports    []controlImport
+	problems   []fileProblem
+	duplicates []controlDuplicate
+	retry      []string
+}
+
+// controlImport is a file sorted by the watch
+type controlImport struct {
+	Source string    `json:"source"`
+	Dest   string    `json:"dest"`
+	Folder string    `json:"folder"`
+	Time   time.Time `json:"time"`
+}
+
+// controlDuplicate is a file skipped by -dedupe and the file it duplicates
+type controlDuplicate struct {
+	Path     string `json:"path"`
+	Existing string `json:"existing"`
+}
+
+// controlStatus is the body of GET /api/status
+type controlStatus struct {
+	State    string         `json:"state"`
+	Current  string         `json:"current,omitempty"`
+	Counts   map[string]int `json:"counts"`
+	Queue    int            `json:"queue"`
+	LastPoll *time.Time     `json:"last_poll,omitempty"`
+	Started  time.Time      `json:"started"`
+}
+
+func newWatchControl() *watchControl {
+	return &watchControl{counts: make(map[string]int), started: time.Now(), trigger: make(chan struct{}, 1)}
+}
+
+// startControlServer serves the control API in the background. With a token,
+// every request must carry it; without one, the API is only served on a
+// loopback address.
+func startControlServer(addr, token string, c *watchControl, destDir string) error {
+	if token == "" && !isLoopbackAddr(addr) {
+		return fmt.Errorf("%s is reachable from other machines, set -control-token or listen on localhost", addr)
+	}
+	server := &http.Server{Addr: addr, Handler: controlHandler(token, c, destDir), ReadHeaderTimeout: 30 * time.Second}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("Control API listening", "addr", listener.Addr().String())
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("Control API stopped", "error", err)
+		}
+	}()
+	return nil
+}
+
+// controlHandler returns the control API, requiring token when it is set,
+// as a bearer token or in the controlCookie set by a front end
+func controlHandler(token string, c *watchControl, destDir string) http.Handler {
+	mux := http.NewServeMux()
+	c.routes(mux, destDir)
+	if token == "" {
+		return guardLoopback(mux)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hasToken(r, token, controlCookie) {
+			writeControlError(w, http.StatusUnauthorized, "missing or wrong token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// guardLoopback protects a server without a token, which only listens on a
+// loopback address, from web pages open in a browser on the same machine.
+// Requests naming another host are refused, so a page cannot reach the
+// server through a DNS name rebound to 127.0.0.1, and requests that change
+// anything must come from a page the server itself served.
+func guardLoopback(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if !isLoopbackHost(host) {
+			writeControlError(w, http.StatusForbidden, "requests must be made to localhost")
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !sameOrigin(r) {
+			writeControlError(w, http.StatusForbidden, "requests without a token must carry an Origin header of this server")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin reports whether a request's Origin header names the host it was
+// sent to. Browsers send it with every POST, and a page on another site
+// cannot change it.
+func sameOrigin(r *http.Request) bool {
+	origin, err := url.Parse(r.Header.Get("Origin"))
+	if err != nil || origin.Host == "" {
+		return false
+	}
+	return strings.EqualFold(origin.Host, r.Host)
+}
+
+// isLoopbackAddr reports whether a listen address such as "localhost:8081"
+// only accepts connections from this machine; ":8081" listens on every
+// interface
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	return isLoopbackHost(host)
+}
+
+// isLoopbackHost reports whether host is localhost or a loopback IP address
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// routes registers the API endpoints on mux, which the control API and the
+// dashboard share
+func (c *watchControl) routes(mux *http.ServeMux, destDir string) {
+	mux.HandleFunc("/api/status", onlyMethod(http.MethodGet, c.handleStatus))
+	mux.HandleFunc("/api/import", onlyMethod(http.MethodPost, c.handleImport))
+	mux.HandleFunc("/api/pause", onlyMethod(http.MethodPost, c.handlePause))
+	mux.HandleFunc("/api/resume", onlyMethod(http.MethodPost, c.handleResume))
+	mux.HandleFunc("/api/stats", onlyMethod(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		if destDir == "" {
+			writeControlError(w, http.StatusNotImplemented, "library statistics need a local destination")
+			return
+		}
+		stats, err := collectStats(destDir)
+		if err != nil {
+			writeControlError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeControlJSON(w, http.StatusOK, stats)
+	}))
+	mux.HandleFunc("/api/imports", onlyMethod(http.MethodGet, c.handleImports))
+	mux.HandleFunc("/api/problems", onlyMethod(http.MethodGet, c.handleProblems))
+	mux.HandleFunc("/api/duplicates", onlyMethod(http.MethodGet, c.handleDuplicates))
+	mux.HandleFunc("/api/retry", onlyMethod(http.MethodPost, c.handleRetry))
+	mux.HandleFunc("/api/dismiss", onlyMethod(http.MethodPost, c.handleDismiss))
+	mux.HandleFunc("/api/thumbnail", onlyMethod(http.MethodGet, c.handleThumbnail))
+}
+
+// status returns a snapshot of the watch
+func (c *watchControl) status() controlStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status := controlStatus{State: controlWatching, Current: c.current, Counts: make(map[string]int, len(c.counts)), Queue: c.queue, Started: c.started}
+	switch {
+	case c.paused:
+		status.State = controlPaused
+	case c.current != "":
+		status.State = controlSorting
+	}
+	for outcome, count := range c.counts {
+		status.Counts[outcome] = count
+	}
+	if !c.lastPoll.IsZero() {
+		lastPoll := c.lastPoll
+		status.LastPoll = &lastPoll
+	}
+	return status
+}
+
+// isPaused reports whether sorting is paused; c may be nil when the control
+// API is off
+func (c *watchControl) isPaused() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// triggered returns the channel that receives requested polls, nil when the
+// control API is off so that it never fires
+func (c *watchControl) triggered() <-chan struct{} {
+	if c == nil {
+		return nil
+	}
+	return c.trigger
+}
+
+// sorting records the file being sorted, or none with an empty path
+func (c *watchControl) sorting(path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current = path
+}
+
+// sorted records the outcome counts after a file, with the transfers,
+// duplicates, and problems it added
+func (c *watchControl) sorted(counts map[string]int, destDir string, transfers []transfer, duplicates []fileDuplicate, problems []fileProblem) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for outcome, count := range counts {
+		c.counts[outcome] = count
+	}
+	for _, t := range transfers {
+		folder := filepath.Dir(t.dest)
+		if rel, err := filepath.Rel(destDir, folder); err == nil && !strings.HasPrefix(rel, "..") {
+			folder = rel
+		}
+		c.imports = append(c.imports, controlImport{Source: t.source, Dest: t.dest, Folder: filepath.ToSlash(folder), Time: time.Now()})
+	}
+	for _, d := range duplicates {
+		c.duplicates = append(c.duplicates, controlDuplicate{Path: d.path, Existing: d.existing})
+	}
+	c.problems = append(c.problems, problems...)
+	c.imports = lastEntries(c.imports, controlHistory)
+	c.duplicates = lastEntries(c.duplicates, controlHistory)
+	c.problems = lastEntries(c.problems, controlHistory)
+}
+
+// retries returns the problem files to try again, and forgets them
+func (c *watchControl) retries() []string {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	paths := c.retry
+	c.retry = nil
+	return paths
+}
+
+// lastEntries returns the last n entries of list
+func lastEntries[T any](list []T, n int) []T {
+	if len(list) <= n {
+		return list
+	}
+	return append([]T(nil), list[len(list)-n:]...)
+}
+
+// polled records the end of a poll with the files still waiting to settle
+func (c *watchControl) polled(queue int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queue = queue
+	c.lastPoll = time.Now()
+}
+
+func (c *watchControl) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeControlJSON(w, http.StatusOK, c.status())
+}
+
+// handleImport asks the watch loop to check the source now. Files found by
+// it are still sorted once they stop changing between two polls.
+func (c *watchControl) handleImport(w http.ResponseWriter, r *http.Request) {
+	if c.isPaused() {
+		writeControlError(w, http.StatusConflict, "sorting is paused")
+		return
+	}
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+		// A poll is already requested
+	}
+	slog.Info("Import requested through the control API")
+	writeControlJSON(w, http.StatusAccepted, c.status())
+}
+
+func (c *watchControl) handleImports(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeControlJSON(w, http.StatusOK, append([]controlImport{}, c.imports...))
+}
+
+func (c *watchControl) handleProblems(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeControlJSON(w, http.StatusOK, append([]fileProblem{}, c.problems...))
+}
+
+func (c *watchControl) handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeControlJSON(w, http.StatusOK, append([]controlDuplicate{}, c.duplicates...))
+}
+
+// handleRetry takes a problem file off the list and has the next poll sort
+// it again, e.g. after a full disk was cleared
+func (c *watchControl) handleRetry(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	c.mu.Lock()
+	found := c.removeProblem(path)
+	if found {
+		c.retry = append(c.retry, path)
+	}
+	c.mu.Unlock()
+	if !found {
+		writeControlError(w, http.StatusNotFound, "no such problem file")
+		return
+	}
+	slog.Info("Retry requested through the control API", "path", path)
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDismiss takes a file off the problem or duplicate list once it has
+// been dealt with
+func (c *watchControl) handleDismiss(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	c.mu.Lock()
+	found := c.removeProblem(path)
+	for i, d := range c.duplicates {
+		if d.Path == path {
+			c.duplicates = append(c.duplicates[:i:i], c.duplicates[i+1:]...)
+			found = true
+			break
+		}
+	}
+	c.mu.Unlock()
+	if !found {
+		writeControlError(w, http.StatusNotFound, "no such file")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeProblem takes a file off the problem list; c.mu must be held
+func (c *watchControl) removeProblem(path string) bool {
+	for i, p := range c.problems {
+		if p.Path == path {
+			c.problems = append(c.problems[:i:i], c.problems[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// handleThumbnail serves a small JPEG of a file on one of the lists, so the
+// dashboard can show what was imported and compare duplicates. Other paths
+// are refused, the endpoint does not serve arbitrary files.
+func (c *watchControl) handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	c.mu.Lock()
+	var candidates []string
+	for _, i := range c.imports {
+		if i.Dest == path || i.Source == path {
+			// A moved file is only at its destination now
+			candidates = []string{i.Dest, i.Source}
+		}
+	}
+	for _, d := range c.duplicates {
+		if d.Path == path || d.Existing == path {
+			candidates = []string{path}
+		}
+	}
+	for _, p := range c.problems {
+		if p.Path == path {
+			candidates = []string{path}
+		}
+	}
+	c.mu.Unlock()
+
+	for _, candidate := range candidates {
+		if thumb := c.sandbox.thumbnail(candidate); thumb != nil {
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Header().Set("Cache-Control", "private, max-age=3600")
+			w.Write(thumb)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func (c *watchControl) handlePause(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	changed := !c.paused
+	c.paused = true
+	c.mu.Unlock()
+	if changed {
+		slog.Info("Sorting paused through the control API")
+	}
+	writeControlJSON(w, http.StatusOK, c.status())
+}
+
+func (c *watchControl) handleResume(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	changed := c.paused
+	c.paused = false
+	c.mu.Unlock()
+	if changed {
+		slog.Info("Sorting resumed through the control API")
+		select {
+		case c.trigger <- struct{}{}:
+		default:
+		}
+	}
+	writeControlJSON(w, http.StatusOK, c.status())
+}
+
+// onlyMethod rejects requests to an endpoint with another method
+func onlyMethod(method string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeControlError(w, http.StatusMethodNotAllowed, "use "+method)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// writeControlJSON writes a JSON response
+func writeControlJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Debug("Could not write control API response", "error", err)
+	}
+}
+
+// writeControlError writes a JSON error response
+func writeControlError(w http.ResponseWriter, code int, message string) {
+	writeControlJSON(w, code, map[string]string{"error": message})
+}
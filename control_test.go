@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestControlAPIRequiresToken(t *testing.T) {
+	server := httptest.NewServer(controlHandler("s3cret", newWatchControl(), ""))
+	defer server.Close()
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "guess", http.StatusUnauthorized},
+		{"bearer token", "s3cret", http.StatusOK},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/api/status", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.want {
+			t.Errorf("%s: status %d, want %d", test.name, resp.StatusCode, test.want)
+		}
+	}
+}
+
+func TestControlAPIWithoutTokenOnlyOnLoopback(t *testing.T) {
+	for _, addr := range []string{":0", "0.0.0.0:0", "[::]:0", "192.0.2.1:0"} {
+		if err := startControlServer(addr, "", newWatchControl(), ""); err == nil {
+			t.Errorf("served the control API on %s without a token", addr)
+		}
+	}
+	for _, addr := range []string{"localhost:0", "127.0.0.1:0"} {
+		if err := startControlServer(addr, "", newWatchControl(), ""); err != nil {
+			t.Errorf("could not serve the control API on %s: %v", addr, err)
+		}
+	}
+}
+
+func TestIsLoopbackAddr(t *testing.T) {
+	tests := map[string]bool{
+		"localhost:8081": true,
+		"127.0.0.1:8081": true,
+		"127.1.2.3:8081": true,
+		"[::1]:8081":     true,
+		":8081":          false,
+		"0.0.0.0:8081":   false,
+		"nas.local:8081": false,
+		"192.0.2.1:8081": false,
+		"localhost":      false,
+	}
+	for addr, want := range tests {
+		if got := isLoopbackAddr(addr); got != want {
+			t.Errorf("isLoopbackAddr(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestControlAPIWithoutTokenRefusesOtherSites(t *testing.T) {
+	handler := controlHandler("", newWatchControl(), "")
+	tests := []struct {
+		name, method, host, origin string
+		want                       int
+	}{
+		{"status", http.MethodGet, "localhost:8081", "", http.StatusOK},
+		{"status by IP", http.MethodGet, "127.0.0.1:8081", "", http.StatusOK},
+		{"rebound name", http.MethodGet, "attacker.example:8081", "", http.StatusForbidden},
+		{"pause from the dashboard", http.MethodPost, "localhost:8081", "http://localhost:8081", http.StatusOK},
+		{"pause without origin", http.MethodPost, "localhost:8081", "", http.StatusForbidden},
+		{"pause from another site", http.MethodPost, "localhost:8081", "https://attacker.example", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		path := "/api/status"
+		if test.method == http.MethodPost {
+			path = "/api/pause"
+		}
+		req := httptest.NewRequest(test.method, "http://"+test.host+path, nil)
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != test.want {
+			t.Errorf("%s: status %d, want %d", test.name, rec.Code, test.want)
+		}
+	}
+}
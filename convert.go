@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// opConvert is reported by -output ndjson for files converted by -convert
+const opConvert = "convert"
+
+// defaultConvertQuality is the JPEG quality of converted files unless
+// -quality says otherwise
+const defaultConvertQuality = 90
+
+// tagOrientation is the EXIF tag telling viewers how to rotate an image
+const tagOrientation = 0x0112
+
+// supportedConversions lists the conversions -convert can make, from the
+// canonical source extension to the target extension
+var supportedConversions = map[string]string{
+	".heic": ".jpg",
+}
+
+// parseConversions parses a -convert value such as "heic=jpg" into a map
+// from canonical source extension to target extension. HEIF files count as
+// HEIC.
+func parseConversions(spec string) (map[string]string, error) {
+	conversions := make(map[string]string)
+	for _, part := range strings.Split(spec, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(part, "=")
+		from, to = canonicalExt(normalizeExtFlag(from)), canonicalExt(normalizeExtFlag(to))
+		if !ok || from == "." || to == "." {
+			return nil, fmt.Errorf("invalid -convert %q, expected FROM=TO (e.g., 'heic=jpg')", part)
+		}
+		if supportedConversions[from] != to {
+			return nil, fmt.Errorf("unsupported conversion %q, only 'heic=jpg' is supported", part)
+		}
+		conversions[from] = to
+	}
+	return conversions, nil
+}
+
+// heicConverter is an external tool that decodes HEIC files: libheif's
+// heif-convert, sips on macOS, or ImageMagick
+type heicConverter struct {
+	name string
+	args func(in, out string, quality int) []string
+}
+
+var heicConverters = []heicConverter{
+	{"heif-convert", func(in, out string, quality int) []string {
+		return []string{"-q", strconv.Itoa(quality), in, out}
+	}},
+	{"sips", func(in, out string, quality int) []string {
+		return []string{"-s", "format", "jpeg", "-s", "formatOptions", strconv.Itoa(quality), in, "--out", out}
+	}},
+	{"magick", func(in, out string, quality int) []string {
+		return []string{in, "-quality", strconv.Itoa(quality), out}
+	}},
+}
+
+// findHEICConverter returns the first HEIC converter installed
+func findHEICConverter() (heicConverter, error) {
+	for _, c := range heicConverters {
+		if _, err := exec.LookPath(c.name); err == nil {
+			return c, nil
+		}
+	}
+	return heicConverter{}, fmt.Errorf("-convert heic=jpg needs heif-convert (libheif), sips (macOS), or magick (ImageMagick)")
+}
+
+// convertToJPEG writes a JPEG of the HEIC file src to dst. The converter
+// writes into a temporary folder next to dst, as some also write depth maps
+// and other auxiliary images, and only the JPEG is moved into place. The
+// EXIF of src is carried over if the converter dropped it.
+func convertToJPEG(converter heicConverter, src, dst string, quality int) error {
+	temp, err := os.MkdirTemp(longPath(filepath.Dir(dst)), ".gopicsort-convert-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(temp)
+
+	out := filepath.Join(temp, "converted.jpg")
+	if _, err := runCommand(converter.name, converter.args(src, out, quality)...); err != nil {
+		return err
+	}
+	if err := carryExif(src, out); err != nil {
+		slog.Warn("Could not copy EXIF into converted file", "path", dst, "error", err)
+	}
+	return os.Rename(out, longPath(dst))
+}
+
+// carryExif inserts the EXIF of a HEIC file into a JPEG converted from it,
+// unless the converter kept it. Converters apply the rotation of the HEIC
+// to the pixels, so the copied Orientation tag is reset to upright.
+func carryExif(heic, jpegPath string) error {
+	data, err := os.ReadFile(jpegPath)
+	if err != nil {
+		return err
+	}
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return fmt.Errorf("converter did not write a JPEG")
+	}
+	if tiff, err := findExifTIFF(data); err != nil || tiff != nil {
+		return err
+	}
+
+	file, err := os.Open(heic)
+	if err != nil {
+		return err
+	}
+	tiff, err := heifExif(file)
+	file.Close()
+	if err == errNoExif {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	if len(payload)+2 > 0xFFFF {
+		return fmt.Errorf("EXIF too large for a JPEG segment")
+	}
+	resetOrientation(payload[6:])
+
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	out := make([]byte, 0, len(data)+len(segment)+len(payload))
+	out = append(out, data[:2]...)
+	out = append(out, segment...)
+	out = append(out, payload...)
+	out = append(out, data[2:]...)
+	return os.WriteFile(jpegPath, out, 0644)
+}
+
+// resetOrientation sets the Orientation tag in IFD0 of a TIFF structure, if
+// there is one, to 1 (upright)
+func resetOrientation(tiff []byte) {
+	if len(tiff) < 8 {
+		return
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return
+	}
+	offset := int(order.Uint32(tiff[4:]))
+	if offset+2 > len(tiff) {
+		return
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return
+		}
+		// Orientation is a SHORT stored in the entry itself
+		if order.Uint16(tiff[entry:]) == tagOrientation && order.Uint16(tiff[entry+2:]) == 3 {
+			order.PutUint16(tiff[entry+8:], 1)
+			return
+		}
+	}
+}
+
+// convert writes the converted copy of path to destPath, leaving an existing
+// file alone like copyFile does, and, with -keep-original, sorts the original to originalPath next to it. With -move
+// the source is removed once the converted copy is written, or moved to
+// originalPath when the original is kept.
+func (s *sorter) convert(path, destPath, originalPath string) error {
+	if _, err := os.Stat(longPath(destPath)); err == nil {
+		slog.Info("Skipping: file already exists at destination", "path", destPath)
+		return nil
+	}
+	if err := convertToJPEG(s.converter, path, destPath, s.convertQuality); err != nil {
+		return fmt.Errorf("failed to convert %s to %s: %v", path, destPath, err)
+	}
+	slog.Info("Converted", "source", path, "dest", destPath)
+
+	switch {
+	case s.keepOriginal && s.moveFiles:
+		if err := moveFile(s.fsys, path, originalPath); err != nil {
+			return fmt.Errorf("failed to move %s to %s: %v", path, originalPath, err)
+		}
+		slog.Info("Moved", "source", path, "dest", originalPath)
+	case s.keepOriginal:
+		if err := copyFile(s.fsys, path, originalPath); err != nil {
+			return fmt.Errorf("failed to copy %s to %s: %v", path, originalPath, err)
+		}
+		slog.Info("Copied", "source", path, "dest", originalPath)
+	case s.moveFiles:
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove converted source %s: %v", path, err)
+		}
+	}
+	if s.moveFiles {
+		s.movedFrom[filepath.Dir(path)] = true
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// dashboardCookie remembers the dashboard token after the first visit with
+// ?token=
+const dashboardCookie = "gopicsort_dashboard"
+
+// startDashboardServer serves the web dashboard enabled by -http in the
+// background: a page for watching a headless watcher from a browser, backed
+// by the control API endpoints. With a token, the page must be opened once
+// with ?token=, which is then remembered in a cookie; without one, the
+// dashboard is only served on a loopback address.
+func startDashboardServer(addr, token string, c *watchControl, destDir string) error {
+	if token == "" && !isLoopbackAddr(addr) {
+		return fmt.Errorf("%s is reachable from other machines, set -http-token or listen on localhost", addr)
+	}
+	server := &http.Server{Addr: addr, Handler: dashboardHandler(token, c, destDir), ReadHeaderTimeout: 30 * time.Second}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("Dashboard listening", "addr", listener.Addr().String())
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("Dashboard stopped", "error", err)
+		}
+	}()
+	return nil
+}
+
+// dashboardHandler returns the dashboard page and the control API endpoints,
+// requiring token when it is set
+func dashboardHandler(token string, c *watchControl, destDir string) http.Handler {
+	mux := http.NewServeMux()
+	c.routes(mux, destDir)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		if token != "" {
+			rememberToken(w, r, token, dashboardCookie)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, dashboardPage)
+	})
+	if token == "" {
+		return guardLoopback(mux)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hasToken(r, token, dashboardCookie) {
+			http.Error(w, "Open this page with ?token=... to sign in", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// dashboardPage polls the control API and renders the progress, the recent
+// imports by folder, the problem files, and the duplicates to review
+const dashboardPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>GoPicSort</title>
+<style>
+body { font-family: sans-serif; max-width: 1100px; margin: 1em auto; padding: 0 1em; color: #222; }
+header { display: flex; align-items: center; gap: 1em; flex-wrap: wrap; }
+h1 { margin: 0; font-size: 1.4em; }
+.state { padding: 0.2em 0.6em; border-radius: 1em; background: #ddd; }
+.state.sorting { background: #cfe8cf; }
+.state.paused { background: #f5e0b0; }
+.counts span { margin-right: 1em; }
+.current { color: #666; font-size: 0.9em; overflow-wrap: anywhere; }
+.files { display: flex; flex-wrap: wrap; gap: 8px; }
+figure { margin: 0; width: 140px; text-align: center; font-size: 0.75em; overflow-wrap: anywhere; }
+figure img, figure .none { width: 140px; height: 105px; object-fit: contain; background: #eee; display: block; }
+.pair { display: flex; gap: 8px; align-items: flex-start; margin-bottom: 1em; }
+table { border-collapse: collapse; width: 100%; font-size: 0.9em; }
+td { border-top: 1px solid #ddd; padding: 0.3em; vertical-align: top; overflow-wrap: anywhere; }
+.error { color: #a33; }
+button { cursor: pointer; }
+</style>
+</head>
+<body>
+<header>
+<h1>GoPicSort</h1>
+<span id="state" class="state">connecting</span>
+<button id="import">Import now</button>
+<button id="pause">Pause</button>
+</header>
+<p class="counts" id="counts"></p>
+<p class="current" id="current"></p>
+
+<h2>Needs Attention</h2>
+<table id="problems"></table>
+
+<h2>Duplicates to Review</h2>
+<p>These files were not imported because the library already has them.</p>
+<div id="duplicates"></div>
+
+<h2>Recent Imports</h2>
+<div id="imports"></div>
+
+<script>
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs || {})) {
+    if (k.startsWith("on")) node.addEventListener(k.slice(2), v); else node.setAttribute(k, v);
+  }
+  for (const child of children) node.append(child);
+  return node;
+}
+function base(path) { return path.split(/[\\/]/).pop(); }
+function thumb(path, caption) {
+  const img = el("img", {src: "api/thumbnail?path=" + encodeURIComponent(path), alt: base(path), loading: "lazy"});
+  img.onerror = () => img.replaceWith(el("div", {class: "none"}));
+  return el("figure", {title: path}, img, el("figcaption", {}, caption || base(path)));
+}
+async function post(url) {
+  await fetch(url, {method: "POST"});
+  refresh();
+}
+async function get(url) {
+  const response = await fetch(url);
+  if (!response.ok) throw new Error(response.statusText);
+  return response.json();
+}
+
+let paused = false;
+document.getElementById("import").onclick = () => post("api/import");
+document.getElementById("pause").onclick = () => post(paused ? "api/resume" : "api/pause");
+
+let shown = {};
+function changed(key, data) {
+  const json = JSON.stringify(data);
+  if (shown[key] === json) return false;
+  shown[key] = json;
+  return true;
+}
+
+async function refresh() {
+  try {
+    const [status, imports, problems, duplicates] = await Promise.all(
+      ["api/status", "api/imports", "api/problems", "api/duplicates"].map(get));
+
+    paused = status.state === "paused";
+    const state = document.getElementById("state");
+    state.textContent = status.state;
+    state.className = "state " + status.state;
+    document.getElementById("pause").textContent = paused ? "Resume" : "Pause";
+    document.getElementById("import").disabled = paused;
+    const counts = document.getElementById("counts");
+    counts.replaceChildren(...Object.entries(status.counts).sort().map(([k, v]) => el("span", {}, k + ": " + v)),
+      el("span", {}, "waiting: " + status.queue));
+    document.getElementById("current").textContent = status.current ? "Sorting " + status.current :
+      status.last_poll ? "Last checked " + new Date(status.last_poll).toLocaleTimeString() : "";
+
+    if (changed("problems", problems)) {
+      const table = document.getElementById("problems");
+      table.replaceChildren(...problems.slice().reverse().map(p => el("tr", {},
+        el("td", {}, p.path),
+        el("td", {}, p.outcome, p.error ? el("div", {class: "error"}, p.error) : ""),
+        el("td", {},
+          el("button", {onclick: () => post("api/retry?path=" + encodeURIComponent(p.path))}, "Retry"), " ",
+          el("button", {onclick: () => post("api/dismiss?path=" + encodeURIComponent(p.path))}, "Dismiss")))));
+      if (!problems.length) table.replaceChildren(el("tr", {}, el("td", {}, "Nothing to follow up.")));
+    }
+
+    if (changed("duplicates", duplicates)) {
+      const list = document.getElementById("duplicates");
+      list.replaceChildren(...duplicates.slice().reverse().map(d => el("div", {class: "pair"},
+        thumb(d.path, "New: " + base(d.path)),
+        thumb(d.existing, "In library: " + base(d.existing)),
+        el("button", {onclick: () => post("api/dismiss?path=" + encodeURIComponent(d.path))}, "Dismiss"))));
+    }
+
+    if (changed("imports", imports)) {
+      const folders = new Map();
+      for (const i of imports.slice().reverse()) {
+        if (!folders.has(i.folder)) folders.set(i.folder, []);
+        folders.get(i.folder).push(i);
+      }
+      const list = document.getElementById("imports");
+      list.replaceChildren(...[...folders].map(([folder, files]) => el("section", {},
+        el("h3", {}, folder + " (" + files.length + ")"),
+        el("div", {class: "files"}, ...files.map(i => thumb(i.dest))))));
+      if (!imports.length) list.replaceChildren(el("p", {}, "Nothing imported yet."));
+    }
+  } catch (err) {
+    const state = document.getElementById("state");
+    state.textContent = "disconnected";
+    state.className = "state";
+  }
+}
+refresh();
+setInterval(refresh, 3000);
+</script>
+</body>
+</html>
+`
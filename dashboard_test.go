@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDashboardRemembersToken(t *testing.T) {
+	server := httptest.NewServer(dashboardHandler("s3cret", newWatchControl(), ""))
+	defer server.Close()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Jar: jar}
+
+	get := func(path string) int {
+		t.Helper()
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := get("/api/status"); code != http.StatusUnauthorized {
+		t.Errorf("status without token: %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := get("/?token=guess"); code != http.StatusUnauthorized {
+		t.Errorf("page with wrong token: %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := get("/?token=s3cret"); code != http.StatusOK {
+		t.Errorf("page with token: %d, want %d", code, http.StatusOK)
+	}
+	// The cookie set by the first visit signs in later requests
+	if code := get("/api/status"); code != http.StatusOK {
+		t.Errorf("status after signing in: %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestDashboardWithoutTokenOnlyOnLoopback(t *testing.T) {
+	if err := startDashboardServer(":0", "", newWatchControl(), ""); err == nil {
+		t.Error("served the dashboard on every interface without a token")
+	}
+	if err := startDashboardServer("localhost:0", "", newWatchControl(), ""); err != nil {
+		t.Errorf("could not serve the dashboard on localhost: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Sources of the capture date accepted by -date-sources
+const (
+	// dateSourceExif is the EXIF date, in -date-tags order
+	dateSourceExif = "exif"
+	// dateSourceXMP is an XMP packet embedded in the file
+	dateSourceXMP = "xmp"
+	// dateSourceContainer is a date the file format records outside EXIF:
+	// the PNG creation time, the MP4/MOV header, or a DJI flight log
+	dateSourceContainer = "container"
+	// dateSourceTakeout is the JSON sidecar of a Google Takeout export
+	dateSourceTakeout = "takeout"
+	// dateSourceFilename is a date in the file name, e.g. IMG_20230714_153012
+	dateSourceFilename = "filename"
+	// dateSourceMtime is the file's modification time
+	dateSourceMtime = "mtime"
+)
+
+// defaultDateSources is the date source order unless -date-sources says
+// otherwise; file names and modification times are often wrong, so they are
+// only used when asked for
+const defaultDateSources = "exif,xmp,container,takeout"
+
+// Policies for files without a capture date, set by -no-date
+const (
+	noDateSkip     = "skip"
+	noDateUnsorted = "unsorted-folder"
+	noDateFail     = "fail"
+)
+
+// unsortedDirName is the folder inside the destination that -no-date
+// unsorted-folder collects undated files in
+const unsortedDirName = "unsorted"
+
+// parseDateSources parses a comma-separated list of date sources
+func parseDateSources(value string) ([]string, error) {
+	var sources []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "":
+			continue
+		case dateSourceExif, dateSourceXMP, dateSourceContainer, dateSourceTakeout, dateSourceFilename, dateSourceMtime:
+			sources = append(sources, name)
+		default:
+			return nil, fmt.Errorf("unknown date source %q, expected exif, xmp, container, takeout, filename, or mtime", name)
+		}
+	}
+	if len(sources) == 0 {
+		return nil, errors.New("no date sources given")
+	}
+	return sources, nil
+}
+
+// validateNoDate checks the -no-date flag value
+func validateNoDate(policy string) error {
+	switch policy {
+	case noDateSkip, noDateUnsorted, noDateFail:
+		return nil
+	default:
+		return fmt.Errorf("invalid -no-date %q, expected 'skip', 'unsorted-folder', or 'fail'", policy)
+	}
+}
+
+// captureDate returns the capture date of a file from the first of the
+// -date-sources that has one, and the name of that source. The error is the
+// EXIF error when no source has a date.
+func (s *sorter) captureDate(path string, info os.FileInfo) (time.Time, string, error) {
+	exifErr := errors.New("no capture date")
+	for _, source := range s.dateSources {
+		switch source {
+		case dateSourceExif:
+			date, err := s.cache.exifDate(s.fsys, path, info)
+			if err == nil {
+				return date, source, nil
+			}
+			exifErr = err
+		case dateSourceXMP:
+			// RAW files and edited TIFFs may carry the date only in XMP
+			if date, ok := getXMPDate(path); ok {
+				slog.Debug("Using XMP capture time", "path", path)
+				return date, source, nil
+			}
+		case dateSourceContainer:
+			if date, ok := pngCreationTime(path); ok {
+				slog.Debug("Using PNG creation time", "path", path)
+				return date, source, nil
+			}
+			// DJI drones keep the local capture time in the flight log and file name
+			if date, ok := djiCaptureTime(path); ok {
+				slog.Debug("Using DJI capture time", "path", path)
+				return date, source, nil
+			}
+			// Videos carry their recording time in the movie header
+			if isVideoFile(s.fileExt(path)) {
+				if date, err := videoCreationTime(path); err == nil {
+					slog.Debug("Using video recording time", "path", path)
+					return date, source, nil
+				}
+			}
+		case dateSourceTakeout:
+			// Google Takeout keeps the capture time in a JSON sidecar when EXIF lacks it
+			if s.takeout == nil {
+				continue
+			}
+			if sidecar := s.takeout.find(path); sidecar != "" {
+				if date, ok := takeoutDate(sidecar); ok {
+					slog.Debug("Using Takeout capture time", "path", path, "sidecar", sidecar)
+					return date, source, nil
+				}
+			}
+		case dateSourceFilename:
+			if date, ok := dateFromName(filepath.Base(path)); ok {
+				slog.Debug("Using date from file name", "path", path)
+				return date, source, nil
+			}
+		case dateSourceMtime:
+			slog.Debug("Using modification time", "path", path)
+			return info.ModTime(), source, nil
+		}
+	}
+	return time.Time{}, "", exifErr
+}
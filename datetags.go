@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// defaultDateTags is the EXIF tag lookup order for the capture date
+const defaultDateTags = "DateTimeOriginal,DateTime"
+
+// dateTagNames maps the accepted -date-tags names to EXIF fields
+var dateTagNames = map[string]exif.FieldName{
+	"datetimeoriginal":  exif.DateTimeOriginal,
+	"datetimedigitized": exif.DateTimeDigitized,
+	"datetime":          exif.DateTime,
+}
+
+// dateTags is the order in which EXIF tags are tried for the capture date,
+// set from -date-tags
+var dateTags = []exif.FieldName{exif.DateTimeOriginal, exif.DateTime}
+
+// parseDateTags parses a comma-separated list of EXIF date tag names
+func parseDateTags(value string) ([]exif.FieldName, error) {
+	var tags []exif.FieldName
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		tag, ok := dateTagNames[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown date tag %q, expected DateTimeOriginal, DateTimeDigitized, or DateTime", name)
+		}
+		tags = append(tags, tag)
+	}
+	if len(tags) == 0 {
+		return nil, errors.New("no date tags given")
+	}
+	return tags, nil
+}
+
+// exifCaptureDate returns the first date found among dateTags. Dates are
+// camera wall clock times, in the time zone recorded by Canon cameras or the
+// local one.
+func exifCaptureDate(x *exif.Exif) (time.Time, error) {
+	var lastErr error
+	for _, name := range dateTags {
+		tag, err := x.Get(name)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if tag.Format() != tiff.StringVal {
+			lastErr = fmt.Errorf("%s not in string format", name)
+			continue
+		}
+		value := strings.TrimSpace(strings.TrimRight(string(tag.Val), "\x00"))
+		zone := time.Local
+		if tz, _ := x.TimeZone(); tz != nil {
+			zone = tz
+		}
+		date, err := time.ParseInLocation(exifDateFormat, value, zone)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return date, nil
+	}
+	return time.Time{}, lastErr
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// contentIndex finds files with identical contents in the destination. Files
+// are indexed by size and only hashed when another file of the same size
+// shows up, so a large library is not hashed up front.
+type contentIndex struct {
+	fsys   fileSystem
+	bySize map[int64][]string
+	hashes map[string]string // path -> SHA-256, filled lazily
+	// cache keeps hashes between runs, may be nil
+	cache *metadataCache
+	// stills compares motion photos by their still image, as
+	// -split-motion-photos leaves them in the destination
+	stills bool
+}
+
+// isToolFolder reports whether a library folder holds GoPicSort's state,
+// quarantined files, moved duplicates, trash, or previews rather than photos
+// of the library
+func isToolFolder(name string) bool {
+	switch name {
+	case stateDirName, quarantineDirName, duplicatesDirName, trashDirName, previewsDirName:
+		return true
+	}
+	return false
+}
+
+// newContentIndex indexes the regular files below root on fsys, skipping
+// GoPicSort's own folders. Hashes from the catalog of an adopted library are
+// reused for files that have not changed since.
+func newContentIndex(fsys fileSystem, root string) (*contentIndex, error) {
+	ix := &contentIndex{fsys: fsys, bySize: make(map[int64][]string), hashes: make(map[string]string)}
+	catalog, err := loadCatalog(root)
+	if err != nil {
+		return nil, err
+	}
+	err = walkFileSystem(fsys, root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			if path != root && isToolFolder(info.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			ix.bySize[info.Size()] = append(ix.bySize[info.Size()], path)
+			rel, _ := filepath.Rel(root, path)
+			if entry, ok := catalog[filepath.ToSlash(rel)]; ok && entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime()) {
+				ix.hashes[path] = entry.SHA256
+			}
+		}
+		return nil
+	})
+	return ix, err
+}
+
+// hash returns the cached content hash of an indexed path
+func (ix *contentIndex) hash(path string) (string, error) {
+	if h, ok := ix.hashes[path]; ok {
+		return h, nil
+	}
+	h, err := ix.cache.hashFile(ix.fsys, path)
+	if err != nil {
+		return "", err
+	}
+	ix.hashes[path] = h
+	return h, nil
+}
+
+// find returns an indexed file with the same contents as path, or "" if there is none
+func (ix *contentIndex) find(path string, size int64) (string, error) {
+	hash := func() (string, error) { return ix.cache.hashFile(ix.fsys, path) }
+	if ix.stills {
+		if mp, ok := findMotionPhoto(path); ok {
+			size = mp.stillEnd
+			hash = func() (string, error) { return hashMotionPhotoStill(ix.fsys, path, mp) }
+		}
+	}
+	candidates := ix.bySize[size]
+	if len(candidates) == 0 {
+		return "", nil
+	}
+	h, err := hash()
+	if err != nil {
+		return "", err
+	}
+	for _, candidate := range candidates {
+		if candidate == path {
+			continue
+		}
+		ch, err := ix.hash(candidate)
+		if err != nil {
+			continue
+		}
+		if ch == h {
+			return candidate, nil
+		}
+	}
+	return "", nil
+}
+
+// add records a file that was written to the destination
+func (ix *contentIndex) add(path string, size int64) {
+	ix.bySize[size] = append(ix.bySize[size], path)
+}
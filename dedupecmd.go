@@ -0,0 +1,186 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Policies accepted by dedupe -policy
+const (
+	dedupeReport = "report"
+	dedupeLink   = "link"
+	dedupeMove   = "move"
+	dedupeDelete = "delete"
+)
+
+// duplicatesDirName is the folder of a library that "dedupe -policy move"
+// moves extra copies into, keeping their path within the library
+const duplicatesDirName = "duplicates"
+
+// validateDedupePolicy checks the dedupe -policy flag value
+func validateDedupePolicy(policy string) error {
+	switch policy {
+	case dedupeReport, dedupeLink, dedupeMove, dedupeDelete:
+		return nil
+	default:
+		return fmt.Errorf("invalid -policy %q, expected 'report', 'link', 'move', or 'delete'", policy)
+	}
+}
+
+// runDedupe implements the "dedupe" subcommand, which finds byte-identical
+// files across the folders of a sorted library and reports them, hard-links
+// them together, moves the extra copies aside, or deletes them. One copy of
+// each group is kept, the one with the oldest modification time.
+func runDedupe(args []string) {
+	fs := flag.NewFlagSet("dedupe", flag.ExitOnError)
+	destDir := fs.String("dest", "", "Sorted photo library")
+	policy := fs.String("policy", dedupeReport, "What to do with duplicates: 'report' them, 'link' them to the kept copy, 'move' them to the duplicates folder, or 'delete' them")
+	trashMode := fs.String("trash", trashFolder, "Where -policy delete puts removed files: the library's trash 'folder', the 'os' trash, or 'off' to delete them permanently")
+	trashDir := fs.String("trash-dir", "", "Trash folder for -trash folder (default .gopicsort-trash in the library)")
+	trashDays := fs.Int("trash-days", defaultTrashDays, "Days the trash folder keeps removed files, 0 to keep them until removed by hand")
+	logOpts := addLogFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s dedupe -dest DIR [-policy report|link|move|delete]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	parseInterspersed(fs, args)
+	if *destDir == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := logOpts.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := validateDedupePolicy(*policy); err != nil {
+		fatal(err.Error())
+	}
+	if err := validateTrashMode(*trashMode); err != nil {
+		fatal(err.Error())
+	}
+
+	groups, err := findDuplicates(*destDir)
+	if err != nil {
+		fatal("Failed to scan library", "error", err)
+	}
+	if *policy == dedupeReport {
+		var files int
+		var reclaimable int64
+		for _, group := range groups {
+			keep, extras := oldestCopy(group)
+			for _, extra := range extras {
+				slog.Info("Duplicate", "path", extra, "same_as", keep)
+			}
+			files += len(extras)
+			reclaimable += int64(len(group.dupes)) * group.size
+		}
+		slog.Info("Scan finished, run with -policy link, move, or delete to remove duplicates", "duplicates", files, "reclaimable", formatSize(reclaimable))
+		return
+	}
+
+	catalog, err := loadRetention(*destDir)
+	if err != nil {
+		fatal("Failed to read retention catalog", "error", err)
+	}
+	runID := newRunID()
+	started := time.Now()
+	var trash *trashCan
+	if *policy == dedupeDelete {
+		trash = newTrashCan(*trashMode, *trashDir, *destDir, runID, *trashDays)
+	}
+	changed := 0
+	for _, group := range groups {
+		keep, extras := oldestCopy(group)
+		keepInfo, err := os.Stat(keep)
+		if err != nil {
+			slog.Warn("Could not read file", "path", keep, "error", err)
+			continue
+		}
+		for _, extra := range extras {
+			if err := catalog.check(extra); err != nil {
+				slog.Warn("Not changing file", "path", extra, "error", err)
+				continue
+			}
+			if isLocked(filepath.Dir(extra)) {
+				slog.Warn("Not changing file in a locked folder, run 'gopicsort unlock' first", "path", extra)
+				continue
+			}
+			switch *policy {
+			case dedupeLink:
+				if info, err := os.Stat(extra); err == nil && os.SameFile(keepInfo, info) {
+					continue
+				}
+				err = replaceWithLink(keep, extra)
+			case dedupeMove:
+				err = moveDuplicate(*destDir, extra)
+			case dedupeDelete:
+				err = trash.discard(extra)
+			}
+			if err != nil {
+				slog.Warn("Could not "+*policy+" duplicate", "path", extra, "error", err)
+				continue
+			}
+			slog.Info("Deduplicated", "policy", *policy, "path", extra, "kept", keep)
+			changed++
+		}
+	}
+
+	record := runRecord{
+		RunID:    runID,
+		Machine:  machineName(),
+		Command:  "dedupe",
+		Started:  started,
+		Finished: time.Now(),
+		Dest:     *destDir,
+		Files:    changed,
+	}
+	if err := appendRunHistory(*destDir, record); err != nil {
+		slog.Warn("Could not record run history", "error", err)
+	}
+	slog.Info("Deduplication finished", "policy", *policy, "files", changed)
+	if trash != nil && trash.mode == trashFolder && changed > 0 {
+		slog.Info("Deleted duplicates are in the trash folder", "path", filepath.Join(trash.dir, runID))
+	}
+}
+
+// oldestCopy picks the copy of a duplicate group to keep, the one with the
+// oldest modification time or the first by path among equally old ones, and
+// returns it with the other names of the group
+func oldestCopy(group duplicateGroup) (string, []string) {
+	names := append([]string{group.keep}, group.dupes...)
+	names = append(names, group.linked...)
+	keep, oldest := 0, time.Time{}
+	for i, name := range names {
+		info, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		if oldest.IsZero() || info.ModTime().Before(oldest) || (info.ModTime().Equal(oldest) && name < names[keep]) {
+			keep, oldest = i, info.ModTime()
+		}
+	}
+	extras := append(append([]string(nil), names[:keep]...), names[keep+1:]...)
+	return names[keep], extras
+}
+
+// moveDuplicate moves an extra copy into the library's duplicates folder,
+// under the same path it had in the library
+func moveDuplicate(destDir, path string) error {
+	rel, err := filepath.Rel(destDir, path)
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(destDir, duplicatesDirName, rel)
+	if _, err := os.Stat(target); err == nil {
+		return fmt.Errorf("%s already exists", target)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return os.Rename(path, target)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// gphoto2 lists its folders as "There are 2 files in folder '/store_00010001/DCIM/100CANON':"
+// and the files in them as "#1     IMG_0001.JPG   rd  2671 KB image/jpeg 1689345612"
+var (
+	gphotoFolderPattern = regexp.MustCompile(`^There (?:is|are) \d+ files? in folder '(.+)'`)
+	gphotoFilePattern   = regexp.MustCompile(`^#(\d+)\s+(\S+)`)
+)
+
+// deviceFile is a file on a camera or phone, addressed like gphoto2 does by
+// folder and its number within the folder
+type deviceFile struct {
+	folder string
+	index  int
+	name   string
+}
+
+// gphoto runs gphoto2 commands against one camera or phone
+type gphoto struct {
+	camera string
+	port   string
+}
+
+// command returns a gphoto2 command selecting the device, if one was given
+func (g gphoto) command(args ...string) *exec.Cmd {
+	var selected []string
+	if g.camera != "" {
+		selected = append(selected, "--camera", g.camera)
+	}
+	if g.port != "" {
+		selected = append(selected, "--port", g.port)
+	}
+	return exec.Command("gphoto2", append(selected, args...)...)
+}
+
+// run runs a gphoto2 command and returns its output
+func (g gphoto) run(args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := g.command(args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("gphoto2 %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// listFiles lists the media files on the device
+func (g gphoto) listFiles() ([]deviceFile, error) {
+	out, err := g.run("--list-files")
+	if err != nil {
+		return nil, err
+	}
+	var files []deviceFile
+	folder := ""
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := gphotoFolderPattern.FindStringSubmatch(line); m != nil {
+			folder = m[1]
+			continue
+		}
+		if m := gphotoFilePattern.FindStringSubmatch(line); m != nil && folder != "" {
+			index, _ := strconv.Atoi(m[1])
+			files = append(files, deviceFile{folder: folder, index: index, name: m[2]})
+		}
+	}
+	return files, scanner.Err()
+}
+
+// download copies every file of a device folder into dir
+func (g gphoto) download(folder, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	_, err := g.run("--folder", folder, "--no-recurse", "--get-all-files", "--force-overwrite", "--filename", filepath.Join(dir, "%f.%C"))
+	return err
+}
+
+// delete removes files of one folder from the device. gphoto2 numbers the
+// files of a folder in order, so they are deleted from the highest number
+// down to keep the other numbers valid.
+func (g gphoto) delete(files []deviceFile) error {
+	sort.Slice(files, func(i, j int) bool { return files[i].index > files[j].index })
+	for _, f := range files {
+		if _, err := g.run("--folder", f.folder, "--delete-file", strconv.Itoa(f.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runImportDevice implements the "import-device" subcommand: download the
+// photos and videos of a camera or phone connected over MTP or PTP using
+// gphoto2, sort them into the library, verify every copy, and optionally
+// delete the imported files from the device
+func runImportDevice(args []string) {
+	fs := flag.NewFlagSet("import-device", flag.ExitOnError)
+	destDir := fs.String("dest", "", "Destination directory for sorted photos")
+	camera := fs.String("camera", "", "Camera model to import from, as listed by 'gphoto2 --auto-detect' (default the only connected device)")
+	port := fs.String("port", "", "Port of the device to import from, e.g. 'usb:001,005'")
+	renameTemplate := fs.String("rename", "", "Template for destination file names")
+	videos := fs.Bool("videos", true, "Also import MP4 and MOV videos")
+	deleteImported := fs.Bool("delete", false, "Delete imported files from the device after they have been verified")
+	onConflict := fs.String("on-conflict", conflictFail, "What to do when another run holds the destination's lock: 'fail', 'wait', or 'warn' to run anyway")
+	notifyURL := fs.String("notify", "", "Webhook URL to notify when the import finishes")
+	notifyFormat := fs.String("notify-format", notifyGeneric, "Notification payload: 'generic' JSON, 'slack', 'discord', or 'ntfy'")
+	logOpts := addLogFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s import-device -dest DIR [options]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	positional := parseInterspersed(fs, args)
+	if *destDir == "" || len(positional) != 0 {
+		fs.Usage()
+		os.Exit(exitFatal)
+	}
+	if err := logOpts.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitFatal)
+	}
+	if err := validateConflictPolicy(*onConflict); err != nil {
+		fatal(err.Error())
+	}
+	if err := validateNotifyFormat(*notifyFormat); err != nil {
+		fatal(err.Error())
+	}
+	if _, err := exec.LookPath("gphoto2"); err != nil {
+		fatal("import-device needs gphoto2 (http://www.gphoto.org) to talk to cameras and phones")
+	}
+	includeVideos = *videos
+
+	device := gphoto{camera: *camera, port: *port}
+	files, err := device.listFiles()
+	if err != nil {
+		fatal("Could not list files on device", "error", err)
+	}
+	folders := make(map[string][]deviceFile)
+	for _, f := range files {
+		if isValidFileFormat(filepath.Ext(f.name), nil) {
+			folders[f.folder] = append(folders[f.folder], f)
+		}
+	}
+	if len(folders) == 0 {
+		slog.Info("No photos on device")
+		return
+	}
+
+	s, err := newSorter("import-device", *destDir)
+	if err != nil {
+		fatal("Failed to start import", "error", err)
+	}
+	s.notify = newNotifier(*notifyURL, *notifyFormat)
+	if *renameTemplate != "" {
+		if s.rename, err = parseRenameTemplate(*renameTemplate); err != nil {
+			fatal("Invalid -rename template", "error", err)
+		}
+	}
+
+	// Files are downloaded into the library's state folder first, on the
+	// same disk as the library, and sorted from there
+	staging := filepath.Join(*destDir, stateDirName, "device-"+s.runID)
+	fail := func(msg string, args ...any) {
+		os.RemoveAll(staging)
+		fatal(msg, args...)
+	}
+	release, err := s.claimSession(*onConflict)
+	if err != nil {
+		fatal("Failed to start import", "error", err)
+	}
+	onDevice := make(map[string]deviceFile)
+	for folder, listed := range folders {
+		dir := filepath.Join(staging, sanitizeFolderName(strings.TrimPrefix(folder, "/")))
+		slog.Info("Downloading from device", "folder", folder, "files", len(listed))
+		if err := device.download(folder, dir); err != nil {
+			release()
+			fail("Could not download files from device", "folder", folder, "error", err)
+		}
+		for _, f := range listed {
+			onDevice[filepath.Join(dir, f.name)] = f
+		}
+	}
+
+	s.sourceDirs = []string{staging}
+	err = s.run()
+	release()
+	if err != nil {
+		s.notify.send(eventRunFinished, s.result(err))
+		fail("Error importing from device", "error", err)
+	}
+
+	// Only files whose copy matches the download are deleted from the device
+	verified, failed := verifyTransfers(s.transfers)
+	slog.Info("Verified import", "imported", len(s.transfers), "verified", len(verified), "failed", len(failed))
+	if *deleteImported {
+		if len(failed) > 0 || s.failures() > 0 {
+			slog.Warn("Not deleting from device because some files could not be imported or verified")
+		} else {
+			byFolder := make(map[string][]deviceFile)
+			for _, path := range verified {
+				if f, ok := onDevice[path]; ok {
+					byFolder[f.folder] = append(byFolder[f.folder], f)
+				}
+			}
+			deleted := 0
+			for folder, files := range byFolder {
+				if err := device.delete(files); err != nil {
+					slog.Warn("Could not delete files from device", "folder", folder, "error", err)
+					continue
+				}
+				deleted += len(files)
+			}
+			slog.Info("Deleted imported files from device", "files", deleted)
+		}
+	}
+
+	result := s.result(nil)
+	if len(failed) > 0 {
+		result.Status, result.ExitCode = "partial", exitPartial
+	}
+	s.notify.send(eventRunFinished, result)
+	os.RemoveAll(staging)
+	if result.ExitCode != exitOK {
+		os.Exit(result.ExitCode)
+	}
+}
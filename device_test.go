@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// useFakeGphoto puts a gphoto2 on the PATH that lists one folder of a
+// camera, downloads files holding their names, and records the files it is
+// asked to delete in the returned log
+func useFakeGphoto(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake gphoto2 is a shell script")
+	}
+	bin := t.TempDir()
+	deleted := filepath.Join(bin, "deleted")
+	script := `#!/bin/sh
+case "$*" in
+*--list-files*)
+	echo "There are 3 files in folder '/store_00010001/DCIM/100CANON':"
+	echo "#1     IMG_20210506_070809.JPG  rd  2671 KB image/jpeg 1689345612"
+	echo "#2     IMG_20210507_070809.JPG  rd  2671 KB image/jpeg 1689345612"
+	echo "#3     NOTES.TXT                rd     1 KB text/plain 1689345612"
+	;;
+*--get-all-files*)
+	while [ "$1" != "--filename" ]; do shift; done
+	dir=$(dirname "$2")
+	echo may > "$dir/IMG_20210506_070809.JPG"
+	echo may again > "$dir/IMG_20210507_070809.JPG"
+	echo notes > "$dir/NOTES.TXT"
+	;;
+*--delete-file*)
+	while [ "$1" != "--delete-file" ]; do shift; done
+	echo "$2" >> '` + deleted + `'
+	;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(bin, "gphoto2"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return deleted
+}
+
+func TestDeviceDownloadAndDelete(t *testing.T) {
+	deletedLog := useFakeGphoto(t)
+	device := gphoto{port: "usb:001,005"}
+	files, err := device.listFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || files[1] != (deviceFile{folder: "/store_00010001/DCIM/100CANON", index: 2, name: "IMG_20210507_070809.JPG"}) {
+		t.Fatalf("listed %v", files)
+	}
+
+	// The download is sorted like any other source
+	dest := t.TempDir()
+	staging := filepath.Join(dest, stateDirName, "device")
+	if err := device.download(files[0].folder, staging); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSorter(t, localFS, dest, staging)
+	s.dateSources = []string{dateSourceFilename}
+	if err := s.run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(listFiles(t, dest), " "); got != "2021/05/IMG_20210506_070809.JPG 2021/05/IMG_20210507_070809.JPG" {
+		t.Errorf("library holds %s", got)
+	}
+	if verified, failed := verifyTransfers(s.transfers); len(verified) != 2 || len(failed) != 0 {
+		t.Errorf("verified %v, failed %v", verified, failed)
+	}
+
+	// Deleting from the highest number down keeps the others valid
+	if err := device.delete(files[:2]); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := os.ReadFile(deletedLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(deleted) != "2\n1\n" {
+		t.Errorf("deleted files %q, want 2 then 1", deleted)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// runDiff implements the "diff" subcommand, which compares two libraries by
+// content and lists the files each has that the other lacks, whatever their
+// names and folders, for example to check that a backup or a family
+// member's copy is complete
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	logOpts := addLogFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s diff [options] LIBRARY_A LIBRARY_B\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	positional := parseInterspersed(fs, args)
+	if len(positional) != 2 {
+		fs.Usage()
+		os.Exit(exitFatal)
+	}
+	if err := logOpts.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitFatal)
+	}
+	a, b := positional[0], positional[1]
+	for _, dir := range positional {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			fatal("Library does not exist or is not a directory", "path", dir)
+		}
+	}
+
+	onlyA, onlyB, common, err := diffLibraries(a, b)
+	if err != nil {
+		fatal("Failed to compare libraries", "error", err)
+	}
+	for _, path := range onlyA {
+		slog.Warn("Only in first library", "path", path)
+	}
+	for _, path := range onlyB {
+		slog.Warn("Only in second library", "path", path)
+	}
+	slog.Info("Compared libraries", "common", common, "only_first", len(onlyA), "only_second", len(onlyB))
+	if len(onlyA) > 0 || len(onlyB) > 0 {
+		os.Exit(exitPartial)
+	}
+}
+
+// diffLibraries compares the files of two libraries by content and returns
+// the files found only in a and only in b, as paths relative to their
+// library, and how many files of a have a copy in b. Files are only hashed
+// when the other library has a file of the same size, and hashes from the
+// catalogs of adopted libraries are reused.
+func diffLibraries(a, b string) (onlyA, onlyB []string, common int, err error) {
+	ixA, err := newContentIndex(localFS, a)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	ixB, err := newContentIndex(localFS, b)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	// missing returns the files of one index without a copy in the other
+	missing := func(ix, other *contentIndex) ([]string, int, error) {
+		var paths []string
+		found := 0
+		for size, files := range ix.bySize {
+			candidates := other.bySize[size]
+			hashes := make(map[string]bool, len(candidates))
+			for _, candidate := range candidates {
+				h, err := other.hash(candidate)
+				if err != nil {
+					return nil, 0, err
+				}
+				hashes[h] = true
+			}
+			for _, path := range files {
+				if len(candidates) > 0 {
+					h, err := ix.hash(path)
+					if err != nil {
+						return nil, 0, err
+					}
+					if hashes[h] {
+						found++
+						continue
+					}
+				}
+				paths = append(paths, path)
+			}
+		}
+		sort.Strings(paths)
+		return paths, found, nil
+	}
+
+	if onlyA, common, err = missing(ixA, ixB); err != nil {
+		return nil, nil, 0, err
+	}
+	if onlyB, _, err = missing(ixB, ixA); err != nil {
+		return nil, nil, 0, err
+	}
+	for i, path := range onlyA {
+		onlyA[i], _ = filepath.Rel(a, path)
+	}
+	for i, path := range onlyB {
+		onlyB[i], _ = filepath.Rel(b, path)
+	}
+	return onlyA, onlyB, common, nil
+}
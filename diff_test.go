@@ -0,0 +1,28 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffLibrariesByContent(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	writeTestFile(t, filepath.Join(a, "2021", "05", "IMG_0001.jpg"), []byte("shared"))
+	writeTestFile(t, filepath.Join(b, "2021", "05", "renamed.jpg"), []byte("shared"))
+	writeTestFile(t, filepath.Join(a, "2021", "05", "IMG_0002.jpg"), []byte("only a"))
+	writeTestFile(t, filepath.Join(b, "2021", "05", "IMG_0002.jpg"), []byte("only b"))
+	onlyA, onlyB, common, err := diffLibraries(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if common != 1 {
+		t.Errorf("%d files in common, want 1", common)
+	}
+	if len(onlyA) != 1 || !strings.HasSuffix(filepath.ToSlash(onlyA[0]), "2021/05/IMG_0002.jpg") {
+		t.Errorf("only in a: %v", onlyA)
+	}
+	if len(onlyB) != 1 || !strings.HasSuffix(filepath.ToSlash(onlyB[0]), "2021/05/IMG_0002.jpg") {
+		t.Errorf("only in b: %v", onlyB)
+	}
+}
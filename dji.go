@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// srtReadLimit bounds how much of a subtitle sidecar is read for its first
+// time stamp
+const srtReadLimit = 64 << 10
+
+// djiNamePattern matches the file names of newer DJI drones and cameras,
+// which start with the local capture time: DJI_20230714153012_0001_D.MP4
+var djiNamePattern = regexp.MustCompile(`^(?i)DJI_(\d{14})_`)
+
+// srtTimePattern matches the time stamps DJI writes into the subtitle track
+// of a flight, "2023-07-14 15:30:12.345" or "2023.07.14 15:30:12" on older
+// models
+var srtTimePattern = regexp.MustCompile(`(\d{4})[-.](\d{2})[-.](\d{2}) (\d{2}):(\d{2}):(\d{2})`)
+
+// djiCaptureTime returns the local capture time of a DJI file from its SRT
+// flight log sidecar or its file name. The movie header of DJI videos is in
+// UTC, unlike most cameras', so these are preferred to keep drone footage
+// on the same day as photos taken on the ground.
+func djiCaptureTime(path string) (time.Time, bool) {
+	base := filepath.Base(path)
+	if !strings.HasPrefix(strings.ToUpper(base), "DJI_") {
+		return time.Time{}, false
+	}
+	if srt := srtSidecar(path); srt != "" {
+		if date, ok := srtStartTime(srt); ok {
+			return date, true
+		}
+	}
+	if m := djiNamePattern.FindStringSubmatch(base); m != nil {
+		if date, err := time.ParseInLocation("20060102150405", m[1], time.Local); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// srtSidecar returns the SRT subtitle file next to a video with the same
+// name, such as DJI_0001.SRT for DJI_0001.MP4, or "" if there is none
+func srtSidecar(path string) string {
+	if !isVideoFile(filepath.Ext(path)) {
+		return ""
+	}
+	stem := strings.TrimSuffix(path, filepath.Ext(path))
+	for _, ext := range []string{".SRT", ".srt"} {
+		if info, err := os.Stat(stem + ext); err == nil && info.Mode().IsRegular() {
+			return stem + ext
+		}
+	}
+	return ""
+}
+
+// srtStartTime returns the first time stamp in an SRT flight log
+func srtStartTime(path string) (time.Time, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, srtReadLimit))
+	if err != nil {
+		return time.Time{}, false
+	}
+	m := srtTimePattern.FindSubmatch(data)
+	if m == nil {
+		return time.Time{}, false
+	}
+	date, err := time.ParseInLocation("2006 01 02 15 04 05", strings.Join([]string{string(m[1]), string(m[2]), string(m[3]), string(m[4]), string(m[5]), string(m[6])}, " "), time.Local)
+	return date, err == nil
+}
+
+// sidecarDest returns where a sidecar goes when its file is sorted to
+// destPath: next to it, with the same name as the sorted file
+func sidecarDest(sidecar, destPath string) string {
+	return strings.TrimSuffix(destPath, filepath.Ext(destPath)) + filepath.Ext(sidecar)
+}
+
+// transferSidecar copies or moves a video's SRT sidecar next to the sorted
+// video, so flight logs stay paired with their footage
+func (s *sorter) transferSidecar(path, destPath string) {
+	srt := srtSidecar(path)
+	if srt == "" {
+		return
+	}
+	dest := sidecarDest(srt, destPath)
+	var err error
+	if s.moveFiles {
+		err = moveFile(s.fsys, srt, dest)
+	} else {
+		err = copyFile(s.fsys, srt, dest)
+	}
+	if err != nil {
+		slog.Warn("Could not transfer sidecar", "path", srt, "dest", dest, "error", err)
+		return
+	}
+	slog.Debug("Transferred sidecar", "path", srt, "dest", dest)
+	s.mirrorFile(dest)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+)
+
+// eventAlbum is a group of photos taken close together in time, such as a
+// trip or a party, sorted into one folder below the month folder
+type eventAlbum struct {
+	name string
+}
+
+// detectEvents reads the capture time of every source file and groups the
+// files into events, starting a new event wherever consecutive photos are
+// more than eventGap apart. Events are named after their first day and
+// numbered within it: "2023-07-14 Event 1". Files without a capture date
+// here are sorted without an event folder.
+func (s *sorter) detectEvents() error {
+	type dated struct {
+		path string
+		date time.Time
+	}
+	var files []dated
+	err := s.walkSource(func(path string, info os.FileInfo) error {
+		if date, err := getPhotoDate(path); err == nil {
+			files = append(files, dated{path, s.adjustTime(date)})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].date.Before(files[j].date) })
+
+	s.eventAlbums = make(map[string]eventAlbum)
+	perDay := make(map[string]int)
+	var current eventAlbum
+	for i, f := range files {
+		if i == 0 || f.date.Sub(files[i-1].date) > s.eventGap {
+			day := f.date.Format("2006-01-02")
+			perDay[day]++
+			current = eventAlbum{name: fmt.Sprintf("%s Event %d", day, perDay[day])}
+		}
+		s.eventAlbums[f.path] = current
+	}
+	total := 0
+	for _, n := range perDay {
+		total += n
+	}
+	slog.Info("Grouped photos into events", "files", len(files), "events", total, "gap", s.eventGap)
+	return nil
+}
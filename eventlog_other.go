@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// newEventLogHandler is not supported on this platform
+func newEventLogHandler(source string, level slog.Leveler) (slog.Handler, error) {
+	return nil, fmt.Errorf("-log-format eventlog is only available on Windows")
+}
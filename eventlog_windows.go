@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// eventLogHandler writes logs to the Windows event log, where a service's
+// logs are found in the Event Viewer under Windows Logs > Application
+type eventLogHandler struct {
+	log   *eventlog.Log
+	level slog.Leveler
+	attrs []slog.Attr
+	group string
+}
+
+// newEventLogHandler opens the event log source registered by
+// "service install"
+func newEventLogHandler(source string, level slog.Leveler) (slog.Handler, error) {
+	log, err := eventlog.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	return &eventLogHandler{log: log, level: level}, nil
+}
+
+func (h *eventLogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *eventLogHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Message)
+	add := func(key, value string) {
+		b.WriteString(" " + key + "=" + value)
+	}
+	for _, a := range h.attrs {
+		flattenAttr("", a, add)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		flattenAttr(h.group, a, add)
+		return true
+	})
+	switch {
+	case r.Level >= slog.LevelError:
+		return h.log.Error(1, b.String())
+	case r.Level >= slog.LevelWarn:
+		return h.log.Warning(1, b.String())
+	default:
+		return h.log.Info(1, b.String())
+	}
+}
+
+func (h *eventLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		if h.group != "" {
+			a.Key = h.group + "." + a.Key
+		}
+		clone.attrs = append(clone.attrs, a)
+	}
+	return &clone
+}
+
+func (h *eventLogHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	if clone.group != "" {
+		name = clone.group + "." + name
+	}
+	clone.group = name
+	return &clone
+}
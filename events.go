@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Events written by -output ndjson
+const (
+	streamScanned  = "scanned"
+	streamPlanned  = "planned"
+	streamCopied   = "copied"
+	streamSkipped  = "skipped"
+	streamError    = "error"
+	streamFinished = "finished"
+)
+
+// events is set by -output ndjson and receives one event per file action
+// while the run goes on; nil otherwise
+var events *eventStream
+
+// eventStream writes events as newline-delimited JSON, one object per line,
+// so other programs can follow a run as it happens
+type eventStream struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// streamEvent is one action on a file. Op is "copy", "move", "link", or
+// "upload" for copied and planned files; Outcome is the reason a file was
+// skipped, as in the run summary.
+type streamEvent struct {
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size,omitempty"`
+	Op      string    `json:"op,omitempty"`
+	Dest    string    `json:"dest,omitempty"`
+	Outcome string    `json:"outcome,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// newEventStream returns a stream writing to out
+func newEventStream(out io.Writer) *eventStream {
+	return &eventStream{enc: json.NewEncoder(out)}
+}
+
+// emit writes an event, stamping its time; e may be nil when events are off
+func (e *eventStream) emit(event streamEvent) {
+	if e == nil {
+		return
+	}
+	event.Time = time.Now()
+	e.write(event)
+}
+
+// finished ends the stream with the run's result
+func (e *eventStream) finished(result runResult) {
+	if e == nil {
+		return
+	}
+	e.write(struct {
+		Event string `json:"event"`
+		runResult
+	}{streamFinished, result})
+}
+
+func (e *eventStream) write(v any) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.enc.Encode(v)
+}
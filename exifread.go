@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// TIFF tags pointing to the interoperability IFD and the EXIF thumbnail
+const (
+	tagInteropIFD      = 0xA005
+	tagThumbnailOffset = 0x0201
+	tagThumbnailLength = 0x0202
+)
+
+// Limits on the TIFF structure walked by tiffExtent, beyond which a file is
+// taken to be damaged
+const (
+	tiffMaxIFDs          = 64
+	tiffMaxEntriesPerIFD = 4096
+)
+
+// exifSection returns the part of a JPEG or TIFF-based file that holds its
+// EXIF metadata, so that decoding does not read the whole file: the goexif
+// decoder scans a JPEG up to its APP1 segment, which for files without one is
+// the whole file, and reads TIFF-based RAW files into memory in full. ok is
+// false when the file is neither or its structure is not understood, and it
+// is then decoded as a whole as before.
+func exifSection(ra io.ReaderAt, size int64) (r io.Reader, ok bool, err error) {
+	var header [4]byte
+	if _, err := ra.ReadAt(header[:], 0); err != nil {
+		return nil, false, nil
+	}
+	switch {
+	case header[0] == 0xff && header[1] == 0xd8:
+		return jpegExifSegment(ra)
+	case string(header[:]) == "II*\x00" || string(header[:]) == "MM\x00*":
+		extent, ok := tiffExtent(ra, size)
+		if !ok {
+			return nil, false, nil
+		}
+		return io.NewSectionReader(ra, 0, extent), true, nil
+	}
+	return nil, false, nil
+}
+
+// jpegExifSegment walks the segments of a JPEG up to the start of the image
+// data and returns its EXIF APP1 segment as a JPEG of just that segment. APP1
+// segments with other contents, such as XMP, are skipped.
+func jpegExifSegment(ra io.ReaderAt) (io.Reader, bool, error) {
+	offset := int64(2)
+	var head [4]byte
+	for {
+		if _, err := ra.ReadAt(head[:2], offset); err != nil || head[0] != 0xff {
+			return nil, false, nil
+		}
+		marker := head[1]
+		switch {
+		case marker == 0xff:
+			// Fill byte before a marker
+			offset++
+			continue
+		case marker == 0xda || marker == 0xd9:
+			// Metadata comes before the image data
+			return nil, true, errNoExif
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7):
+			// Markers without a length
+			offset += 2
+			continue
+		}
+		if _, err := ra.ReadAt(head[2:], offset+2); err != nil {
+			return nil, false, nil
+		}
+		length := int64(binary.BigEndian.Uint16(head[2:]))
+		if length < 2 {
+			return nil, false, nil
+		}
+		if marker == 0xe1 && length > 8 {
+			data := make([]byte, length-2)
+			if _, err := ra.ReadAt(data, offset+4); err != nil {
+				return nil, false, nil
+			}
+			if bytes.HasPrefix(data, []byte("Exif\x00\x00")) {
+				segment := append([]byte{0xff, 0xd8, 0xff, 0xe1, head[2], head[3]}, data...)
+				return bytes.NewReader(segment), true, nil
+			}
+		}
+		offset += 2 + length
+	}
+}
+
+// tiffExtent returns how many bytes from the start of TIFF data hold its
+// header, the chain of IFDs with their EXIF, GPS, and interoperability
+// sub-IFDs, the values they point to, and the EXIF thumbnail: everything
+// the goexif decoder reads, but not the image data of a RAW file
+func tiffExtent(ra io.ReaderAt, size int64) (int64, bool) {
+	var header [8]byte
+	if _, err := ra.ReadAt(header[:], 0); err != nil {
+		return 0, false
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if header[0] == 'M' {
+		order = binary.BigEndian
+	}
+
+	extent := int64(len(header))
+	grow := func(end int64) {
+		if end > extent {
+			extent = end
+		}
+	}
+	queue := []int64{int64(order.Uint32(header[4:]))}
+	visited := make(map[int64]bool)
+	for len(queue) > 0 && len(visited) < tiffMaxIFDs {
+		ifd := queue[0]
+		queue = queue[1:]
+		if ifd == 0 || visited[ifd] {
+			continue
+		}
+		visited[ifd] = true
+
+		var count [2]byte
+		if _, err := ra.ReadAt(count[:], ifd); err != nil {
+			return 0, false
+		}
+		n := int64(order.Uint16(count[:]))
+		if n > tiffMaxEntriesPerIFD {
+			return 0, false
+		}
+		entries := make([]byte, n*12+4)
+		if _, err := ra.ReadAt(entries, ifd+2); err != nil {
+			return 0, false
+		}
+		grow(ifd + 2 + int64(len(entries)))
+
+		var thumbOffset, thumbLength int64
+		for i := int64(0); i < n; i++ {
+			entry := entries[i*12 : i*12+12]
+			tag := order.Uint16(entry[0:])
+			valueSize := int64(tiffTypeSizes[order.Uint16(entry[2:])]) * int64(order.Uint32(entry[4:]))
+			value := int64(order.Uint32(entry[8:]))
+			if valueSize > 4 {
+				grow(value + valueSize)
+			}
+			switch tag {
+			case tagExifIFD, tagGPSInfo, tagInteropIFD:
+				queue = append(queue, value)
+			case tagThumbnailOffset:
+				thumbOffset = value
+			case tagThumbnailLength:
+				thumbLength = value
+			}
+		}
+		if thumbOffset > 0 {
+			grow(thumbOffset + thumbLength)
+		}
+		queue = append(queue, int64(order.Uint32(entries[n*12:])))
+	}
+	// Values pointing past the end are left for the decoder to report
+	if extent > size {
+		extent = size
+	}
+	return extent, true
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// largeFixtureSize is the size of the image data after the metadata in the
+// benchmark fixtures, about that of a RAW file or a high-resolution JPEG
+const largeFixtureSize = 64 << 20
+
+// writeLargeJPEG writes a JPEG with an EXIF date followed by a large scan
+func writeLargeJPEG(b *testing.B) string {
+	b.Helper()
+	date := time.Date(2021, time.May, 6, 7, 8, 9, 0, time.Local)
+	data := []byte{0xFF, 0xD8}
+	data = append(data, exifDateSegment([]byte(date.Format(exifDateFormat)+"\x00"))...)
+	data = append(data, 0xFF, 0xDA, 0x00, 0x02)
+	data = append(data, bytes.Repeat([]byte{0x55}, largeFixtureSize)...)
+	data = append(data, 0xFF, 0xD9)
+	path := filepath.Join(b.TempDir(), "large.jpg")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		b.Fatal(err)
+	}
+	return path
+}
+
+// writeLargeTIFF writes a TIFF-based RAW file whose IFDs come first and whose
+// image data fills the rest of the file
+func writeLargeTIFF(b *testing.B) string {
+	b.Helper()
+	date := time.Date(2021, time.May, 6, 7, 8, 9, 0, time.Local)
+	// The APP1 segment is the marker, its length, "Exif\0\0", and the TIFF
+	segment := exifDateSegment([]byte(date.Format(exifDateFormat) + "\x00"))
+	data := append([]byte(nil), segment[10:]...)
+	data = append(data, bytes.Repeat([]byte{0x55}, largeFixtureSize)...)
+	path := filepath.Join(b.TempDir(), "large.dng")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		b.Fatal(err)
+	}
+	return path
+}
+
+// benchmarkExifReads compares decodeExif, which reads only the EXIF section,
+// with decoding from the whole file as before
+func benchmarkExifReads(b *testing.B, path string) {
+	b.Run("bounded", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := decodeExif(localFS, path); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("full", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			file, err := os.Open(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			_, err = exif.Decode(file)
+			file.Close()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkDecodeExifLargeJPEG(b *testing.B) {
+	benchmarkExifReads(b, writeLargeJPEG(b))
+}
+
+func BenchmarkDecodeExifLargeTIFF(b *testing.B) {
+	benchmarkExifReads(b, writeLargeTIFF(b))
+}
+
+func BenchmarkExifSectionLargeTIFF(b *testing.B) {
+	path := writeLargeTIFF(b)
+	file, err := os.Open(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok, err := exifSection(file, info.Size()); !ok || err != nil {
+			b.Fatalf("no EXIF section found: %v", err)
+		}
+	}
+}
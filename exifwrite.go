@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// EXIF tags holding capture dates
+const (
+	tagDateTime          = 0x0132
+	tagExifIFD           = 0x8769
+	tagDateTimeOriginal  = 0x9003
+	tagDateTimeDigitized = 0x9004
+
+	// EXIF 2.31 tags holding the UTC offset of each date
+	tagOffsetTime          = 0x9010
+	tagOffsetTimeOriginal  = 0x9011
+	tagOffsetTimeDigitized = 0x9012
+)
+
+// exifDateFormat and exifOffsetFormat are the layouts of EXIF date and
+// offset values
+const (
+	exifDateFormat   = "2006:01:02 15:04:05"
+	exifOffsetFormat = "-07:00"
+)
+
+// errExifWriteUnsupported is returned for files that are not JPEGs
+var errExifWriteUnsupported = errors.New("writing EXIF is only supported for JPEG files")
+
+// writeExifDate sets the capture date of a JPEG file. Existing date tags are
+// updated in place; a file without EXIF gets a minimal EXIF segment holding
+// DateTimeOriginal. The file is replaced atomically.
+func writeExifDate(path string, date time.Time) error {
+	return writeExifDates(path, date, false)
+}
+
+// writeExifDateOffset is like writeExifDate but also updates the offset tags
+// the file already has to the offset of date's location
+func writeExifDateOffset(path string, date time.Time) error {
+	return writeExifDates(path, date, true)
+}
+
+func writeExifDates(path string, date time.Time, withOffset bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return errExifWriteUnsupported
+	}
+	stamp := []byte(date.Format(exifDateFormat) + "\x00")
+	var offset []byte
+	if withOffset {
+		offset = []byte(date.Format(exifOffsetFormat) + "\x00")
+	}
+
+	tiff, err := findExifTIFF(data)
+	if err != nil {
+		return err
+	}
+	if tiff != nil {
+		updated, err := patchExifDates(tiff, stamp, offset)
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return fmt.Errorf("EXIF has no date tags to update")
+		}
+		return replaceFile(path, data)
+	}
+
+	// No EXIF yet; insert a segment right after the start-of-image marker
+	out := make([]byte, 0, len(data)+128)
+	out = append(out, data[:2]...)
+	out = append(out, exifDateSegment(stamp)...)
+	out = append(out, data[2:]...)
+	return replaceFile(path, out)
+}
+
+// findExifTIFF returns the TIFF structure of the EXIF segment of JPEG data,
+// sharing memory with data, or nil if there is no EXIF segment
+func findExifTIFF(data []byte) ([]byte, error) {
+	for pos := 2; pos+4 <= len(data) && data[pos] == 0xFF; {
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if end > len(data) {
+			return nil, fmt.Errorf("truncated JPEG segment")
+		}
+		segment := data[pos+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], nil
+		}
+		pos = end
+	}
+	return nil, nil
+}
+
+// exifOffsetTime returns the OffsetTimeOriginal value of a JPEG file, such as
+// "+02:00", or "" if it has none
+func exifOffsetTime(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return ""
+	}
+	tiff, err := findExifTIFF(data)
+	if tiff == nil || err != nil {
+		return ""
+	}
+	var value string
+	eachExifEntry(tiff, func(tag, kind uint16, n, offset uint32) {
+		if tag == tagOffsetTimeOriginal && kind == 2 && n > 1 && int(offset)+int(n) <= len(tiff) {
+			value = string(tiff[offset : offset+n-1])
+		}
+	})
+	return value
+}
+
+// patchExifDates overwrites the date tags of IFD0 and the EXIF IFD in a TIFF
+// structure, and the offset tags if zone is given, and returns how many
+// dates were updated
+func patchExifDates(tiff, stamp, zone []byte) (int, error) {
+	updated := 0
+	err := eachExifEntry(tiff, func(tag, kind uint16, n, value uint32) {
+		switch tag {
+		case tagDateTime, tagDateTimeOriginal, tagDateTimeDigitized:
+			// Dates are 20-byte ASCII values stored outside the entry
+			if kind != 2 || n != uint32(len(stamp)) || int(value)+len(stamp) > len(tiff) {
+				return
+			}
+			copy(tiff[value:], stamp)
+			updated++
+		case tagOffsetTime, tagOffsetTimeOriginal, tagOffsetTimeDigitized:
+			// Offsets are 7-byte ASCII values such as "+02:00"
+			if zone == nil || kind != 2 || n != uint32(len(zone)) || int(value)+len(zone) > len(tiff) {
+				return
+			}
+			copy(tiff[value:], zone)
+		}
+	})
+	return updated, err
+}
+
+// eachExifEntry calls fn for every entry of IFD0 and the EXIF IFD in a TIFF
+// structure; values of more than four bytes are offsets into tiff
+func eachExifEntry(tiff []byte, fn func(tag, kind uint16, n, value uint32)) error {
+	if len(tiff) < 8 {
+		return fmt.Errorf("EXIF data too short")
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return fmt.Errorf("invalid EXIF byte order")
+	}
+
+	offsets := []uint32{order.Uint32(tiff[4:])}
+	for len(offsets) > 0 {
+		offset := int(offsets[0])
+		offsets = offsets[1:]
+		if offset+2 > len(tiff) {
+			return fmt.Errorf("EXIF directory out of range")
+		}
+		count := int(order.Uint16(tiff[offset:]))
+		for i := 0; i < count; i++ {
+			entry := offset + 2 + i*12
+			if entry+12 > len(tiff) {
+				return fmt.Errorf("EXIF directory out of range")
+			}
+			tag := order.Uint16(tiff[entry:])
+			value := order.Uint32(tiff[entry+8:])
+			if tag == tagExifIFD {
+				offsets = append(offsets, value)
+				continue
+			}
+			fn(tag, order.Uint16(tiff[entry+2:]), order.Uint32(tiff[entry+4:]), value)
+		}
+	}
+	return nil
+}
+
+// exifDateSegment builds a big-endian APP1 segment whose IFD0 holds DateTime
+// and a pointer to an EXIF IFD holding DateTimeOriginal
+func exifDateSegment(stamp []byte) []byte {
+	var tiff bytes.Buffer
+	be := binary.BigEndian
+	write := func(v any) { binary.Write(&tiff, be, v) }
+	entry := func(tag, kind uint16, count, value uint32) {
+		write(tag)
+		write(kind)
+		write(count)
+		write(value)
+	}
+
+	const ifd0, exifIFD = 8, 8 + 2 + 2*12 + 4
+	const dateValue = exifIFD + 2 + 12 + 4
+	const originalValue = dateValue + 20
+
+	tiff.WriteString("MM\x00\x2a")
+	write(uint32(ifd0))
+	write(uint16(2))
+	entry(tagDateTime, 2, uint32(len(stamp)), dateValue)
+	entry(tagExifIFD, 4, 1, exifIFD)
+	write(uint32(0))
+	write(uint16(1))
+	entry(tagDateTimeOriginal, 2, uint32(len(stamp)), originalValue)
+	write(uint32(0))
+	tiff.Write(stamp)
+	tiff.Write(stamp)
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	be.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// replaceFile atomically replaces path with data, keeping its permissions
+func replaceFile(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), ".gopicsort-*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	os.Chmod(temp.Name(), mode)
+	if err := os.Rename(temp.Name(), path); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return nil
+}
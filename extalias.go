@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// extensionAliases maps alternative spellings of an extension to one
+// canonical form. It is used wherever extensions are compared: format
+// filters, the supported-format check, content sniffing, and -normalize-ext.
+var extensionAliases = map[string]string{
+	".jpeg":  ".jpg",
+	".jpe":   ".jpg",
+	".jfif":  ".jpg",
+	".tif":   ".tiff",
+	".heif":  ".heic",
+	".qt":    ".mov",
+	".mpeg4": ".mp4",
+	".m2ts":  ".mts",
+}
+
+// canonicalExt returns the lower-case canonical form of an extension
+func canonicalExt(ext string) string {
+	ext = strings.ToLower(ext)
+	if canonical, ok := extensionAliases[ext]; ok {
+		return canonical
+	}
+	return ext
+}
+
+// addExtensionAlias adds an alias from an -ext-alias value such as
+// "jpeg=jpg"; leading dots are optional
+func addExtensionAlias(spec string) error {
+	alias, canonical, ok := strings.Cut(spec, "=")
+	alias = normalizeExtFlag(alias)
+	canonical = normalizeExtFlag(canonical)
+	if !ok || alias == "." || canonical == "." {
+		return fmt.Errorf("invalid -ext-alias %q, expected ALIAS=EXT (e.g., 'jpeg=jpg')", spec)
+	}
+	// Resolve through existing aliases so chains end at one canonical form
+	extensionAliases[alias] = canonicalExt(canonical)
+	return nil
+}
+
+// normalizeExtFlag lower-cases an extension given on the command line and
+// adds the leading dot
+func normalizeExtFlag(ext string) string {
+	ext = strings.ToLower(strings.TrimSpace(ext))
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
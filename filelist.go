@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// readFileList reads the paths listed in a file, or on standard input when
+// name is "-". Paths are separated by NUL bytes if there are any, as written
+// by "find -print0", and by lines otherwise; empty entries are ignored.
+func readFileList(name string) ([]string, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sep := []byte("\n")
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = []byte{0}
+	}
+	var paths []string
+	for _, entry := range bytes.Split(data, sep) {
+		if sep[0] == '\n' {
+			entry = bytes.TrimSuffix(entry, []byte("\r"))
+		}
+		if len(entry) > 0 {
+			paths = append(paths, string(entry))
+		}
+	}
+	return paths, nil
+}
+
+// walkFileList calls fn for every listed file, applying the same filters as
+// walkDir. Exclude patterns are matched against the paths as listed.
+func (s *sorter) walkFileList(fn func(path string, info os.FileInfo) error) error {
+	for _, path := range s.fileList {
+		info, err := s.fsys.Stat(path)
+		if err != nil {
+			// The list is walked more than once, e.g. by the space check
+			if !s.unreadableListed[path] {
+				slog.Error("Could not read listed file", "path", path, "error", err)
+				s.tally(outcomeFailed, path)
+				if s.unreadableListed == nil {
+					s.unreadableListed = make(map[string]bool)
+				}
+				s.unreadableListed[path] = true
+			}
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			slog.Debug("Skipping listed path that is not a file", "path", path)
+			continue
+		}
+		if (s.skipHidden && isHiddenOrSystem(info.Name())) || matchesExclude(filepath.ToSlash(path), s.excludes) {
+			continue
+		}
+		if !isValidFileFormat(s.fileExt(path), s.formats) || !s.sizeInRange(info) || !s.sampled(path, info) {
+			continue
+		}
+		if err := fn(path, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
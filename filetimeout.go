@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// readWithin reads a whole file, returning an error if that takes longer
+// than timeout. A read stuck on a failing disk cannot be interrupted, so the
+// reading goroutine is abandoned; only the read is left behind, not any
+// sorting state. Once read, the file is normally in the page cache and the
+// actual processing does not touch the disk again.
+func readWithin(path string, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		file, err := os.Open(path)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer file.Close()
+		_, err = io.Copy(io.Discard, file)
+		done <- err
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("reading took longer than %v", timeout)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// stringList is a flag value that can be repeated and also accepts
+// comma-separated values
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// systemNames lists folders and files created by operating systems, NAS
+// software, and photo applications that should never be sorted
+var systemNames = map[string]bool{
+	"@eadir":                    true, // Synology thumbnails
+	"#recycle":                  true, // Synology recycle bin
+	"#snapshot":                 true,
+	"$recycle.bin":              true, // Windows recycle bin
+	"system volume information": true,
+	"thumbs.db":                 true,
+	"desktop.ini":               true,
+	"lost+found":                true,
+}
+
+// isHiddenOrSystem reports whether a file or directory name is hidden
+// (dot-prefixed) or a known system/application artifact
+func isHiddenOrSystem(name string) bool {
+	if strings.HasPrefix(name, ".") && name != "." && name != ".." {
+		return true
+	}
+	lower := strings.ToLower(name)
+	// Lightroom keeps previews in "<catalog> Previews.lrdata" folders
+	return systemNames[lower] || strings.HasSuffix(lower, ".lrdata")
+}
+
+// matchesExclude reports whether a slash-separated path relative to the
+// source root matches any of the exclude patterns. Patterns without a slash
+// match the base name at any depth; patterns with a slash match the whole
+// relative path, where "**" matches any number of path segments.
+func matchesExclude(rel string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+				return true
+			}
+			continue
+		}
+		if matchSegments(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(rel, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSegments matches path segments against pattern segments with "**" support
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Collapse consecutive "**" and try every possible split point
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := range segments {
+				if matchSegments(pattern, segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+// matchesAnyPattern reports whether any of the values matches any of the
+// case-insensitive glob patterns
+func matchesAnyPattern(values, patterns []string) bool {
+	for _, value := range values {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(value)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cameraNames returns the EXIF Make and Model of a file, alone and combined,
+// for matching -camera patterns against either; nil if it has neither
+func cameraNames(fsys fileSystem, path string) []string {
+	x, err := decodeExif(fsys, path)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	maker, model := exifString(x, exif.Make), exifString(x, exif.Model)
+	for _, name := range []string{maker, model, maker + " " + model, cameraName(x)} {
+		if name = strings.TrimSpace(name); name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// sizeInRange reports whether a file is within the -min-size and -max-size
+// limits; a limit of zero is no limit
+func (s *sorter) sizeInRange(info os.FileInfo) bool {
+	return info.Size() >= s.minSize && (s.maxSize == 0 || info.Size() <= s.maxSize)
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// tzChange is one journaled capture time rewrite by fix-tz. The journal is
+// written before each file is changed, so an interrupted run can be undone.
+type tzChange struct {
+	Path      string `json:"path"`
+	NewPath   string `json:"new_path,omitempty"`
+	Old       string `json:"old"`
+	New       string `json:"new"`
+	OldOffset string `json:"old_offset,omitempty"`
+	NewOffset string `json:"new_offset,omitempty"`
+}
+
+// fixTZJournalPath returns the journal of a fix-tz run
+func fixTZJournalPath(destDir, runID string) string {
+	return filepath.Join(destDir, stateDirName, "fix-tz", runID+".jsonl")
+}
+
+// runFixTZ implements the "fix-tz" subcommand, which rewrites the EXIF
+// capture times of photos taken with the camera clock set to the wrong time
+// zone, such as home time while travelling
+func runFixTZ(args []string) {
+	fs := flag.NewFlagSet("fix-tz", flag.ExitOnError)
+	destDir := fs.String("dest", "", "Sorted photo library")
+	from := fs.String("from", "", "Time zone the camera clock was set to (e.g., 'America/New_York')")
+	to := fs.String("to", "", "Time zone the photos were actually taken in (e.g., 'Europe/Paris')")
+	afterDate := fs.String("after", "", "Only fix photos taken on or after this date (YYYY-MM-DD, camera time)")
+	beforeDate := fs.String("before", "", "Only fix photos taken before this date (YYYY-MM-DD, camera time)")
+	refile := fs.Bool("refile", false, "Move photos whose corrected date falls in another month into that month's folder")
+	dryRun := fs.Bool("dry-run", false, "Only list the changes that would be made")
+	undo := fs.String("undo", "", "Revert the fix-tz run with this run ID using its journal")
+	logOpts := addLogFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s fix-tz -dest DIR -from ZONE -to ZONE [options] [path ...]\n       %s fix-tz -dest DIR -undo RUNID\n", filepath.Base(os.Args[0]), filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	paths := parseInterspersed(fs, args)
+	if *destDir == "" || (*undo == "" && (*from == "" || *to == "")) {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := logOpts.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	catalog, err := loadRetention(*destDir)
+	if err != nil {
+		fatal("Failed to read retention catalog", "error", err)
+	}
+	if *undo != "" {
+		if err := undoFixTZ(*destDir, *undo, catalog); err != nil {
+			fatal("Failed to undo fix-tz run", "run", *undo, "error", err)
+		}
+		return
+	}
+
+	fromLoc, err := time.LoadLocation(*from)
+	if err != nil {
+		fatal("Invalid -from time zone", "error", err)
+	}
+	toLoc, err := time.LoadLocation(*to)
+	if err != nil {
+		fatal("Invalid -to time zone", "error", err)
+	}
+	after, err := parseDateFlag("after", *afterDate)
+	if err != nil {
+		fatal(err.Error())
+	}
+	before, err := parseDateFlag("before", *beforeDate)
+	if err != nil {
+		fatal(err.Error())
+	}
+
+	layout, err := libraryLayout(*destDir)
+	if err != nil {
+		fatal("Failed to read library configuration", "error", err)
+	}
+
+	// Paths are relative to the library, e.g. "2019/07"; default is all of it
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	var changes []tzChange
+	for _, path := range paths {
+		root := filepath.Join(*destDir, filepath.FromSlash(path))
+		err := filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				// GoPicSort's own folders hold no photos of the library
+				if file != root && isToolFolder(info.Name()) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if canonicalExt(filepath.Ext(file)) != ".jpg" {
+				if isImageFile(filepath.Ext(file)) {
+					slog.Debug("Skipping non-JPEG file", "path", file)
+				}
+				return nil
+			}
+			change, ok := planTZChange(*destDir, file, fromLoc, toLoc, after, before, *refile, layout)
+			if ok {
+				changes = append(changes, change)
+			}
+			return nil
+		})
+		if err != nil {
+			fatal("Failed to list files", "path", path, "error", err)
+		}
+	}
+
+	if *dryRun {
+		for _, c := range changes {
+			slog.Info("Would change capture time", "path", c.Path, "old", c.Old, "new", c.New, "refile", c.NewPath)
+		}
+		slog.Info("Dry run complete", "files", len(changes))
+		return
+	}
+	if len(changes) == 0 {
+		slog.Info("No photos to change")
+		return
+	}
+
+	runID := newRunID()
+	started := time.Now()
+	applied, err := applyTZChanges(*destDir, runID, changes, toLoc, catalog)
+	if err != nil {
+		fatal("Failed to fix time zone", "error", err, "changed", applied)
+	}
+	record := runRecord{
+		RunID:    runID,
+		Machine:  machineName(),
+		Command:  "fix-tz",
+		Started:  started,
+		Finished: time.Now(),
+		Dest:     *destDir,
+		Files:    applied,
+	}
+	if err := appendRunHistory(*destDir, record); err != nil {
+		slog.Warn("Could not record run history", "error", err)
+	}
+	slog.Info("Fixed capture times", "files", applied, "run", runID, "undo", "gopicsort fix-tz -dest "+*destDir+" -undo "+runID)
+}
+
+// planTZChange works out the corrected capture time of a photo and, with
+// refile, the folder of the library's layout it belongs in
+func planTZChange(destDir, path string, fromLoc, toLoc *time.Location, after, before time.Time, refile bool, layout string) (tzChange, bool) {
+	x, err := decodeExif(localFS, path)
+	if err != nil {
+		return tzChange{}, false
+	}
+	old := exifString(x, exif.DateTimeOriginal)
+	if old == "" {
+		old = exifString(x, exif.DateTime)
+	}
+	wall, err := time.ParseInLocation(exifDateFormat, old, time.Local)
+	if err != nil || !inDateRange(wall, after, before) {
+		return tzChange{}, false
+	}
+
+	camera := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, fromLoc)
+	corrected := camera.In(toLoc)
+	change := tzChange{
+		Path:      path,
+		Old:       old,
+		New:       corrected.Format(exifDateFormat),
+		OldOffset: exifOffsetTime(path),
+		NewOffset: corrected.Format(exifOffsetFormat),
+	}
+	if change.Old == change.New && (change.OldOffset == "" || change.OldOffset == change.NewOffset) {
+		return tzChange{}, false
+	}
+
+	// Only files in the folder for their old date are moved, keeping any
+	// subfolder below it
+	oldFolder, newFolder := formatLayout(dateLayout(layout), wall), formatLayout(dateLayout(layout), corrected)
+	if refile && oldFolder != newFolder {
+		rel, err := filepath.Rel(destDir, path)
+		rel = filepath.ToSlash(rel)
+		if err == nil && strings.HasPrefix(rel, oldFolder+"/") {
+			change.NewPath = filepath.Join(destDir, filepath.FromSlash(newFolder+strings.TrimPrefix(rel, oldFolder)))
+		}
+	}
+	return change, true
+}
+
+// applyTZChanges journals and applies each change and returns how many were
+// applied. Files under retention or in locked folders are left alone.
+func applyTZChanges(destDir, runID string, changes []tzChange, toLoc *time.Location, catalog *retentionCatalog) (int, error) {
+	journalPath := fixTZJournalPath(destDir, runID)
+	if err := os.MkdirAll(filepath.Dir(journalPath), 0755); err != nil {
+		return 0, err
+	}
+	journal, err := os.OpenFile(journalPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer journal.Close()
+
+	applied := 0
+	for _, c := range changes {
+		if err := catalog.check(c.Path); err != nil {
+			slog.Warn("Not changing file", "path", c.Path, "error", err)
+			continue
+		}
+		if isLocked(filepath.Dir(c.Path)) || (c.NewPath != "" && isLocked(filepath.Dir(c.NewPath))) {
+			slog.Warn("Not changing file in a locked folder, run 'gopicsort unlock' first", "path", c.Path)
+			continue
+		}
+		if c.NewPath != "" {
+			if _, err := os.Stat(c.NewPath); err == nil {
+				slog.Warn("Not moving file, the target already exists", "path", c.Path, "target", c.NewPath)
+				c.NewPath = ""
+			}
+		}
+
+		line, err := json.Marshal(c)
+		if err != nil {
+			return applied, err
+		}
+		if _, err := journal.Write(append(line, '\n')); err != nil {
+			return applied, err
+		}
+		if err := journal.Sync(); err != nil {
+			return applied, err
+		}
+
+		date, _ := time.ParseInLocation(exifDateFormat, c.New, toLoc)
+		if err := writeExifDateOffset(c.Path, date); err != nil {
+			slog.Error("Could not write capture time", "path", c.Path, "error", err)
+			continue
+		}
+		if c.NewPath != "" {
+			if err := os.MkdirAll(filepath.Dir(c.NewPath), 0755); err != nil {
+				return applied, err
+			}
+			if err := os.Rename(c.Path, c.NewPath); err != nil {
+				return applied, err
+			}
+			slog.Info("Fixed and moved", "path", c.Path, "dest", c.NewPath, "old", c.Old, "new", c.New)
+		} else {
+			slog.Info("Fixed", "path", c.Path, "old", c.Old, "new", c.New)
+		}
+		applied++
+	}
+	return applied, nil
+}
+
+// undoFixTZ restores the capture times and locations recorded in a fix-tz
+// journal, newest change first, and removes the journal
+func undoFixTZ(destDir, runID string, catalog *retentionCatalog) error {
+	journalPath := fixTZJournalPath(destDir, runID)
+	file, err := os.Open(journalPath)
+	if err != nil {
+		return err
+	}
+	var changes []tzChange
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var c tzChange
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			// A torn last line from an interrupted run was never applied
+			continue
+		}
+		changes = append(changes, c)
+	}
+	file.Close()
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	failed := 0
+	for i := len(changes) - 1; i >= 0; i-- {
+		c := changes[i]
+		current := c.Path
+		if c.NewPath != "" {
+			if _, err := os.Stat(c.NewPath); err == nil {
+				current = c.NewPath
+			}
+		}
+		if err := catalog.check(current); err != nil {
+			slog.Warn("Not restoring file", "path", current, "error", err)
+			failed++
+			continue
+		}
+		if current != c.Path {
+			if err := os.MkdirAll(filepath.Dir(c.Path), 0755); err != nil {
+				return err
+			}
+			if err := moveFile(localFS, current, c.Path); err != nil {
+				slog.Error("Could not move file back", "path", current, "error", err)
+				failed++
+				continue
+			}
+		}
+
+		// Restore the old offset tags only if the file had them
+		loc := time.Local
+		if zone, err := time.Parse(exifOffsetFormat, c.OldOffset); err == nil {
+			_, seconds := zone.Zone()
+			loc = time.FixedZone("", seconds)
+		}
+		date, err := time.ParseInLocation(exifDateFormat, c.Old, loc)
+		if err != nil {
+			return fmt.Errorf("invalid journal entry for %s: %v", c.Path, err)
+		}
+		write := writeExifDate
+		if c.OldOffset != "" {
+			write = writeExifDateOffset
+		}
+		if err := write(c.Path, date); err != nil {
+			slog.Error("Could not restore capture time", "path", c.Path, "error", err)
+			failed++
+			continue
+		}
+		slog.Info("Restored", "path", c.Path, "date", c.Old)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d file(s) could not be restored; the journal is kept at %s", failed, journalPath)
+	}
+	return os.Remove(journalPath)
+}
@@ -0,0 +1,38 @@
+package main
+
+import "syscall"
+
+// msdosSuperMagic identifies FAT file systems in statfs
+const msdosSuperMagic = 0x4d44
+
+// maxFileSize returns the largest file the file system holding dir can
+// store and its name, or 0 if there is no limit worth checking
+func maxFileSize(dir string) (int64, string) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, ""
+	}
+	if st.Type == msdosSuperMagic {
+		return fat32MaxFileSize, "FAT"
+	}
+	return 0, ""
+}
+
+// freeSpace returns the bytes available to unprivileged users on the file
+// system holding dir
+func freeSpace(dir string) (uint64, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, false
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), true
+}
+
+// sameFileSystem reports whether two paths are on the same file system
+func sameFileSystem(a, b string) bool {
+	var sa, sb syscall.Stat_t
+	if syscall.Stat(a, &sa) != nil || syscall.Stat(b, &sb) != nil {
+		return false
+	}
+	return sa.Dev == sb.Dev
+}
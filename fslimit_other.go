@@ -0,0 +1,19 @@
+//go:build !linux && !darwin
+
+package main
+
+// maxFileSize is not detected on this platform
+func maxFileSize(dir string) (int64, string) {
+	return 0, ""
+}
+
+// freeSpace is not detected on this platform
+func freeSpace(dir string) (uint64, bool) {
+	return 0, false
+}
+
+// sameFileSystem is not detected on this platform, so paths are assumed to
+// be on different file systems
+func sameFileSystem(a, b string) bool {
+	return false
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// fileSystem is what the walker, the copier, and the content index read and
+// write files through. Sort runs use the local disk; tests can run the
+// pipeline against an in-memory implementation, and other sources such as
+// archives plug in by implementing it. Names are native paths as used by the
+// os package.
+type fileSystem interface {
+	// Open opens a file for reading
+	Open(name string) (io.ReadCloser, error)
+	// Create creates or truncates a file for writing
+	Create(name string) (io.WriteCloser, error)
+	// Stat returns information about a file, following links
+	Stat(name string) (fs.FileInfo, error)
+	// Lstat returns information about a file without following links
+	Lstat(name string) (fs.FileInfo, error)
+	// ReadDir lists a directory sorted by name
+	ReadDir(name string) ([]fs.DirEntry, error)
+	// MkdirAll creates a directory and any missing parents
+	MkdirAll(name string, perm fs.FileMode) error
+	// Rename moves a file, replacing the target
+	Rename(oldName, newName string) error
+	// Remove removes a file or an empty directory
+	Remove(name string) error
+}
+
+// osFileSystem is the local disk
+type osFileSystem struct{}
+
+// localFS is the local disk, used by everything that is not given another
+// file system
+var localFS fileSystem = osFileSystem{}
+
+func (osFileSystem) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (osFileSystem) Create(name string) (io.WriteCloser, error) {
+	return os.Create(name)
+}
+
+func (osFileSystem) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFileSystem) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (osFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (osFileSystem) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+func (osFileSystem) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (osFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// sameFile reports whether two FileInfos describe the same file. Files on
+// the local disk are compared by device and inode; other file systems
+// describe a file with the same Sys value every time.
+func sameFile(a, b fs.FileInfo) bool {
+	if os.SameFile(a, b) {
+		return true
+	}
+	return a.Sys() != nil && a.Sys() == b.Sys()
+}
+
+// walkFileSystem walks the tree at root on fsys like filepath.Walk: in
+// lexical order, without following links, calling fn for every file and
+// directory, and skipping a directory when fn returns filepath.SkipDir for it
+func walkFileSystem(fsys fileSystem, root string, fn filepath.WalkFunc) error {
+	info, err := fsys.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkFileSystemDir(fsys, root, info, fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkFileSystemDir(fsys fileSystem, path string, info fs.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+	entries, err := fsys.ReadDir(path)
+	err1 := fn(path, info, err)
+	// A directory that cannot be read is reported once, and then skipped
+	// unless fn ignores the error
+	if err != nil || err1 != nil {
+		return err1
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, entry := range entries {
+		name := filepath.Join(path, entry.Name())
+		info, err := fsys.Lstat(name)
+		if err != nil {
+			if err := fn(name, info, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		if err := walkFileSystemDir(fsys, name, info, fn); err != nil {
+			if !info.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
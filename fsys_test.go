@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memFile is a file or directory of a memFS
+type memFile struct {
+	name    string
+	data    []byte
+	dir     bool
+	modTime time.Time
+}
+
+// memFileInfo describes a memFile; Sys returns the file, so sameFile can
+// tell whether two infos describe the same one
+type memFileInfo struct{ file *memFile }
+
+func (i memFileInfo) Name() string       { return i.file.name }
+func (i memFileInfo) Size() int64        { return int64(len(i.file.data)) }
+func (i memFileInfo) ModTime() time.Time { return i.file.modTime }
+func (i memFileInfo) IsDir() bool        { return i.file.dir }
+func (i memFileInfo) Sys() any           { return i.file }
+
+func (i memFileInfo) Mode() fs.FileMode {
+	if i.file.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+// memFS is a fileSystem held in memory, for running the sorting pipeline
+// without touching the disk
+type memFS struct {
+	mu    sync.Mutex
+	files map[string]*memFile
+}
+
+func newMemFS() *memFS {
+	return &memFS{files: map[string]*memFile{string(filepath.Separator): {name: string(filepath.Separator), dir: true}}}
+}
+
+// writeFile adds a file and its parent directories
+func (m *memFS) writeFile(name string, data []byte, modTime time.Time) {
+	m.MkdirAll(filepath.Dir(name), 0755)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[filepath.Clean(name)] = &memFile{name: filepath.Base(name), data: data, modTime: modTime}
+}
+
+// names returns the paths of the regular files, sorted
+func (m *memFS) names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for name, file := range m.files {
+		if !file.dir {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *memFS) lookup(op, name string) (*memFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	file, ok := m.files[filepath.Clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return file, nil
+}
+
+func (m *memFS) Open(name string) (io.ReadCloser, error) {
+	file, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if file.dir {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return io.NopCloser(bytes.NewReader(file.data)), nil
+}
+
+// memWriter stores what was written when it is closed
+type memWriter struct {
+	bytes.Buffer
+	fs   *memFS
+	name string
+}
+
+func (w *memWriter) Close() error {
+	w.fs.writeFile(w.name, w.Bytes(), time.Now())
+	return nil
+}
+
+func (m *memFS) Create(name string) (io.WriteCloser, error) {
+	parent, err := m.lookup("create", filepath.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+	if !parent.dir {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrInvalid}
+	}
+	return &memWriter{fs: m, name: name}, nil
+}
+
+func (m *memFS) Stat(name string) (fs.FileInfo, error) {
+	file, err := m.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return memFileInfo{file}, nil
+}
+
+func (m *memFS) Lstat(name string) (fs.FileInfo, error) {
+	return m.Stat(name)
+}
+
+func (m *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	dir, err := m.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !dir.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var entries []fs.DirEntry
+	for path, file := range m.files {
+		if path != filepath.Clean(name) && filepath.Dir(path) == filepath.Clean(name) {
+			entries = append(entries, fs.FileInfoToDirEntry(memFileInfo{file}))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (m *memFS) MkdirAll(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for dir := filepath.Clean(name); ; dir = filepath.Dir(dir) {
+		if file, ok := m.files[dir]; ok {
+			if !file.dir {
+				return &fs.PathError{Op: "mkdir", Path: dir, Err: fs.ErrExist}
+			}
+		} else {
+			m.files[dir] = &memFile{name: filepath.Base(dir), dir: true, modTime: time.Now()}
+		}
+		if dir == filepath.Dir(dir) {
+			return nil
+		}
+	}
+}
+
+func (m *memFS) Rename(oldName, newName string) error {
+	file, err := m.lookup("rename", oldName)
+	if err != nil {
+		return err
+	}
+	if file.dir {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: fs.ErrInvalid}
+	}
+	if _, err := m.lookup("rename", filepath.Dir(newName)); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, filepath.Clean(oldName))
+	file.name = filepath.Base(newName)
+	m.files[filepath.Clean(newName)] = file
+	return nil
+}
+
+func (m *memFS) Remove(name string) error {
+	file, err := m.lookup("remove", name)
+	if err != nil {
+		return err
+	}
+	if file.dir {
+		entries, _ := m.ReadDir(name)
+		if len(entries) > 0 {
+			return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrExist}
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, filepath.Clean(name))
+	return nil
+}
+
+// newMemSorter returns a sorter over a memFS whose files are dated by their
+// names, as most other date sources read the disk directly. The paths are
+// inside a temporary folder, so anything written to the disk by mistake
+// shows up there.
+func newMemSorter(t *testing.T, fsys *memFS) (s *sorter, src, dest string) {
+	t.Helper()
+	root := t.TempDir()
+	src, dest = filepath.Join(root, "src"), filepath.Join(root, "dest")
+	fsys.MkdirAll(src, 0755)
+	fsys.MkdirAll(dest, 0755)
+	s = newTestSorter(t, fsys, dest, src)
+	s.dateSources = []string{dateSourceFilename}
+	return s, src, dest
+}
+
+// checkMemFiles compares the files of a memFS, relative to root, with want
+func checkMemFiles(t *testing.T, fsys *memFS, root string, want ...string) {
+	t.Helper()
+	var got []string
+	for _, name := range fsys.names() {
+		rel, err := filepath.Rel(root, name)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			t.Fatalf("file %s outside %s", name, root)
+		}
+		got = append(got, filepath.ToSlash(rel))
+	}
+	sort.Strings(want)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("files are\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestMemFSCopiesIntoMonthFolders(t *testing.T) {
+	fsys := newMemFS()
+	s, src, dest := newMemSorter(t, fsys)
+	fsys.writeFile(filepath.Join(src, "IMG_20210506_070809.jpg"), []byte("may"), time.Now())
+	fsys.writeFile(filepath.Join(src, "trip", "IMG_20221224_180000.jpg"), []byte("december"), time.Now())
+	fsys.writeFile(filepath.Join(src, "notes.txt"), []byte("not a photo"), time.Now())
+	if err := s.run(); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Dir(dest)
+	checkMemFiles(t, fsys, root,
+		"src/IMG_20210506_070809.jpg",
+		"src/trip/IMG_20221224_180000.jpg",
+		"src/notes.txt",
+		"dest/2021/05/IMG_20210506_070809.jpg",
+		"dest/2022/12/IMG_20221224_180000.jpg",
+	)
+	file, err := fsys.Open(filepath.Join(dest, "2022", "12", "IMG_20221224_180000.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(file)
+	if string(data) != "december" {
+		t.Errorf("copy holds %q, want %q", data, "december")
+	}
+	if s.counts[outcomeSorted] != 2 {
+		t.Errorf("sorted %d files, want 2", s.counts[outcomeSorted])
+	}
+	if _, err := os.Stat(filepath.Join(dest, "2021")); err == nil {
+		t.Error("the run wrote month folders to the disk")
+	}
+}
+
+func TestMemFSMoveLeavesExistingFiles(t *testing.T) {
+	fsys := newMemFS()
+	s, src, dest := newMemSorter(t, fsys)
+	s.moveFiles = true
+	fsys.writeFile(filepath.Join(src, "IMG_20210506_070809.jpg"), []byte("new"), time.Now())
+	fsys.writeFile(filepath.Join(src, "IMG_20210507_070809.jpg"), []byte("second"), time.Now())
+	fsys.writeFile(filepath.Join(dest, "2021", "05", "IMG_20210506_070809.jpg"), []byte("old"), time.Now())
+	if err := s.run(); err != nil {
+		t.Fatal(err)
+	}
+	checkMemFiles(t, fsys, filepath.Dir(dest),
+		"src/IMG_20210506_070809.jpg",
+		"dest/2021/05/IMG_20210506_070809.jpg",
+		"dest/2021/05/IMG_20210507_070809.jpg",
+	)
+	if s.counts[outcomeExisting] != 1 || s.counts[outcomeSorted] != 1 {
+		t.Errorf("counts are %v, want one existing and one sorted", s.counts)
+	}
+}
+
+func TestMemFSResortInPlace(t *testing.T) {
+	fsys := newMemFS()
+	s, _, dest := newMemSorter(t, fsys)
+	s.sourceDirs = []string{dest}
+	s.moveFiles = true
+	fsys.writeFile(filepath.Join(dest, "2021", "05", "IMG_20210506_070809.jpg"), []byte("in place"), time.Now())
+	fsys.writeFile(filepath.Join(dest, "2020", "01", "IMG_20210601_120000.jpg"), []byte("misplaced"), time.Now())
+	if err := s.run(); err != nil {
+		t.Fatal(err)
+	}
+	checkMemFiles(t, fsys, dest,
+		"2021/05/IMG_20210506_070809.jpg",
+		"2021/06/IMG_20210601_120000.jpg",
+	)
+}
+
+func TestMemFSDatesByExif(t *testing.T) {
+	fsys := newMemFS()
+	s, src, dest := newMemSorter(t, fsys)
+	s.dateSources = []string{dateSourceExif}
+	date := time.Date(2021, time.May, 6, 7, 8, 9, 0, time.Local)
+	fsys.writeFile(filepath.Join(src, "DSC_0001.jpg"), testJPEG(date, "a"), time.Now())
+	if err := s.run(); err != nil {
+		t.Fatal(err)
+	}
+	checkMemFiles(t, fsys, filepath.Dir(dest),
+		"src/DSC_0001.jpg",
+		"dest/2021/05/DSC_0001.jpg",
+	)
+}
+
+func TestMemFSUndatedFiles(t *testing.T) {
+	fsys := newMemFS()
+	s, src, dest := newMemSorter(t, fsys)
+	s.noDate = noDateUnsorted
+	fsys.writeFile(filepath.Join(src, "card", "DSC_0001.jpg"), []byte("undated"), time.Now())
+	if err := s.run(); err != nil {
+		t.Fatal(err)
+	}
+	checkMemFiles(t, fsys, filepath.Dir(dest),
+		"src/card/DSC_0001.jpg",
+		"dest/unsorted/card/DSC_0001.jpg",
+	)
+}
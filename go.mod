@@ -2,4 +2,8 @@ module github.com/acadmyn/gopicsort
 
 go 1.21
 
-require github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd 
+require github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
+
+require golang.org/x/text v0.14.0
+
+require golang.org/x/sys v0.30.0
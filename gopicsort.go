@@ -1,11 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -13,32 +16,530 @@ import (
 )
 
 func main() {
+	// Dispatch subcommands before parsing the sort flags
+	planning := false
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "plan":
+			// Plans take the sort flags, so they are parsed below
+			planning = true
+			os.Args = append(os.Args[:1:1], os.Args[2:]...)
+		case "apply":
+			runApply(os.Args[2:])
+			return
+		case "yearbook":
+			runYearbook(os.Args[2:])
+			return
+		case "import-card":
+			runImportCard(os.Args[2:])
+			return
+		case "auto-import":
+			runAutoImport(os.Args[2:])
+			return
+		case "import-device":
+			runImportDevice(os.Args[2:])
+			return
+		case "unlock":
+			runUnlock(os.Args[2:])
+			return
+		case "decode-worker":
+			runDecodeWorker(os.Args[2:])
+			return
+		case "lint":
+			runLint(os.Args[2:])
+			return
+		case "history":
+			runHistory(os.Args[2:])
+			return
+		case "hold":
+			runHold(os.Args[2:])
+			return
+		case "stats":
+			runStats(os.Args[2:])
+			return
+		case "fix-tz":
+			runFixTZ(os.Args[2:])
+			return
+		case "verify":
+			runVerify(os.Args[2:])
+			return
+		case "consolidate":
+			runConsolidate(os.Args[2:])
+			return
+		case "dedupe":
+			runDedupe(os.Args[2:])
+			return
+		case "adopt":
+			runAdopt(os.Args[2:])
+			return
+		case "scrub":
+			runScrub(os.Args[2:])
+			return
+		case "diff":
+			runDiff(os.Args[2:])
+			return
+		case "service":
+			runService(os.Args[2:])
+			return
+		case "completion":
+			runCompletion(os.Args[2:])
+			return
+		case "man":
+			runMan(os.Args[2:])
+			return
+		case "version":
+			runVersion(os.Args[2:])
+			return
+		}
+	}
+	runSort(planning)
+}
+
+// runSort runs the sort command, or with planning the "plan" subcommand,
+// with the flags in os.Args
+func runSort(planning bool) {
 	// Parse command-line arguments
-	sourceDir := flag.String("source", "", "Source directory containing photos")
-	destDir := flag.String("dest", "", "Destination directory for sorted photos")
+	var sourceDirs stringList
+	flag.Var(&sourceDirs, "source", "Source directory containing photos. Can be repeated or comma-separated, and further sources can follow the flags as arguments; '-' reads a list of files from standard input like -files-from -")
+	filesFrom := flag.String("files-from", "", "Sort the files listed in this file, one per line or NUL-separated as written by 'find -print0'; '-' reads standard input")
+	var destDirs stringList
+	flag.Var(&destDirs, "dest", "Destination directory for sorted photos. Can be repeated or comma-separated to mirror every sorted file into further destinations, e.g., a backup drive")
 	moveFiles := flag.Bool("move", false, "Move files instead of copying them")
+	pruneEmpty := flag.Bool("prune-empty", false, "With -move, remove source directories that are left empty")
+	readOnlySource := flag.Bool("read-only-source", false, "Never write to the source directories, refuse -move and -link=hard, and check that the source files hash the same after the run as before it")
+	resumable := flag.Bool("resumable", false, "Copy large files in journaled chunks that resume after an interruption instead of restarting")
+	retryWait := flag.Duration("retry-wait", 10*time.Minute, "With -resumable, how long to wait for a disconnected destination to come back")
+	spaceCheck := flag.String("space-check", spaceCheckAbort, "Before copying, compare the size of the files with the free space on the destination: 'abort', 'warn', or 'off'")
+	maxBandwidth := flag.String("max-bandwidth", "", "Limit how fast file contents are read for copies and uploads (e.g., '50MB/s')")
+	maxFilesPerSec := flag.Float64("max-files-per-sec", 0, "Limit how many files are processed per second")
+	lowPriority := flag.Bool("low-priority", false, "Run with idle I/O priority and lower CPU priority (Linux), so other services on the machine come first")
+	keepAlbums := flag.Bool("keep-folder-names", false, "Append the name of each file's source folder to its date folder, e.g. 'Italy Trip/IMG_1.jpg' goes to '2023/07/Italy Trip/'")
+	eventGap := flag.Duration("event-gap", 0, "Group photos into event folders below the month, e.g. '2023/07/2023-07-14 Event 1', starting a new event when photos are more than this far apart (e.g., '6h')")
+	symlinks := flag.String("symlinks", symlinkSkip, "Symbolic links in the sources: 'skip' with a warning, 'follow' to sort linked files and walk linked folders, or 'preserve' to recreate linked files as links in the destination")
+	followSymlinks := flag.Bool("follow-symlinks", false, "Same as -symlinks follow")
+	unicodeForm := flag.String("unicode", unicodeNFC, "Unicode normalization of destination names: 'nfc' (Linux and Windows), 'nfd' (macOS), or 'off' to keep names as they are")
+	sequenceGap := flag.Duration("sequence-gap", 0, "Group bursts and timelapses into a folder below the month, e.g. '2023/07/2023-07-14 15.30.12 Sequence', when photos in a folder follow each other within this time (e.g., '10s')")
+	sequenceMin := flag.Int("sequence-min", defaultSequenceMin, "With -sequence-gap, the fewest photos that make a sequence")
+	fileTimeout := flag.Duration("file-timeout", 0, "Skip files that cannot be read within this time (e.g., '2m'), such as files on a failing disk, instead of waiting indefinitely")
+	linkMode := flag.String("link", "", "Link instead of copying: 'hard' for hard links, 'reflink' for copy-on-write clones (falls back to copying)")
 	fileFormat := flag.String("format", "", "Specific file format to process (e.g., 'jpg,png'). Leave empty for all supported formats")
+	var extAliases stringList
+	flag.Var(&extAliases, "ext-alias", "Treat an extension as another one (e.g., 'jfif=jpg'), in addition to built-in aliases such as jpeg=jpg and tif=tiff. Can be repeated or comma-separated")
+	normalizeExt := flag.Bool("normalize-ext", false, "Give sorted files the lower-case canonical extension (e.g., IMG_1.JPEG becomes IMG_1.jpg)")
+	sniff := flag.Bool("sniff", false, "Detect file formats from their contents instead of trusting extensions, so renamed and extension-less files are recognized")
+	fixExt := flag.Bool("fix-ext", false, "Give destination files the extension matching their detected format (implies -sniff)")
+	validate := flag.String("validate", "", "Check image integrity before sorting: 'header' decodes the image header, 'full' decodes the whole image")
+	quarantineCorrupt := flag.Bool("quarantine", false, "Move (with -move) or copy unreadable images into a quarantine folder in the destination, with a report")
+	dedupe := flag.Bool("dedupe", false, "Skip files whose contents already exist in the destination or were imported earlier in the run")
+	recoverMode := flag.Bool("recover", false, "Recovery mode for file-carving output (e.g., PhotoRec): implies -sniff, -fix-ext, -validate=full, -quarantine, and -dedupe, and quarantines files without a capture date")
+	var excludes stringList
+	flag.Var(&excludes, "exclude", "Glob pattern of files or directories to skip (e.g., '*.tmp', '**/Thumbnails/**'). Can be repeated or comma-separated")
+	afterDate := flag.String("after", "", "Only process photos taken on or after this date (YYYY-MM-DD)")
+	beforeDate := flag.String("before", "", "Only process photos taken before this date (YYYY-MM-DD)")
+	timeOffset := flag.Duration("time-offset", 0, "Add this duration to every capture time to correct a wrong camera clock (e.g., '+2h30m', '-45m')")
+	dateSources := flag.String("date-sources", defaultDateSources, "Where to read the capture date from, in order of preference: exif, xmp, container (PNG, video, DJI), takeout, filename, mtime")
+	noDate := flag.String("no-date", noDateSkip, "What to do with files without a capture date: 'skip', 'unsorted-folder' to collect them in an unsorted folder in the destination, or 'fail'")
+	dateTagOrder := flag.String("date-tags", defaultDateTags, "EXIF tags to read the capture date from, in order of preference (DateTimeOriginal, DateTimeDigitized, DateTime)")
+	assumeTZ := flag.String("assume-tz", "", "Time zone the camera clock was set to (e.g., 'UTC', 'Europe/Berlin'); capture times are converted to the local time zone")
+	writeExif := flag.Bool("write-exif", false, "Write corrected capture dates into the EXIF of sorted JPEG copies")
+	videos := flag.Bool("videos", false, "Also sort MP4 and MOV videos, dated by their recording time")
+	previews := flag.Bool("previews", false, "Write a downscaled JPEG preview of every sorted file into a .previews folder in the destination, with the same layout")
+	previewSize := flag.Int("preview-size", defaultPreviewSize, "Longest side of -previews in pixels")
+	convertSpec := flag.String("convert", "", "Convert files to another format at the destination, e.g., 'heic=jpg' to turn iPhone HEICs into JPEGs (needs heif-convert, sips, or magick)")
+	convertQuality := flag.Int("quality", defaultConvertQuality, "JPEG quality of files converted by -convert, from 1 to 100")
+	keepOriginal := flag.Bool("keep-original", false, "With -convert, also sort the original file next to the converted one")
+	stripPrivate := flag.Bool("strip-private", false, "Remove GPS location, camera owner, serial numbers, and maker notes from sorted JPEG copies; other formats are not sorted")
+	splitMotionPhotos := flag.Bool("split-motion-photos", false, "Sort the video embedded in Google and Samsung motion photos as a separate MP4 file next to the still, instead of keeping the file intact")
+	useManifest := flag.Bool("manifest", false, "Record the SHA-256 of every sorted file in the library's catalog, so 'scrub' can detect files that later change or rot on disk")
+	reportPath := flag.String("report", "", "Write an HTML report of the run with thumbnails of the imported files, duplicates, and errors to this file")
+	touchExif := flag.Bool("touch-exif", false, "Set the modification time (and creation time on Windows and macOS) of sorted files to their capture time")
+	screenshotsDir := flag.String("screenshots", "", "Sort screenshots and downloaded images into this separate tree (relative paths are inside -dest, e.g., 'screenshots')")
+	messagingDir := flag.String("messaging", "", "Sort WhatsApp and Telegram media into this separate tree (relative paths are inside -dest, e.g., 'messaging')")
+	onConflict := flag.String("on-conflict", conflictFail, "What to do when another run, possibly on another machine, holds the destination's lock: 'fail', 'wait', or 'warn' to run anyway")
+	retainUntil := flag.String("retain-until", "", "Put every sorted file under retention until this date (YYYY-MM-DD); held files are never moved, modified, or deleted by any command")
+	retainReason := flag.String("retain-reason", "", "Reason recorded with -retain-until (e.g., client name)")
+	s3Endpoint := flag.String("s3-endpoint", os.Getenv("AWS_ENDPOINT_URL"), "Endpoint of an S3-compatible service for s3:// destinations, e.g., MinIO or Backblaze B2 (default AWS, or $AWS_ENDPOINT_URL)")
+	sandbox := flag.Bool("sandbox", false, "Decode images for -validate, slate matching, previews, and thumbnails in a separate process with a timeout and memory limit")
+	decodeTimeout := flag.Duration("decode-timeout", 30*time.Second, "With -sandbox, how long decoding one file may take")
+	decodeMemory := flag.Int64("decode-memory", 1024, "With -sandbox, memory limit in MB for decoding one file")
+	overflowDir := flag.String("overflow", "", "Sort files too large for the destination file system (over 4 GB on FAT32) into this directory instead of skipping them")
+	samplePercent := flag.Float64("sample", 0, "Only sort this percentage of the files (e.g., 5), chosen reproducibly from -seed, to try settings on a large collection")
+	metadataCachePath := flag.String("metadata-cache", "", "Keep the EXIF dates and content hashes of files in this file between runs, so repeated plans and retries over the same source only read new and changed files")
+	seed := flag.Int64("seed", 0, "Seed for -sample; the same seed selects the same files (default random, recorded in the run history)")
+	takeout := flag.Bool("takeout", false, "Use the capture time from Google Takeout JSON sidecars for files without an EXIF date")
+	var personFilter stringList
+	flag.Var(&personFilter, "person", "Only process photos tagged with a matching person in XMP face regions (glob, case-insensitive). Can be repeated or comma-separated")
+	var cameraFilter, excludeCamera stringList
+	flag.Var(&cameraFilter, "camera", "Only process files whose EXIF camera make or model matches (glob, case-insensitive, e.g., 'iPhone*' or 'Canon EOS*'). Can be repeated or comma-separated")
+	flag.Var(&excludeCamera, "exclude-camera", "Skip files whose EXIF camera make or model matches (glob, case-insensitive, e.g., 'DJI*'). Can be repeated or comma-separated")
+	minRating := flag.Int("min-rating", 0, "Only process files with at least this many stars in their XMP or EXIF rating (1 to 5); unrated and rejected files are skipped")
+	var keywordFilter, excludeKeywords stringList
+	flag.Var(&keywordFilter, "keyword", "Only process files with a matching XMP or EXIF keyword (glob, case-insensitive). Can be repeated or comma-separated")
+	flag.Var(&excludeKeywords, "exclude-keyword", "Skip files with a matching XMP or EXIF keyword (glob, case-insensitive). Can be repeated or comma-separated")
+	picksDir := flag.String("picks", "", "Sort files rated -pick-rating stars or more into this separate tree (relative paths are inside -dest, e.g., 'picks')")
+	pickRating := flag.Int("pick-rating", 4, "Least star rating of the files -picks takes")
+	rejectsDir := flag.String("rejects", "", "Sort files rejected in Lightroom or Bridge (rating -1) into this separate tree instead of the library (relative paths are inside -dest, e.g., 'rejects')")
+	var routeSpecs stringList
+	flag.Var(&routeSpecs, "route", "Sort some formats into another library, as FORMATS=DIR with extensions or 'raw', 'image', and 'video' (e.g., 'raw=/archive/raw', 'jpg,heic=/photos'); relative paths are inside -dest. Can be repeated")
+	var viewSpecs stringList
+	flag.Var(&viewSpecs, "view", "Build a symlink tree browsing the library by 'camera', 'lens', or 'location' in a directory (e.g., 'camera=/photos-by-camera'). Can be repeated")
+	peopleView := flag.String("people-view", "", "Directory in which to create per-person folders of symlinks to the sorted photos")
+	classifierSpec := flag.String("classifier", "", "External image classifier: an http(s) endpoint receiving the image as POST body, or a command run with the image path")
+	classifierMinScore := flag.Float64("classifier-min-score", 0, "Minimum score for scored classifier labels")
+	var labelFilter, excludeLabels stringList
+	flag.Var(&labelFilter, "label", "Only process images with a matching classifier label (glob, case-insensitive). Can be repeated or comma-separated")
+	flag.Var(&excludeLabels, "exclude-label", "Skip images with a matching classifier label (glob, case-insensitive). Can be repeated or comma-separated")
+	slateDecoder := flag.String("slate-decoder", "", "Command that prints the text of a QR code slate in an image (e.g., 'zbarimg --raw -q'); starts a new set folder named after the code")
+	slateImage := flag.String("slate-image", "", "Reference image of a marker card; matching photos start a new numbered set folder")
+	slateThreshold := flag.Int("slate-threshold", 10, "Maximum image hash distance (0-64) for a photo to match -slate-image")
+	minSize := flag.String("min-size", "", "Skip files smaller than this (e.g., '50KB'), such as thumbnails and messaging-app previews")
+	maxSize := flag.String("max-size", "", "Skip files larger than this (e.g., '2GB'), for example to leave large videos for a separate run")
+	maxDepth := flag.Int("max-depth", 0, "Only descend this many folder levels into the sources; 1 sorts just the files directly in each source (default 0, no limit)")
+	skipHidden := flag.Bool("skip-hidden", false, "Skip hidden files and directories and system folders such as @eaDir and $RECYCLE.BIN")
+	layout := flag.String("layout", "", "Folder layout as a Go time layout (e.g., '2006/2006-01-02'), or preset:week or preset:quarter for ISO week and quarter folders; default is the layout recorded by 'adopt', or '2006/01'")
+	monthNameList := flag.String("month-names", "", "Month names for 'January' and 'Jan' in -layout: a language code such as 'de' or 'fr', or twelve comma-separated names (default English, or the names recorded by 'adopt')")
+	renameTemplate := flag.String("rename", "", "Template for destination file names (e.g., '{{.Shoot}}_{{.DateTime.Format \"150405\"}}_{{.Name}}{{.Ext}}')")
+	shootName := flag.String("shoot", "", "Shoot name, available as {{.Shoot}} in -rename templates")
+	lockMode := flag.String("lock", "", "After a successful run, make the month folders written to read-only: 'readonly' or 'immutable' (also sets chattr +i / chflags uchg where permitted)")
+	snapshotMode := flag.String("snapshot", "", "After a successful run, snapshot the destination: 'btrfs' (destination must be a subvolume) or 'zfs'")
+	snapshotDir := flag.String("snapshot-dir", "", "Where btrfs snapshots are created (default .snapshots next to the destination)")
+	backupDir := flag.String("backup", "", "Backup destination that receives a second copy of every sorted file, using the same layout")
+	watch := flag.Bool("watch", false, "Keep running and process new files as they appear in the source directory")
+	tether := flag.Bool("tether", false, "Tethered-capture mode: like -watch, tuned for sub-second latency on tethering software output folders")
+	pollInterval := flag.Duration("poll-interval", 2*time.Second, "How often -watch checks the source directory for new files")
+	notifyURL := flag.String("notify", "", "Webhook URL to notify when the run, or a batch of files in watch mode, finishes")
+	notifyFormat := flag.String("notify-format", notifyGeneric, "Notification payload: 'generic' JSON, 'slack', 'discord', or 'ntfy'")
+	metricsAddr := flag.String("metrics-addr", "", "In watch mode, serve Prometheus metrics at /metrics on this address (e.g., ':9090')")
+	uploadAddr := flag.String("upload-addr", "", "In watch mode, serve an authenticated photo upload page and endpoint on this address (e.g., ':8080')")
+	uploadToken := flag.String("upload-token", os.Getenv("GOPICSORT_UPLOAD_TOKEN"), "Token required by the upload endpoint (default $GOPICSORT_UPLOAD_TOKEN)")
+	controlAddr := flag.String("control-addr", "", "In watch mode, serve a REST API to trigger imports, pause and resume, and query progress and stats on this address (e.g., 'localhost:8081')")
+	controlToken := flag.String("control-token", os.Getenv("GOPICSORT_CONTROL_TOKEN"), "Bearer token required by the control API (default $GOPICSORT_CONTROL_TOKEN)")
+	httpAddr := flag.String("http", "", "In watch mode, serve a web dashboard with live progress, recent imports, problem files, and duplicates to review on this address (e.g., ':8080')")
+	httpToken := flag.String("http-token", os.Getenv("GOPICSORT_HTTP_TOKEN"), "Token required by the web dashboard, given once as ?token= (default $GOPICSORT_HTTP_TOKEN)")
+	output := flag.String("output", outputText, "Result format: 'text', 'json' to print a single JSON object with the run's summary to standard output when it ends, or 'ndjson' to print one JSON event per file action as the run goes on")
+	logOpts := addLogFlags(flag.CommandLine)
+	var planOut *string
+	if planning {
+		planOut = flag.String("o", "", "Write the plan to this file (default standard output)")
+	}
 	flag.Parse()
+	sourceDirs = append(sourceDirs, flag.Args()...)
+
+	// Configure logging before anything is logged
+	if err := logOpts.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitFatal)
+	}
+	if err := validateOutput(*output); err != nil {
+		fatal(err.Error())
+	}
+	jsonResult = *output == outputJSON
+	if *output == outputNDJSON {
+		events = newEventStream(os.Stdout)
+	}
+
+	// "-source -" reads the file list from standard input
+	dirs := sourceDirs[:0]
+	for _, dir := range sourceDirs {
+		if dir != "-" {
+			dirs = append(dirs, dir)
+		} else if *filesFrom == "" || *filesFrom == "-" {
+			*filesFrom = "-"
+		} else {
+			fatal("-source - cannot be combined with -files-from " + *filesFrom)
+		}
+	}
+	sourceDirs = dirs
+
+	// The first destination is the library, further ones are its mirrors
+	destDir := ""
+	if len(destDirs) > 0 {
+		destDir = destDirs[0]
+	}
 
 	// Validate command-line arguments
-	if *sourceDir == "" || *destDir == "" {
+	if (len(sourceDirs) == 0 && *filesFrom == "") || destDir == "" {
 		flag.Usage()
-		os.Exit(1)
+		os.Exit(exitFatal)
+	}
+	if *keepAlbums && *eventGap > 0 {
+		fatal("-keep-folder-names and -event-gap both add a folder below the month, use one of them")
+	}
+	if *eventGap < 0 {
+		fatal("-event-gap must not be negative")
+	}
+	if *eventGap > 0 && (*watch || *tether) {
+		fatal("-event-gap needs all photos up front and cannot be used with -watch")
+	}
+	if *sequenceGap < 0 {
+		fatal("-sequence-gap must not be negative")
+	}
+	if *sequenceMin < 2 {
+		fatal("-sequence-min must be at least 2")
+	}
+	if *sequenceGap > 0 && (*watch || *tether) {
+		fatal("-sequence-gap needs all photos up front and cannot be used with -watch")
+	}
+	var fileList []string
+	if *filesFrom != "" {
+		var err error
+		if fileList, err = readFileList(*filesFrom); err != nil {
+			fatal("Failed to read file list", "error", err)
+		}
+		if len(sourceDirs) == 0 && (*watch || *tether) {
+			fatal("-watch needs a source directory, a file list is only sorted once")
+		}
 	}
 
-	// Ensure the source directory exists
-	sourceStat, err := os.Stat(*sourceDir)
-	if err != nil || !sourceStat.IsDir() {
-		log.Fatalf("Source directory does not exist or is not a directory: %v", *sourceDir)
+	// Recovery mode turns on every safeguard for carved, nameless files
+	if *recoverMode {
+		*fixExt = true
+		*dedupe = true
+		*quarantineCorrupt = true
+		if *validate == "" {
+			*validate = validateFull
+		}
 	}
 
-	// Ensure the destination directory exists, create if not
-	if err := os.MkdirAll(*destDir, 0755); err != nil {
-		log.Fatalf("Failed to create destination directory: %v", err)
+	// A read-only source is never moved from or written to, so it cannot be
+	// re-sorted in place or receive uploads. Hard links share the source
+	// file, so later edits in the library would change the original.
+	if *readOnlySource {
+		for _, name := range []string{"move", "prune-empty", "watch", "tether", "upload-addr"} {
+			if isFlagSet(flag.CommandLine, name) {
+				fatal("-read-only-source cannot be combined with -" + name)
+			}
+		}
+		if *linkMode == linkHard {
+			fatal("-read-only-source cannot be combined with -link=hard")
+		}
+		for _, dir := range sourceDirs {
+			if !isRemoteDest(destDir) && (sameDir(dir, destDir) || isWithin(dir, destDir)) {
+				fatal("-read-only-source needs a destination outside the source", "source", dir, "dest", destDir)
+			}
+		}
+		// Nor may the report, cache, log, or other files of the run go there
+		outputs := map[string]string{
+			"report":         *reportPath,
+			"metadata-cache": *metadataCachePath,
+			"log-file":       *logOpts.file,
+			"backup":         *backupDir,
+			"overflow":       *overflowDir,
+			"snapshot-dir":   *snapshotDir,
+			"people-view":    *peopleView,
+		}
+		if planOut != nil {
+			outputs["o"] = *planOut
+		}
+		for name, path := range outputs {
+			if dir := containingSource(sourceDirs, path); path != "" && path != "-" && dir != "" {
+				fatal("-read-only-source cannot write -"+name+" inside the source", "source", dir, "path", path)
+			}
+		}
+	}
+
+	// A source that is the destination itself is re-sorted in place: files
+	// already in the right folder stay, misplaced ones are moved
+	for _, dir := range sourceDirs {
+		if isRemoteDest(destDir) || !sameDir(dir, destDir) {
+			continue
+		}
+		if *linkMode != "" {
+			fatal("-link cannot be used to re-sort a library in place")
+		}
+		if !*moveFiles {
+			slog.Info("Source is the destination, re-sorting in place by moving misplaced files")
+			*moveFiles = true
+		}
+	}
+
+	// Validate the link mode
+	if err := validateLinkMode(*linkMode); err != nil {
+		fatal(err.Error())
+	}
+	if *linkMode != "" && *moveFiles {
+		fatal("-link cannot be combined with -move")
+	}
+	if *followSymlinks {
+		if isFlagSet(flag.CommandLine, "symlinks") && *symlinks != symlinkFollow {
+			fatal("-follow-symlinks cannot be combined with -symlinks " + *symlinks)
+		}
+		*symlinks = symlinkFollow
+	}
+	if err := validateSymlinkPolicy(*symlinks); err != nil {
+		fatal(err.Error())
+	}
+	if *symlinks == symlinkPreserve && (planning || isRemoteDest(destDir)) {
+		fatal("-symlinks preserve needs a local destination and cannot be used with 'plan'")
+	}
+	if err := validateUnicodeForm(*unicodeForm); err != nil {
+		fatal(err.Error())
+	}
+	if err := validateSpaceCheck(*spaceCheck); err != nil {
+		fatal(err.Error())
+	}
+	if err := validateLockMode(*lockMode); err != nil {
+		fatal(err.Error())
+	}
+	if err := validateValidateMode(*validate); err != nil {
+		fatal(err.Error())
+	}
+	if err := validateSnapshotMode(*snapshotMode); err != nil {
+		fatal(err.Error())
+	}
+	if err := validateConflictPolicy(*onConflict); err != nil {
+		fatal(err.Error())
+	}
+	if err := validateNotifyFormat(*notifyFormat); err != nil {
+		fatal(err.Error())
+	}
+	if *maxBandwidth != "" {
+		rate, err := parseBandwidth(*maxBandwidth)
+		if err != nil {
+			fatal(err.Error())
+		}
+		bandwidthLimit = newRateLimiter(rate)
+	}
+	if *maxFilesPerSec < 0 {
+		fatal("-max-files-per-sec must not be negative")
+	}
+	if *lowPriority {
+		if err := lowerPriority(); err != nil {
+			slog.Warn("Could not lower priority", "error", err)
+		}
+	}
+	if *pruneEmpty && !*moveFiles {
+		fatal("-prune-empty requires -move")
+	}
+
+	// Ensure the source directories exist
+	for _, dir := range sourceDirs {
+		if stat, err := os.Stat(dir); err != nil || !stat.IsDir() {
+			fatal("Source directory does not exist or is not a directory", "path", dir)
+		}
+	}
+
+	// Connect to a remote destination, or ensure the destination directory
+	// exists, create if not
+	if planning {
+		for _, name := range planIncompatible {
+			if isFlagSet(flag.CommandLine, name) {
+				fatal("Flag cannot be used with 'plan'", "flag", "-"+name)
+			}
+		}
+		if isRemoteDest(destDir) {
+			fatal("'plan' requires a local destination")
+		}
+		if len(destDirs) > 1 {
+			fatal("'plan' takes a single -dest")
+		}
+		if jsonResult {
+			fatal("'plan' writes the plan instead of a result, use -output text or ndjson")
+		}
+		if events != nil && *planOut == "" {
+			fatal("'plan' with -output ndjson requires -o for the plan")
+		}
+	}
+
+	var store storage
+	var err error
+	if isRemoteDest(destDir) {
+		for _, name := range remoteIncompatible {
+			if isFlagSet(flag.CommandLine, name) {
+				fatal("Flag requires a local destination", "flag", "-"+name)
+			}
+		}
+		if filepath.IsAbs(*screenshotsDir) {
+			fatal("-screenshots must be a relative path with a remote destination")
+		}
+		if filepath.IsAbs(*messagingDir) {
+			fatal("-messaging must be a relative path with a remote destination")
+		}
+		if filepath.IsAbs(*picksDir) || filepath.IsAbs(*rejectsDir) {
+			fatal("-picks and -rejects must be relative paths with a remote destination")
+		}
+		for _, spec := range routeSpecs {
+			if _, dir, _ := strings.Cut(spec, "="); filepath.IsAbs(strings.TrimSpace(dir)) {
+				fatal("-route must use relative paths with a remote destination", "route", spec)
+			}
+		}
+		if store, err = newStorage(destDir, *s3Endpoint); err != nil {
+			fatal(err.Error())
+		}
+	} else if err := os.MkdirAll(destDir, 0755); err != nil {
+		fatal("Failed to create destination directory", "error", err)
+	}
+
+	// Mirrors get a copy of every sorted file in the library's layout
+	for _, mirror := range destDirs[1:] {
+		if store != nil || isRemoteDest(mirror) {
+			fatal("Mirrored destinations must be local directories", "dest", mirror)
+		}
+		if err := os.MkdirAll(mirror, 0755); err != nil {
+			fatal("Failed to create mirrored destination", "path", mirror, "error", err)
+		}
+		if sameDir(mirror, destDir) {
+			fatal("A mirrored destination is the same directory as the library", "dest", mirror)
+		}
+	}
+
+	s := &sorter{
+		fsys:              localFS,
+		sourceDirs:        sourceDirs,
+		fileList:          fileList,
+		destDir:           destDir,
+		store:             store,
+		moveFiles:         *moveFiles,
+		pruneEmpty:        *pruneEmpty,
+		linkMode:          *linkMode,
+		symlinks:          *symlinks,
+		unicodeForm:       *unicodeForm,
+		excludes:          excludes,
+		skipHidden:        *skipHidden,
+		maxDepth:          *maxDepth,
+		sniff:             *sniff || *fixExt,
+		fixExt:            *fixExt,
+		normalizeExt:      *normalizeExt,
+		validate:          *validate,
+		personFilter:      personFilter,
+		cameraFilter:      cameraFilter,
+		excludeCamera:     excludeCamera,
+		peopleView:        *peopleView,
+		labelFilter:       labelFilter,
+		excludeLabels:     excludeLabels,
+		shoot:             *shootName,
+		backupDir:         *backupDir,
+		mirrors:           destDirs[1:],
+		lockMode:          *lockMode,
+		resumable:         *resumable,
+		retryWait:         *retryWait,
+		fileTimeout:       *fileTimeout,
+		fileLimit:         newRateLimiter(*maxFilesPerSec),
+		eventGap:          *eventGap,
+		sequenceGap:       *sequenceGap,
+		sequenceMin:       *sequenceMin,
+		keepAlbums:        *keepAlbums,
+		spaceCheck:        *spaceCheck,
+		quarantineCorrupt: *quarantineCorrupt,
+		quarantineUndated: *recoverMode,
+		dedupe:            *dedupe,
+		snapshotMode:      *snapshotMode,
+		snapshotDir:       *snapshotDir,
+		command:           "sort",
+		runID:             newRunID(),
+		started:           time.Now(),
+		movedFrom:         make(map[string]bool),
+		notify:            newNotifier(*notifyURL, *notifyFormat),
+	}
+
+	// Extend the extension alias table before any extensions are compared
+	for _, alias := range extAliases {
+		if err := addExtensionAlias(alias); err != nil {
+			fatal(err.Error())
+		}
 	}
 
 	// Process the file format parameter
-	var formats []string
 	if *fileFormat != "" {
 		// Split the format string by comma and trim spaces
 		for _, f := range strings.Split(*fileFormat, ",") {
@@ -48,146 +549,555 @@ func main() {
 				if !strings.HasPrefix(format, ".") {
 					format = "." + format
 				}
-				formats = append(formats, strings.ToLower(format))
+				s.formats = append(s.formats, strings.ToLower(format))
 			}
 		}
 	}
 
-	// Walk through the source directory
-	err = filepath.Walk(*sourceDir, func(path string, info os.FileInfo, err error) error {
+	// Parse the date range filter
+	if s.after, err = parseDateFlag("after", *afterDate); err != nil {
+		fatal(err.Error())
+	}
+	if s.before, err = parseDateFlag("before", *beforeDate); err != nil {
+		fatal(err.Error())
+	}
+	if *readOnlySource {
+		s.fsys = readOnlyFS{fileSystem: s.fsys, roots: append(append([]string{}, sourceDirs...), fileList...)}
+	}
+	if !s.after.IsZero() && !s.before.IsZero() && !s.after.Before(s.before) {
+		fatal("-after must be earlier than -before", "after", *afterDate, "before", *beforeDate)
+	}
+
+	// Object keys are relative to the remote destination, which has no
+	// retention catalog
+	if store != nil {
+		s.destDir = ""
+	} else if s.retention, err = loadRetention(destDir); err != nil {
+		fatal("Failed to read retention catalog", "path", destDir, "error", err)
+	}
+	if *moveFiles {
+		for _, dir := range sourceDirs {
+			holds, err := loadRetention(dir)
+			if err != nil {
+				fatal("Failed to read retention catalog", "path", dir, "error", err)
+			}
+			s.sourceHolds = append(s.sourceHolds, holds)
+		}
+	}
+	// Follow the layout of an adopted library unless one is given
+	if *layout != "" {
+		if s.layout, err = resolveLayout(*layout); err != nil {
+			fatal(err.Error())
+		}
+	} else if store == nil {
+		if s.layout, err = libraryLayout(destDir); err != nil {
+			fatal("Failed to read library configuration", "error", err)
+		}
+		if s.layout != defaultLayout {
+			slog.Info("Using the library's folder layout", "layout", s.layout)
+		}
+	}
+	if *monthNameList != "" {
+		if monthNames, err = parseMonthNames(*monthNameList); err != nil {
+			fatal(err.Error())
+		}
+	}
+	if s.keepAlbums && keepsRelDir(s.layout) {
+		fatal("-keep-folder-names cannot be used with a layout ending in " + relDirVar + ", which keeps the whole source folder already")
+	}
+	if s.retainUntil, err = parseDateFlag("retain-until", *retainUntil); err != nil {
+		fatal(err.Error())
+	}
+	s.retainReason = *retainReason
+
+	// Choose where capture dates come from and what happens without one
+	if s.dateSources, err = parseDateSources(*dateSources); err != nil {
+		fatal("Invalid -date-sources", "error", err)
+	}
+	if err := validateNoDate(*noDate); err != nil {
+		fatal(err.Error())
+	}
+	if s.noDate = *noDate; s.noDate == noDateUnsorted && s.store != nil {
+		fatal("-no-date unsorted-folder needs a local destination")
+	}
+
+	// Load the camera time zone
+	if dateTags, err = parseDateTags(*dateTagOrder); err != nil {
+		fatal("Invalid -date-tags", "error", err)
+	}
+	if *assumeTZ != "" {
+		if s.assumeTZ, err = time.LoadLocation(*assumeTZ); err != nil {
+			fatal("Invalid -assume-tz", "error", err)
+		}
+	}
+	s.timeOffset = *timeOffset
+	s.writeExif = *writeExif
+	s.touchExif = *touchExif
+	s.reportPath = *reportPath
+	if *convertSpec != "" {
+		if s.conversions, err = parseConversions(*convertSpec); err != nil {
+			fatal(err.Error())
+		}
+		if *convertQuality < 1 || *convertQuality > 100 {
+			fatal("-quality must be between 1 and 100")
+		}
+		if s.converter, err = findHEICConverter(); err != nil {
+			fatal(err.Error())
+		}
+		s.convertQuality, s.keepOriginal = *convertQuality, *keepOriginal
+	}
+	if s.stripPrivate = *stripPrivate; s.stripPrivate {
+		switch {
+		case s.linkMode != "":
+			fatal("-strip-private cannot be used with -link, links share their metadata with the source")
+		case s.symlinks == symlinkPreserve:
+			fatal("-strip-private cannot be used with -symlinks preserve, links share their metadata with the source")
+		case s.keepOriginal:
+			fatal("-strip-private cannot be used with -keep-original, which sorts originals with their metadata")
+		}
+	}
+	if s.splitMotionPhotos = *splitMotionPhotos; s.splitMotionPhotos {
+		switch {
+		case s.linkMode != "":
+			fatal("-split-motion-photos cannot be used with -link, links share their contents with the source")
+		case s.symlinks == symlinkPreserve:
+			fatal("-split-motion-photos cannot be used with -symlinks preserve, links share their contents with the source")
+		}
+	}
+	s.useManifest = *useManifest
+	if s.maxDepth < 0 {
+		fatal("-max-depth cannot be negative")
+	}
+	if *minSize != "" {
+		n, err := parseBytes(*minSize)
 		if err != nil {
-			return err
+			fatal("Invalid -min-size, expected a size such as '50KB'", "value", *minSize)
+		}
+		s.minSize = int64(n)
+	}
+	if *maxSize != "" {
+		n, err := parseBytes(*maxSize)
+		if err != nil || n == 0 {
+			fatal("Invalid -max-size, expected a size such as '2GB'", "value", *maxSize)
+		}
+		s.maxSize = int64(n)
+	}
+	if s.maxSize > 0 && s.maxSize < s.minSize {
+		fatal("-max-size must not be smaller than -min-size")
+	}
+	if s.views, err = parseViews(viewSpecs); err != nil {
+		fatal(err.Error())
+	}
+	s.previews, s.previewSize = *previews, *previewSize
+	if s.previews && s.previewSize < 16 {
+		fatal("-preview-size must be at least 16")
+	}
+	includeVideos = *videos
+	if *takeout {
+		s.takeout = make(takeoutSidecars)
+	}
+	if *metadataCachePath != "" {
+		if s.cache, err = openMetadataCache(*metadataCachePath); err != nil {
+			slog.Warn("Could not read metadata cache, starting a new one", "path", *metadataCachePath, "error", err)
 		}
+	}
 
-		// Skip directories
-		if info.IsDir() {
-			return nil
+	// Choose the run's seed, logged so a sample can be reproduced
+	if *samplePercent < 0 || *samplePercent > 100 {
+		fatal("-sample must be a percentage between 0 and 100")
+	}
+	s.samplePercent = *samplePercent
+	if s.seed = *seed; s.seed == 0 {
+		s.seed = newRunSeed()
+	}
+	if s.samplePercent > 0 {
+		slog.Info("Sorting a sample of the files", "percent", s.samplePercent, "seed", s.seed)
+	}
+	if s.routes, err = parseRoutes(routeSpecs, s.destDir); err != nil {
+		fatal(err.Error())
+	}
+	if *minRating < 0 || *minRating > 5 {
+		fatal("-min-rating must be between 0 and 5")
+	}
+	if *pickRating < 1 || *pickRating > 5 {
+		fatal("-pick-rating must be between 1 and 5")
+	}
+	s.minRating, s.keywordFilter, s.excludeKeywords, s.pickRating = *minRating, keywordFilter, excludeKeywords, *pickRating
+	if s.picksDir = *picksDir; s.picksDir != "" && !filepath.IsAbs(s.picksDir) {
+		s.picksDir = filepath.Join(s.destDir, s.picksDir)
+	}
+	if s.rejectsDir = *rejectsDir; s.rejectsDir != "" && !filepath.IsAbs(s.rejectsDir) {
+		s.rejectsDir = filepath.Join(s.destDir, s.rejectsDir)
+	}
+	if *screenshotsDir != "" {
+		s.screenshotsDir = *screenshotsDir
+		if !filepath.IsAbs(s.screenshotsDir) {
+			s.screenshotsDir = filepath.Join(s.destDir, s.screenshotsDir)
 		}
+	}
+	if s.messagingDir = *messagingDir; s.messagingDir != "" && !filepath.IsAbs(s.messagingDir) {
+		s.messagingDir = filepath.Join(s.destDir, s.messagingDir)
+	}
 
-		ext := strings.ToLower(filepath.Ext(path))
-		
-		// Check if the file is an image and matches the format filter (if any)
-		if !isValidFileFormat(ext, formats) {
-			return nil
+	// Files over the destination's size limit are skipped or sent to -overflow
+	if store == nil {
+		var fsName string
+		if s.maxFileSize, fsName = maxFileSize(destDir); s.maxFileSize > 0 {
+			slog.Info("Destination file system limits file size", "filesystem", fsName, "limit", s.maxFileSize)
 		}
+	}
+	if *overflowDir != "" {
+		if limit, _ := maxFileSize(*overflowDir); limit > 0 {
+			fatal("-overflow must be on a file system without the destination's size limit", "path", *overflowDir)
+		}
+		s.overflowDir = *overflowDir
+	}
 
-		// Get date from EXIF data
-		date, err := getPhotoDate(path)
-		if err != nil {
-			log.Printf("Warning: Could not get date for %s: %v", path, err)
-			return nil
+	// Set up the external classifier
+	if *classifierSpec != "" {
+		s.labeler = newClassifier(*classifierSpec, *classifierMinScore)
+		// Labels are kept in the catalog of a local library
+		if store == nil {
+			if s.labels, err = loadLabelIndex(destDir); err != nil {
+				fatal("Failed to read catalog", "path", destDir, "error", err)
+			}
 		}
+	} else if len(labelFilter) > 0 || len(excludeLabels) > 0 {
+		fatal("-label and -exclude-label require -classifier")
+	}
+
+	// Set up the decoder sandbox
+	if *sandbox {
+		s.sandbox = &decoderSandbox{timeout: *decodeTimeout, memoryLimit: *decodeMemory << 20}
+	}
 
-		// Create destination directory structure: yyyy/mm/
-		yearMonth := filepath.Join(*destDir, fmt.Sprintf("%04d", date.Year()), fmt.Sprintf("%02d", date.Month()))
-		if err := os.MkdirAll(yearMonth, 0755); err != nil {
-			return fmt.Errorf("failed to create directory %s: %v", yearMonth, err)
+	// Set up slate detection for shoot segmentation
+	if *slateDecoder != "" || *slateImage != "" {
+		s.slates, err = newSlateDetector(*slateDecoder, *slateImage, *slateThreshold, s.sandbox)
+		if err != nil {
+			fatal(err.Error())
 		}
+	}
 
-		// Destination file path
-		destPath := filepath.Join(yearMonth, filepath.Base(path))
+	// Parse the rename template
+	if *renameTemplate != "" {
+		if s.rename, err = parseRenameTemplate(*renameTemplate); err != nil {
+			fatal("Invalid -rename template", "error", err)
+		}
+	}
 
-		// Copy or move the file
-		if *moveFiles {
-			if err := moveFile(path, destPath); err != nil {
-				return fmt.Errorf("failed to move %s to %s: %v", path, destPath, err)
+	// Watch the source for new files, or sort it once
+	if *tether {
+		*watch = true
+		if !isFlagSet(flag.CommandLine, "poll-interval") {
+			*pollInterval = tetherPollInterval
+		}
+	}
+	if *uploadAddr != "" {
+		if !*watch {
+			fatal("-upload-addr requires -watch")
+		}
+		if *uploadToken == "" {
+			fatal("-upload-addr requires -upload-token")
+		}
+		// Uploads are received into the first source directory
+		if err := startUploadServer(*uploadAddr, sourceDirs[0], *uploadToken); err != nil {
+			fatal("Failed to start upload endpoint", "error", err)
+		}
+	}
+	if planning {
+		s.command = "plan"
+		if err := s.writePlan(*planOut); err != nil {
+			fatal("Failed to plan", "error", err)
+		}
+		events.finished(s.result(nil))
+		return
+	}
+	if *metricsAddr != "" {
+		if !*watch {
+			fatal("-metrics-addr requires -watch")
+		}
+		s.metrics = &watchMetrics{}
+		if err := startMetricsServer(*metricsAddr, s.metrics); err != nil {
+			fatal("Failed to start metrics endpoint", "error", err)
+		}
+	}
+	if *controlAddr != "" || *httpAddr != "" {
+		if *controlAddr != "" && !*watch {
+			fatal("-control-addr requires -watch")
+		}
+		if *httpAddr != "" && !*watch {
+			fatal("-http requires -watch")
+		}
+		s.control = newWatchControl()
+		s.control.sandbox = s.sandbox
+		// Statistics are read from a local library only
+		statsDir := destDir
+		if store != nil {
+			statsDir = ""
+		}
+		if *controlAddr != "" {
+			if err := startControlServer(*controlAddr, *controlToken, s.control, statsDir); err != nil {
+				fatal("Failed to start control API", "error", err)
 			}
-			log.Printf("Moved %s to %s", path, destPath)
-		} else {
-			if err := copyFile(path, destPath); err != nil {
-				return fmt.Errorf("failed to copy %s to %s: %v", path, destPath, err)
+		}
+		if *httpAddr != "" {
+			if err := startDashboardServer(*httpAddr, *httpToken, s.control, statsDir); err != nil {
+				fatal("Failed to start dashboard", "error", err)
 			}
-			log.Printf("Copied %s to %s", path, destPath)
 		}
+	}
+	var sourceSums map[string]string
+	if *readOnlySource {
+		if sourceSums, err = s.hashSources(); err != nil {
+			fatal("Failed to hash the source files", "error", err)
+		}
+	}
+	release := func() {}
+	if store == nil {
+		if release, err = s.claimSession(*onConflict); err != nil {
+			fatal("Failed to start run", "error", err)
+		}
+	}
+	if *watch {
+		err = s.watch(*pollInterval)
+	} else {
+		err = s.run()
+	}
+	release()
+	if store != nil {
+		store.Close()
+	}
+	if sourceSums != nil {
+		if checkErr := s.checkSources(sourceSums); checkErr != nil && err == nil {
+			err = checkErr
+		}
+	}
+	s.notify.send(eventRunFinished, s.result(err))
+	if jsonResult {
+		writeResult(os.Stdout, s.result(err))
+		jsonResult = false
+	}
+	events.finished(s.result(err))
+	events = nil
+	if err != nil {
+		fatal("Error processing files", "error", err)
+	}
+	if code := s.exitCode(nil); code != exitOK {
+		slog.Error("Photo sorting completed with errors", "failed", s.failures())
+		os.Exit(code)
+	}
 
-		return nil
+	slog.Info("Photo sorting completed successfully!")
+}
+
+// isFlagSet reports whether a flag was given explicitly on the command line
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
 	})
+	return set
+}
 
+// parseDateFlag parses a YYYY-MM-DD date flag in local time; an empty value yields the zero time
+func parseDateFlag(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	date, err := time.ParseInLocation("2006-01-02", value, time.Local)
 	if err != nil {
-		log.Fatalf("Error processing files: %v", err)
+		return time.Time{}, fmt.Errorf("invalid -%s date %q, expected YYYY-MM-DD", name, value)
 	}
+	return date, nil
+}
 
-	log.Println("Photo sorting completed successfully!")
+// inDateRange reports whether date falls within [after, before); zero bounds are open
+func inDateRange(date, after, before time.Time) bool {
+	if !after.IsZero() && date.Before(after) {
+		return false
+	}
+	if !before.IsZero() && !date.Before(before) {
+		return false
+	}
+	return true
 }
 
 // isValidFileFormat checks if the file extension is valid based on the format filter
 func isValidFileFormat(ext string, formats []string) bool {
 	// If no specific formats are specified, check against all supported formats
 	if len(formats) == 0 {
-		return isImageFile(ext)
+		return isImageFile(ext) || (includeVideos && isVideoFile(ext))
 	}
-	
-	// Otherwise, check if the extension is in the list of specified formats
+
+	// Otherwise, check if the extension is in the list of specified formats,
+	// treating aliases such as .jpeg and .jpg as the same format
 	for _, format := range formats {
-		if ext == format {
+		if canonicalExt(ext) == canonicalExt(format) {
 			return true
 		}
 	}
-	
+
 	return false
 }
 
+// imageFormats lists the canonical extensions of the image formats sorted
+var imageFormats = []string{
+	".jpg", ".png", ".gif", ".bmp", ".tiff", ".heic", ".webp", ".avif",
+	".raw", ".cr2", ".nef", ".arw", ".raf", ".orf", ".rw2", ".dng", ".pef", ".srw",
+}
+
 // isImageFile returns true if the file extension corresponds to a common image format
 func isImageFile(ext string) bool {
-	switch ext {
-	case ".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tiff", ".tif", ".heic", ".heif", ".raw", ".cr2", ".nef":
-		return true
-	default:
-		return false
-	}
+	return slices.Contains(imageFormats, canonicalExt(ext))
+}
+
+// rawFormats lists the canonical extensions of the camera RAW formats among
+// imageFormats
+var rawFormats = []string{".raw", ".cr2", ".nef", ".arw", ".raf", ".orf", ".rw2", ".dng", ".pef", ".srw"}
+
+// isRawFile reports whether an extension is a camera RAW format
+func isRawFile(ext string) bool {
+	return slices.Contains(rawFormats, canonicalExt(ext))
 }
 
-// getPhotoDate extracts the date when the photo was taken from EXIF metadata
+// getPhotoDate extracts the date when the photo was taken from EXIF metadata,
+// falling back to XMP and the PNG creation time for files whose date is only
+// recorded there
 func getPhotoDate(filepath string) (time.Time, error) {
-	file, err := os.Open(filepath)
+	date, err := getExifDate(localFS, filepath)
 	if err != nil {
-		return time.Time{}, err
+		if xmpDate, ok := getXMPDate(filepath); ok {
+			return xmpDate, nil
+		}
+		if created, ok := pngCreationTime(filepath); ok {
+			return created, nil
+		}
+		if taken, ok := djiCaptureTime(filepath); ok {
+			return taken, nil
+		}
+		if recorded, err := videoCreationTime(filepath); err == nil {
+			return recorded, nil
+		}
 	}
-	defer file.Close()
+	return date, err
+}
 
-	// Decode EXIF data
-	x, err := exif.Decode(file)
+// getExifDate extracts the date when the photo was taken from EXIF metadata
+func getExifDate(fsys fileSystem, path string) (time.Time, error) {
+	x, err := decodeExif(fsys, path)
 	if err != nil {
 		return time.Time{}, err
 	}
 
-	// Try to get the date the photo was taken
-	datetime, err := x.DateTime()
+	// Try to get the date the photo was taken, in -date-tags order
+	return exifCaptureDate(x)
+}
+
+// decodeExif opens a file on fsys and decodes its EXIF metadata
+func decodeExif(fsys fileSystem, path string) (*exif.Exif, error) {
+	info, err := fsys.Stat(path)
 	if err != nil {
-		return time.Time{}, err
+		return nil, err
+	}
+	rc, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	// Files that cannot seek, such as those of an in-memory fileSystem, are
+	// read whole
+	file, ok := rc.(metadataFile)
+	if !ok {
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			return nil, err
+		}
+		file = bytes.NewReader(data)
+	}
+
+	// PNG, WebP, HEIF/AVIF, and some RAW formats keep EXIF in their own
+	// chunks, boxes, or previews
+	r, ok, err := containerExif(file, info.Size())
+	if ok {
+		if err != nil {
+			return nil, err
+		}
+		return exif.Decode(r)
+	}
+	// JPEG and TIFF-based RAW files only have their metadata read
+	if r, ok, err := exifSection(file, info.Size()); ok {
+		if err != nil {
+			return nil, err
+		}
+		return exif.Decode(r)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
 	}
+	return exif.Decode(file)
+}
 
-	return datetime, nil
+// exifString returns the trimmed string value of an EXIF tag, or "" if absent
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	value, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(value, "\x00"))
 }
 
-// copyFile copies a file from src to dst
-func copyFile(src, dst string) error {
+// copyFile copies a file from src to dst on fsys
+func copyFile(fsys fileSystem, src, dst string) error {
+	src, dst = longPath(src), longPath(dst)
+
 	// Check if destination file already exists
-	if _, err := os.Stat(dst); err == nil {
+	if _, err := fsys.Stat(dst); err == nil {
 		// File exists, don't overwrite
-		log.Printf("Skipping %s: file already exists at destination", dst)
+		slog.Info("Skipping: file already exists at destination", "path", dst)
 		return nil
 	}
 
-	// Read source file
-	data, err := os.ReadFile(src)
+	in, err := fsys.Open(src)
 	if err != nil {
 		return err
 	}
+	defer in.Close()
 
-	// Write to destination
-	return os.WriteFile(dst, data, 0644)
+	// Write to a partial file first, so an interrupted copy is never taken
+	// for a complete file by a later run
+	partial := dst + partialSuffix
+	out, err := fsys.Create(partial)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, throttled(in))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fsys.Remove(partial)
+		return err
+	}
+	return fsys.Rename(partial, dst)
 }
 
-// moveFile moves a file from src to dst
-func moveFile(src, dst string) error {
+// moveFile moves a file from src to dst on fsys
+func moveFile(fsys fileSystem, src, dst string) error {
+	src, dst = longPath(src), longPath(dst)
+
 	// Check if destination file already exists
-	if _, err := os.Stat(dst); err == nil {
+	if _, err := fsys.Stat(dst); err == nil {
 		// File exists, don't overwrite
-		log.Printf("Skipping %s: file already exists at destination", dst)
+		slog.Info("Skipping: file already exists at destination", "path", dst)
 		return nil
 	}
 
-	// Use os.Rename to move the file
-	return os.Rename(src, dst)
-} 
\ No newline at end of file
+	return fsys.Rename(src, dst)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// goproChapterPattern matches the chapter files a GoPro splits long
+// recordings into: GH010123.MP4, GH020123.MP4, ... (GX for HEVC), and on
+// older models GOPR0123.MP4 followed by GP010123.MP4, GP020123.MP4, ...
+var goproChapterPattern = regexp.MustCompile(`^(?i)(GOPR|G[HXP](\d\d))(\d{4})(\.MP4)$`)
+
+// goproChapter returns the file name of the first chapter of the recording
+// a GoPro file belongs to, and the file's chapter number, counted from 1
+func goproChapter(name string) (first string, chapter int, ok bool) {
+	m := goproChapterPattern.FindStringSubmatch(name)
+	if m == nil {
+		return "", 0, false
+	}
+	prefix, number, ext := m[1], m[3], m[4]
+	if strings.EqualFold(prefix, "GOPR") {
+		return name, 1, true
+	}
+	n, _ := strconv.Atoi(m[2])
+	if strings.EqualFold(prefix[:2], "GP") {
+		// GP01 is the second chapter, after GOPR
+		gopr := "GOPR"
+		if prefix[:2] == "gp" {
+			gopr = "gopr"
+		}
+		return gopr + number + ext, n + 1, true
+	}
+	return prefix[:2] + "01" + number + ext, n, true
+}
+
+// recordingDate returns the date that decides the folder of a file: for a
+// chapter of a GoPro recording the capture time of the first chapter, so all
+// chapters stay together even when the recording runs past midnight, and
+// date for other files. The first chapter is looked for next to the file
+// unless it was sorted earlier in the run.
+func (s *sorter) recordingDate(path string, date time.Time) time.Time {
+	first, chapter, ok := goproChapter(filepath.Base(path))
+	if !ok {
+		return date
+	}
+	key := filepath.Join(filepath.Dir(path), strings.ToUpper(first))
+	if chapter == 1 {
+		// Remembered for later chapters, as -move takes this one away
+		if s.recordings == nil {
+			s.recordings = make(map[string]time.Time)
+		}
+		s.recordings[key] = date
+		return date
+	}
+	if start, ok := s.recordings[key]; ok {
+		return start
+	}
+	start, err := getPhotoDate(filepath.Join(filepath.Dir(path), first))
+	if err != nil {
+		slog.Debug("Could not read first chapter of recording", "path", path, "first", first, "error", err)
+		return date
+	}
+	slog.Debug("Sorting chapter with the first chapter of its recording", "path", path, "first", first)
+	return s.adjustTime(start)
+}
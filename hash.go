@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+)
+
+// hashFile returns the hex-encoded SHA-256 digest of a file's contents
+func hashFile(fsys fileSystem, path string) (string, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sameContents reports whether two files have identical contents
+func sameContents(fsys fileSystem, a, b string) (bool, error) {
+	hashA, err := hashFile(fsys, a)
+	if err != nil {
+		return false, err
+	}
+	hashB, err := hashFile(fsys, b)
+	if err != nil {
+		return false, err
+	}
+	return hashA == hashB, nil
+}
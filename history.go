@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// stateDirName is the folder inside the library where GoPicSort keeps its own state
+const stateDirName = ".gopicsort"
+
+// runRecord is one entry in a library's run history
+type runRecord struct {
+	RunID    string    `json:"run_id"`
+	Machine  string    `json:"machine,omitempty"`
+	Command  string    `json:"command"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Source   string    `json:"source,omitempty"`
+	Dest     string    `json:"dest"`
+	Files    int       `json:"files"`
+	Snapshot string    `json:"snapshot,omitempty"`
+	Seed     int64     `json:"seed,omitempty"`
+}
+
+// historyPath returns the location of one machine's run history for a
+// library. Each machine appends to its own file so a library shared between
+// machines, or synced between them, never has two writers on one file.
+func historyPath(destDir, machine string) string {
+	return filepath.Join(destDir, stateDirName, "history-"+machine+".jsonl")
+}
+
+// machineName identifies this machine in run records, from $GOPICSORT_MACHINE
+// or the host name
+func machineName() string {
+	name := os.Getenv("GOPICSORT_MACHINE")
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	if name == "" {
+		name = "unknown"
+	}
+	return sanitizeFolderName(strings.ToLower(name))
+}
+
+// appendRunHistory appends a record to the machine's run history, one JSON
+// object per line
+func appendRunHistory(destDir string, record runRecord) error {
+	path := historyPath(destDir, record.Machine)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// readRunHistory merges the run history of every machine, including the
+// single history.jsonl of older versions and conflict copies made by sync
+// tools (e.g., "history-nas.sync-conflict-20230105-101010-ABC.jsonl").
+// Records are deduplicated by run ID and returned in start order.
+func readRunHistory(destDir string) ([]runRecord, []string, error) {
+	files, err := filepath.Glob(filepath.Join(destDir, stateDirName, "history*.jsonl"))
+	if err != nil {
+		return nil, nil, err
+	}
+	byID := make(map[string]runRecord)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			var record runRecord
+			if len(bytes.TrimSpace(line)) == 0 || json.Unmarshal(line, &record) != nil || record.RunID == "" {
+				continue
+			}
+			// A run recorded twice keeps the copy written last
+			if existing, ok := byID[record.RunID]; !ok || record.Finished.After(existing.Finished) {
+				byID[record.RunID] = record
+			}
+		}
+	}
+
+	records := make([]runRecord, 0, len(byID))
+	for _, record := range byID {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].Started.Equal(records[j].Started) {
+			return records[i].Started.Before(records[j].Started)
+		}
+		return records[i].RunID < records[j].RunID
+	})
+	return records, files, nil
+}
+
+// newRunID returns a unique identifier for a run, ordered by start time
+func newRunID() string {
+	random := make([]byte, 4)
+	rand.Read(random)
+	return time.Now().Format("20060102T150405") + "-" + hex.EncodeToString(random)
+}
+
+// runHistory implements the "history" subcommand, which lists the runs of
+// every machine that wrote to a library and can merge their history files
+func runHistory(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	destDir := fs.String("dest", "", "Sorted photo library")
+	compact := fs.Bool("compact", false, "Merge conflict copies and duplicate records into one history file per machine")
+	logOpts := addLogFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s history -dest DIR [-compact]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	parseInterspersed(fs, args)
+	if *destDir == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := logOpts.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	records, files, err := readRunHistory(*destDir)
+	if err != nil {
+		fatal("Failed to read run history", "error", err)
+	}
+
+	// List every run, then compare the machines side by side
+	type machineSummary struct {
+		runs, files int
+		last        time.Time
+	}
+	summaries := make(map[string]*machineSummary)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tMACHINE\tCOMMAND\tSTARTED\tFILES\tSNAPSHOT")
+	for _, record := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", record.RunID, record.Machine, record.Command, record.Started.Format("2006-01-02 15:04"), record.Files, record.Snapshot)
+		summary := summaries[record.Machine]
+		if summary == nil {
+			summary = &machineSummary{}
+			summaries[record.Machine] = summary
+		}
+		summary.runs++
+		summary.files += record.Files
+		if record.Finished.After(summary.last) {
+			summary.last = record.Finished
+		}
+	}
+	machines := make([]string, 0, len(summaries))
+	for machine := range summaries {
+		machines = append(machines, machine)
+	}
+	sort.Strings(machines)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "MACHINE\tRUNS\tFILES\tLAST RUN")
+	for _, machine := range machines {
+		summary := summaries[machine]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", machine, summary.runs, summary.files, summary.last.Format("2006-01-02 15:04"))
+	}
+	w.Flush()
+
+	if *compact {
+		if err := compactRunHistory(*destDir, records, files); err != nil {
+			fatal("Failed to compact run history", "error", err)
+		}
+		slog.Info("Compacted run history", "runs", len(records), "machines", len(machines))
+	}
+}
+
+// compactRunHistory rewrites the merged records as one file per machine and
+// removes the files they were merged from. Records from versions without
+// machine names stay in history.jsonl.
+func compactRunHistory(destDir string, records []runRecord, files []string) error {
+	groups := make(map[string][]byte)
+	for _, record := range records {
+		path := filepath.Join(destDir, stateDirName, "history.jsonl")
+		if record.Machine != "" {
+			path = historyPath(destDir, record.Machine)
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		groups[path] = append(append(groups[path], data...), '\n')
+	}
+	for path, data := range groups {
+		if err := replaceFile(path, data); err != nil {
+			return err
+		}
+	}
+	for _, file := range files {
+		if _, kept := groups[file]; !kept {
+			if err := os.Remove(file); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
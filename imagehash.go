@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif" // register decoders for image.Decode
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"os"
+)
+
+// differenceHash computes a 64-bit dHash of an image: the image is reduced
+// to 9x8 grayscale samples and each bit records whether a sample is brighter
+// than its right-hand neighbour. Similar images have hashes with a small
+// Hamming distance.
+func differenceHash(img image.Image) uint64 {
+	const w, h = 9, 8
+	bounds := img.Bounds()
+	var gray [h][w]uint32
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// Average the block of source pixels covered by this sample
+			x0 := bounds.Min.X + x*bounds.Dx()/w
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/w
+			y0 := bounds.Min.Y + y*bounds.Dy()/h
+			y1 := bounds.Min.Y + (y+1)*bounds.Dy()/h
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			if y1 == y0 {
+				y1 = y0 + 1
+			}
+			stepX, stepY := (x1-x0+15)/16, (y1-y0+15)/16
+			var sum, n uint32
+			for sy := y0; sy < y1; sy += stepY {
+				for sx := x0; sx < x1; sx += stepX {
+					r, g, b, _ := img.At(sx, sy).RGBA()
+					sum += (299*r + 587*g + 114*b) / 1000 >> 8
+					n++
+				}
+			}
+			gray[y][x] = sum / n
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			hash <<= 1
+			if gray[y][x] > gray[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// hashDistance returns the number of differing bits between two hashes
+func hashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// fileDifferenceHash hashes an image file, using the embedded EXIF thumbnail
+// when available to avoid decoding the full-size image
+func fileDifferenceHash(path string) (uint64, error) {
+	if x, err := decodeExif(localFS, path); err == nil {
+		if thumb := exifThumbnail(x); thumb != nil {
+			if img, _, err := image.Decode(bytes.NewReader(thumb)); err == nil {
+				return differenceHash(img), nil
+			}
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return 0, err
+	}
+	return differenceHash(img), nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// cardMarker is written to the card after a verified import
+type cardMarker struct {
+	RunID     string    `json:"run_id"`
+	Time      time.Time `json:"time"`
+	Dest      string    `json:"dest"`
+	Imported  int       `json:"imported"`
+	Verified  int       `json:"verified"`
+	Failed    []string  `json:"failed,omitempty"`
+	Cleared   bool      `json:"cleared"`
+	Hostname  string    `json:"hostname,omitempty"`
+	Generator string    `json:"generator"`
+}
+
+// runImportCard implements the "import-card" subcommand: import a memory
+// card, verify every copy, record the run on the card, optionally clear it,
+// and eject it
+func runImportCard(args []string) {
+	fs := flag.NewFlagSet("import-card", flag.ExitOnError)
+	destDir := fs.String("dest", "", "Destination directory for sorted photos")
+	backupDir := fs.String("backup", "", "Backup destination that receives a second copy of every sorted file")
+	renameTemplate := fs.String("rename", "", "Template for destination file names")
+	lockMode := fs.String("lock", "", "After the import, make the month folders written to read-only: 'readonly' or 'immutable'")
+	clearCard := fs.Bool("clear", false, "Delete imported files from the card after they have been verified")
+	noEject := fs.Bool("no-eject", false, "Leave the card mounted after the import")
+	onConflict := fs.String("on-conflict", conflictFail, "What to do when another run holds the destination's lock: 'fail', 'wait', or 'warn' to run anyway")
+	notifyURL := fs.String("notify", "", "Webhook URL to notify when the import finishes")
+	notifyFormat := fs.String("notify-format", notifyGeneric, "Notification payload: 'generic' JSON, 'slack', 'discord', or 'ntfy'")
+	spaceCheck := fs.String("space-check", spaceCheckAbort, "Before copying, compare the size of the card's files with the free space on the destination: 'abort', 'warn', or 'off'")
+	logOpts := addLogFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s import-card -dest DIR [options] CARD\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	positional := parseInterspersed(fs, args)
+	if *destDir == "" || len(positional) != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := logOpts.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := validateLockMode(*lockMode); err != nil {
+		fatal(err.Error())
+	}
+	if err := validateSpaceCheck(*spaceCheck); err != nil {
+		fatal(err.Error())
+	}
+	if err := validateConflictPolicy(*onConflict); err != nil {
+		fatal(err.Error())
+	}
+	if err := validateNotifyFormat(*notifyFormat); err != nil {
+		fatal(err.Error())
+	}
+
+	card := positional[0]
+	if stat, err := os.Stat(card); err != nil || !stat.IsDir() {
+		fatal("Card does not exist or is not a directory", "path", card)
+	}
+
+	// Cameras store media below DCIM; fall back to the whole card otherwise
+	source := card
+	if stat, err := os.Stat(filepath.Join(card, "DCIM")); err == nil && stat.IsDir() {
+		source = filepath.Join(card, "DCIM")
+	}
+
+	if err := os.MkdirAll(*destDir, 0755); err != nil {
+		fatal("Failed to create destination directory", "error", err)
+	}
+
+	s, err := newCardSorter(source, *destDir)
+	if err != nil {
+		fatal("Failed to start import", "error", err)
+	}
+	s.backupDir = *backupDir
+	s.lockMode = *lockMode
+	s.spaceCheck = *spaceCheck
+	s.notify = newNotifier(*notifyURL, *notifyFormat)
+	if *renameTemplate != "" {
+		if s.rename, err = parseRenameTemplate(*renameTemplate); err != nil {
+			fatal("Invalid -rename template", "error", err)
+		}
+	}
+
+	runID := s.runID
+	slog.Info("Importing card", "card", card, "run", runID)
+	release, err := s.claimSession(*onConflict)
+	if err != nil {
+		fatal("Failed to start import", "error", err)
+	}
+	err = s.run()
+	release()
+	if err != nil {
+		s.notify.send(eventRunFinished, s.result(err))
+		fatal("Error importing card", "error", err)
+	}
+
+	// Verify every copy against the card before anything is deleted
+	marker := cardMarker{
+		RunID:     runID,
+		Time:      time.Now(),
+		Dest:      *destDir,
+		Imported:  len(s.transfers),
+		Generator: "gopicsort",
+	}
+	marker.Hostname, _ = os.Hostname()
+	var verified []string
+	verified, marker.Failed = verifyTransfers(s.transfers)
+	marker.Verified = len(verified)
+	slog.Info("Verified import", "imported", marker.Imported, "verified", marker.Verified, "failed", len(marker.Failed))
+
+	// Clear the card only when every file was sorted and verified
+	if len(marker.Failed) == 0 && s.failures() == 0 && len(verified) > 0 && (*clearCard || confirm(fmt.Sprintf("Delete %d verified files from %s?", len(verified), card))) {
+		for _, path := range verified {
+			if err := os.Remove(path); err != nil {
+				slog.Warn("Could not delete file from card", "path", path, "error", err)
+			}
+		}
+		marker.Cleared = true
+		slog.Info("Cleared card", "files", len(verified))
+	} else if *clearCard && len(marker.Failed) > 0 {
+		slog.Warn("Not clearing card because some files failed verification")
+	} else if *clearCard && s.failures() > 0 {
+		slog.Warn("Not clearing card because some files could not be imported")
+	}
+
+	if err := writeCardMarker(card, marker); err != nil {
+		slog.Warn("Could not write import marker to card", "error", err)
+	}
+
+	if !*noEject {
+		if err := ejectVolume(card); err != nil {
+			slog.Warn("Could not eject card", "path", card, "error", err)
+		} else {
+			slog.Info("Ejected card", "path", card)
+		}
+	}
+
+	// Copies that failed verification make the import partial too
+	result := s.result(nil)
+	if len(marker.Failed) > 0 {
+		result.Status, result.ExitCode = "partial", exitPartial
+	}
+	s.notify.send(eventRunFinished, result)
+	if result.ExitCode != exitOK {
+		os.Exit(result.ExitCode)
+	}
+}
+
+// newCardSorter returns the sorter importing a card's media folder into the
+// library at destDir; the card's hidden files, such as macOS metadata, are
+// left out
+func newCardSorter(source, destDir string) (*sorter, error) {
+	s, err := newSorter("import-card", destDir, source)
+	if err != nil {
+		return nil, err
+	}
+	s.skipHidden = true
+	return s, nil
+}
+
+// verifyTransfers compares every copy with its source and returns the
+// sources that were copied intact and those that were not
+func verifyTransfers(transfers []transfer) (verified, failed []string) {
+	for _, t := range transfers {
+		same, err := sameContents(localFS, t.source, t.dest)
+		if err != nil || !same {
+			slog.Error("Verification failed", "source", t.source, "dest", t.dest, "error", err)
+			failed = append(failed, t.source)
+			continue
+		}
+		verified = append(verified, t.source)
+	}
+	return verified, failed
+}
+
+// writeCardMarker records the import run on the card
+func writeCardMarker(card string, marker cardMarker) error {
+	data, err := json.MarshalIndent(marker, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(card, fmt.Sprintf(".gopicsort-import-%s.json", marker.RunID)), data, 0644)
+}
+
+// confirm asks a yes/no question on the terminal; it answers no when stdin
+// is not interactive
+func confirm(question string) bool {
+	stat, err := os.Stdin.Stat()
+	if err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// ejectVolume unmounts a removable volume using the platform's tools
+func ejectVolume(path string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("diskutil", "eject", path)
+	case "linux":
+		cmd = exec.Command("umount", path)
+	default:
+		return fmt.Errorf("ejecting volumes is not supported on %s", runtime.GOOS)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCardSorterDatesByExif(t *testing.T) {
+	card, lib := t.TempDir(), t.TempDir()
+	date := time.Date(2021, time.May, 6, 7, 8, 9, 0, time.Local)
+	writeTestFile(t, filepath.Join(card, "DCIM", "100CANON", "IMG_0001.JPG"), testJPEG(date, "a"))
+	writeTestFile(t, filepath.Join(card, "DCIM", "100CANON", "._IMG_0001.JPG"), []byte("AppleDouble"))
+	s, err := newCardSorter(filepath.Join(card, "DCIM"), lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.run(); err != nil {
+		t.Fatal(err)
+	}
+	if s.counts[outcomeSorted] != 1 || s.counts[outcomeUndated] != 0 {
+		t.Errorf("counts are %v, want one sorted file", s.counts)
+	}
+	if got := listFiles(t, lib); len(got) != 1 || got[0] != "2021/05/IMG_0001.JPG" {
+		t.Errorf("library holds %v, want 2021/05/IMG_0001.JPG", got)
+	}
+}
+
+func TestCardSorterFollowsAdoptedLayout(t *testing.T) {
+	card, lib := t.TempDir(), t.TempDir()
+	if err := os.MkdirAll(filepath.Join(lib, stateDirName), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeJSONFile(libraryConfigPath(lib), libraryConfig{Layout: "2006/2006-01-02"}); err != nil {
+		t.Fatal(err)
+	}
+	date := time.Date(2021, time.May, 6, 7, 8, 9, 0, time.Local)
+	writeTestFile(t, filepath.Join(card, "IMG_0001.JPG"), testJPEG(date, "a"))
+	s, err := newCardSorter(card, lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := listFiles(t, lib); len(got) != 1 || got[0] != "2021/2021-05-06/IMG_0001.JPG" {
+		t.Errorf("library holds %v, want 2021/2021-05-06/IMG_0001.JPG", got)
+	}
+}
+
+func TestCardVerifyCatchesBadCopies(t *testing.T) {
+	card, lib := t.TempDir(), t.TempDir()
+	writeTestFile(t, filepath.Join(card, "IMG_0001.JPG"), testJPEG(time.Date(2021, time.May, 6, 7, 8, 9, 0, time.Local), "a"))
+	writeTestFile(t, filepath.Join(card, "IMG_0002.JPG"), testJPEG(time.Date(2021, time.May, 7, 7, 8, 9, 0, time.Local), "b"))
+	s, err := newCardSorter(card, lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.run(); err != nil {
+		t.Fatal(err)
+	}
+	// A copy damaged after it was written must keep its original on the card
+	writeTestFile(t, filepath.Join(lib, "2021", "05", "IMG_0002.JPG"), []byte("damaged"))
+	verified, failed := verifyTransfers(s.transfers)
+	if len(verified) != 1 || verified[0] != filepath.Join(card, "IMG_0001.JPG") {
+		t.Errorf("verified %v, want only IMG_0001.JPG", verified)
+	}
+	if len(failed) != 1 || failed[0] != filepath.Join(card, "IMG_0002.JPG") {
+		t.Errorf("failed %v, want IMG_0002.JPG", failed)
+	}
+
+	marker := cardMarker{RunID: s.runID, Imported: len(s.transfers), Verified: len(verified), Failed: failed}
+	if err := writeCardMarker(card, marker); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(card, ".gopicsort-import-"+s.runID+".json")); err != nil {
+		t.Errorf("no import marker on the card: %v", err)
+	}
+}
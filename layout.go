@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultLayout is the folder layout of a library, as a Go time layout with
+// slashes separating folder levels: yyyy/mm
+const defaultLayout = "2006/01"
+
+// relDirVar ends a layout whose date folders keep each file's folder below
+// the source, as in "2006/01/{{.RelDir}}"
+const relDirVar = "{{.RelDir}}"
+
+// layoutCandidates are the folder layouts "adopt" recognizes in existing
+// libraries, from year folders alone down to daily folders
+var layoutCandidates = []string{
+	"2006",
+	"2006/01",
+	"2006/1",
+	"2006/01 January",
+	"2006/01-January",
+	"2006/01_January",
+	"2006/January",
+	"2006/Jan",
+	"2006/2006-01",
+	"2006/2006_01",
+	"2006/200601",
+	"2006-01",
+	"2006_01",
+	"2006/01/02",
+	"2006/2006-01-02",
+	"2006/01/2006-01-02",
+	"2006-01-02",
+	isoYearVar + "/W" + weekVar,
+	"2006/Q" + quarterVar,
+}
+
+// libraryConfig is kept in a library's state folder and describes how the
+// library is organized, so later imports follow the same convention
+type libraryConfig struct {
+	Layout string `json:"layout"`
+	// MonthNames are the names of months in folder names, when not English
+	MonthNames []string  `json:"month_names,omitempty"`
+	Adopted    time.Time `json:"adopted"`
+	Files      int       `json:"files"`
+	Matched    int       `json:"matched"`
+}
+
+// libraryConfigPath returns where a library's configuration is kept
+func libraryConfigPath(root string) string {
+	return filepath.Join(root, stateDirName, "library.json")
+}
+
+// loadLibraryConfig reads the configuration of a library; a library that was
+// never adopted has none and nil is returned
+func loadLibraryConfig(root string) (*libraryConfig, error) {
+	data, err := os.ReadFile(libraryConfigPath(root))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var config libraryConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid library configuration: %v", err)
+	}
+	if err := validateLayout(config.Layout); err != nil {
+		return nil, err
+	}
+	if config.MonthNames != nil {
+		if err := validateMonthNames(config.MonthNames); err != nil {
+			return nil, fmt.Errorf("invalid library configuration: %v", err)
+		}
+	}
+	return &config, nil
+}
+
+// libraryLayout returns the folder layout of a library, the default if it
+// was never adopted, and sets monthNames to the month names it uses
+func libraryLayout(root string) (string, error) {
+	config, err := loadLibraryConfig(root)
+	if err != nil || config == nil {
+		return defaultLayout, err
+	}
+	monthNames = config.MonthNames
+	return config.Layout, nil
+}
+
+// validateLayout checks that a layout puts photos of different years into
+// different folders, and that {{.RelDir}} only appears as its last level
+// with no variables other than the date parts of layoutvars.go
+func validateLayout(layout string) error {
+	date := dateLayout(layout)
+	if date == "" || !(strings.Contains(date, "2006") || strings.Contains(date, isoYearVar)) || strings.HasPrefix(date, "/") {
+		return fmt.Errorf("invalid folder layout %q, expected a Go time layout with the year, e.g. '2006/01'", layout)
+	}
+	if strings.Contains(strings.NewReplacer(isoYearVar, "", weekVar, "", quarterVar, "").Replace(date), "{{") {
+		return fmt.Errorf("invalid folder layout %q, %s can only be the last folder level, e.g. '2006/01/%s'", layout, relDirVar, relDirVar)
+	}
+	return nil
+}
+
+// dateLayout returns the date folders of a layout, without {{.RelDir}}
+func dateLayout(layout string) string {
+	return strings.TrimSuffix(layout, "/"+relDirVar)
+}
+
+// keepsRelDir reports whether a layout keeps the source folder of each file
+func keepsRelDir(layout string) bool {
+	return dateLayout(layout) != layout
+}
+
+// folderFor returns the date folder, relative to the library, for a capture
+// date; any {{.RelDir}} below it is left to the caller
+func folderFor(layout string, date time.Time) string {
+	return filepath.FromSlash(formatLayout(dateLayout(layout), date))
+}
+
+// folderFor returns the folder for a capture date in the sorter's layout
+func (s *sorter) folderFor(date time.Time) string {
+	if s.layout == "" {
+		return folderFor(defaultLayout, date)
+	}
+	return folderFor(s.layout, date)
+}
+
+// layoutMatches reports whether dir, relative to the library, is the folder
+// for date in layout or below it. A folder may carry an event name after the
+// date, as in "2019/2019-07-14 Beach".
+func layoutMatches(layout, dir string, date time.Time) bool {
+	dir = filepath.ToSlash(dir)
+	folder := formatLayout(dateLayout(layout), date)
+	if dir == folder || strings.HasPrefix(dir, folder+"/") {
+		return true
+	}
+	if !strings.HasPrefix(dir, folder) {
+		return false
+	}
+	next := dir[len(folder)]
+	return next == ' ' || next == '_'
+}
+
+// layoutFolderDepth returns how many folder levels a layout has
+func layoutFolderDepth(layout string) int {
+	return strings.Count(dateLayout(layout), "/") + 1
+}
+
+// parseLayoutFolder returns the date encoded in the folders of rel, a path
+// relative to the library, or false if rel is not inside a layout folder.
+// Like layoutMatches, it accepts an event name after the date.
+func parseLayoutFolder(layout, rel string) (time.Time, bool) {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	depth := layoutFolderDepth(layout)
+	if len(parts) <= depth {
+		return time.Time{}, false
+	}
+	return parseDateFolder(layout, strings.Join(parts[:depth], "/"))
+}
+
+// parseDateFolder returns the date of folder, a date folder of layout
+// relative to the library, possibly followed by an event name
+func parseDateFolder(layout, folder string) (time.Time, bool) {
+	folder = filepath.ToSlash(folder)
+	for end := len(folder); end > 0; end-- {
+		if end < len(folder) && folder[end] != ' ' && folder[end] != '_' {
+			continue
+		}
+		if date, err := parseLayout(dateLayout(layout), folder[:end]); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// layoutHasMonth reports whether a layout has a folder level for the month
+func layoutHasMonth(layout string) bool {
+	layout = dateLayout(layout)
+	return strings.Contains(layout, "01") || strings.Contains(layout, "Jan") || strings.HasSuffix(layout, "/1") || strings.Contains(layout, "/1/")
+}
+
+// inferLayout scans a library and returns the candidate layout that places
+// the most dated files correctly, with the number of files it matches and
+// the number of dated files. Ties go to the more specific layout.
+func inferLayout(root string) (string, int, int, error) {
+	scores := make(map[string]int)
+	dated := 0
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != root && (info.Name() == stateDirName || isHiddenOrSystem(info.Name())) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isImageFile(filepath.Ext(path)) {
+			return nil
+		}
+		date, err := getPhotoDate(path)
+		if err != nil {
+			return nil
+		}
+		dated++
+		rel, _ := filepath.Rel(root, filepath.Dir(path))
+		for _, layout := range layoutCandidates {
+			if layoutMatches(layout, rel, date) {
+				scores[layout]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	layouts := append([]string(nil), layoutCandidates...)
+	sort.SliceStable(layouts, func(i, j int) bool {
+		if scores[layouts[i]] != scores[layouts[j]] {
+			return scores[layouts[i]] > scores[layouts[j]]
+		}
+		return len(layouts[i]) > len(layouts[j])
+	})
+	if dated == 0 || scores[layouts[0]] == 0 {
+		return defaultLayout, 0, dated, nil
+	}
+	return layouts[0], scores[layouts[0]], dated, nil
+}
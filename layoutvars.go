@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Date parts that Go time layouts lack, usable in folder layouts
+const (
+	// isoYearVar is the ISO 8601 year the week belongs to, which differs
+	// from the calendar year for the first and last days of some years
+	isoYearVar = "{{.ISOYear}}"
+	// weekVar is the ISO 8601 week number, 01 to 53
+	weekVar = "{{.Week}}"
+	// quarterVar is the quarter of the year, 1 to 4
+	quarterVar = "{{.Quarter}}"
+)
+
+// layoutPrefix selects a built-in layout in -layout, as in "preset:week"
+const layoutPrefix = "preset:"
+
+// layoutPresets are the built-in layouts selectable with -layout preset:NAME
+var layoutPresets = map[string]string{
+	"month":   defaultLayout,
+	"day":     "2006/01/02",
+	"week":    isoYearVar + "/W" + weekVar,
+	"quarter": "2006/Q" + quarterVar,
+}
+
+// resolveLayout returns the layout -layout selects, expanding a preset
+// name, and checks it
+func resolveLayout(value string) (string, error) {
+	layout := value
+	if name, ok := strings.CutPrefix(value, layoutPrefix); ok {
+		rest := ""
+		if i := strings.Index(name, "/"); i >= 0 {
+			name, rest = name[:i], name[i:]
+		}
+		preset, ok := layoutPresets[name]
+		if !ok {
+			names := make([]string, 0, len(layoutPresets))
+			for name := range layoutPresets {
+				names = append(names, layoutPrefix+name)
+			}
+			sort.Strings(names)
+			return "", fmt.Errorf("unknown layout preset %q, expected %s", value, strings.Join(names, ", "))
+		}
+		layout = preset + rest
+	}
+	return layout, validateLayout(layout)
+}
+
+// nextLayoutToken finds the first month name or date part variable in a
+// layout, returning its position and the token, or -1 if there is none
+func nextLayoutToken(layout string) (int, string) {
+	tokens := []string{isoYearVar, weekVar, quarterVar}
+	// English month names are left to time.Format
+	if monthNames != nil {
+		tokens = append(tokens, "January", "Jan")
+	}
+	pos, token := -1, ""
+	for _, t := range tokens {
+		if i := strings.Index(layout, t); i >= 0 && (pos < 0 || i < pos) {
+			pos, token = i, t
+		}
+	}
+	return pos, token
+}
+
+// formatLayout formats a date layout like time.Format, with the date part
+// variables and the month names from monthNames
+func formatLayout(layout string, date time.Time) string {
+	var b strings.Builder
+	for {
+		i, token := nextLayoutToken(layout)
+		if i < 0 {
+			break
+		}
+		b.WriteString(date.Format(layout[:i]))
+		year, week := date.ISOWeek()
+		switch token {
+		case isoYearVar:
+			fmt.Fprintf(&b, "%04d", year)
+		case weekVar:
+			fmt.Fprintf(&b, "%02d", week)
+		case quarterVar:
+			fmt.Fprintf(&b, "%d", (date.Month()-1)/3+1)
+		case "January":
+			b.WriteString(monthNames[date.Month()-1])
+		case "Jan":
+			b.WriteString(shortMonthName(date.Month()))
+		}
+		layout = layout[i+len(token):]
+	}
+	b.WriteString(date.Format(layout))
+	return b.String()
+}
+
+// parseLayout parses a folder name formatted by formatLayout. A week is
+// parsed as its Monday and a quarter as its first day.
+func parseLayout(layout, value string) (time.Time, error) {
+	if !strings.Contains(layout, "{{") {
+		return parseMonthLayout(layout, value)
+	}
+	year, month, day := 0, time.January, 1
+	isoYear, week, quarter := 0, 0, 0
+	for layout != "" {
+		var text string
+		i, token := nextLayoutToken(layout)
+		switch {
+		case i < 0:
+			text, layout = layout, ""
+		case i > 0 || token == "January" || token == "Jan":
+			// Text up to the next variable is parsed as a time layout
+			text, layout = layout[:i], layout[i:]
+			if i == 0 {
+				text, layout = token, layout[len(token):]
+			}
+		}
+		if text != "" {
+			n, t, err := parseLayoutPrefix(text, value, layout == "")
+			if err != nil {
+				return time.Time{}, err
+			}
+			value = value[n:]
+			if t.Year() != 0 {
+				year = t.Year()
+			}
+			if t.Month() != time.January {
+				month = t.Month()
+			}
+			if t.Day() != 1 {
+				day = t.Day()
+			}
+			continue
+		}
+
+		width := map[string]int{isoYearVar: 4, weekVar: 2, quarterVar: 1}[token]
+		if len(value) < width {
+			return time.Time{}, fmt.Errorf("parsing %q: too short for %s", value, token)
+		}
+		n, err := strconv.Atoi(value[:width])
+		if err != nil || strings.ContainsAny(value[:width], "+-") {
+			return time.Time{}, fmt.Errorf("parsing %q: expected a number for %s", value, token)
+		}
+		switch token {
+		case isoYearVar:
+			isoYear = n
+		case weekVar:
+			week = n
+		case quarterVar:
+			quarter = n
+		}
+		value, layout = value[width:], layout[len(token):]
+	}
+	if value != "" {
+		return time.Time{}, fmt.Errorf("extra text %q", value)
+	}
+
+	if isoYear == 0 {
+		isoYear = year
+	}
+	switch {
+	case isoYear == 0:
+		return time.Time{}, errors.New("no year")
+	case week > 0:
+		// Week 1 is the one with January 4th, and weeks start on Monday
+		jan4 := time.Date(isoYear, time.January, 4, 0, 0, 0, 0, time.Local)
+		monday := jan4.AddDate(0, 0, -(int(jan4.Weekday())+6)%7+(week-1)*7)
+		if y, w := monday.ISOWeek(); y != isoYear || w != week {
+			return time.Time{}, fmt.Errorf("%d has no week %d", isoYear, week)
+		}
+		return monday, nil
+	case quarter > 0:
+		if quarter > 4 {
+			return time.Time{}, fmt.Errorf("invalid quarter %d", quarter)
+		}
+		return time.Date(isoYear, time.Month((quarter-1)*3+1), 1, 0, 0, 0, 0, time.Local), nil
+	}
+	return time.Date(isoYear, month, day, 0, 0, 0, 0, time.Local), nil
+}
+
+// parseLayoutPrefix parses the shortest start of value that matches a time
+// layout, or all of value when last, and returns its length
+func parseLayoutPrefix(layout, value string, last bool) (int, time.Time, error) {
+	if last {
+		t, err := parseMonthLayout(layout, value)
+		return len(value), t, err
+	}
+	for n := 1; n <= len(value); n++ {
+		if t, err := parseMonthLayout(layout, value[:n]); err == nil {
+			return n, t, nil
+		}
+	}
+	return 0, time.Time{}, fmt.Errorf("parsing %q: does not match %q", value, layout)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Link modes accepted by -link
+const (
+	linkNone    = ""
+	linkHard    = "hard"
+	linkReflink = "reflink"
+)
+
+// errReflinkUnsupported is returned when the platform or filesystem cannot clone files
+var errReflinkUnsupported = errors.New("reflinks are not supported on this platform")
+
+// validateLinkMode checks the -link flag value
+func validateLinkMode(mode string) error {
+	switch mode {
+	case linkNone, linkHard, linkReflink:
+		return nil
+	default:
+		return fmt.Errorf("invalid -link mode %q, expected 'hard' or 'reflink'", mode)
+	}
+}
+
+// linkFile hard-links or reflinks src to dst. If the link cannot be created,
+// for example because src and dst are on different filesystems, it falls back
+// to a regular copy on fsys. Links can only be made on the local disk.
+func linkFile(fsys fileSystem, src, dst, mode string) error {
+	src, dst = longPath(src), longPath(dst)
+
+	// Check if destination file already exists
+	if _, err := fsys.Stat(dst); err == nil {
+		// File exists, don't overwrite
+		slog.Info("Skipping: file already exists at destination", "path", dst)
+		return nil
+	}
+
+	var err error
+	if mode == linkHard {
+		err = os.Link(src, dst)
+	} else {
+		err = reflinkFile(src, dst)
+	}
+	if err == nil {
+		return nil
+	}
+
+	slog.Warn("Could not create link, copying instead", "mode", mode, "path", src, "error", err)
+	return copyFile(fsys, src, dst)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// lintIssue is one anomaly found in a sorted library
+type lintIssue struct {
+	Kind   string `json:"kind"`
+	Path   string `json:"path"`
+	Detail string `json:"detail"`
+}
+
+// planMove is a single file move proposed to fix the layout
+type planMove struct {
+	Source string `json:"source"`
+	Dest   string `json:"dest"`
+	Reason string `json:"reason"`
+}
+
+// fixPlan is written by "lint -plan" and lists the moves that restore the
+// yyyy/mm layout. Paths are relative to Dest.
+type fixPlan struct {
+	Dest    string     `json:"dest"`
+	Created time.Time  `json:"created"`
+	Moves   []planMove `json:"moves"`
+}
+
+// Kinds of layout anomalies
+const (
+	lintUnexpectedFolder = "unexpected-folder"
+	lintMisplaced        = "misplaced"
+	lintOutsideMonth     = "outside-month"
+	lintMixedNames       = "mixed-names"
+)
+
+var (
+	yearFolderPattern  = regexp.MustCompile(`^\d{4}$`)
+	monthNamePattern   = regexp.MustCompile(`^(0[1-9]|1[0-2])$`)
+	nameLettersPattern = regexp.MustCompile(`\pL+`)
+	nameDigitsPattern  = regexp.MustCompile(`\d+`)
+)
+
+// runLint implements the "lint" subcommand, which checks an existing library
+// against the yyyy/mm layout and optionally writes a plan to fix it
+func runLint(args []string) {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	destDir := fs.String("dest", "", "Sorted photo library to check")
+	planFile := fs.String("plan", "", "Write the moves that fix misplaced files to this JSON file")
+	screenshots := fs.String("screenshots", "", "Separate screenshots tree inside -dest to skip")
+	timeOffset := fs.Duration("time-offset", 0, "Time offset used when the library was sorted")
+	assumeTZ := fs.String("assume-tz", "", "Camera time zone used when the library was sorted")
+	dateTagOrder := fs.String("date-tags", defaultDateTags, "EXIF date tag order used when the library was sorted")
+	logOpts := addLogFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s lint -dest DIR [-plan FILE]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	parseInterspersed(fs, args)
+	if *destDir == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := logOpts.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	s := newLibrarySorter(*destDir, *screenshots, *timeOffset, *assumeTZ, *dateTagOrder)
+	issues, plan, err := s.lintLayout()
+	if err != nil {
+		fatal("Failed to check library", "error", err)
+	}
+	for _, issue := range issues {
+		slog.Warn("Layout issue", "kind", issue.Kind, "path", issue.Path, "detail", issue.Detail)
+	}
+	slog.Info("Layout check finished", "issues", len(issues), "moves", len(plan.Moves))
+
+	if *planFile != "" {
+		if err := writeJSONFile(*planFile, plan); err != nil {
+			fatal("Failed to write plan", "error", err)
+		}
+		slog.Info("Wrote fix plan", "path", *planFile)
+	}
+	if len(issues) > 0 {
+		os.Exit(1)
+	}
+}
+
+// newLibrarySorter returns a sorter for checking an existing library, set up
+// with the screenshots tree, time corrections, and date tags used when it was
+// sorted
+func newLibrarySorter(destDir, screenshots string, timeOffset time.Duration, assumeTZ, dateTagOrder string) *sorter {
+	s := &sorter{fsys: localFS, destDir: destDir, timeOffset: timeOffset}
+	var err error
+	if s.layout, err = libraryLayout(destDir); err != nil {
+		fatal("Failed to read library configuration", "error", err)
+	}
+	if screenshots != "" && !filepath.IsAbs(screenshots) {
+		s.screenshotsDir = filepath.Join(destDir, screenshots)
+	} else {
+		s.screenshotsDir = screenshots
+	}
+	if dateTags, err = parseDateTags(dateTagOrder); err != nil {
+		fatal("Invalid -date-tags", "error", err)
+	}
+	if assumeTZ != "" {
+		if s.assumeTZ, err = time.LoadLocation(assumeTZ); err != nil {
+			fatal("Invalid -assume-tz", "error", err)
+		}
+	}
+	return s
+}
+
+// lintLayout walks the destination and reports folders that are not year or
+// month folders, files whose capture date does not match their month folder,
+// files outside any month folder, and month folders mixing naming schemes.
+// In a library adopted with another layout, folder names are not checked and
+// every file outside the folder for its date counts as misplaced.
+func (s *sorter) lintLayout() ([]lintIssue, fixPlan, error) {
+	custom := s.layout != "" && s.layout != defaultLayout
+	plan := fixPlan{Dest: s.destDir, Created: time.Now()}
+	var issues []lintIssue
+	schemes := make(map[string]map[string]int) // month folder -> name scheme -> count
+
+	err := filepath.Walk(s.destDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(s.destDir, path)
+		if rel == "." {
+			return nil
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if isHiddenOrSystem(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			switch {
+			case len(parts) == 1 && (info.Name() == stateDirName || info.Name() == quarantineDirName || info.Name() == previewsDirName || info.Name() == unsortedDirName || path == s.screenshotsDir):
+				return filepath.SkipDir
+			case custom:
+				return nil
+			case len(parts) == 1 && !yearFolderPattern.MatchString(parts[0]):
+				issues = append(issues, lintIssue{lintUnexpectedFolder, rel, "not a year folder"})
+				return filepath.SkipDir
+			case len(parts) == 2 && !monthNamePattern.MatchString(parts[1]):
+				issues = append(issues, lintIssue{lintUnexpectedFolder, rel, "not a month folder"})
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		date, dateErr := getPhotoDate(path)
+		if dateErr == nil {
+			// Later chapters of a GoPro recording belong with the first
+			date = s.recordingDate(path, s.adjustTime(date))
+		}
+		if custom {
+			folder := filepath.ToSlash(filepath.Dir(rel))
+			if schemes[folder] == nil {
+				schemes[folder] = make(map[string]int)
+			}
+			schemes[folder][nameScheme(info.Name())]++
+			if dateErr == nil && !layoutMatches(s.layout, folder, date) {
+				expected := formatLayout(dateLayout(s.layout), date)
+				issues = append(issues, lintIssue{lintMisplaced, rel, fmt.Sprintf("taken %s, belongs in %s", date.Format("2006-01-02"), expected)})
+				s.planMove(&plan, rel, date, info.Name(), "taken in "+expected)
+			}
+			return nil
+		}
+		if len(parts) < 3 {
+			issues = append(issues, lintIssue{lintOutsideMonth, rel, "file is not inside a month folder"})
+			if dateErr == nil {
+				s.planMove(&plan, rel, date, parts[len(parts)-1], "outside month folder")
+			}
+			return nil
+		}
+
+		month := parts[0] + "/" + parts[1]
+		if schemes[month] == nil {
+			schemes[month] = make(map[string]int)
+		}
+		schemes[month][nameScheme(info.Name())]++
+
+		if dateErr != nil {
+			return nil
+		}
+		if expected := date.Format("2006/01"); expected != month {
+			issues = append(issues, lintIssue{lintMisplaced, rel, fmt.Sprintf("taken %s, belongs in %s", date.Format("2006-01-02"), expected)})
+			// Keep any set subfolder below the month
+			s.planMove(&plan, rel, date, filepath.Join(parts[2:]...), "taken in "+expected)
+		}
+		return nil
+	})
+
+	var months []string
+	for month := range schemes {
+		months = append(months, month)
+	}
+	sort.Strings(months)
+	for _, month := range months {
+		if len(schemes[month]) < 2 {
+			continue
+		}
+		var names []string
+		for scheme, count := range schemes[month] {
+			names = append(names, fmt.Sprintf("%s (%d)", scheme, count))
+		}
+		sort.Strings(names)
+		issues = append(issues, lintIssue{lintMixedNames, filepath.FromSlash(month), strings.Join(names, ", ")})
+	}
+	return issues, plan, err
+}
+
+// planMove adds a move of rel into the folder for date
+func (s *sorter) planMove(plan *fixPlan, rel string, date time.Time, name, reason string) {
+	dest := filepath.Join(s.folderFor(date), name)
+	plan.Moves = append(plan.Moves, planMove{Source: rel, Dest: dest, Reason: reason})
+}
+
+// nameScheme reduces a file name to its shape, so "IMG_0001.JPG" and
+// "DSC_1234.NEF" both become "A_9" while "2023-07-01 12.00.00.jpg" becomes "9-9-9 9.9.9"
+func nameScheme(name string) string {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	base = nameDigitsPattern.ReplaceAllString(base, "9")
+	return nameLettersPattern.ReplaceAllString(base, "A")
+}
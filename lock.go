@@ -0,0 +1,203 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Lock modes accepted by -lock
+const (
+	lockNone      = ""
+	lockReadOnly  = "readonly"
+	lockImmutable = "immutable"
+)
+
+// validateLockMode checks the -lock flag value
+func validateLockMode(mode string) error {
+	switch mode {
+	case lockNone, lockReadOnly, lockImmutable:
+		return nil
+	default:
+		return fmt.Errorf("invalid -lock mode %q, expected 'readonly' or 'immutable'", mode)
+	}
+}
+
+// lockFolder makes a folder and everything below it read-only. With
+// immutable, it additionally sets the immutable flag (chattr +i on Linux,
+// which requires root, or chflags uchg on macOS) where permitted.
+func lockFolder(dir string, immutable bool) error {
+	var dirs []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			dirs = append(dirs, path)
+			return nil
+		}
+		return os.Chmod(path, info.Mode().Perm()&^0222)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Lock directories deepest first, after their contents
+	for i := len(dirs) - 1; i >= 0; i-- {
+		info, err := os.Stat(dirs[i])
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(dirs[i], info.Mode().Perm()&^0222); err != nil {
+			return err
+		}
+	}
+
+	if immutable {
+		if err := setImmutable(dir, true); err != nil {
+			slog.Warn("Could not set immutable flag, folder is read-only only", "path", dir, "error", err)
+		}
+	}
+	return nil
+}
+
+// unlockFolder clears the immutable flag and restores owner write
+// permission on a folder and everything below it
+func unlockFolder(dir string) error {
+	// Clearing a flag that was never set is harmless; only report real failures
+	if err := setImmutable(dir, false); err != nil {
+		slog.Debug("Could not clear immutable flag", "path", dir, "error", err)
+	}
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chmod(path, info.Mode().Perm()|0200)
+	})
+}
+
+// isLocked reports whether a folder has been made read-only
+func isLocked(dir string) bool {
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir() && info.Mode().Perm()&0200 == 0
+}
+
+// setImmutable sets or clears the platform's immutable flag recursively
+func setImmutable(dir string, on bool) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux":
+		flag := "-i"
+		if on {
+			flag = "+i"
+		}
+		cmd = exec.Command("chattr", "-R", flag, dir)
+	case "darwin", "freebsd":
+		flag := "nouchg"
+		if on {
+			flag = "uchg"
+		}
+		cmd = exec.Command("chflags", "-R", flag, dir)
+	default:
+		return fmt.Errorf("immutable flags are not supported on %s", runtime.GOOS)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// monthFolders returns the date folders of a library in its layout, the
+// folders -lock locks, such as yyyy/mm with the default layout
+func monthFolders(destDir string) ([]string, error) {
+	layout, err := libraryLayout(destDir)
+	if err != nil {
+		return nil, err
+	}
+	depth := layoutFolderDepth(layout)
+	var folders []string
+	err = filepath.WalkDir(destDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || path == destDir {
+			return nil
+		}
+		if isToolFolder(d.Name()) || isHiddenOrSystem(d.Name()) {
+			return filepath.SkipDir
+		}
+		rel, _ := filepath.Rel(destDir, path)
+		if strings.Count(filepath.ToSlash(rel), "/")+1 < depth {
+			return nil
+		}
+		if _, ok := parseDateFolder(layout, rel); ok {
+			folders = append(folders, path)
+		}
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(folders)
+	return folders, nil
+}
+
+// runUnlock implements the "unlock" subcommand, making locked month folders
+// writable again for intentional edits
+func runUnlock(args []string) {
+	fs := flag.NewFlagSet("unlock", flag.ExitOnError)
+	destDir := fs.String("dest", "", "Sorted photo library")
+	logOpts := addLogFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s unlock -dest DIR [FOLDER ...]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	positional := parseInterspersed(fs, args)
+	if *destDir == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := logOpts.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Unlock the given month folders, or every month folder in the library
+	var folders []string
+	for _, month := range positional {
+		folders = append(folders, filepath.Join(*destDir, filepath.FromSlash(month)))
+	}
+	if len(folders) == 0 {
+		var err error
+		if folders, err = monthFolders(*destDir); err != nil {
+			fatal("Failed to list month folders", "error", err)
+		}
+	}
+
+	failed := false
+	for _, folder := range folders {
+		// Folders with files under retention stay locked
+		if err := checkRetention(*destDir, folder); err != nil {
+			slog.Error("Not unlocking folder", "path", folder, "error", err)
+			failed = true
+			continue
+		}
+		if err := unlockFolder(folder); err != nil {
+			slog.Error("Could not unlock folder", "path", folder, "error", err)
+			failed = true
+			continue
+		}
+		slog.Info("Unlocked", "path", folder)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMonthFoldersFollowAdoptedLayout(t *testing.T) {
+	lib := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(lib, stateDirName), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeJSONFile(libraryConfigPath(lib), libraryConfig{Layout: "2006/2006-01-02"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"2021/2021-05-06", "2021/2021-05-07 Beach", "2021/05", previewsDirName + "/2021/2021-05-06", unsortedDirName + "/card"} {
+		if err := os.MkdirAll(filepath.Join(lib, filepath.FromSlash(dir)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	folders, err := monthFolders(lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, folder := range folders {
+		rel, _ := filepath.Rel(lib, folder)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	if want := "2021/2021-05-06 2021/2021-05-07 Beach"; strings.Join(got, " ") != want {
+		t.Errorf("folders are %q, want %q", got, want)
+	}
+}
+
+func TestLockSkipsNewFoldersAfterFailures(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+	may, june := filepath.Join(dest, "2021", "05"), filepath.Join(dest, "2021", "06")
+	t.Cleanup(func() {
+		unlockFolder(may)
+		unlockFolder(june)
+	})
+	writeTestFile(t, filepath.Join(src, "IMG_0001.jpg"), testJPEG(time.Date(2021, time.May, 6, 7, 8, 9, 0, time.Local), "a"))
+	// PNGs cannot be stripped of private metadata, so this file fails
+	writeTestFile(t, filepath.Join(src, "IMG_20210601_120000.png"), []byte("png"))
+	if err := os.MkdirAll(june, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := lockFolder(june, false); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestSorter(t, localFS, dest, src)
+	s.dateSources = []string{dateSourceExif, dateSourceFilename}
+	s.lockMode = lockReadOnly
+	s.stripPrivate = true
+	if err := s.run(); err != nil {
+		t.Fatal(err)
+	}
+	if s.failures() != 1 || s.counts[outcomeSorted] != 1 {
+		t.Fatalf("counts are %v, want one sorted and one failed file", s.counts)
+	}
+	if isLocked(may) {
+		t.Error("locked a new folder after a failed file")
+	}
+	if !isLocked(june) {
+		t.Error("left a folder locked before the run unlocked")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// logOptions holds the logging flags shared by all subcommands
+type logOptions struct {
+	format  *string
+	level   *string
+	file    *string
+	plain   *bool
+	verbose *verbosity
+}
+
+// eventLogSource is the event log source -log-format eventlog writes to,
+// the name of the Windows service
+var eventLogSource = "gopicsort"
+
+// addLogFlags registers the logging flags on a flag set
+func addLogFlags(fs *flag.FlagSet) *logOptions {
+	o := &logOptions{
+		format:  fs.String("log-format", "auto", "Log format: console (colored, one line per file), text, json, or eventlog (the Windows event log); auto uses console on a terminal and text otherwise"),
+		level:   fs.String("log-level", "info", "Minimum log level: debug, info, warn, or error"),
+		file:    fs.String("log-file", "", "Append logs to this file instead of standard error"),
+		plain:   fs.Bool("plain", false, "Plain output for screen readers and log processors: one sentence per event, no timestamps, colors, or progress bars"),
+		verbose: new(verbosity),
+	}
+	fs.Var(o.verbose, "verbose", "Log more: once for debug messages, twice to also show times and every detail in console output")
+	return o
+}
+
+// setup installs the configured logger as the default slog logger
+func (o *logOptions) setup() error {
+	var level slog.Level
+	switch strings.ToLower(*o.level) {
+	case "debug":
+		level = slog.LevelDebug
+	case "info":
+		level = slog.LevelInfo
+	case "warn", "warning":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	default:
+		return fmt.Errorf("invalid -log-level %q", *o.level)
+	}
+	if *o.verbose > 0 {
+		level = slog.LevelDebug
+	}
+
+	var out io.Writer = os.Stderr
+	if *o.file != "" {
+		file, err := os.OpenFile(*o.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %v", err)
+		}
+		out = file
+	}
+
+	handlerOptions := &slog.HandlerOptions{Level: level}
+	format := strings.ToLower(*o.format)
+	if *o.plain {
+		if format != "text" && format != "auto" {
+			return fmt.Errorf("-plain cannot be combined with -log-format %s", *o.format)
+		}
+		plainOutput = true
+		slog.SetDefault(slog.New(newPlainHandler(out, level)))
+		return nil
+	}
+	if format == "auto" {
+		format = "text"
+		if isTerminal(out) {
+			format = "console"
+		}
+	}
+	switch format {
+	case "console":
+		color := isTerminal(out) && colorAllowed()
+		slog.SetDefault(slog.New(newConsoleHandler(out, level, color, *o.verbose > 1)))
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(out, handlerOptions)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(out, handlerOptions)))
+	case "eventlog":
+		handler, err := newEventLogHandler(eventLogSource, level)
+		if err != nil {
+			return err
+		}
+		slog.SetDefault(slog.New(handler))
+	default:
+		return fmt.Errorf("invalid -log-format %q, expected 'console', 'text', 'json', or 'eventlog'", *o.format)
+	}
+	return nil
+}
+
+// fatal logs an error and exits with the status for a failed run
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	if jsonResult {
+		writeResult(os.Stdout, failureResult(msg, args...))
+	}
+	events.finished(failureResult(msg, args...))
+	os.Exit(exitFatal)
+}
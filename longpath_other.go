@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+// longPath returns path unchanged, long paths need no special form here
+func longPath(path string) string {
+	return path
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// longPath returns path in the extended-length form Windows needs for paths
+// longer than MAX_PATH (260 characters): "C:\photos\..." becomes
+// "\\?\C:\photos\..." and a network share "\\server\share\..." becomes
+// "\\?\UNC\server\share\...". Such paths are taken literally by Windows, so
+// the path is made absolute and cleaned first.
+func longPath(path string) string {
+	if strings.HasPrefix(path, `\\?\`) || strings.HasPrefix(path, `\\.\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
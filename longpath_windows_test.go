@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestLongPath(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{`C:\photos\2021\05\IMG_0001.jpg`, `\\?\C:\photos\2021\05\IMG_0001.jpg`},
+		{`C:\photos\..\library\.\a.jpg`, `\\?\C:\library\a.jpg`},
+		{`C:/photos/2021/a.jpg`, `\\?\C:\photos\2021\a.jpg`},
+		{`\\nas\share\photos\a.jpg`, `\\?\UNC\nas\share\photos\a.jpg`},
+		{`\\nas\share\photos\..\a.jpg`, `\\?\UNC\nas\share\a.jpg`},
+		{`\\?\C:\photos\a.jpg`, `\\?\C:\photos\a.jpg`},
+		{`\\?\UNC\nas\share\a.jpg`, `\\?\UNC\nas\share\a.jpg`},
+		{`\\.\PhysicalDrive0`, `\\.\PhysicalDrive0`},
+	}
+	for _, test := range tests {
+		if got := longPath(test.path); got != test.want {
+			t.Errorf("longPath(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
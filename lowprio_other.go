@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// lowerPriority is not supported on this platform
+func lowerPriority() error {
+	return fmt.Errorf("-low-priority is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// recordManifest adds the SHA-256 of a file just written to the library to
+// the manifest saved into the library's catalog at the end of the run, so
+// scrub can later tell if its contents changed on disk. labels are the
+// classifier's labels of the file, nil if it was not classified.
+func (s *sorter) recordManifest(destPath string, labels []string) {
+	rel, err := filepath.Rel(s.destDir, destPath)
+	if err != nil || !isWithin(s.destDir, destPath) {
+		return
+	}
+	info, err := s.fsys.Stat(longPath(destPath))
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+	sum, err := hashFile(s.fsys, longPath(destPath))
+	if err != nil {
+		slog.Warn("Could not hash file for the manifest", "path", destPath, "error", err)
+		return
+	}
+	if s.manifest == nil {
+		s.manifest = make(map[string]catalogEntry)
+	}
+	s.manifest[filepath.ToSlash(rel)] = catalogEntry{Size: info.Size(), ModTime: info.ModTime(), SHA256: sum, Verified: time.Now(), Labels: labels, Classified: labels != nil}
+}
+
+// saveManifest merges the hashes recorded in the run into the library's
+// catalog
+func (s *sorter) saveManifest() error {
+	if len(s.manifest) == 0 {
+		return nil
+	}
+	catalog, err := loadCatalog(s.destDir)
+	if err != nil {
+		return err
+	}
+	for rel, entry := range s.manifest {
+		// Labels of an unchanged file survive runs without the classifier
+		if old, ok := catalog[rel]; ok && !entry.Classified && old.SHA256 == entry.SHA256 {
+			entry.Labels, entry.Classified = old.Labels, old.Classified
+		}
+		catalog[rel] = entry
+	}
+	if err := os.MkdirAll(filepath.Join(s.destDir, stateDirName), 0755); err != nil {
+		return fmt.Errorf("failed to create state folder: %v", err)
+	}
+	return writeJSONFile(catalogPath(s.destDir), catalog)
+}
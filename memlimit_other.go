@@ -0,0 +1,6 @@
+//go:build !linux && !darwin
+
+package main
+
+// limitMemory relies on the Go runtime's soft limit alone on this platform
+func limitMemory(limit int64) {}
@@ -0,0 +1,13 @@
+//go:build linux || darwin
+
+package main
+
+import "syscall"
+
+// limitMemory caps the process data segment, which includes the Go heap, so
+// the kernel refuses allocations beyond the limit
+func limitMemory(limit int64) {
+	// Leave room for the runtime and the binary's own data
+	limit += 64 << 20
+	syscall.Setrlimit(syscall.RLIMIT_DATA, &syscall.Rlimit{Cur: uint64(limit), Max: uint64(limit)})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Messaging apps whose saved media is recognized
+const (
+	appWhatsApp = "whatsapp"
+	appTelegram = "telegram"
+)
+
+// messagingNamePatterns match the names messaging apps give received media.
+// The named groups hold the date the chat received the file, in local time.
+var messagingNamePatterns = []struct {
+	app     string
+	pattern *regexp.Regexp
+}{
+	// IMG-20230105-WA0001.jpg, VID-20230105-WA0003.mp4
+	{appWhatsApp, regexp.MustCompile(`(?i)^(?:IMG|VID|AUD|PTT|STK|DOC)-(?P<year>\d{4})(?P<month>\d{2})(?P<day>\d{2})-WA\d+`)},
+	// WhatsApp Image 2023-01-05 at 10.10.10.jpeg, saved from WhatsApp Web and Desktop
+	{appWhatsApp, regexp.MustCompile(`(?i)^WhatsApp (?:Image|Video|Audio|Ptt) (?P<year>\d{4})-(?P<month>\d{2})-(?P<day>\d{2}) at (?P<hour>\d{1,2})[.:](?P<minute>\d{2})[.:](?P<second>\d{2})(?:[ \x{202f}]?(?P<ampm>[AP]M))?`)},
+	// photo_2023-01-05_10-10-10.jpg, downloaded with Telegram Desktop
+	{appTelegram, regexp.MustCompile(`(?i)^(?:photo|video|file|round_video|sticker|animation)_(?P<year>\d{4})-(?P<month>\d{2})-(?P<day>\d{2})_(?P<hour>\d{2})-(?P<minute>\d{2})-(?P<second>\d{2})`)},
+	// photo_12@05-01-2023_10-10-10.jpg, from a Telegram chat export
+	{appTelegram, regexp.MustCompile(`(?i)^(?:photo|video|file|round_video|sticker|animation)_\d+@(?P<day>\d{2})-(?P<month>\d{2})-(?P<year>\d{4})_(?P<hour>\d{2})-(?P<minute>\d{2})-(?P<second>\d{2})`)},
+}
+
+// messagingFolderPattern matches the folders the phone apps save media in,
+// such as "WhatsApp Images" or "Telegram Video", where names carry no date
+var messagingFolderPattern = regexp.MustCompile(`(?i)^(WhatsApp|Telegram) (Images|Video|Animated Gifs|Documents|Stickers|Audio)$`)
+
+// messagingMedia reports the messaging app a file was saved from, by its
+// name or folder, and the date the chat received it when the name has one
+func messagingMedia(path string) (string, time.Time, bool) {
+	name := filepath.Base(path)
+	for _, p := range messagingNamePatterns {
+		m := p.pattern.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		date, ok := messagingNameDate(p.pattern, m)
+		return p.app, date, ok
+	}
+	for dir := filepath.Dir(path); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if m := messagingFolderPattern.FindStringSubmatch(filepath.Base(dir)); m != nil {
+			return strings.ToLower(m[1]), time.Time{}, false
+		}
+	}
+	return "", time.Time{}, false
+}
+
+// messagingNameDate builds the date from the named groups of a match,
+// rejecting impossible dates
+func messagingNameDate(pattern *regexp.Regexp, m []string) (time.Time, bool) {
+	parts := make(map[string]int)
+	pm := false
+	for i, group := range pattern.SubexpNames() {
+		switch {
+		case group == "ampm":
+			pm = strings.EqualFold(m[i], "PM")
+			if m[i] != "" && parts["hour"] == 12 {
+				parts["hour"] = 0
+			}
+		case group != "" && m[i] != "":
+			parts[group], _ = strconv.Atoi(m[i])
+		}
+	}
+	if pm {
+		parts["hour"] += 12
+	}
+	year, month, day := parts["year"], parts["month"], parts["day"]
+	date := time.Date(year, time.Month(month), day, parts["hour"], parts["minute"], parts["second"], 0, time.Local)
+	if date.Year() != year || int(date.Month()) != month || date.Day() != day || date.Hour() != parts["hour"] || year < 2009 {
+		return time.Time{}, false
+	}
+	return date, true
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// metadataEntry is what the metadata cache knows about one file. It is only
+// used while the file keeps the size and modification time it was read with.
+type metadataEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// DateTags is the -date-tags order the EXIF date was read with, as a
+	// different order may find a different date
+	DateTags  string     `json:"date_tags,omitempty"`
+	Date      *time.Time `json:"date,omitempty"`
+	DateError string     `json:"date_error,omitempty"`
+	SHA256    string     `json:"sha256,omitempty"`
+}
+
+// metadataCache keeps the EXIF dates and content hashes of files between
+// runs, set with -metadata-cache, so repeated plans and retries over a large
+// source do not parse and hash every file again. Entries are keyed by
+// absolute path and dropped when the file's size or modification time
+// changes. A nil cache reads every file.
+type metadataCache struct {
+	path    string
+	entries map[string]*metadataEntry
+	// used holds the paths looked up in this run
+	used    map[string]bool
+	changed bool
+}
+
+// openMetadataCache loads the cache file at path, which need not exist yet.
+// A cache that cannot be read is returned empty along with the error.
+func openMetadataCache(path string) (*metadataCache, error) {
+	c := &metadataCache{path: path, entries: make(map[string]*metadataEntry), used: make(map[string]bool)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		c.entries = make(map[string]*metadataEntry)
+		return c, fmt.Errorf("invalid metadata cache: %v", err)
+	}
+	return c, nil
+}
+
+// entry returns the cache entry of a file, replacing a stale one with an
+// empty entry for its current size and modification time
+func (c *metadataCache) entry(path string, info fs.FileInfo) (string, *metadataEntry) {
+	key, err := filepath.Abs(path)
+	if err != nil {
+		key = path
+	}
+	c.used[key] = true
+	entry := c.entries[key]
+	if entry == nil || entry.Size != info.Size() || !entry.ModTime.Equal(info.ModTime()) {
+		entry = &metadataEntry{Size: info.Size(), ModTime: info.ModTime()}
+		c.entries[key] = entry
+		c.changed = true
+	}
+	return key, entry
+}
+
+// exifDate returns the EXIF capture date of a file like getExifDate, from
+// the cache when the file was read before with the same -date-tags
+func (c *metadataCache) exifDate(fsys fileSystem, path string, info fs.FileInfo) (time.Time, error) {
+	if c == nil {
+		return getExifDate(fsys, path)
+	}
+	_, entry := c.entry(path, info)
+	tags := dateTagsKey()
+	if entry.DateTags == tags {
+		if entry.Date != nil {
+			return *entry.Date, nil
+		}
+		if entry.DateError != "" {
+			return time.Time{}, errors.New(entry.DateError)
+		}
+	}
+
+	date, err := getExifDate(fsys, path)
+	// Failures to read the file may go away, only what it contains is kept
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return date, err
+	}
+	entry.DateTags, entry.Date, entry.DateError = tags, nil, ""
+	if err != nil {
+		entry.DateError = err.Error()
+	} else {
+		entry.Date = &date
+	}
+	c.changed = true
+	return date, err
+}
+
+// hashFile returns the SHA-256 of a file on fsys like the hashFile function,
+// from the cache when the file was hashed before
+func (c *metadataCache) hashFile(fsys fileSystem, path string) (string, error) {
+	if c == nil {
+		return hashFile(fsys, path)
+	}
+	info, err := fsys.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	_, entry := c.entry(path, info)
+	if entry.SHA256 != "" {
+		return entry.SHA256, nil
+	}
+	sum, err := hashFile(fsys, path)
+	if err != nil {
+		return "", err
+	}
+	entry.SHA256 = sum
+	c.changed = true
+	return sum, nil
+}
+
+// save writes the cache back to its file. Entries below sourceDirs that were
+// not looked up in this run are dropped, as their files were moved or
+// deleted since.
+func (c *metadataCache) save(sourceDirs []string) error {
+	if c == nil {
+		return nil
+	}
+	var roots []string
+	for _, dir := range sourceDirs {
+		if abs, err := filepath.Abs(dir); err == nil {
+			roots = append(roots, abs)
+		}
+	}
+	for key := range c.entries {
+		if c.used[key] {
+			continue
+		}
+		for _, root := range roots {
+			if isWithin(root, key) {
+				delete(c.entries, key)
+				c.changed = true
+				break
+			}
+		}
+	}
+	if !c.changed {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	if err := writeJSONFile(c.path, c.entries); err != nil {
+		return err
+	}
+	c.changed = false
+	return nil
+}
+
+// dateTagsKey identifies the current -date-tags order in cache entries
+func dateTagsKey() string {
+	names := make([]string, len(dateTags))
+	for i, tag := range dateTags {
+		names[i] = string(tag)
+	}
+	return strings.Join(names, ",")
+}
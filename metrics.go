@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// watchMetrics counts what a long-running watch has done, for the
+// Prometheus endpoint enabled by -metrics-addr. The watch loop updates it
+// while the endpoint reads it, so every access holds mu.
+type watchMetrics struct {
+	mu       sync.Mutex
+	files    map[string]int64 // by outcome
+	bytes    int64
+	errors   int64
+	queue    int
+	lastPoll time.Time
+}
+
+// startMetricsServer serves the metrics in the Prometheus text format at
+// /metrics in the background
+func startMetricsServer(addr string, m *watchMetrics) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", m.handleMetrics)
+
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 30 * time.Second}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("Metrics endpoint listening", "addr", listener.Addr().String())
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("Metrics endpoint stopped", "error", err)
+		}
+	}()
+	return nil
+}
+
+// file counts a file with an outcome; m may be nil when metrics are off
+func (m *watchMetrics) file(outcome string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.files == nil {
+		m.files = make(map[string]int64)
+	}
+	m.files[outcome]++
+	if isFailure(outcome) {
+		m.errors++
+	}
+}
+
+// copied counts the bytes of a file written to the destination
+func (m *watchMetrics) copied(n int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytes += n
+}
+
+// polled records the end of a poll with the files still waiting to settle
+func (m *watchMetrics) polled(queue int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queue = queue
+	m.lastPoll = time.Now()
+}
+
+// handleMetrics writes the metrics in the Prometheus text exposition format
+func (m *watchMetrics) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP gopicsort_files_total Files processed, by outcome.")
+	fmt.Fprintln(w, "# TYPE gopicsort_files_total counter")
+	outcomes := make([]string, 0, len(m.files))
+	for outcome := range m.files {
+		outcomes = append(outcomes, outcome)
+	}
+	sort.Strings(outcomes)
+	for _, outcome := range outcomes {
+		fmt.Fprintf(w, "gopicsort_files_total{outcome=%q} %d\n", outcome, m.files[outcome])
+	}
+	fmt.Fprintln(w, "# HELP gopicsort_bytes_copied_total Bytes of files written to the destination.")
+	fmt.Fprintln(w, "# TYPE gopicsort_bytes_copied_total counter")
+	fmt.Fprintf(w, "gopicsort_bytes_copied_total %d\n", m.bytes)
+	fmt.Fprintln(w, "# HELP gopicsort_errors_total Files that should have been sorted but could not be.")
+	fmt.Fprintln(w, "# TYPE gopicsort_errors_total counter")
+	fmt.Fprintf(w, "gopicsort_errors_total %d\n", m.errors)
+	fmt.Fprintln(w, "# HELP gopicsort_queue_depth Files found in the source that are waiting to finish writing.")
+	fmt.Fprintln(w, "# TYPE gopicsort_queue_depth gauge")
+	fmt.Fprintf(w, "gopicsort_queue_depth %d\n", m.queue)
+	fmt.Fprintln(w, "# HELP gopicsort_last_poll_timestamp_seconds Time the source was last checked for new files.")
+	fmt.Fprintln(w, "# TYPE gopicsort_last_poll_timestamp_seconds gauge")
+	if m.lastPoll.IsZero() {
+		fmt.Fprintln(w, "gopicsort_last_poll_timestamp_seconds 0")
+	} else {
+		fmt.Fprintf(w, "gopicsort_last_poll_timestamp_seconds %.3f\n", float64(m.lastPoll.UnixMilli())/1000)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// mirrorFile copies a file of the library to the same place in every
+// mirror given with further -dest flags. A mirror that already has the file
+// is left alone, one with a different file under the name keeps it, and a
+// mirror that cannot be written does not stop the others; the last two are
+// remembered as out of sync.
+func (s *sorter) mirrorFile(destPath string) {
+	rel, err := filepath.Rel(s.destDir, destPath)
+	if err != nil || !isWithin(s.destDir, destPath) {
+		return
+	}
+	for _, mirror := range s.mirrors {
+		target := filepath.Join(mirror, rel)
+		if err := mirrorCopy(destPath, target); err != nil {
+			slog.Warn("Could not mirror file", "path", destPath, "mirror", mirror, "error", err)
+			if s.outOfSync == nil {
+				s.outOfSync = make(map[string][]string)
+			}
+			s.outOfSync[mirror] = append(s.outOfSync[mirror], rel)
+			continue
+		}
+		slog.Debug("Mirrored", "path", destPath, "dest", target)
+	}
+}
+
+// mirrorCopy copies src to dst unless dst already holds the same contents,
+// and reports a conflict if it holds different ones
+func mirrorCopy(src, dst string) error {
+	if dstInfo, err := os.Stat(longPath(dst)); err == nil {
+		srcInfo, err := os.Stat(longPath(src))
+		if err != nil {
+			return err
+		}
+		if dstInfo.Size() == srcInfo.Size() {
+			if same, err := sameContents(localFS, src, dst); err != nil || same {
+				return err
+			}
+		}
+		return fmt.Errorf("a different file already exists at %s", dst)
+	}
+	if err := os.MkdirAll(longPath(filepath.Dir(dst)), 0755); err != nil {
+		return err
+	}
+	return copyFile(localFS, src, dst)
+}
+
+// mirrorProblems returns how many files could not be mirrored
+func (s *sorter) mirrorProblems() int {
+	n := 0
+	for _, paths := range s.outOfSync {
+		n += len(paths)
+	}
+	return n
+}
+
+// printMirrorSummary lists, per mirror, the files it is missing or has a
+// different version of after the run
+func (s *sorter) printMirrorSummary() {
+	mirrors := make([]string, 0, len(s.outOfSync))
+	for mirror := range s.outOfSync {
+		mirrors = append(mirrors, mirror)
+	}
+	sort.Strings(mirrors)
+	for _, mirror := range mirrors {
+		slog.Error("Mirror is out of sync with the library", "mirror", mirror, "files", len(s.outOfSync[mirror]))
+		for _, rel := range s.outOfSync[mirror] {
+			fmt.Fprintln(os.Stderr, "  "+rel)
+		}
+	}
+}
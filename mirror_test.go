@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMirrorsReceiveSortedFiles(t *testing.T) {
+	src, lib, mirror := t.TempDir(), t.TempDir(), t.TempDir()
+	writeTestFile(t, filepath.Join(src, "IMG_20210506_070809.jpg"), []byte("may"))
+	writeTestFile(t, filepath.Join(src, "IMG_20210507_070809.jpg"), []byte("second"))
+	// The mirror keeps a different file it already has under the name
+	writeTestFile(t, filepath.Join(mirror, "2021", "05", "IMG_20210507_070809.jpg"), []byte("other"))
+
+	s := newTestSorter(t, localFS, lib, src)
+	s.dateSources = []string{dateSourceFilename}
+	s.mirrors = []string{mirror}
+	if err := s.run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(listFiles(t, mirror), " "); got != "2021/05/IMG_20210506_070809.jpg 2021/05/IMG_20210507_070809.jpg" {
+		t.Errorf("mirror holds %s", got)
+	}
+	data, err := os.ReadFile(filepath.Join(mirror, "2021", "05", "IMG_20210507_070809.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "other" {
+		t.Error("mirroring replaced a different file")
+	}
+	if s.mirrorProblems() != 1 {
+		t.Errorf("%d files out of sync, want 1", s.mirrorProblems())
+	}
+}
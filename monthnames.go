@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// monthLocales are the month names -month-names accepts by language code
+var monthLocales = map[string][]string{
+	"da": {"Januar", "Februar", "Marts", "April", "Maj", "Juni", "Juli", "August", "September", "Oktober", "November", "December"},
+	"de": {"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+	"es": {"Enero", "Febrero", "Marzo", "Abril", "Mayo", "Junio", "Julio", "Agosto", "Septiembre", "Octubre", "Noviembre", "Diciembre"},
+	"fi": {"Tammikuu", "Helmikuu", "Maaliskuu", "Huhtikuu", "Toukokuu", "Kesäkuu", "Heinäkuu", "Elokuu", "Syyskuu", "Lokakuu", "Marraskuu", "Joulukuu"},
+	"fr": {"Janvier", "Février", "Mars", "Avril", "Mai", "Juin", "Juillet", "Août", "Septembre", "Octobre", "Novembre", "Décembre"},
+	"it": {"Gennaio", "Febbraio", "Marzo", "Aprile", "Maggio", "Giugno", "Luglio", "Agosto", "Settembre", "Ottobre", "Novembre", "Dicembre"},
+	"nb": {"Januar", "Februar", "Mars", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Desember"},
+	"nl": {"Januari", "Februari", "Maart", "April", "Mei", "Juni", "Juli", "Augustus", "September", "Oktober", "November", "December"},
+	"pl": {"Styczeń", "Luty", "Marzec", "Kwiecień", "Maj", "Czerwiec", "Lipiec", "Sierpień", "Wrzesień", "Październik", "Listopad", "Grudzień"},
+	"pt": {"Janeiro", "Fevereiro", "Março", "Abril", "Maio", "Junho", "Julho", "Agosto", "Setembro", "Outubro", "Novembro", "Dezembro"},
+	"sv": {"Januari", "Februari", "Mars", "April", "Maj", "Juni", "Juli", "Augusti", "September", "Oktober", "November", "December"},
+}
+
+// monthNames replaces the English month names of "January" and "Jan" in
+// folder layouts, set from -month-names or the library's configuration; nil
+// keeps the English names
+var monthNames []string
+
+// parseMonthNames parses -month-names: a language code, "en" for the English
+// names, or twelve comma-separated names starting with January
+func parseMonthNames(value string) ([]string, error) {
+	value = strings.TrimSpace(value)
+	if strings.EqualFold(value, "en") {
+		return nil, nil
+	}
+	if names, ok := monthLocales[strings.ToLower(value)]; ok {
+		return names, nil
+	}
+	if !strings.Contains(value, ",") {
+		codes := make([]string, 0, len(monthLocales))
+		for code := range monthLocales {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		return nil, fmt.Errorf("unknown month names %q, expected en, %s, or twelve names separated by commas", value, strings.Join(codes, ", "))
+	}
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		names = append(names, strings.TrimSpace(name))
+	}
+	if err := validateMonthNames(names); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// validateMonthNames checks that there are twelve distinct names usable in
+// folder names
+func validateMonthNames(names []string) error {
+	if len(names) != 12 {
+		return fmt.Errorf("expected twelve month names, got %d", len(names))
+	}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if name == "" || sanitizeFolderName(name) != name {
+			return fmt.Errorf("month name %q cannot be used in a folder name", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("month name %q is given twice", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// shortMonthName abbreviates the name of month, used for "Jan" in layouts,
+// to its first three letters, or more where that is needed to tell it from
+// the other months, as for the French Juin and Juillet
+func shortMonthName(month time.Month) string {
+	name := monthNames[month-1]
+	for n := 3; n < utf8.RuneCountInString(name); n++ {
+		short := runePrefix(name, n)
+		unique := true
+		for i, other := range monthNames {
+			if time.Month(i+1) != month && runePrefix(other, n) == short {
+				unique = false
+			}
+		}
+		if unique {
+			return short
+		}
+	}
+	return name
+}
+
+// runePrefix returns the first n letters of s
+func runePrefix(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+// parseMonthLayout parses a Go time layout with month names from monthNames
+func parseMonthLayout(layout, value string) (time.Time, error) {
+	if monthNames == nil {
+		return time.ParseInLocation(layout, value, time.Local)
+	}
+	var full, short []string
+	for month := time.January; month <= time.December; month++ {
+		full = append(full, monthNames[month-1], month.String())
+		short = append(short, shortMonthName(month), month.String()[:3])
+	}
+	// Full names are tried first, so they are not taken for an abbreviation,
+	// but a name may be its own abbreviation, as the French Juin is
+	date, err := time.ParseInLocation(layout, strings.NewReplacer(append(full, short...)...).Replace(value), time.Local)
+	if err != nil {
+		if shortDate, shortErr := time.ParseInLocation(layout, strings.NewReplacer(append(short, full...)...).Replace(value), time.Local); shortErr == nil {
+			return shortDate, nil
+		}
+	}
+	return date, err
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// XMP namespaces of Google motion photos, also written by Samsung phones
+const (
+	nsGCamera   = "http://ns.google.com/photos/1.0/camera/"
+	nsContainer = "http://ns.google.com/photos/1.0/container/"
+	nsItem      = "http://ns.google.com/photos/1.0/container/item/"
+)
+
+// motionXMPScan bounds how much of the start of a JPEG is searched for the
+// XMP packet describing a motion photo; APP1 segments come first
+const motionXMPScan = 256 << 10
+
+// maxMotionPhotoScan bounds how much of a JPEG with a Samsung trailer is
+// searched for the embedded video
+const maxMotionPhotoScan = 64 << 20
+
+// samsungMotionMarker precedes the video in the trailer of Samsung motion
+// photos without Google XMP
+var samsungMotionMarker = []byte("MotionPhoto_Data")
+
+// motionPhoto locates the parts of a JPEG with an embedded video
+type motionPhoto struct {
+	// stillEnd is where the JPEG image ends, after its end-of-image marker
+	stillEnd int64
+	// videoOffset and videoLength locate the MP4 video
+	videoOffset int64
+	videoLength int64
+}
+
+// findMotionPhoto reports whether a file is a Google or Samsung motion
+// photo, a JPEG followed by a short MP4 video, and where its parts are
+func findMotionPhoto(path string) (motionPhoto, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return motionPhoto{}, false
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return motionPhoto{}, false
+	}
+	return locateMotionPhoto(file, info.Size())
+}
+
+// locateMotionPhoto finds the video of a motion photo from the XMP
+// directory of its items or, failing that, the Samsung trailer marker
+func locateMotionPhoto(r io.ReaderAt, size int64) (motionPhoto, bool) {
+	head := make([]byte, min(size, motionXMPScan))
+	if _, err := r.ReadAt(head, 0); err != nil || !bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}) {
+		return motionPhoto{}, false
+	}
+	var offset int64
+	if fromEnd := motionVideoFromEnd(extractXMPPacket(head)); fromEnd > 0 && fromEnd < size {
+		offset = size - fromEnd
+	} else {
+		tail := make([]byte, 4)
+		if _, err := r.ReadAt(tail, size-4); err != nil || string(tail) != "SEFT" || size > maxMotionPhotoScan {
+			return motionPhoto{}, false
+		}
+		data := make([]byte, size)
+		if _, err := r.ReadAt(data, 0); err != nil {
+			return motionPhoto{}, false
+		}
+		i := bytes.LastIndex(data, samsungMotionMarker)
+		if i < 0 {
+			return motionPhoto{}, false
+		}
+		offset = int64(i + len(samsungMotionMarker))
+	}
+
+	length := mp4Length(r, offset, size)
+	if length == 0 {
+		return motionPhoto{}, false
+	}
+	// The still ends at the last end-of-image marker before the video; data
+	// in between is a Samsung trailer or padding
+	mp := motionPhoto{stillEnd: offset, videoOffset: offset, videoLength: length}
+	gap := make([]byte, min(offset, 64<<10))
+	if _, err := r.ReadAt(gap, offset-int64(len(gap))); err == nil {
+		if i := bytes.LastIndex(gap, []byte{0xFF, 0xD9}); i >= 0 {
+			mp.stillEnd = offset - int64(len(gap)) + int64(i) + 2
+		}
+	}
+	return mp, true
+}
+
+// motionVideoFromEnd returns how far from the end of the file the video of
+// a motion photo starts, from the Container directory of current motion
+// photos or the MicroVideoOffset of older ones, or 0 if the XMP describes
+// none
+func motionVideoFromEnd(packet []byte) int64 {
+	if packet == nil {
+		return 0
+	}
+	motion := false
+	var microOffset int64
+	var semantics []string
+	var lengths []int64
+	decoder := xml.NewDecoder(bytes.NewReader(packet))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		isItem := start.Name.Space == nsContainer && start.Name.Local == "Item"
+		semantic, length := "", int64(0)
+		for _, attr := range start.Attr {
+			switch {
+			case attr.Name.Space == nsGCamera && (attr.Name.Local == "MotionPhoto" || attr.Name.Local == "MicroVideo"):
+				motion = motion || strings.TrimSpace(attr.Value) == "1"
+			case attr.Name.Space == nsGCamera && attr.Name.Local == "MicroVideoOffset":
+				microOffset, _ = strconv.ParseInt(strings.TrimSpace(attr.Value), 10, 64)
+			case isItem && attr.Name.Space == nsItem && attr.Name.Local == "Semantic":
+				semantic = attr.Value
+			case isItem && attr.Name.Space == nsItem && attr.Name.Local == "Length":
+				length, _ = strconv.ParseInt(strings.TrimSpace(attr.Value), 10, 64)
+			}
+		}
+		if isItem {
+			semantics = append(semantics, semantic)
+			lengths = append(lengths, length)
+		}
+	}
+	if !motion {
+		return 0
+	}
+	// Items are stored one after another behind the primary image, so the
+	// video starts the length of it and every later item before the end
+	for i, semantic := range semantics {
+		if semantic != "MotionPhoto" {
+			continue
+		}
+		var fromEnd int64
+		for _, length := range lengths[i:] {
+			fromEnd += length
+		}
+		return fromEnd
+	}
+	return microOffset
+}
+
+// mp4Length returns the length of the MP4 file starting at offset, walking
+// its top-level boxes up to the first that is not one, or 0 if no MP4 file
+// starts there
+func mp4Length(r io.ReaderAt, offset, size int64) int64 {
+	head := make([]byte, 16)
+	pos := offset
+	for pos+8 <= size {
+		if _, err := r.ReadAt(head[:8], pos); err != nil {
+			break
+		}
+		kind := head[4:8]
+		if pos == offset && string(kind) != "ftyp" {
+			return 0
+		}
+		if !isBoxType(kind) {
+			break
+		}
+		boxSize := int64(binary.BigEndian.Uint32(head[0:4]))
+		switch boxSize {
+		case 0:
+			boxSize = size - pos
+		case 1:
+			if _, err := r.ReadAt(head[8:16], pos+8); err != nil {
+				return pos - offset
+			}
+			boxSize = int64(binary.BigEndian.Uint64(head[8:16]))
+		}
+		if boxSize < 8 || pos+boxSize > size {
+			break
+		}
+		pos += boxSize
+	}
+	return pos - offset
+}
+
+// isBoxType reports whether four bytes can be the type of an MP4 box
+func isBoxType(kind []byte) bool {
+	for _, c := range kind {
+		if c < 0x20 || c > 0x7E {
+			return false
+		}
+	}
+	return true
+}
+
+// motionPhotoStill returns the JPEG image of a motion photo without its
+// video, marked as a plain photo so viewers do not look for the video
+func motionPhotoStill(data []byte, mp motionPhoto) []byte {
+	still := append([]byte(nil), data[:mp.stillEnd]...)
+	for _, property := range []string{"MotionPhoto", "MicroVideo"} {
+		still = bytes.ReplaceAll(still, []byte("GCamera:"+property+`="1"`), []byte("GCamera:"+property+`="0"`))
+	}
+	return still
+}
+
+// splitMotionPhoto replaces a motion photo with its still image and writes
+// its video next to it under the same name with an .mp4 extension. It
+// returns the path of the video, or "" if the file is no motion photo.
+func splitMotionPhoto(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	mp, ok := locateMotionPhoto(bytes.NewReader(data), int64(len(data)))
+	if !ok {
+		return "", nil
+	}
+	videoPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".mp4"
+	if _, err := os.Stat(videoPath); err == nil {
+		return "", fmt.Errorf("%s already exists", videoPath)
+	}
+	if err := replaceFile(videoPath, data[mp.videoOffset:mp.videoOffset+mp.videoLength]); err != nil {
+		return "", err
+	}
+	if err := replaceFile(path, motionPhotoStill(data, mp)); err != nil {
+		os.Remove(videoPath)
+		return "", err
+	}
+	return videoPath, nil
+}
+
+// hashMotionPhotoStill returns the SHA-256 of the still that splitting a
+// motion photo leaves
+func hashMotionPhotoStill(fsys fileSystem, path string, mp motionPhoto) (string, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) < mp.stillEnd {
+		return "", fmt.Errorf("%s changed while reading", path)
+	}
+	sum := sha256.Sum256(motionPhotoStill(data, mp))
+	return hex.EncodeToString(sum[:]), nil
+}
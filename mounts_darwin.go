@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// listMounts returns the volumes mounted below /Volumes, where macOS mounts
+// memory cards and other removable disks
+func listMounts() ([]string, error) {
+	entries, err := os.ReadDir("/Volumes")
+	if err != nil {
+		return nil, err
+	}
+	var mounts []string
+	for _, entry := range entries {
+		mounts = append(mounts, filepath.Join("/Volumes", entry.Name()))
+	}
+	return mounts, nil
+}
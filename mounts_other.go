@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// listMounts is not supported on this platform
+func listMounts() ([]string, error) {
+	return nil, errors.New("detecting mounted volumes is not supported on this platform")
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Payload formats accepted by -notify-format
+const (
+	notifyGeneric = "generic"
+	notifySlack   = "slack"
+	notifyDiscord = "discord"
+	notifyNtfy    = "ntfy"
+)
+
+// Events a notification is sent for
+const (
+	eventRunFinished   = "run_finished"
+	eventBatchFinished = "batch_finished"
+)
+
+// notifyTimeout bounds a notification request, so an unreachable endpoint
+// never holds up a run
+const notifyTimeout = 10 * time.Second
+
+// notifier posts a summary to a webhook when a run, or a batch of files in
+// watch mode, finishes
+type notifier struct {
+	url    string
+	format string
+	client *http.Client
+}
+
+// notification is the payload of a generic webhook: the run result with the
+// event that triggered it
+type notification struct {
+	Event   string `json:"event"`
+	Machine string `json:"machine"`
+	runResult
+}
+
+// validateNotifyFormat checks the -notify-format flag value
+func validateNotifyFormat(format string) error {
+	switch format {
+	case notifyGeneric, notifySlack, notifyDiscord, notifyNtfy:
+		return nil
+	default:
+		return fmt.Errorf("invalid -notify-format %q, expected 'generic', 'slack', 'discord', or 'ntfy'", format)
+	}
+}
+
+// newNotifier returns a notifier for a webhook URL, or nil if url is empty
+func newNotifier(url, format string) *notifier {
+	if url == "" {
+		return nil
+	}
+	return &notifier{url: url, format: format, client: &http.Client{Timeout: notifyTimeout}}
+}
+
+// send posts a notification. Failures are logged and otherwise ignored; a
+// run is never failed because its notification could not be delivered.
+func (n *notifier) send(event string, result runResult) {
+	if n == nil {
+		return
+	}
+	note := notification{Event: event, Machine: machineName(), runResult: result}
+	var body []byte
+	contentType := "application/json"
+	var err error
+	switch n.format {
+	case notifySlack:
+		body, err = json.Marshal(map[string]string{"text": note.message(true)})
+	case notifyDiscord:
+		body, err = json.Marshal(map[string]string{"content": note.message(true)})
+	case notifyNtfy:
+		body, contentType = []byte(note.message(false)), "text/plain; charset=utf-8"
+	default:
+		body, err = json.Marshal(note)
+	}
+	if err != nil {
+		slog.Warn("Could not encode notification", "error", err)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("Could not send notification", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", contentType)
+	if n.format == notifyNtfy {
+		req.Header.Set("Title", note.title())
+		if note.Status != "ok" {
+			req.Header.Set("Priority", "high")
+			req.Header.Set("Tags", "warning")
+		}
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		slog.Warn("Could not send notification", "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		slog.Warn("Notification was rejected", "status", resp.Status)
+		return
+	}
+	slog.Debug("Sent notification", "event", event)
+}
+
+// title is a one-line headline for chat and push notifications
+func (n notification) title() string {
+	what := "GoPicSort run"
+	if n.Event == eventBatchFinished {
+		what = "GoPicSort watch batch"
+	}
+	switch n.Status {
+	case "failed":
+		return fmt.Sprintf("%s failed on %s", what, n.Machine)
+	case "partial":
+		return fmt.Sprintf("%s on %s finished with errors", what, n.Machine)
+	default:
+		return fmt.Sprintf("%s on %s finished", what, n.Machine)
+	}
+}
+
+// message is the text of chat and push notifications: the headline unless
+// it is sent separately, the count of each outcome, and the error that ended
+// the run, if any
+func (n notification) message(withTitle bool) string {
+	var lines []string
+	if withTitle {
+		lines = append(lines, n.title())
+	}
+	var counts []string
+	for _, outcome := range summaryOutcomes() {
+		if n.Counts[outcome] > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", n.Counts[outcome], strings.ReplaceAll(outcome, "_", " ")))
+		}
+	}
+	if len(counts) == 0 {
+		counts = append(counts, "no files")
+	}
+	lines = append(lines, strings.Join(counts, ", "))
+	if n.Dest != "" {
+		lines = append(lines, "Destination: "+n.Dest)
+	}
+	if n.Error != "" {
+		lines = append(lines, "Error: "+n.Error)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// notifyBatch sends a notification for the files processed by one poll in
+// watch mode, given the counts and number of problems before it. Polls that
+// found nothing new stay quiet.
+func (s *sorter) notifyBatch(before map[string]int, problems int) {
+	if s.notify == nil {
+		return
+	}
+	result := s.result(nil)
+	result.Counts = make(map[string]int)
+	processed, failed := 0, 0
+	for outcome, count := range s.counts {
+		if n := count - before[outcome]; n > 0 {
+			result.Counts[outcome] = n
+			processed += n
+			if isFailure(outcome) {
+				failed += n
+			}
+		}
+	}
+	if processed == 0 {
+		return
+	}
+	result.Problems = s.problems[problems:]
+	result.Status, result.ExitCode = "ok", exitOK
+	if failed > 0 {
+		result.Status, result.ExitCode = "partial", exitPartial
+	}
+	s.notify.send(eventBatchFinished, result)
+}
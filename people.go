@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// linkPeopleView creates a symlink to destPath in a per-person folder below
+// viewDir for every person tagged in the photo
+func linkPeopleView(viewDir, destPath string, people []string) error {
+	target, err := filepath.Abs(destPath)
+	if err != nil {
+		return err
+	}
+	for _, person := range people {
+		personDir := filepath.Join(viewDir, sanitizeFolderName(person))
+		if err := os.MkdirAll(personDir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %v", personDir, err)
+		}
+		link := filepath.Join(personDir, filepath.Base(destPath))
+		if _, err := os.Lstat(link); err == nil {
+			continue
+		}
+		if err := os.Symlink(target, link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// plainOutput is set by -plain; output that would use terminal control
+// characters or colors must check it
+var plainOutput bool
+
+// plainHandler writes each log event as one plain sentence, such as
+// "Warning: Could not get date. path /a.jpg, error EOF", for screen readers
+// and simple log processing. It never writes timestamps, escape sequences,
+// or line breaks inside an event.
+type plainHandler struct {
+	out   io.Writer
+	mu    *sync.Mutex
+	level slog.Leveler
+	attrs []slog.Attr
+	group string
+}
+
+func newPlainHandler(out io.Writer, level slog.Leveler) *plainHandler {
+	return &plainHandler{out: out, mu: new(sync.Mutex), level: level}
+}
+
+func (h *plainHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *plainHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	switch {
+	case r.Level >= slog.LevelError:
+		b.WriteString("Error: ")
+	case r.Level >= slog.LevelWarn:
+		b.WriteString("Warning: ")
+	case r.Level < slog.LevelInfo:
+		b.WriteString("Debug: ")
+	}
+	message := plainText(r.Message)
+	b.WriteString(message)
+
+	var fields []string
+	for _, a := range h.attrs {
+		fields = appendPlainAttr(fields, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		fields = appendPlainAttr(fields, h.group, a)
+		return true
+	})
+	if len(fields) > 0 {
+		if !strings.HasSuffix(message, ".") && !strings.HasSuffix(message, "!") {
+			b.WriteByte('.')
+		}
+		b.WriteString(" " + strings.Join(fields, ", "))
+	}
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.out, b.String())
+	return err
+}
+
+func (h *plainHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		if h.group != "" {
+			a.Key = h.group + "." + a.Key
+		}
+		clone.attrs = append(clone.attrs, a)
+	}
+	return &clone
+}
+
+func (h *plainHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	if clone.group != "" {
+		name = clone.group + "." + name
+	}
+	clone.group = name
+	return &clone
+}
+
+// appendPlainAttr formats an attribute as "key value", flattening groups
+func appendPlainAttr(fields []string, prefix string, a slog.Attr) []string {
+	a.Value = a.Value.Resolve()
+	key := a.Key
+	if prefix != "" && key != "" {
+		key = prefix + "." + key
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		for _, member := range a.Value.Group() {
+			fields = appendPlainAttr(fields, key, member)
+		}
+		return fields
+	}
+	if key == "" {
+		return fields
+	}
+	return append(fields, key+" "+plainText(a.Value.String()))
+}
+
+// plainText quotes values containing control characters so an event always
+// stays on one line
+func plainText(s string) string {
+	for _, c := range s {
+		if c < ' ' || c == 0x7f {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
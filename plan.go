@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// sortPlanVersion is the format version of plan files
+const sortPlanVersion = 1
+
+// Operations of a planned transfer
+const (
+	opCopy   = "copy"
+	opMove   = "move"
+	opLink   = "link"
+	opUpload = "upload" // not planned, only reported by -output ndjson
+)
+
+// planIncompatible lists the sort flags with effects a plan cannot record
+var planIncompatible = []string{
+	"watch", "tether", "upload-addr", "metrics-addr", "control-addr", "http", "quarantine", "recover",
+	"write-exif", "people-view", "backup", "lock", "snapshot", "retain-until",
+	"resumable", "prune-empty", "touch-exif", "report", "previews",
+	"convert", "strip-private", "view", "manifest", "split-motion-photos",
+}
+
+// sortPlan is written by "plan" and executed by "apply": every transfer a
+// sort run would make, with the state of each source file when the plan
+// was made, so the plan can be reviewed and diffed before anything changes
+type sortPlan struct {
+	Version int          `json:"version"`
+	Created time.Time    `json:"created"`
+	Machine string       `json:"machine"`
+	Args    []string     `json:"args"`
+	Sources []string     `json:"sources"`
+	Dest    string       `json:"dest"`
+	Actions []planAction `json:"actions"`
+}
+
+// planAction is one planned transfer. Paths are absolute; Dest may lie
+// outside the library for screenshots and overflow files.
+type planAction struct {
+	Op       string    `json:"op"`
+	LinkMode string    `json:"link_mode,omitempty"`
+	Source   string    `json:"source"`
+	Dest     string    `json:"dest"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+}
+
+// writePlan walks the sources like a sort run, recording the transfers in a
+// plan instead of making them, and writes the plan to path, or to standard
+// output if path is empty
+func (s *sorter) writePlan(path string) error {
+	// Absolute paths let the plan be applied from any folder
+	s.plan = &sortPlan{
+		Version: sortPlanVersion,
+		Created: time.Now(),
+		Machine: machineName(),
+		Args:    os.Args[1:],
+	}
+	var err error
+	if s.plan.Dest, err = filepath.Abs(s.destDir); err != nil {
+		return err
+	}
+	for _, dir := range s.sourceDirs {
+		source, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		s.plan.Sources = append(s.plan.Sources, source)
+	}
+	if s.eventGap > 0 {
+		if err := s.detectEvents(); err != nil {
+			return err
+		}
+	}
+	if s.sequenceGap > 0 {
+		if err := s.detectSequences(); err != nil {
+			return err
+		}
+	}
+	if err := s.walkSource(s.sortFile); err != nil {
+		return err
+	}
+	s.printSummary()
+	if err := s.cache.save(s.sourceDirs); err != nil {
+		slog.Warn("Could not save metadata cache", "path", s.cache.path, "error", err)
+	}
+
+	// Indented JSON keeps plans readable and diffable
+	data, err := json.MarshalIndent(s.plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	slog.Info("Wrote plan, review it and run 'gopicsort apply'", "path", path, "actions", len(s.plan.Actions))
+	return nil
+}
+
+// planTransfer records the transfer of a file to destPath in the plan. The
+// same files are skipped as in a sort run: those already at the destination
+// and those whose name another source file was planned to take.
+func (s *sorter) planTransfer(path, destPath string, info os.FileInfo) error {
+	if _, err := os.Stat(destPath); err == nil {
+		slog.Debug("Skipping: file already exists at destination", "path", destPath)
+		s.tally(outcomeExisting, path)
+		return nil
+	}
+	if other, ok := s.sortedTo[destPath]; ok && other != path {
+		slog.Warn("Skipping: another source file was already planned to this name", "path", path, "other", other, "dest", destPath)
+		s.tally(outcomeConflict, path)
+		return nil
+	}
+
+	source, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	dest, err := filepath.Abs(destPath)
+	if err != nil {
+		return err
+	}
+	action := planAction{Op: opCopy, Source: source, Dest: dest, Size: info.Size(), ModTime: info.ModTime()}
+	switch {
+	case s.moveFiles:
+		action.Op = opMove
+	case s.linkMode != "":
+		action.Op, action.LinkMode = opLink, s.linkMode
+	}
+	s.plan.Actions = append(s.plan.Actions, action)
+	if srt := srtSidecar(path); srt != "" {
+		if err := s.planSidecar(action, srt); err != nil {
+			return err
+		}
+	}
+	slog.Info("Planned", "op", action.Op, "source", path, "dest", destPath)
+	events.emit(streamEvent{Event: streamPlanned, Path: path, Size: info.Size(), Op: action.Op, Dest: dest})
+
+	if s.sortedTo == nil {
+		s.sortedTo = make(map[string]string)
+	}
+	s.sortedTo[destPath] = path
+	// Later files with the same contents are duplicates of the planned one
+	if s.index != nil {
+		s.index.add(path, info.Size())
+	}
+	s.tally(outcomePlanned, path)
+	return nil
+}
+
+// planSidecar records the transfer of a sidecar next to its planned file
+func (s *sorter) planSidecar(action planAction, sidecar string) error {
+	info, err := os.Stat(sidecar)
+	if err != nil {
+		return err
+	}
+	source, err := filepath.Abs(sidecar)
+	if err != nil {
+		return err
+	}
+	action.Source, action.Dest = source, sidecarDest(sidecar, action.Dest)
+	action.Size, action.ModTime = info.Size(), info.ModTime()
+	if action.Op == opLink {
+		action.Op, action.LinkMode = opCopy, ""
+	}
+	s.plan.Actions = append(s.plan.Actions, action)
+	return nil
+}
+
+// runApply implements the "apply" subcommand, which executes the transfers
+// of a plan written by "plan" and nothing else. Source files that changed
+// since the plan was made, and targets that appeared in the meantime, are
+// skipped and reported.
+func runApply(args []string) {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	onConflict := fs.String("on-conflict", conflictFail, "What to do when another run holds the destination's lock: 'fail', 'wait', or 'warn' to run anyway")
+	logOpts := addLogFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s apply [options] PLAN\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	positional := parseInterspersed(fs, args)
+	if len(positional) != 1 {
+		fs.Usage()
+		os.Exit(exitFatal)
+	}
+	if err := logOpts.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitFatal)
+	}
+	if err := validateConflictPolicy(*onConflict); err != nil {
+		fatal(err.Error())
+	}
+
+	data, err := os.ReadFile(positional[0])
+	if err != nil {
+		fatal("Failed to read plan", "error", err)
+	}
+	var plan sortPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		fatal("Invalid plan", "path", positional[0], "error", err)
+	}
+	if plan.Version != sortPlanVersion {
+		fatal("Unsupported plan version", "version", plan.Version)
+	}
+	if plan.Dest == "" {
+		fatal("Invalid plan, it has no destination", "path", positional[0])
+	}
+
+	s, err := newSorter("apply", plan.Dest, plan.Sources...)
+	if err != nil {
+		fatal("Failed to start run", "error", err)
+	}
+	release, err := s.claimSession(*onConflict)
+	if err != nil {
+		fatal("Failed to start run", "error", err)
+	}
+	slog.Info("Applying plan", "path", positional[0], "created", plan.Created.Format(time.RFC3339), "actions", len(plan.Actions))
+	for _, action := range plan.Actions {
+		if err := s.applyAction(action); err != nil {
+			slog.Error("Could not apply", "op", action.Op, "source", action.Source, "error", err)
+			s.tally(outcomeFailed, action.Source)
+		}
+	}
+	s.finish()
+	release()
+
+	if code := s.exitCode(nil); code != exitOK {
+		slog.Error("Plan applied with errors", "failed", s.failures())
+		os.Exit(code)
+	}
+	slog.Info("Plan applied successfully")
+}
+
+// applyAction performs one planned transfer after checking that the source
+// is as it was when planned and the target is still free
+func (s *sorter) applyAction(action planAction) error {
+	info, err := os.Stat(action.Source)
+	if err != nil {
+		return err
+	}
+	if info.Size() != action.Size || !info.ModTime().Equal(action.ModTime) {
+		return fmt.Errorf("source changed since the plan was made")
+	}
+	if _, err := os.Stat(action.Dest); err == nil {
+		slog.Warn("Skipping: target appeared since the plan was made", "source", action.Source, "dest", action.Dest)
+		s.tally(outcomeConflict, action.Source)
+		return nil
+	}
+	dir := filepath.Dir(action.Dest)
+	if err := s.prepareMonthFolder(dir); err != nil {
+		return err
+	}
+	if err := s.fsys.MkdirAll(longPath(dir), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %v", dir, err)
+	}
+
+	var done string
+	switch action.Op {
+	case opCopy:
+		err, done = copyFile(s.fsys, action.Source, action.Dest), "Copied"
+	case opMove:
+		err, done = moveFile(s.fsys, action.Source, action.Dest), "Moved"
+		s.movedFrom[filepath.Dir(action.Source)] = true
+	case opLink:
+		if err := validateLinkMode(action.LinkMode); err != nil {
+			return err
+		}
+		err, done = linkFile(s.fsys, action.Source, action.Dest, action.LinkMode), "Linked"
+	default:
+		return fmt.Errorf("unknown operation %q", action.Op)
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info(done, "source", action.Source, "dest", action.Dest)
+	s.transfers = append(s.transfers, transfer{source: action.Source, dest: action.Dest})
+	s.tally(outcomeSorted, action.Source)
+	return nil
+}
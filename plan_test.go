@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// applyPlan applies the plan at path like the apply subcommand
+func applyPlan(t *testing.T, path string) *sorter {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plan sortPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		t.Fatal(err)
+	}
+	s, err := newSorter("apply", plan.Dest, plan.Sources...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range plan.Actions {
+		if err := s.applyAction(action); err != nil {
+			s.tally(outcomeFailed, action.Source)
+		}
+	}
+	return s
+}
+
+func TestPlanThenApply(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+	writeTestFile(t, filepath.Join(src, "IMG_20210506_070809.jpg"), []byte("may"))
+	writeTestFile(t, filepath.Join(src, "IMG_20210507_070809.jpg"), []byte("changed later"))
+	writeTestFile(t, filepath.Join(src, "IMG_20221224_180000.jpg"), []byte("december"))
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+
+	s := newTestSorter(t, localFS, dest, src)
+	s.dateSources = []string{dateSourceFilename}
+	if err := s.writePlan(planPath); err != nil {
+		t.Fatal(err)
+	}
+	if got := listFiles(t, dest); len(got) != 0 {
+		t.Fatalf("planning wrote %v", got)
+	}
+	if len(s.plan.Actions) != 3 {
+		t.Fatalf("plan has %d actions, want 3", len(s.plan.Actions))
+	}
+
+	// A source changed and a target taken since planning are both left alone
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(src, "IMG_20210507_070809.jpg"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dest, "2022", "12", "IMG_20221224_180000.jpg"), []byte("other"))
+
+	applied := applyPlan(t, planPath)
+	if got := strings.Join(listFiles(t, dest), " "); got != "2021/05/IMG_20210506_070809.jpg 2022/12/IMG_20221224_180000.jpg" {
+		t.Errorf("library holds %s", got)
+	}
+	if applied.counts[outcomeSorted] != 1 || applied.counts[outcomeConflict] != 1 || applied.failures() != 2 {
+		t.Errorf("counts are %v, want one sorted, one conflict, and one failed file", applied.counts)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Disk space check modes accepted by -space-check
+const (
+	spaceCheckAbort = "abort"
+	spaceCheckWarn  = "warn"
+	spaceCheckOff   = "off"
+)
+
+// validateSpaceCheck checks the -space-check flag value
+func validateSpaceCheck(mode string) error {
+	switch mode {
+	case spaceCheckAbort, spaceCheckWarn, spaceCheckOff:
+		return nil
+	default:
+		return fmt.Errorf("invalid -space-check mode %q, expected 'abort', 'warn', or 'off'", mode)
+	}
+}
+
+// checkSpace adds up the files the run would write to the destination and
+// compares the total with the free space there, so a run does not stop
+// halfway with a full disk. Moves are renames and need no space, and links
+// only when they fall back to copying across file systems. Files already in
+// the library are counted too, so the total is an upper bound.
+func (s *sorter) checkSpace() error {
+	if s.spaceCheck == "" || s.spaceCheck == spaceCheckOff || s.store != nil || s.moveFiles {
+		return nil
+	}
+	free, ok := freeSpace(s.destDir)
+	if !ok {
+		slog.Debug("Free space of the destination is unknown, not checking it", "path", s.destDir)
+		return nil
+	}
+
+	var needed int64
+	files := 0
+	err := s.walkSource(func(path string, info os.FileInfo) error {
+		if s.linkMode != linkNone && sameFileSystem(path, s.destDir) {
+			return nil
+		}
+		needed += info.Size()
+		files++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if uint64(needed) <= free {
+		slog.Debug("Destination has enough free space", "needed", formatSize(needed), "free", formatSize(int64(free)), "files", files)
+		return nil
+	}
+	if s.spaceCheck == spaceCheckWarn {
+		slog.Warn("Destination may not have enough free space", "needed", formatSize(needed), "free", formatSize(int64(free)), "files", files)
+		return nil
+	}
+	return fmt.Errorf("not enough free space on the destination: %s needed for %d files, %s free; free up space, or pass -space-check warn if many of the files are already in the library", formatSize(needed), files, formatSize(int64(free)))
+}
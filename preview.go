@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// previewsDirName is the folder at the top of the library holding the
+// previews written by -previews, in the same layout as the library
+const previewsDirName = ".previews"
+
+// defaultPreviewSize is the longest side of previews unless -preview-size
+// says otherwise
+const defaultPreviewSize = 1024
+
+// maxEmbeddedScan bounds how much of a file is searched for embedded JPEG
+// previews
+const maxEmbeddedScan = 256 << 20
+
+// previewPath returns where the preview of a library file goes: the file's
+// path below the previews folder with ".jpg" appended, so IMG_0001.CR2 and
+// IMG_0001.JPG get previews of their own. Files outside the library, such
+// as -overflow files, have no preview.
+func previewPath(destDir, path string) (string, bool) {
+	rel, err := filepath.Rel(destDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.Join(destDir, previewsDirName, rel+".jpg"), true
+}
+
+// writePreview writes a downscaled JPEG preview of a sorted file into the
+// previews folder, unless one exists already. Failures are logged, as the
+// file itself was sorted.
+func (s *sorter) writePreview(destPath string) {
+	target, ok := previewPath(s.destDir, destPath)
+	if !ok {
+		return
+	}
+	if _, err := os.Stat(target); err == nil {
+		return
+	}
+	preview, err := s.sandbox.preview(destPath, s.previewSize)
+	if err != nil {
+		slog.Debug("No preview", "path", destPath, "error", err)
+		return
+	}
+	if err := os.MkdirAll(longPath(filepath.Dir(target)), 0755); err != nil {
+		slog.Warn("Could not create preview folder", "path", filepath.Dir(target), "error", err)
+		return
+	}
+	if err := os.WriteFile(longPath(target), preview, 0644); err != nil {
+		slog.Warn("Could not write preview", "path", target, "error", err)
+		return
+	}
+	slog.Debug("Wrote preview", "path", target)
+}
+
+// previewJPEG returns a JPEG preview of a file whose longest side is at
+// most size pixels
+func previewJPEG(path string, size int) ([]byte, error) {
+	img, err := previewImage(path)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleDown(img, size), &jpeg.Options{Quality: 85}); err != nil {
+		return nil, fmt.Errorf("could not encode preview: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// previewImage decodes the image a preview is made from: the file itself
+// for JPEG, PNG, and GIF, and the largest JPEG embedded in it for RAW files
+// and other formats that carry a full-size preview
+func previewImage(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if img, _, err := image.Decode(file); err == nil {
+		return img, nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxEmbeddedScan))
+	if err != nil {
+		return nil, err
+	}
+	if preview := largestEmbeddedJPEG(data); preview != nil {
+		return jpeg.Decode(bytes.NewReader(preview))
+	}
+	return nil, fmt.Errorf("no decodable image or embedded preview")
+}
+
+// largestEmbeddedJPEG finds the JPEG streams embedded in a file, as RAW
+// formats keep a thumbnail and one or more larger previews, and returns the
+// one with the most pixels, or nil if there is none
+func largestEmbeddedJPEG(data []byte) []byte {
+	var best []byte
+	bestPixels := 0
+	for off := 0; ; {
+		i := bytes.Index(data[off:], []byte{0xff, 0xd8, 0xff})
+		if i < 0 {
+			return best
+		}
+		start := off + i
+		off = start + 3
+		config, err := jpeg.DecodeConfig(bytes.NewReader(data[start:]))
+		if err != nil || config.Width*config.Height <= bestPixels {
+			continue
+		}
+		best, bestPixels = data[start:], config.Width*config.Height
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// EXIF tags removed by -strip-private: where a photo was taken, who owns the
+// camera, and serial numbers that tie photos to one camera or lens. Maker
+// notes are removed too, as most cameras record their serial number there.
+const (
+	tagGPSInfo         = 0x8825
+	tagMakerNote       = 0x927C
+	tagCameraOwnerName = 0xA430
+	tagBodySerial      = 0xA431
+	tagLensSerial      = 0xA435
+)
+
+var privateTags = map[uint16]bool{
+	tagGPSInfo:         true,
+	tagMakerNote:       true,
+	tagCameraOwnerName: true,
+	tagBodySerial:      true,
+	tagLensSerial:      true,
+}
+
+// xmpPrivateProperties are the XMP properties whose presence makes
+// -strip-private drop an XMP packet, which would otherwise repeat the
+// location or serial numbers
+var xmpPrivateProperties = [][]byte{
+	[]byte("GPSLatitude"), []byte("GPSLongitude"), []byte("SerialNumber"), []byte("OwnerName"),
+}
+
+// tiffTypeSizes is the size in bytes of one value of each TIFF field type
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// stripPrivateMetadata removes the location, owner, and serial numbers from
+// a sorted JPEG file, replacing it atomically. Removed entries are dropped
+// from their directories and the values they pointed to are zeroed, so the
+// information is gone from the file rather than just hidden.
+func stripPrivateMetadata(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return fmt.Errorf("only JPEG files can be stripped of private metadata")
+	}
+	tiff, err := findExifTIFF(data)
+	if err != nil {
+		return err
+	}
+	if tiff != nil {
+		if err := stripExifTags(tiff, privateTags); err != nil {
+			return err
+		}
+	}
+	return replaceFile(path, dropPrivateXMP(data))
+}
+
+// stripExifTags removes the tags of IFD0 and the EXIF IFD in a TIFF
+// structure that are in remove, in place
+func stripExifTags(tiff []byte, remove map[uint16]bool) error {
+	if len(tiff) < 8 {
+		return fmt.Errorf("EXIF data too short")
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return fmt.Errorf("invalid EXIF byte order")
+	}
+
+	offsets := []int{int(order.Uint32(tiff[4:]))}
+	for len(offsets) > 0 {
+		offset := offsets[0]
+		offsets = offsets[1:]
+		if offset+2 > len(tiff) {
+			return fmt.Errorf("EXIF directory out of range")
+		}
+		count := int(order.Uint16(tiff[offset:]))
+		end := offset + 2 + count*12
+		if end+4 > len(tiff) {
+			return fmt.Errorf("EXIF directory out of range")
+		}
+		kept := 0
+		for i := 0; i < count; i++ {
+			entry := tiff[offset+2+i*12 : offset+2+(i+1)*12]
+			tag := order.Uint16(entry)
+			switch {
+			case tag == tagExifIFD:
+				offsets = append(offsets, int(order.Uint32(entry[8:])))
+			case remove[tag]:
+				if tag == tagGPSInfo {
+					zeroIFD(tiff, order, int(order.Uint32(entry[8:])))
+				} else {
+					zeroValue(tiff, order, entry)
+				}
+				continue
+			}
+			// Kept entries move up over the removed ones
+			copy(tiff[offset+2+kept*12:], entry)
+			kept++
+		}
+		if kept == count {
+			continue
+		}
+		// The offset of the next directory follows the entries
+		newEnd := offset + 2 + kept*12
+		copy(tiff[newEnd:newEnd+4], tiff[end:end+4])
+		for i := newEnd + 4; i < end+4; i++ {
+			tiff[i] = 0
+		}
+		order.PutUint16(tiff[offset:], uint16(kept))
+	}
+	return nil
+}
+
+// zeroValue zeroes the value of a directory entry stored outside it
+func zeroValue(tiff []byte, order binary.ByteOrder, entry []byte) {
+	size := tiffTypeSizes[order.Uint16(entry[2:])] * int(order.Uint32(entry[4:]))
+	if size <= 4 {
+		return
+	}
+	start := int(order.Uint32(entry[8:]))
+	if start < 8 || start+size > len(tiff) || size < 0 {
+		return
+	}
+	for i := start; i < start+size; i++ {
+		tiff[i] = 0
+	}
+}
+
+// zeroIFD zeroes a directory, such as the GPS IFD, and the values its
+// entries point to
+func zeroIFD(tiff []byte, order binary.ByteOrder, offset int) {
+	if offset < 8 || offset+2 > len(tiff) {
+		return
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	end := offset + 2 + count*12 + 4
+	if end > len(tiff) {
+		return
+	}
+	for i := 0; i < count; i++ {
+		zeroValue(tiff, order, tiff[offset+2+i*12:offset+2+(i+1)*12])
+	}
+	for i := offset; i < end; i++ {
+		tiff[i] = 0
+	}
+}
+
+// dropPrivateXMP returns JPEG data without the XMP packets that carry a
+// location, owner, or serial number
+func dropPrivateXMP(data []byte) []byte {
+	out := append([]byte(nil), data[:2]...)
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if end > len(data) {
+			break
+		}
+		segment := data[pos+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte(nsXMP)) && containsAny(segment, xmpPrivateProperties) {
+			pos = end
+			continue
+		}
+		out = append(out, data[pos:end]...)
+		pos = end
+	}
+	return append(out, data[pos:]...)
+}
+
+// containsAny reports whether data contains any of the needles
+func containsAny(data []byte, needles [][]byte) bool {
+	for _, needle := range needles {
+		if bytes.Contains(data, needle) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package main
+
+// processAlive cannot check processes on this platform, so a lock is only
+// considered stale once its heartbeat stops
+func processAlive(pid int) bool {
+	return true
+}
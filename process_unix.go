@@ -0,0 +1,12 @@
+//go:build linux || darwin
+
+package main
+
+import "syscall"
+
+// processAlive reports whether a process with this ID is running on this
+// machine
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
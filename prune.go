@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// pruneEmptyDirs removes the given directories and their parents up to (but
+// not including) root if they are empty. Non-empty directories are left alone.
+func pruneEmptyDirs(root string, dirs map[string]bool) {
+	root = filepath.Clean(root)
+
+	// Remove the deepest directories first so parents can become empty
+	sorted := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sorted = append(sorted, filepath.Clean(dir))
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return strings.Count(sorted[i], string(filepath.Separator)) > strings.Count(sorted[j], string(filepath.Separator))
+	})
+
+	for _, dir := range sorted {
+		for dir != root && isWithin(root, dir) {
+			// A followed link to a folder is not the link's to remove
+			if isSymlink(dir) {
+				break
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil || len(entries) > 0 {
+				break
+			}
+			if err := os.Remove(dir); err != nil {
+				slog.Warn("Could not remove empty directory", "path", dir, "error", err)
+				break
+			}
+			slog.Info("Removed empty directory", "path", dir)
+			dir = filepath.Dir(dir)
+		}
+	}
+}
+
+// sameDir reports whether two paths name the same existing directory
+func sameDir(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	return err == nil && os.SameFile(infoA, infoB)
+}
+
+// isWithin reports whether path is inside root
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
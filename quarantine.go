@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Validation levels accepted by -validate
+const (
+	validateNone   = ""
+	validateHeader = "header"
+	validateFull   = "full"
+)
+
+// quarantineDirName is the folder inside the destination for unreadable files
+const quarantineDirName = "quarantine"
+
+// validateValidateMode checks the -validate flag value
+func validateValidateMode(mode string) error {
+	switch mode {
+	case validateNone, validateHeader, validateFull:
+		return nil
+	default:
+		return fmt.Errorf("invalid -validate level %q, expected 'header' or 'full'", mode)
+	}
+}
+
+// checkIntegrity decodes an image's header, or with full the whole image,
+// and returns an error describing why it is unreadable. Formats the standard
+// library cannot decode (RAW, HEIC, TIFF) are assumed to be intact.
+func checkIntegrity(path, level string) error {
+	format := sniffFormat(path)
+	switch format {
+	case ".jpg", ".png", ".gif":
+	case "":
+		return fmt.Errorf("unrecognized file contents")
+	default:
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if level != validateFull {
+		if _, _, err := image.DecodeConfig(file); err != nil {
+			return fmt.Errorf("invalid header: %v", err)
+		}
+		return nil
+	}
+
+	if _, _, err := image.Decode(file); err != nil {
+		return fmt.Errorf("decode failed: %v", err)
+	}
+
+	// The JPEG decoder tolerates missing trailing data, so check the end
+	// marker, which a motion photo has before its video
+	if format == ".jpg" {
+		if info, err := file.Stat(); err == nil && info.Size() >= 2 {
+			end := info.Size()
+			if mp, ok := locateMotionPhoto(file, end); ok {
+				end = mp.stillEnd
+			}
+			tail := make([]byte, 2)
+			if _, err := file.ReadAt(tail, end-2); err == nil && !bytes.Equal(tail, []byte{0xFF, 0xD9}) {
+				return fmt.Errorf("truncated: missing end-of-image marker")
+			}
+		}
+	}
+	return nil
+}
+
+// handleCorrupt skips an unreadable file, quarantining it if requested
+func (s *sorter) handleCorrupt(path string, reason error) error {
+	if !s.quarantineCorrupt {
+		slog.Warn("Skipping unreadable file", "path", path, "reason", reason)
+		s.tally(outcomeCorrupt, path)
+		return nil
+	}
+	if err := s.quarantine(path, reason); err != nil {
+		slog.Error("Could not quarantine file", "path", path, "error", err)
+	}
+	return nil
+}
+
+// quarantine moves (with -move) or copies an unreadable file into the
+// quarantine folder and records the reason in the quarantine report
+func (s *sorter) quarantine(path string, reason error) error {
+	dir := filepath.Join(s.destDir, quarantineDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	destPath := filepath.Join(dir, filepath.Base(path))
+	var err error
+	if s.moveFiles {
+		err = moveFile(s.fsys, path, destPath)
+	} else {
+		err = copyFile(s.fsys, path, destPath)
+	}
+	if err != nil {
+		return err
+	}
+	slog.Warn("Quarantined unreadable file", "path", path, "dest", destPath, "reason", reason)
+	s.tally(outcomeQuarantined, path)
+
+	report, err := os.OpenFile(filepath.Join(dir, "report.txt"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer report.Close()
+	_, err = io.WriteString(report, fmt.Sprintf("%s\t%s\t%s\n", time.Now().Format(time.RFC3339), path, reason))
+	return err
+}
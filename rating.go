@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// ratingRejected is the rating Lightroom and Bridge give rejected photos
+const ratingRejected = -1
+
+// tagRating is the EXIF rating tag written by Windows, 0 to 5 stars
+const tagRating = 0x4746
+
+// fileRating returns the star rating of a file, -1 for rejected, from its
+// XMP xmp:Rating or the EXIF rating written by Windows, or false if it has
+// none. x may be nil when the file has no EXIF.
+func fileRating(packet []byte, x *exif.Exif) (int, bool) {
+	if rating, ok := parseXMPRating(packet); ok {
+		return rating, true
+	}
+	if x == nil || x.Tiff == nil || len(x.Tiff.Dirs) == 0 {
+		return 0, false
+	}
+	for _, tag := range x.Tiff.Dirs[0].Tags {
+		if tag.Id == tagRating {
+			if rating, err := tag.Int(0); err == nil && rating >= 0 && rating <= 5 {
+				return rating, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// parseXMPRating reads xmp:Rating from an XMP packet, given as an attribute
+// or an element. Ratings are whole stars; fractions are rounded down.
+func parseXMPRating(packet []byte) (int, bool) {
+	if packet == nil {
+		return 0, false
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(packet))
+	var current xml.Name
+	value := ""
+	for value == "" {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			current = t.Name
+			for _, attr := range t.Attr {
+				if attr.Name.Space == nsXMP && attr.Name.Local == "Rating" {
+					value = strings.TrimSpace(attr.Value)
+				}
+			}
+		case xml.EndElement:
+			current = xml.Name{}
+		case xml.CharData:
+			if current.Space == nsXMP && current.Local == "Rating" {
+				value = strings.TrimSpace(string(t))
+			}
+		}
+	}
+	rating, err := strconv.ParseFloat(value, 64)
+	if err != nil || rating < ratingRejected || rating > 5 {
+		return 0, false
+	}
+	return int(rating), true
+}
+
+// fileKeywords returns the keywords of a file from XMP dc:subject and the
+// EXIF keywords written by Windows, deduplicated and sorted. x may be nil
+// when the file has no EXIF.
+func fileKeywords(packet []byte, x *exif.Exif) []string {
+	keywords := make(map[string]bool)
+	for _, keyword := range parseXMPKeywords(packet) {
+		keywords[keyword] = true
+	}
+	if x != nil {
+		if tag, err := x.Get(exif.XPKeywords); err == nil {
+			for _, keyword := range strings.Split(decodeUTF16LE(tag.Val), ";") {
+				keywords[strings.TrimSpace(keyword)] = true
+			}
+		}
+	}
+	delete(keywords, "")
+	list := make([]string, 0, len(keywords))
+	for keyword := range keywords {
+		list = append(list, keyword)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// parseXMPKeywords reads the rdf:li items of dc:subject from an XMP packet
+func parseXMPKeywords(packet []byte) []string {
+	if packet == nil {
+		return nil
+	}
+	var keywords []string
+	decoder := xml.NewDecoder(bytes.NewReader(packet))
+	var stack []xml.Name
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			n := len(stack)
+			if n >= 3 && stack[n-1].Space == nsRDF && stack[n-1].Local == "li" && stack[n-3].Space == nsDC && stack[n-3].Local == "subject" {
+				if keyword := strings.TrimSpace(string(t)); keyword != "" {
+					keywords = append(keywords, keyword)
+				}
+			}
+		}
+	}
+	return keywords
+}
+
+// decodeUTF16LE decodes the NUL-terminated UTF-16 text of the Windows EXIF tags
+func decodeUTF16LE(data []byte) string {
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		unit := binary.LittleEndian.Uint16(data[i:])
+		if unit == 0 {
+			break
+		}
+		units = append(units, unit)
+	}
+	return string(utf16.Decode(units))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// errReadOnlySource is returned for writes to a source under -read-only-source
+var errReadOnlySource = errors.New("source is read-only")
+
+// readOnlyFS refuses to create, rename, or remove anything below the source
+// directories, so no code path of a -read-only-source run can change the
+// originals; reads and writes elsewhere go to the wrapped file system
+type readOnlyFS struct {
+	fileSystem
+	roots []string
+}
+
+// protected reports whether a path is one of the sources or below them
+func (r readOnlyFS) protected(name string) bool {
+	for _, root := range r.roots {
+		if filepath.Clean(name) == filepath.Clean(root) || isWithin(root, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r readOnlyFS) Create(name string) (io.WriteCloser, error) {
+	if r.protected(name) {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: errReadOnlySource}
+	}
+	return r.fileSystem.Create(name)
+}
+
+func (r readOnlyFS) MkdirAll(name string, perm fs.FileMode) error {
+	if r.protected(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: errReadOnlySource}
+	}
+	return r.fileSystem.MkdirAll(name, perm)
+}
+
+func (r readOnlyFS) Rename(oldName, newName string) error {
+	if r.protected(oldName) || r.protected(newName) {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: errReadOnlySource}
+	}
+	return r.fileSystem.Rename(oldName, newName)
+}
+
+func (r readOnlyFS) Remove(name string) error {
+	if r.protected(name) {
+		return &fs.PathError{Op: "remove", Path: name, Err: errReadOnlySource}
+	}
+	return r.fileSystem.Remove(name)
+}
+
+// containingSource returns the source directory that path is or is inside
+// of, or "" if there is none
+func containingSource(sources []string, path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	for _, dir := range sources {
+		root, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		if abs == root || isWithin(root, abs) || sameDir(root, abs) {
+			return dir
+		}
+	}
+	return ""
+}
+
+// hashSources returns the SHA-256 of every regular file in the sources and
+// the file list, whether or not the run sorts it
+func (s *sorter) hashSources() (map[string]string, error) {
+	sums := make(map[string]string)
+	hash := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		sum, err := hashFile(s.fsys, path)
+		if err != nil {
+			return err
+		}
+		sums[path] = sum
+		return nil
+	}
+	for _, dir := range s.sourceDirs {
+		if err := walkFileSystem(s.fsys, dir, hash); err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range s.fileList {
+		info, err := s.fsys.Stat(path)
+		if err := hash(path, info, err); err != nil {
+			return nil, err
+		}
+	}
+	return sums, nil
+}
+
+// checkSources hashes the source files again after a -read-only-source run
+// and reports every file that changed or disappeared since hashSources
+func (s *sorter) checkSources(before map[string]string) error {
+	changed := 0
+	for path, sum := range before {
+		after, err := hashFile(s.fsys, path)
+		switch {
+		case err != nil:
+			slog.Error("Source file can no longer be read", "path", path, "error", err)
+			changed++
+		case after != sum:
+			slog.Error("Source file changed during the run", "path", path, "before", sum, "after", after)
+			changed++
+		}
+	}
+	if changed > 0 {
+		return fmt.Errorf("%d of %d source files changed during the run", changed, len(before))
+	}
+	slog.Info("Source files are unchanged", "files", len(before))
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestContainingSource(t *testing.T) {
+	root := t.TempDir()
+	card, other := filepath.Join(root, "card"), filepath.Join(root, "other")
+	for _, dir := range []string{card, other} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := map[string]string{
+		filepath.Join(card, "report.html"):          card,
+		filepath.Join(card, "DCIM", "cache.json"):   card,
+		filepath.Join(card, "..", "card", "a.json"): card,
+		card:                                card,
+		filepath.Join(other, "report.html"): "",
+		filepath.Join(root, "card-report"):  "",
+		filepath.Join(root, "report.html"):  "",
+	}
+	for path, want := range tests {
+		if got := containingSource([]string{card}, path); got != want {
+			t.Errorf("containingSource(%q) = %q, want %q", path, got, want)
+		}
+	}
+
+	// Relative paths are taken from the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(card); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if got := containingSource([]string{card}, "report.html"); got != card {
+		t.Errorf("containingSource(report.html) in the source = %q, want %q", got, card)
+	}
+}
+
+func TestReadOnlyFSRefusesSourceWrites(t *testing.T) {
+	fsys := newMemFS()
+	src, dest := filepath.Join(string(filepath.Separator), "card"), filepath.Join(string(filepath.Separator), "library")
+	fsys.writeFile(filepath.Join(src, "a.jpg"), []byte("photo"), time.Now())
+	fsys.MkdirAll(dest, 0755)
+	ro := readOnlyFS{fileSystem: fsys, roots: []string{src}}
+	if _, err := ro.Create(filepath.Join(src, "b.jpg")); err == nil {
+		t.Error("created a file in the source")
+	}
+	if err := ro.Rename(filepath.Join(src, "a.jpg"), filepath.Join(dest, "a.jpg")); err == nil {
+		t.Error("moved a file out of the source")
+	}
+	if err := ro.Remove(filepath.Join(src, "a.jpg")); err == nil {
+		t.Error("removed a file from the source")
+	}
+	if err := copyFile(ro, filepath.Join(src, "a.jpg"), filepath.Join(dest, "a.jpg")); err != nil {
+		t.Errorf("could not copy out of the source: %v", err)
+	}
+}
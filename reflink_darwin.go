@@ -0,0 +1,16 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// reflinkFile clones src to dst on APFS using "cp -c", which calls clonefile(2)
+func reflinkFile(src, dst string) error {
+	out, err := exec.Command("/bin/cp", "-c", "-n", src, dst).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// ficlone is the FICLONE ioctl request number (_IOW(0x94, 9, int))
+const ficlone = 0x40049409
+
+// reflinkFile clones src to dst with the FICLONE ioctl, which shares the
+// underlying extents on btrfs, XFS, and other copy-on-write filesystems
+func reflinkFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, out.Fd(), ficlone, in.Fd())
+	closeErr := out.Close()
+	if errno != 0 {
+		os.Remove(dst)
+		return errno
+	}
+	return closeErr
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package main
+
+// reflinkFile is not supported on this platform
+func reflinkFile(src, dst string) error {
+	return errReflinkUnsupported
+}
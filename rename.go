@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// nameData is the data available to -rename templates
+type nameData struct {
+	Name     string    // original file name without extension
+	Ext      string    // original extension including the dot
+	Shoot    string    // shoot name from -shoot
+	DateTime time.Time // capture date
+	Camera   string    // camera make and model from EXIF, "" if unknown
+}
+
+// parseRenameTemplate parses a -rename template
+func parseRenameTemplate(text string) (*template.Template, error) {
+	return template.New("rename").Option("missingkey=error").Parse(text)
+}
+
+// renderName renders the destination file name for a photo from its
+// original (or extension-corrected) base name
+func (s *sorter) renderName(path, base string, date time.Time) (string, error) {
+	ext := filepath.Ext(base)
+	data := nameData{
+		Name:     strings.TrimSuffix(base, ext),
+		Ext:      ext,
+		Shoot:    s.shoot,
+		DateTime: date,
+	}
+	if x, err := decodeExif(s.fsys, path); err == nil {
+		// Spaces would make names harder to handle in shells and scripts
+		data.Camera = strings.ReplaceAll(cameraName(x), " ", "-")
+	}
+
+	var buf bytes.Buffer
+	if err := s.rename.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	// The template names a file, not a path
+	name := s.normalizeName(sanitizeFolderName(buf.String()))
+	if name == "_" || name == ext {
+		return "", fmt.Errorf("template produced an empty file name")
+	}
+	return name, nil
+}
+
+// uniqueName returns the destination path for a renamed file in dir. Names
+// rendered from a template can repeat, for example for several shots in the
+// same second, so a numeric suffix is added until the name is free, e.g.
+// "20230701_120000_1.jpg". A name already holding this file's contents is
+// kept, so importing the same files again still skips them. Remote
+// destinations are only asked for the size of a taken name, so there a file
+// of the same size counts as this one.
+func (s *sorter) uniqueName(path, dir, name string, info os.FileInfo) (string, error) {
+	ext := filepath.Ext(name)
+	for n := 0; ; n++ {
+		candidate := name
+		if n > 0 {
+			candidate = fmt.Sprintf("%s_%d%s", strings.TrimSuffix(name, ext), n, ext)
+		}
+		destPath := s.existingForm(filepath.Join(dir, candidate))
+		if other, ok := s.sortedTo[destPath]; ok {
+			if other == path {
+				return destPath, nil
+			}
+			continue
+		}
+		if s.store != nil {
+			size, exists, err := s.store.Stat(filepath.ToSlash(destPath))
+			if err != nil {
+				return "", fmt.Errorf("failed to check %s: %v", s.store.Location(filepath.ToSlash(destPath)), err)
+			}
+			if !exists || size == info.Size() {
+				return destPath, nil
+			}
+			continue
+		}
+		destInfo, err := s.fsys.Stat(destPath)
+		if err != nil {
+			return destPath, nil
+		}
+		if sameFile(info, destInfo) {
+			return destPath, nil
+		}
+		if destInfo.Size() == info.Size() {
+			if same, err := sameContents(s.fsys, path, destPath); err == nil && same {
+				return destPath, nil
+			}
+		}
+	}
+}
+
+// sanitizeFolderName replaces characters that are invalid in folder names on
+// common filesystems
+func sanitizeFolderName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		if r < 32 {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, " .")
+	if name == "" {
+		return "_"
+	}
+	return windowsSafeName(name)
+}
+
+// windowsReserved lists the device names Windows does not allow as file or
+// folder names, with or without an extension
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// windowsSafeName adjusts a file or folder name that Windows, and network
+// shares and memory cards formatted for it, cannot store: trailing dots and
+// spaces are dropped, and a reserved device name such as "CON.jpg" or "aux"
+// gets an underscore, "CON_.jpg". Other names are returned unchanged.
+func windowsSafeName(name string) string {
+	trimmed := strings.TrimRight(name, " .")
+	if trimmed == "" {
+		return "_"
+	}
+	stem, rest, _ := strings.Cut(trimmed, ".")
+	if windowsReserved[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		if rest != "" {
+			return stem + "_." + rest
+		}
+		return stem + "_"
+	}
+	return trimmed
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestWindowsSafeName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"IMG_0001.jpg", "IMG_0001.jpg"},
+		{"CON", "CON_"},
+		{"con.jpg", "con_.jpg"},
+		{"NUL.tar.gz", "NUL_.tar.gz"},
+		{"aux", "aux_"},
+		{"PRN.JPG", "PRN_.JPG"},
+		{"COM1.jpg", "COM1_.jpg"},
+		{"lpt9", "lpt9_"},
+		{"COM10.jpg", "COM10.jpg"},
+		{"CONSOLE.jpg", "CONSOLE.jpg"},
+		{"icon.jpg", "icon.jpg"},
+		{"CON .jpg", "CON _.jpg"},
+		{"holiday.", "holiday"},
+		{"holiday ", "holiday"},
+		{"holiday. . ", "holiday"},
+		{"NUL.", "NUL_"},
+		{"...", "_"},
+		{" ", "_"},
+		{".hidden", ".hidden"},
+	}
+	for _, test := range tests {
+		if got := windowsSafeName(test.name); got != test.want {
+			t.Errorf("windowsSafeName(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
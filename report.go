@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"html/template"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// reportThumbSize is the longest side of the thumbnails in a run report
+const reportThumbSize = 160
+
+// runReport is the content of the HTML report written by -report: what a run
+// imported, grouped by folder, followed by the duplicates it skipped and the
+// files it could not sort
+type runReport struct {
+	RunID      string
+	Command    string
+	Dest       string
+	Started    time.Time
+	Finished   time.Time
+	Sorted     int
+	Folders    []reportFolder
+	Duplicates []reportFile
+	Problems   []reportFile
+}
+
+// reportFolder lists the files sorted into one folder of the library
+type reportFolder struct {
+	Name  string
+	Files []reportFile
+}
+
+// reportFile is one file in a run report with its thumbnail, if one could
+// be made
+type reportFile struct {
+	Name  string
+	Path  string
+	Note  string
+	Thumb []byte
+}
+
+// DataURI returns the thumbnail encoded for inline use in HTML
+func (f reportFile) DataURI() template.URL {
+	return template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(f.Thumb))
+}
+
+// fileDuplicate is a file skipped by -dedupe and the file it duplicates
+type fileDuplicate struct {
+	path     string
+	existing string
+}
+
+// writeReport writes a self-contained HTML report of the run to path, with
+// a small thumbnail of every file so the import can be reviewed in a browser
+func (s *sorter) writeReport(path string) error {
+	report := runReport{
+		RunID:    s.runID,
+		Command:  s.command,
+		Dest:     s.destDir,
+		Started:  s.started,
+		Finished: time.Now(),
+	}
+
+	folders := make(map[string]*reportFolder)
+	for _, t := range s.transfers {
+		dir := filepath.Dir(t.dest)
+		if rel, err := filepath.Rel(s.destDir, dir); err == nil && s.store == nil {
+			dir = rel
+		}
+		folder := folders[dir]
+		if folder == nil {
+			folder = &reportFolder{Name: filepath.ToSlash(dir)}
+			folders[dir] = folder
+		}
+		// Previews are quicker to scale than the files, and remote and moved
+		// files are thumbnailed from wherever they still are
+		var thumb []byte
+		if preview, ok := previewPath(s.destDir, t.dest); ok && s.store == nil {
+			thumb = s.sandbox.thumbnail(preview)
+		}
+		if thumb == nil {
+			thumb = s.sandbox.thumbnail(t.dest)
+		}
+		if thumb == nil {
+			thumb = s.sandbox.thumbnail(t.source)
+		}
+		folder.Files = append(folder.Files, reportFile{Name: filepath.Base(t.dest), Path: t.source, Thumb: thumb})
+		report.Sorted++
+	}
+	for _, folder := range folders {
+		report.Folders = append(report.Folders, *folder)
+	}
+	sort.Slice(report.Folders, func(i, j int) bool { return report.Folders[i].Name < report.Folders[j].Name })
+
+	for _, d := range s.duplicates {
+		report.Duplicates = append(report.Duplicates, reportFile{
+			Name:  filepath.Base(d.path),
+			Path:  d.path,
+			Note:  "Same as " + d.existing,
+			Thumb: s.sandbox.thumbnail(d.path),
+		})
+	}
+	for _, p := range problemOutcomes {
+		for _, problem := range s.problems {
+			if problem.Outcome == p.outcome {
+				report.Problems = append(report.Problems, reportFile{
+					Name:  filepath.Base(problem.Path),
+					Path:  problem.Path,
+					Note:  p.message,
+					Thumb: s.sandbox.thumbnail(problem.Path),
+				})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := reportTemplate.Execute(&buf, report); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// reportThumbnail returns a small JPEG of an image: its embedded EXIF
+// thumbnail if it has one, or else the image itself scaled down. It returns
+// nil for videos, RAW files without a thumbnail, and files that cannot be
+// read.
+func reportThumbnail(path string) []byte {
+	if x, err := decodeExif(localFS, path); err == nil {
+		if thumb := exifThumbnail(x); thumb != nil {
+			return thumb
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleDown(img, reportThumbSize), &jpeg.Options{Quality: 75}); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// scaleDown shrinks an image so its longest side is at most size pixels.
+// Each pixel averages a grid of samples from the block of source pixels it
+// covers, which avoids the jagged look of nearest-neighbour scaling without
+// reading every pixel of a large photo.
+func scaleDown(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	tw, th := size, h*size/w
+	if h > w {
+		tw, th = w*size/h, size
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+	thumb := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := bounds.Min.Y+y*h/th, bounds.Min.Y+(y+1)*h/th
+		for x := 0; x < tw; x++ {
+			x0, x1 := bounds.Min.X+x*w/tw, bounds.Min.X+(x+1)*w/tw
+			stepX, stepY := (x1-x0+3)/4, (y1-y0+3)/4
+			var r, g, b, n uint32
+			for sy := y0; sy < y1; sy += stepY {
+				for sx := x0; sx < x1; sx += stepX {
+					sr, sg, sb, _ := img.At(sx, sy).RGBA()
+					r, g, b, n = r+sr>>8, g+sg>>8, b+sb>>8, n+1
+				}
+			}
+			i := thumb.PixOffset(x, y)
+			thumb.Pix[i], thumb.Pix[i+1], thumb.Pix[i+2], thumb.Pix[i+3] = uint8(r/n), uint8(g/n), uint8(b/n), 0xff
+		}
+	}
+	return thumb
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Photo import {{.Finished.Format "2006-01-02 15:04"}}</title>
+<style>
+body { font-family: sans-serif; max-width: 960px; margin: 2em auto; color: #222; }
+.files { display: flex; flex-wrap: wrap; gap: 8px; }
+.files figure { margin: 0; width: 160px; text-align: center; font-size: 0.8em; overflow-wrap: anywhere; }
+.files img { max-width: 160px; max-height: 160px; }
+.files .none { width: 160px; height: 120px; background: #eee; line-height: 120px; color: #888; }
+.note { color: #a33; }
+</style>
+</head>
+<body>
+<h1>Photo import {{.Finished.Format "2006-01-02 15:04"}}</h1>
+<p>{{.Sorted}} files sorted into {{.Dest}}{{if .Duplicates}}, {{len .Duplicates}} duplicates skipped{{end}}{{if .Problems}}, {{len .Problems}} files need attention{{end}}.</p>
+
+{{range .Folders}}<h2>{{.Name}}</h2>
+<div class="files">
+{{range .Files}}{{template "file" .}}{{end}}</div>
+{{end}}
+{{if .Duplicates}}<h2>Duplicates</h2>
+<p>These files were not imported because the library already has them.</p>
+<div class="files">
+{{range .Duplicates}}{{template "file" .}}{{end}}</div>
+{{end}}
+{{if .Problems}}<h2>Needs Attention</h2>
+<p>These files were not imported.</p>
+<div class="files">
+{{range .Problems}}{{template "file" .}}{{end}}</div>
+{{end}}
+<p><small>Generated by GoPicSort, {{.Command}} run {{.RunID}}, started {{.Started.Format "2006-01-02 15:04:05"}}</small></p>
+</body>
+</html>
+{{define "file"}}<figure title="{{.Path}}">{{if .Thumb}}<img src="{{.DataURI}}" alt="{{.Name}}">{{else}}<div class="none">no preview</div>{{end}}<figcaption>{{.Name}}{{if .Note}}<br><span class="note">{{.Note}}</span>{{end}}</figcaption></figure>
+{{end}}`))
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Output formats accepted by -output
+const (
+	outputText   = "text"
+	outputJSON   = "json"
+	outputNDJSON = "ndjson"
+)
+
+// jsonResult is set by -output json: the run ends by printing a runResult to
+// standard output, including when it fails
+var jsonResult bool
+
+// Outcomes of a file in a sort run, the keys of runResult.Counts
+const (
+	outcomeSorted      = "sorted"
+	outcomePlanned     = "planned"
+	outcomeExisting    = "existing"
+	outcomeDuplicate   = "duplicate"
+	outcomeFiltered    = "filtered"
+	outcomeHeld        = "held"
+	outcomeUndated     = "undated"
+	outcomeUnsorted    = "unsorted"
+	outcomeCorrupt     = "corrupt"
+	outcomeQuarantined = "quarantined"
+	outcomeTooLarge    = "too_large"
+	outcomeUnreadable  = "unreadable"
+	outcomeConflict    = "conflict"
+	outcomeFailed      = "failed"
+)
+
+// Exit codes of a sort run
+const (
+	exitOK      = 0
+	exitPartial = 1 // some files could not be sorted
+	exitFatal   = 2 // the run could not start or was aborted
+)
+
+// problemOutcome describes the outcomes whose files are listed individually
+// in the summary at the end of a run
+type problemOutcome struct {
+	outcome string
+	// failure marks files that should have been sorted but could not be,
+	// which makes the run a partial failure
+	failure bool
+	message string
+}
+
+// problemOutcomes lists the problem outcomes in the order they are reported
+var problemOutcomes = []problemOutcome{
+	{outcomeFailed, true, "Files could not be sorted"},
+	{outcomeUnreadable, true, "Files were skipped because reading them failed or took longer than -file-timeout; the source disk may be failing"},
+	{outcomeTooLarge, true, "Files were skipped because they exceed the destination's file size limit; use -overflow to sort them elsewhere"},
+	{outcomeCorrupt, true, "Files were skipped because they could not be decoded; use -quarantine to set them aside"},
+	{outcomeConflict, true, "Files were skipped because another source file was already sorted to the same name"},
+	{outcomeQuarantined, false, "Files were quarantined, see the quarantine report"},
+	{outcomeUndated, false, "Files were skipped because they have no capture date"},
+	{outcomeUnsorted, false, "Files without a capture date were put into the unsorted folder"},
+}
+
+// isProblem reports whether files with an outcome are listed individually
+func isProblem(outcome string) bool {
+	for _, p := range problemOutcomes {
+		if p.outcome == outcome {
+			return true
+		}
+	}
+	return false
+}
+
+// isFailure reports whether an outcome means a file should have been sorted
+// but could not be
+func isFailure(outcome string) bool {
+	for _, p := range problemOutcomes {
+		if p.outcome == outcome {
+			return p.failure
+		}
+	}
+	return false
+}
+
+// fileProblem is a file that was not sorted for a reason worth following up
+type fileProblem struct {
+	Path    string `json:"path"`
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// runResult is the single JSON object printed by -output json
+type runResult struct {
+	Status    string              `json:"status"`
+	ExitCode  int                 `json:"exit_code"`
+	Error     string              `json:"error,omitempty"`
+	RunID     string              `json:"run_id,omitempty"`
+	Command   string              `json:"command,omitempty"`
+	Started   *time.Time          `json:"started,omitempty"`
+	Finished  time.Time           `json:"finished"`
+	Sources   []string            `json:"sources,omitempty"`
+	Dest      string              `json:"dest,omitempty"`
+	Seed      int64               `json:"seed,omitempty"`
+	Counts    map[string]int      `json:"counts,omitempty"`
+	Problems  []fileProblem       `json:"problems,omitempty"`
+	OutOfSync map[string][]string `json:"out_of_sync,omitempty"`
+	Reports   map[string]string   `json:"reports,omitempty"`
+}
+
+// validateOutput checks the -output flag value
+func validateOutput(format string) error {
+	switch format {
+	case outputText, outputJSON, outputNDJSON:
+		return nil
+	default:
+		return fmt.Errorf("invalid -output format %q, expected 'text', 'json', or 'ndjson'", format)
+	}
+}
+
+// tally counts the outcome of a file, remembering problem files by path
+func (s *sorter) tally(outcome, path string) {
+	if s.counts == nil {
+		s.counts = make(map[string]int)
+	}
+	s.counts[outcome]++
+	s.metrics.file(outcome)
+	// Transfers and failures have their own events with more detail
+	if outcome != outcomeSorted && outcome != outcomePlanned && outcome != outcomeFailed {
+		events.emit(streamEvent{Event: streamSkipped, Path: path, Outcome: outcome})
+	}
+	if isProblem(outcome) {
+		s.problems = append(s.problems, fileProblem{Path: path, Outcome: outcome})
+	}
+}
+
+// failures returns how many files should have been sorted but could not be
+func (s *sorter) failures() int {
+	n := 0
+	for outcome, count := range s.counts {
+		if isFailure(outcome) {
+			n += count
+		}
+	}
+	return n + s.mirrorProblems()
+}
+
+// exitCode returns the exit code for a run that ended with err
+func (s *sorter) exitCode(err error) int {
+	switch {
+	case err != nil:
+		return exitFatal
+	case s.failures() > 0:
+		return exitPartial
+	default:
+		return exitOK
+	}
+}
+
+// summaryOutcomes returns every outcome in the order runs report them
+func summaryOutcomes() []string {
+	outcomes := []string{outcomeSorted, outcomePlanned, outcomeExisting, outcomeDuplicate, outcomeFiltered, outcomeHeld}
+	for _, p := range problemOutcomes {
+		outcomes = append(outcomes, p.outcome)
+	}
+	return outcomes
+}
+
+// printSummary logs how many files had each outcome, then lists the files
+// that were not sorted, grouped by reason
+func (s *sorter) printSummary() {
+	var counts []any
+	for _, outcome := range summaryOutcomes() {
+		if s.counts[outcome] > 0 {
+			counts = append(counts, outcome, s.counts[outcome])
+		}
+	}
+	slog.Info("Run summary", counts...)
+
+	for _, p := range problemOutcomes {
+		var paths []string
+		for _, problem := range s.problems {
+			if problem.Outcome == p.outcome {
+				paths = append(paths, problem.Path)
+			}
+		}
+		if len(paths) == 0 {
+			continue
+		}
+		if p.failure {
+			slog.Error(p.message, "files", len(paths))
+		} else {
+			slog.Warn(p.message, "files", len(paths))
+		}
+		for _, path := range paths {
+			fmt.Fprintln(os.Stderr, "  "+path)
+		}
+	}
+}
+
+// result summarizes the run for -output json; err is the error that ended
+// it, if any
+func (s *sorter) result(err error) runResult {
+	result := runResult{
+		Status:    "ok",
+		RunID:     s.runID,
+		Command:   s.command,
+		Started:   &s.started,
+		Finished:  time.Now(),
+		Sources:   s.sourceDirs,
+		Dest:      s.destDir,
+		Seed:      s.seed,
+		Counts:    s.counts,
+		Problems:  s.problems,
+		OutOfSync: s.outOfSync,
+	}
+	result.ExitCode = s.exitCode(err)
+	switch {
+	case err != nil:
+		result.Status, result.Error = "failed", err.Error()
+	case result.ExitCode == exitPartial:
+		result.Status = "partial"
+	}
+	if s.store == nil {
+		result.Reports = map[string]string{"history": historyPath(s.destDir, machineName())}
+		if s.counts[outcomeQuarantined] > 0 {
+			result.Reports["quarantine"] = filepath.Join(s.destDir, quarantineDirName, "report.txt")
+		}
+	}
+	if s.reportPath != "" {
+		if result.Reports == nil {
+			result.Reports = make(map[string]string)
+		}
+		result.Reports["html"] = s.reportPath
+	}
+	return result
+}
+
+// writeResult prints a run result as one line of JSON
+func writeResult(out io.Writer, result runResult) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	fmt.Fprintln(out, string(data))
+}
+
+// failureResult describes a run that stopped before sorting, from the
+// arguments of fatal
+func failureResult(msg string, args ...any) runResult {
+	text := msg
+	for i := 0; i+1 < len(args); i += 2 {
+		sep := ", "
+		if i == 0 {
+			sep = ": "
+		}
+		text += fmt.Sprintf("%s%v=%v", sep, args[i], args[i+1])
+	}
+	return runResult{Status: "failed", ExitCode: exitFatal, Error: text, Finished: time.Now()}
+}
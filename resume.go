@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// resumeChunkSize is how much data is copied between journal updates
+const resumeChunkSize = 8 << 20
+
+// partialSuffix marks destination files that are still being copied
+const partialSuffix = ".partial"
+
+// copyJournal records the progress of a resumable copy so it can continue
+// after the destination disappears and comes back, or in a later run
+type copyJournal struct {
+	Source  string    `json:"source"`
+	Dest    string    `json:"dest"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// Offset is the number of bytes durably written to the partial file
+	Offset int64 `json:"offset"`
+}
+
+// journalPath returns where the journal for a destination file is kept
+func (s *sorter) journalPath(dst string) string {
+	sum := sha1.Sum([]byte(dst))
+	return filepath.Join(s.destDir, stateDirName, "journal", hex.EncodeToString(sum[:])+".json")
+}
+
+// copyResumable copies src to dst in chunks, journaling the completed byte
+// range. If the copy fails, for example because a USB drive disconnected, it
+// waits up to retryWait for the destination to return and continues from the
+// last journaled offset. Files smaller than one chunk are copied normally.
+func (s *sorter) copyResumable(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.Size() <= resumeChunkSize {
+		return copyFile(s.fsys, src, dst)
+	}
+
+	deadline := time.Now().Add(s.retryWait)
+	for {
+		err := s.copyChunks(src, dst, info)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		slog.Warn("Copy interrupted, waiting for destination", "path", dst, "error", err)
+		if !waitForDir(filepath.Dir(dst), deadline) {
+			return err
+		}
+		slog.Info("Destination available again, resuming copy", "path", dst)
+	}
+}
+
+// copyChunks performs one attempt of a journaled copy
+func (s *sorter) copyChunks(src, dst string, info os.FileInfo) error {
+	// Check if destination file already exists
+	if _, err := os.Stat(dst); err == nil {
+		// File exists, don't overwrite
+		slog.Info("Skipping: file already exists at destination", "path", dst)
+		return nil
+	}
+
+	journalPath := s.journalPath(dst)
+	partial := dst + partialSuffix
+	journal := copyJournal{Source: src, Dest: dst, Size: info.Size(), ModTime: info.ModTime()}
+
+	// Resume from the journal if it describes the same source file
+	if data, err := os.ReadFile(journalPath); err == nil {
+		var previous copyJournal
+		if json.Unmarshal(data, &previous) == nil && previous.Source == src && previous.Size == info.Size() && previous.ModTime.Equal(info.ModTime()) {
+			if stat, err := os.Stat(partial); err == nil && stat.Size() >= previous.Offset {
+				journal.Offset = previous.Offset
+			}
+		}
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	// The journal only says what was written; check that it really is on
+	// the destination before building on it
+	if journal.Offset > 0 {
+		if ok, err := prefixMatches(in, out, journal.Offset); err != nil {
+			slog.Warn("Could not check partial copy, starting over", "path", dst, "error", err)
+			journal.Offset = 0
+		} else if !ok {
+			slog.Warn("Partial copy does not match the source, starting over", "path", dst)
+			journal.Offset = 0
+		}
+	}
+	if journal.Offset > 0 {
+		slog.Info("Resuming copy", "path", dst, "offset", journal.Offset, "size", journal.Size)
+	}
+
+	// Drop anything written after the last journaled chunk
+	if err := out.Truncate(journal.Offset); err != nil {
+		return err
+	}
+	if _, err := in.Seek(journal.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := out.Seek(journal.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(journalPath), 0755); err != nil {
+		return err
+	}
+
+	buf := make([]byte, resumeChunkSize)
+	reader := throttled(in)
+	for journal.Offset < journal.Size {
+		n, err := io.ReadFull(reader, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("source %s shrank during copy", src)
+		}
+		if _, err := out.Write(buf[:n]); err != nil {
+			return err
+		}
+		if err := out.Sync(); err != nil {
+			return err
+		}
+		journal.Offset += int64(n)
+		if err := writeJSONFile(journalPath, journal); err != nil {
+			return err
+		}
+	}
+
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(partial, dst); err != nil {
+		return err
+	}
+	os.Remove(journalPath)
+	return nil
+}
+
+// prefixMatches reports whether the first offset bytes of a partial copy
+// have the same contents as the source. The whole prefix is compared, as a
+// drive that disconnected mid-write can lose or garble any chunk written
+// since its cache was last flushed, not only the last one.
+func prefixMatches(src, partial *os.File, offset int64) (bool, error) {
+	want := make([]byte, resumeChunkSize)
+	got := make([]byte, resumeChunkSize)
+	for pos := int64(0); pos < offset; {
+		n := int64(resumeChunkSize)
+		if offset-pos < n {
+			n = offset - pos
+		}
+		if _, err := src.ReadAt(want[:n], pos); err != nil {
+			return false, err
+		}
+		if _, err := partial.ReadAt(got[:n], pos); err != nil {
+			return false, err
+		}
+		if !bytes.Equal(want[:n], got[:n]) {
+			return false, nil
+		}
+		pos += n
+	}
+	return true, nil
+}
+
+// waitForDir polls until dir is accessible again or the deadline passes
+func waitForDir(dir string, deadline time.Time) bool {
+	for time.Now().Before(deadline) {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return true
+		}
+		time.Sleep(5 * time.Second)
+	}
+	return false
+}
+
+// writeJSONFile atomically replaces a file with the JSON encoding of v
+func writeJSONFile(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
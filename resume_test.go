@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResumeStartsOverWhenPartialCopyDiffers(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+	data := make([]byte, 2*resumeChunkSize+1000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	source := filepath.Join(src, "clip.mp4")
+	writeTestFile(t, source, data)
+	info, err := os.Stat(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A journal claims two chunks were written, but the first one was lost
+	// while only the second reached the drive
+	s := newTestSorter(t, localFS, dest, src)
+	target := filepath.Join(dest, "clip.mp4")
+	partial := append(make([]byte, resumeChunkSize), data[resumeChunkSize:2*resumeChunkSize]...)
+	writeTestFile(t, target+partialSuffix, partial)
+	if err := os.MkdirAll(filepath.Dir(s.journalPath(target)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	journal := copyJournal{Source: source, Dest: target, Size: info.Size(), ModTime: info.ModTime(), Offset: 2 * resumeChunkSize}
+	if err := writeJSONFile(s.journalPath(target), journal); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.copyResumable(source, target); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("resumed copy does not match the source")
+	}
+	if _, err := os.Stat(target + partialSuffix); !os.IsNotExist(err) {
+		t.Error("partial copy left behind")
+	}
+}
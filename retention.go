@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// retentionEntry protects one file in a library until a date
+type retentionEntry struct {
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason,omitempty"`
+	Set    time.Time `json:"set"`
+	RunID  string    `json:"run_id,omitempty"`
+}
+
+// retentionCatalog lists the files of a library under retention, keyed by
+// their slash-separated path relative to the library
+type retentionCatalog struct {
+	root  string
+	Files map[string]retentionEntry `json:"files"`
+}
+
+// retentionPath returns where a library's retention catalog is kept
+func retentionPath(root string) string {
+	return filepath.Join(root, stateDirName, "retention.json")
+}
+
+// loadRetention reads the retention catalog of a library; a library without
+// one has no files under retention
+func loadRetention(root string) (*retentionCatalog, error) {
+	c := &retentionCatalog{root: root, Files: make(map[string]retentionEntry)}
+	data, err := os.ReadFile(retentionPath(root))
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("invalid retention catalog: %v", err)
+	}
+	if c.Files == nil {
+		c.Files = make(map[string]retentionEntry)
+	}
+	return c, nil
+}
+
+// save writes the catalog back to the library
+func (c *retentionCatalog) save() error {
+	if err := os.MkdirAll(filepath.Join(c.root, stateDirName), 0755); err != nil {
+		return err
+	}
+	return writeJSONFile(retentionPath(c.root), c)
+}
+
+// key returns the catalog key of a path inside the library
+func (c *retentionCatalog) key(path string) (string, bool) {
+	rel, err := filepath.Rel(c.root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// hold puts a file under retention, keeping an existing later date
+func (c *retentionCatalog) hold(path string, entry retentionEntry) {
+	key, ok := c.key(path)
+	if !ok {
+		return
+	}
+	if existing, held := c.Files[key]; held && existing.Until.After(entry.Until) {
+		return
+	}
+	c.Files[key] = entry
+}
+
+// check returns an error if path, or any file below it, is under retention
+func (c *retentionCatalog) check(path string) error {
+	key, ok := c.key(path)
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	for file, entry := range c.Files {
+		if !now.Before(entry.Until) {
+			continue
+		}
+		if file == key || key == "." || strings.HasPrefix(file, key+"/") {
+			return fmt.Errorf("%s is under retention until %s", filepath.FromSlash(file), entry.Until.Format("2006-01-02"))
+		}
+	}
+	return nil
+}
+
+// checkRetention refuses changes to a path in a library with files under
+// retention; paths outside any catalog are never held
+func checkRetention(root, path string) error {
+	c, err := loadRetention(root)
+	if err != nil {
+		return err
+	}
+	return c.check(path)
+}
+
+// runHold implements the "hold" subcommand, which puts files under retention,
+// lists held files, or releases them
+func runHold(args []string) {
+	fs := flag.NewFlagSet("hold", flag.ExitOnError)
+	destDir := fs.String("dest", "", "Sorted photo library")
+	until := fs.String("until", "", "Keep the files unchanged until this date (YYYY-MM-DD)")
+	reason := fs.String("reason", "", "Why the files are held (e.g., client or case name)")
+	release := fs.Bool("release", false, "Release the given files from retention")
+	logOpts := addLogFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s hold -dest DIR [-until YYYY-MM-DD [-reason TEXT] | -release] [path ...]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	paths := parseInterspersed(fs, args)
+	if *destDir == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := logOpts.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	catalog, err := loadRetention(*destDir)
+	if err != nil {
+		fatal("Failed to read retention catalog", "error", err)
+	}
+
+	// Without -until or -release, list the files under retention
+	if *until == "" && !*release {
+		keys := make([]string, 0, len(catalog.Files))
+		for key := range catalog.Files {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "FILE\tUNTIL\tREASON")
+		for _, key := range keys {
+			entry := catalog.Files[key]
+			fmt.Fprintf(w, "%s\t%s\t%s\n", key, entry.Until.Format("2006-01-02"), entry.Reason)
+		}
+		w.Flush()
+		return
+	}
+	if len(paths) == 0 {
+		fatal("No files given")
+	}
+
+	// Paths are relative to the library; folders hold every file below them
+	var files []string
+	for _, path := range paths {
+		full := filepath.Join(*destDir, filepath.FromSlash(path))
+		err := filepath.Walk(full, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			fatal("Failed to list files", "path", path, "error", err)
+		}
+	}
+
+	if *release {
+		for _, file := range files {
+			if key, ok := catalog.key(file); ok {
+				delete(catalog.Files, key)
+			}
+		}
+		slog.Info("Released files from retention", "files", len(files))
+	} else {
+		date, err := parseDateFlag("until", *until)
+		if err != nil {
+			fatal(err.Error())
+		}
+		entry := retentionEntry{Until: date, Reason: *reason, Set: time.Now()}
+		for _, file := range files {
+			catalog.hold(file, entry)
+		}
+		slog.Info("Put files under retention", "files", len(files), "until", *until)
+	}
+	if err := catalog.save(); err != nil {
+		fatal("Failed to write retention catalog", "error", err)
+	}
+}
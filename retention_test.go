@@ -0,0 +1,73 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResortLeavesHeldFiles(t *testing.T) {
+	lib := t.TempDir()
+	writeTestFile(t, filepath.Join(lib, "2020", "01", "IMG_20210506_070809.jpg"), []byte("held"))
+	writeTestFile(t, filepath.Join(lib, "2020", "01", "IMG_20210601_120000.jpg"), []byte("free"))
+	holds, err := loadRetention(lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	holds.hold(filepath.Join(lib, "2020", "01", "IMG_20210506_070809.jpg"), retentionEntry{Until: time.Now().AddDate(1, 0, 0), Reason: "client"})
+	// An expired hold no longer protects anything
+	holds.hold(filepath.Join(lib, "2020", "01", "IMG_20210601_120000.jpg"), retentionEntry{Until: time.Now().AddDate(0, 0, -1)})
+	if err := holds.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestSorter(t, localFS, lib, lib)
+	s.dateSources = []string{dateSourceFilename}
+	s.moveFiles = true
+	sourceHolds, err := loadRetention(lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.sourceHolds = []*retentionCatalog{sourceHolds}
+	if err := s.run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(listFiles(t, lib), " "); got != "2020/01/IMG_20210506_070809.jpg 2021/06/IMG_20210601_120000.jpg" {
+		t.Errorf("library holds %s", got)
+	}
+	if s.counts[outcomeHeld] != 1 {
+		t.Errorf("counts are %v, want one held file", s.counts)
+	}
+
+	// The folder of the held file cannot be unlocked or changed either
+	if err := checkRetention(lib, filepath.Join(lib, "2020")); err == nil {
+		t.Error("the folder of a held file is not under retention")
+	}
+	if err := checkRetention(lib, filepath.Join(lib, "2021")); err != nil {
+		t.Errorf("a folder without held files is under retention: %v", err)
+	}
+}
+
+func TestHoldKeepsLaterDate(t *testing.T) {
+	lib := t.TempDir()
+	holds, err := loadRetention(lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(lib, "2021", "05", "IMG_0001.jpg")
+	later := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	holds.hold(path, retentionEntry{Until: later})
+	holds.hold(path, retentionEntry{Until: later.AddDate(-1, 0, 0)})
+	holds.hold(filepath.Join(t.TempDir(), "outside.jpg"), retentionEntry{Until: later})
+	if err := holds.save(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := loadRetention(lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.Files) != 1 || !reloaded.Files["2021/05/IMG_0001.jpg"].Until.Equal(later) {
+		t.Errorf("catalog holds %v, want the file until %s", reloaded.Files, later.Format("2006-01-02"))
+	}
+}
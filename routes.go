@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Format classes accepted by -route besides extensions
+const (
+	routeRaw   = "raw"
+	routeImage = "image"
+	routeVideo = "video"
+)
+
+// formatRoute sends the files of some formats to a library of their own,
+// in the same layout as the destination
+type formatRoute struct {
+	// formats holds canonical extensions and format classes
+	formats []string
+	dir     string
+}
+
+// parseRoutes parses -route values such as "raw=/archive/raw" or
+// "jpg,heic=photos". Relative directories are inside destDir.
+func parseRoutes(specs []string, destDir string) ([]formatRoute, error) {
+	var routes []formatRoute
+	for _, spec := range specs {
+		formats, dir, ok := strings.Cut(spec, "=")
+		dir = strings.TrimSpace(dir)
+		if !ok || dir == "" {
+			return nil, fmt.Errorf("invalid -route %q, expected FORMATS=DIR, e.g. 'raw=/archive/raw'", spec)
+		}
+		route := formatRoute{dir: dir}
+		if !filepath.IsAbs(dir) {
+			route.dir = filepath.Join(destDir, dir)
+		}
+		for _, format := range strings.Split(formats, ",") {
+			format = strings.ToLower(strings.TrimSpace(format))
+			switch format {
+			case "":
+				continue
+			case routeRaw, routeImage, routeVideo:
+				route.formats = append(route.formats, format)
+			default:
+				route.formats = append(route.formats, canonicalExt("."+strings.TrimPrefix(format, ".")))
+			}
+		}
+		if len(route.formats) == 0 {
+			return nil, fmt.Errorf("invalid -route %q, no formats given", spec)
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// matches reports whether a route takes files with an extension
+func (r formatRoute) matches(ext string) bool {
+	ext = canonicalExt(ext)
+	for _, format := range r.formats {
+		switch format {
+		case routeRaw:
+			if isRawFile(ext) {
+				return true
+			}
+		case routeImage:
+			if isImageFile(ext) && !isRawFile(ext) {
+				return true
+			}
+		case routeVideo:
+			if isVideoFile(ext) {
+				return true
+			}
+		default:
+			if format == ext {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// routeFor returns the library a file goes to, the destination unless a
+// -route takes its format; the first matching route wins
+func (s *sorter) routeFor(path string) string {
+	ext := s.fileExt(path)
+	for _, route := range s.routes {
+		if route.matches(ext) {
+			return route.dir
+		}
+	}
+	return s.destDir
+}
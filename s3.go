@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3 multipart upload sizes: files above the threshold are uploaded in parts
+const (
+	s3MultipartThreshold = 64 << 20
+	s3PartSize           = 16 << 20
+)
+
+// s3Storage stores files in an S3-compatible bucket (AWS, Backblaze B2,
+// MinIO, ...) using path-style requests signed with AWS Signature Version 4
+type s3Storage struct {
+	endpoint     *url.URL
+	bucket       string
+	prefix       string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+// newS3Storage parses an s3://bucket/prefix destination. Credentials and the
+// region come from the standard AWS environment variables; endpoint may
+// point at any S3-compatible service and defaults to AWS.
+func newS3Storage(dest, endpoint string) (*s3Storage, error) {
+	u, err := url.Parse(dest)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 destination %q, expected s3://bucket/prefix", dest)
+	}
+	st := &s3Storage{
+		bucket:       u.Host,
+		prefix:       strings.Trim(u.Path, "/"),
+		region:       os.Getenv("AWS_REGION"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       &http.Client{Timeout: 30 * time.Minute},
+	}
+	if st.region == "" {
+		st.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if st.region == "" {
+		st.region = "us-east-1"
+	}
+	if st.accessKey == "" || st.secretKey == "" {
+		return nil, fmt.Errorf("S3 destination requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if endpoint == "" {
+		endpoint = "https://s3." + st.region + ".amazonaws.com"
+	}
+	if st.endpoint, err = url.Parse(endpoint); err != nil || st.endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+	return st, nil
+}
+
+// key returns the object key for a destination name
+func (st *s3Storage) key(name string) string {
+	if st.prefix == "" {
+		return name
+	}
+	return st.prefix + "/" + name
+}
+
+func (st *s3Storage) Location(name string) string {
+	return "s3://" + st.bucket + "/" + st.key(name)
+}
+
+func (st *s3Storage) Stat(name string) (int64, bool, error) {
+	resp, err := st.do(http.MethodHead, st.key(name), nil, nil, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.ContentLength, true, nil
+	case http.StatusNotFound:
+		return 0, false, nil
+	default:
+		return 0, false, fmt.Errorf("HEAD %s: %s", st.Location(name), resp.Status)
+	}
+}
+
+func (st *s3Storage) Put(name, src string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	key := st.key(name)
+	header := http.Header{}
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if info.Size() <= s3MultipartThreshold {
+		data, err := io.ReadAll(throttled(file))
+		if err != nil {
+			return err
+		}
+		return st.expect(st.do(http.MethodPut, key, nil, header, data))
+	}
+	return st.putMultipart(key, throttled(file), header)
+}
+
+// putMultipart uploads a large file in parts, aborting the upload on failure
+// so no orphaned parts are left in the bucket
+func (st *s3Storage) putMultipart(key string, file io.Reader, header http.Header) error {
+	resp, err := st.do(http.MethodPost, key, url.Values{"uploads": {""}}, header, nil)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := decodeS3Response(resp, &initiated); err != nil {
+		return err
+	}
+
+	type completedPart struct {
+		PartNumber int
+		ETag       string
+	}
+	var parts []completedPart
+	buf := make([]byte, s3PartSize)
+	upload := func() error {
+		for number := 1; ; number++ {
+			n, err := io.ReadFull(file, buf)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {initiated.UploadID}}
+			resp, err := st.do(http.MethodPut, key, query, nil, buf[:n])
+			if err != nil {
+				return err
+			}
+			if err := st.expect(resp, nil); err != nil {
+				return fmt.Errorf("part %d: %v", number, err)
+			}
+			parts = append(parts, completedPart{number, resp.Header.Get("ETag")})
+			if n < len(buf) {
+				return nil
+			}
+		}
+	}
+	if err := upload(); err != nil {
+		st.abort(key, initiated.UploadID)
+		return err
+	}
+
+	complete := struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts}
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	resp, err = st.do(http.MethodPost, key, url.Values{"uploadId": {initiated.UploadID}}, nil, body)
+	if err != nil {
+		st.abort(key, initiated.UploadID)
+		return err
+	}
+	// Completion can fail after a 200 status, reported in the body
+	if err := decodeS3Response(resp, nil); err != nil {
+		st.abort(key, initiated.UploadID)
+		return err
+	}
+	return nil
+}
+
+func (st *s3Storage) Close() error {
+	st.client.CloseIdleConnections()
+	return nil
+}
+
+// abort cancels a multipart upload
+func (st *s3Storage) abort(key, uploadID string) {
+	if resp, err := st.do(http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// expect checks that a request succeeded and discards the response
+func (st *s3Storage) expect(resp *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// decodeS3Response reads an XML response into v, turning S3 error documents
+// into errors
+func decodeS3Response(resp *http.Response, v any) error {
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var s3Err struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}
+	if resp.StatusCode/100 != 2 || xml.Unmarshal(data, &s3Err) == nil {
+		return fmt.Errorf("%s: %s %s", resp.Status, s3Err.Code, s3Err.Message)
+	}
+	if v == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, v)
+}
+
+// do sends a signed request for an object key in the bucket
+func (st *s3Storage) do(method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *st.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + st.bucket + "/" + key
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	st.sign(req, body, time.Now().UTC())
+	return st.client.Do(req)
+}
+
+// sign adds an AWS Signature Version 4 Authorization header covering the
+// host and every header already set on the request
+func (st *s3Storage) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256.Sum256(body)
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if st.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", st.sessionToken)
+	}
+
+	// Canonical headers are lower-case, sorted, and include the host
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := day + "/" + st.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+st.secretKey), day)
+	key = hmacSHA256(key, st.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", st.accessKey, scope, signedHeaders, signature))
+	// The request must carry the same path encoding that was signed
+	req.URL.RawPath = s3EscapePath(req.URL.Path)
+}
+
+// s3EscapePath percent-encodes every byte of a path except unreserved
+// characters and slashes, as Signature Version 4 requires
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is a bucket server keeping objects in memory by request path
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDTEST/") {
+		http.Error(w, "unsigned request", http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodHead:
+		data, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[r.URL.Path] = data
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+func TestS3UploadsSortedFiles(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	bucket := &fakeS3{objects: map[string][]byte{"/photos/library/2021/05/IMG_20210507_070809.jpg": []byte("stored")}}
+	server := httptest.NewServer(bucket)
+	defer server.Close()
+	st, err := newS3Storage("s3://photos/library", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := t.TempDir()
+	writeTestFile(t, filepath.Join(src, "IMG_20210506_070809.jpg"), []byte("photo"))
+	writeTestFile(t, filepath.Join(src, "IMG_20210507_070809.jpg"), []byte("stored"))
+	s := newStoreSorter(t, st, src)
+	if err := s.run(); err != nil {
+		t.Fatal(err)
+	}
+	if s.counts[outcomeSorted] != 1 || s.counts[outcomeExisting] != 1 {
+		t.Errorf("counts are %v, want one sorted and one existing file", s.counts)
+	}
+	if got := string(bucket.objects["/photos/library/2021/05/IMG_20210506_070809.jpg"]); got != "photo" {
+		t.Errorf("object holds %q, want %q", got, "photo")
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// newRunSeed returns a random seed for a run's sampling decisions
+func newRunSeed() int64 {
+	var random [8]byte
+	rand.Read(random[:])
+	return int64(binary.BigEndian.Uint64(random[:]) >> 1)
+}
+
+// sampled reports whether a file belongs to the -sample selection. The
+// decision depends only on the seed, the file name, and its size, so a run
+// with the same seed picks the same files, even when the source is mounted
+// at another path or files were added since.
+func (s *sorter) sampled(path string, info os.FileInfo) bool {
+	if s.samplePercent <= 0 || s.samplePercent >= 100 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(strconv.FormatInt(s.seed, 10) + "\x00" + filepath.Base(path) + "\x00" + strconv.FormatInt(info.Size(), 10)))
+	return float64(h.Sum64()%1000000) < s.samplePercent*10000
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime/debug"
+	"time"
+)
+
+// Operations run by the decode worker
+const (
+	decodeIntegrity = "integrity"
+	decodeHash      = "hash"
+	decodePreview   = "preview"
+	decodeThumbnail = "thumbnail"
+)
+
+// decoderSandbox runs image decoding in a separate process with a timeout
+// and a memory limit, so a malformed file that makes a decoder hang or
+// allocate without bound costs one file instead of the whole import. A nil
+// sandbox decodes in process.
+type decoderSandbox struct {
+	timeout     time.Duration
+	memoryLimit int64 // bytes
+}
+
+// decodeResult is the answer of the decode worker, written as JSON to stdout
+type decodeResult struct {
+	Error string `json:"error,omitempty"`
+	Hash  uint64 `json:"hash,omitempty"`
+	Image []byte `json:"image,omitempty"`
+}
+
+// checkIntegrity runs checkIntegrity in the sandbox. A decoder that crashes,
+// runs out of memory, or times out marks the file as unreadable.
+func (b *decoderSandbox) checkIntegrity(path, level string) error {
+	if b == nil {
+		return checkIntegrity(path, level)
+	}
+	result, err := b.call(decodeIntegrity, path, "-level", level)
+	if err != nil {
+		return err
+	}
+	if result.Error != "" {
+		return errors.New(result.Error)
+	}
+	return nil
+}
+
+// differenceHash runs fileDifferenceHash in the sandbox
+func (b *decoderSandbox) differenceHash(path string) (uint64, error) {
+	if b == nil {
+		return fileDifferenceHash(path)
+	}
+	result, err := b.call(decodeHash, path)
+	if err != nil {
+		return 0, err
+	}
+	if result.Error != "" {
+		return 0, errors.New(result.Error)
+	}
+	return result.Hash, nil
+}
+
+// preview runs previewJPEG in the sandbox
+func (b *decoderSandbox) preview(path string, size int) ([]byte, error) {
+	if b == nil {
+		return previewJPEG(path, size)
+	}
+	result, err := b.call(decodePreview, path, "-size", fmt.Sprint(size))
+	if err != nil {
+		return nil, err
+	}
+	if result.Error != "" {
+		return nil, errors.New(result.Error)
+	}
+	return result.Image, nil
+}
+
+// thumbnail runs reportThumbnail in the sandbox, returning nil for files
+// that fail to decode there as well as for files without an image
+func (b *decoderSandbox) thumbnail(path string) []byte {
+	if b == nil {
+		return reportThumbnail(path)
+	}
+	result, err := b.call(decodeThumbnail, path)
+	if err != nil {
+		slog.Debug("No thumbnail", "path", path, "error", err)
+		return nil
+	}
+	return result.Image
+}
+
+// call runs one operation in a fresh worker process, passing it the flags
+// in args
+func (b *decoderSandbox) call(op, path string, args ...string) (decodeResult, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return decodeResult{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+
+	args = append([]string{"decode-worker", "-op", op, "-memory", fmt.Sprint(b.memoryLimit)}, args...)
+	cmd := exec.CommandContext(ctx, executable, append(args, "--", path)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return decodeResult{}, fmt.Errorf("decoder timed out after %s", b.timeout)
+	}
+	if err != nil {
+		if bytes.Contains(stderr.Bytes(), []byte("out of memory")) {
+			return decodeResult{}, fmt.Errorf("decoder exceeded the %d MB memory limit", b.memoryLimit>>20)
+		}
+		return decodeResult{}, fmt.Errorf("decoder crashed: %v", err)
+	}
+
+	var result decodeResult
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		return decodeResult{}, fmt.Errorf("invalid decoder output: %v", err)
+	}
+	return result, nil
+}
+
+// runDecodeWorker implements the internal "decode-worker" subcommand, which
+// performs a single decoding operation for the sandbox and exits
+func runDecodeWorker(args []string) {
+	fs := flag.NewFlagSet("decode-worker", flag.ExitOnError)
+	op := fs.String("op", "", "Operation")
+	level := fs.String("level", "", "Validation level")
+	size := fs.Int("size", defaultPreviewSize, "Longest side of previews")
+	memory := fs.Int64("memory", 0, "Memory limit in bytes")
+	paths := parseInterspersed(fs, args)
+	if len(paths) != 1 {
+		os.Exit(2)
+	}
+
+	// Keep the heap below the limit and have the kernel enforce it
+	if *memory > 0 {
+		debug.SetMemoryLimit(*memory)
+		limitMemory(*memory)
+	}
+
+	var result decodeResult
+	switch *op {
+	case decodeIntegrity:
+		if err := checkIntegrity(paths[0], *level); err != nil {
+			result.Error = err.Error()
+		}
+	case decodeHash:
+		hash, err := fileDifferenceHash(paths[0])
+		if err != nil {
+			result.Error = err.Error()
+		}
+		result.Hash = hash
+	case decodePreview:
+		preview, err := previewJPEG(paths[0], *size)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		result.Image = preview
+	case decodeThumbnail:
+		result.Image = reportThumbnail(paths[0])
+	default:
+		os.Exit(2)
+	}
+	json.NewEncoder(os.Stdout).Encode(result)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"image"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// screenshotNamePattern matches the names phones and desktops give screenshots
+var screenshotNamePattern = regexp.MustCompile(`(?i)^(screenshot|screen shot|screen_shot|screencap|scrnli|bildschirmfoto|capture d.[ée]cran|schermafbeelding|captura de pantalla|istantanea)`)
+
+// downloadNamePattern matches images saved from messengers, social apps, and browsers
+var downloadNamePattern = regexp.MustCompile(`(?i)^(fb_img_|received_|img-\d{8}-wa\d+|images?( ?\(\d+\))?\.|download( ?\(\d+\))?\.|unnamed( ?\(\d+\))?\.|tumblr_|pinterest)`)
+
+// nameDatePattern finds a date and time in names like "Screenshot_20230105-101010"
+// or "Screen Shot 2023-01-05 at 10.10.10"
+var nameDatePattern = regexp.MustCompile(`(\d{4})[-_.]?(\d{2})[-_.]?(\d{2})(?:[ _-]*(?:at )?(\d{2})[-_.:]?(\d{2})[-_.:]?(\d{2}))?`)
+
+// screenSizes lists common phone, tablet, and monitor resolutions (width x height)
+var screenSizes = map[[2]int]bool{
+	{1280, 720}: true, {1280, 800}: true, {1366, 768}: true, {1440, 900}: true,
+	{1536, 864}: true, {1600, 900}: true, {1680, 1050}: true, {1920, 1080}: true,
+	{1920, 1200}: true, {2560, 1440}: true, {2560, 1600}: true, {2880, 1800}: true,
+	{3024, 1964}: true, {3456, 2234}: true, {3840, 2160}: true, {5120, 2880}: true,
+	{750, 1334}: true, {828, 1792}: true, {1080, 1920}: true, {1080, 2340}: true,
+	{1080, 2400}: true, {1125, 2436}: true, {1170, 2532}: true, {1179, 2556}: true,
+	{1242, 2208}: true, {1242, 2688}: true, {1284, 2778}: true, {1290, 2796}: true,
+	{1440, 2560}: true, {1440, 3040}: true, {1440, 3120}: true, {1440, 3200}: true,
+	{1620, 2160}: true, {1640, 2360}: true, {1668, 2388}: true, {2048, 2732}: true,
+}
+
+// detectNonPhoto reports why a file looks like a screenshot or a downloaded
+// image rather than a camera photo, or "" if it looks like a photo
+func detectNonPhoto(fsys fileSystem, path string) string {
+	name := filepath.Base(path)
+	if screenshotNamePattern.MatchString(name) {
+		return "screenshot name"
+	}
+
+	// Camera photos name the camera; screenshots and saved images do not
+	camera := false
+	if x, err := decodeExif(fsys, path); err == nil {
+		for _, field := range []exif.FieldName{exif.Software, exif.UserComment, exif.ImageDescription} {
+			if strings.Contains(strings.ToLower(exifString(x, field)), "screenshot") {
+				return "screenshot metadata"
+			}
+		}
+		camera = exifString(x, exif.Make) != "" || exifString(x, exif.Model) != ""
+	}
+	if camera {
+		return ""
+	}
+
+	if downloadNamePattern.MatchString(name) {
+		return "downloaded image"
+	}
+	if width, height, ok := imageSize(fsys, path); ok {
+		if screenSizes[[2]int{width, height}] || screenSizes[[2]int{height, width}] {
+			return "screen-sized image"
+		}
+	}
+	return ""
+}
+
+// imageSize reads the dimensions of a JPEG, PNG, or GIF image
+func imageSize(fsys fileSystem, path string) (int, int, bool) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return 0, 0, false
+	}
+	defer file.Close()
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, false
+	}
+	return config.Width, config.Height, true
+}
+
+// dateFromName extracts a capture date embedded in a file name
+func dateFromName(name string) (time.Time, bool) {
+	m := nameDatePattern.FindStringSubmatch(name)
+	if m == nil {
+		return time.Time{}, false
+	}
+	n := make([]int, 6)
+	for i := range n {
+		n[i], _ = strconv.Atoi(m[i+1])
+	}
+	date := time.Date(n[0], time.Month(n[1]), n[2], n[3], n[4], n[5], 0, time.Local)
+	// Reject impossible dates that time.Date would normalize
+	if date.Year() != n[0] || int(date.Month()) != n[1] || date.Day() != n[2] || n[0] < 1990 {
+		return time.Time{}, false
+	}
+	return date, true
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// runScrub implements the "scrub" subcommand, which re-hashes the files of
+// a library against the hashes in its catalog, recorded by -manifest or
+// adopt, and reports files that are missing or whose contents changed
+// although their size and modification time did not, the sign of bit rot
+// or a failing disk. Files least recently verified are checked first, so a
+// -budget spreads a large library over several scheduled runs.
+func runScrub(args []string) {
+	fs := flag.NewFlagSet("scrub", flag.ExitOnError)
+	destDir := fs.String("dest", "", "Library to check")
+	budget := fs.Duration("budget", 0, "Stop hashing after this long (e.g., '1h'); the next run continues with the files not yet checked")
+	update := fs.Bool("update", false, "Record the hashes of new and intentionally changed files and forget missing ones; corrupted files are never updated")
+	logOpts := addLogFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s scrub -dest DIR [-budget DURATION] [-update]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	parseInterspersed(fs, args)
+	if *destDir == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := logOpts.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	catalog, err := loadCatalog(*destDir)
+	if err != nil {
+		fatal("Failed to read catalog", "error", err)
+	}
+	files, err := libraryFiles(*destDir)
+	if err != nil {
+		fatal("Failed to scan library", "error", err)
+	}
+
+	// Check the files verified longest ago first
+	keys := make([]string, 0, len(catalog))
+	for key := range catalog {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := catalog[keys[i]].Verified, catalog[keys[j]].Verified
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return keys[i] < keys[j]
+	})
+
+	var deadline time.Time
+	if *budget > 0 {
+		deadline = time.Now().Add(*budget)
+	}
+	verified, corrupted, missing, modified, unchecked := 0, 0, 0, 0, 0
+	for _, key := range keys {
+		entry := catalog[key]
+		path := filepath.Join(*destDir, filepath.FromSlash(key))
+		info, ok := files[key]
+		if !ok {
+			slog.Error("Missing file", "path", path)
+			missing++
+			if *update {
+				delete(catalog, key)
+			}
+			continue
+		}
+		// A new size or modification time means the file was edited, e.g.
+		// by fix-tz; only unexplained changes count as corruption
+		if info.Size() != entry.Size || !info.ModTime().Equal(entry.ModTime) {
+			slog.Warn("File changed since it was cataloged", "path", path)
+			modified++
+			if *update {
+				if sum, err := hashFile(localFS, longPath(path)); err != nil {
+					slog.Warn("Could not read file", "path", path, "error", err)
+				} else {
+					// Edits such as fix-tz keep the picture, and so its labels
+					entry.Size, entry.ModTime, entry.SHA256, entry.Verified = info.Size(), info.ModTime(), sum, time.Now()
+					catalog[key] = entry
+				}
+			}
+			continue
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			unchecked++
+			continue
+		}
+		sum, err := hashFile(localFS, longPath(path))
+		if err != nil {
+			slog.Error("Could not read file", "path", path, "error", err)
+			corrupted++
+			continue
+		}
+		if sum != entry.SHA256 {
+			slog.Error("Corrupted file: contents changed but size and modification time did not", "path", path, "expected", entry.SHA256, "actual", sum)
+			corrupted++
+			continue
+		}
+		entry.Verified = time.Now()
+		catalog[key] = entry
+		verified++
+	}
+
+	// Files sorted without -manifest or added by hand have no hash yet
+	added := 0
+	for key, info := range files {
+		if _, ok := catalog[key]; ok {
+			continue
+		}
+		if !*update {
+			added++
+			continue
+		}
+		path := filepath.Join(*destDir, filepath.FromSlash(key))
+		sum, err := hashFile(localFS, longPath(path))
+		if err != nil {
+			slog.Warn("Could not read file", "path", path, "error", err)
+			continue
+		}
+		catalog[key] = catalogEntry{Size: info.Size(), ModTime: info.ModTime(), SHA256: sum, Verified: time.Now()}
+		added++
+	}
+
+	if err := os.MkdirAll(filepath.Join(*destDir, stateDirName), 0755); err != nil {
+		fatal("Failed to create state folder", "error", err)
+	}
+	if err := writeJSONFile(catalogPath(*destDir), catalog); err != nil {
+		fatal("Failed to write catalog", "error", err)
+	}
+	addedKey := "uncataloged"
+	if *update {
+		addedKey = "added"
+	}
+	slog.Info("Scrub finished", "verified", verified, "corrupted", corrupted, "missing", missing, "modified", modified, "unchecked", unchecked, addedKey, added)
+	if corrupted > 0 || missing > 0 {
+		os.Exit(1)
+	}
+}
+
+// libraryFiles returns the regular files of a library keyed like the
+// catalog, skipping GoPicSort's own folders
+func libraryFiles(root string) (map[string]os.FileInfo, error) {
+	files := make(map[string]os.FileInfo)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != root && (info.Name() == stateDirName || info.Name() == previewsDirName || info.Name() == trashDirName) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			rel, _ := filepath.Rel(root, path)
+			files[filepath.ToSlash(rel)] = info
+		}
+		return nil
+	})
+	return files, err
+}
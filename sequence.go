@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// defaultSequenceMin is the fewest photos that make a burst or timelapse
+const defaultSequenceMin = 10
+
+// detectSequences finds bursts and timelapses: runs of at least sequenceMin
+// photos in one source folder, each taken within sequenceGap of the one
+// before. Every photo of a run is sorted into a folder named after the first
+// frame, "2023-07-14 15.30.12 Sequence", below its month folder. Photos are
+// only compared within a folder, as a folder usually holds one camera's
+// shots.
+func (s *sorter) detectSequences() error {
+	type frame struct {
+		path string
+		date time.Time
+	}
+	byDir := make(map[string][]frame)
+	err := s.walkSource(func(path string, info os.FileInfo) error {
+		if date, err := getPhotoDate(path); err == nil {
+			dir := filepath.Dir(path)
+			byDir[dir] = append(byDir[dir], frame{path, s.adjustTime(date)})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.sequences = make(map[string]string)
+	count := 0
+	for _, frames := range byDir {
+		sort.SliceStable(frames, func(i, j int) bool { return frames[i].date.Before(frames[j].date) })
+		for start := 0; start < len(frames); {
+			end := start + 1
+			for end < len(frames) && frames[end].date.Sub(frames[end-1].date) <= s.sequenceGap {
+				end++
+			}
+			if end-start >= s.sequenceMin {
+				name := frames[start].date.Format("2006-01-02 15.04.05") + " Sequence"
+				for _, f := range frames[start:end] {
+					s.sequences[f.path] = name
+				}
+				count++
+			}
+			start = end
+		}
+	}
+	slog.Info("Grouped bursts and timelapses", "sequences", count, "files", len(s.sequences), "gap", s.sequenceGap)
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// serviceOptions describes the watcher a service runs
+type serviceOptions struct {
+	Name        string
+	Description string
+	Executable  string
+	WorkDir     string
+	User        string
+	Watchdog    time.Duration
+	// Args are the sort flags of the watch, including -watch
+	Args []string
+}
+
+// serviceContext is cancelled when the Windows service manager stops the
+// service, which ends the watch like an interrupt does
+var serviceContext = context.Background()
+
+// runService implements the "service" subcommand, which runs a watch as a
+// system service: "unit" prints a systemd unit for it, and "install" and
+// "uninstall" set it up and remove it, with systemd on Linux and the service
+// manager on Windows, which starts the watch with "run". The sort flags of
+// the watch follow "--"; -watch is added when missing.
+func runService(args []string) {
+	fs := flag.NewFlagSet("service", flag.ExitOnError)
+	name := fs.String("name", "gopicsort", "Name of the service")
+	user := fs.String("user", "", "Account the service runs as (default: the account installing it, or root)")
+	watchdog := fs.Duration("watchdog", 10*time.Minute, "Restart the service when sorting a single file takes longer than this (0 turns the watchdog off)")
+	dir := fs.String("dir", "", "Working directory of the watch started by 'run'")
+	logOpts := addLogFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s service unit|install|uninstall|run [options] [-- SORT_FLAGS]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	action := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+	// Everything after the options belongs to the watch
+	fs.Parse(args)
+	sortArgs := fs.Args()
+	if action == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := logOpts.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if action == "run" {
+		if *dir != "" {
+			if err := os.Chdir(*dir); err != nil {
+				fatal("Failed to change to the working directory", "dir", *dir, "error", err)
+			}
+		}
+		if err := runAsService(*name, sortArgs); err != nil {
+			fatal("Failed to run service", "name", *name, "error", err)
+		}
+		return
+	}
+	if action == "uninstall" {
+		if err := uninstallService(*name); err != nil {
+			fatal("Failed to uninstall service", "name", *name, "error", err)
+		}
+		return
+	}
+	if action != "unit" && action != "install" {
+		fatal("Unknown service action, expected 'unit', 'install', 'uninstall', or 'run'", "action", action)
+	}
+	if len(sortArgs) == 0 {
+		fatal("Give the sort flags of the watch after --, e.g. -- -source /srv/incoming -dest /srv/library")
+	}
+	opts, err := newServiceOptions(*name, *user, *watchdog, sortArgs)
+	if err != nil {
+		fatal("Failed to describe service", "error", err)
+	}
+	if action == "unit" {
+		if err := writeSystemdUnit(os.Stdout, opts); err != nil {
+			fatal("Failed to write unit", "error", err)
+		}
+		return
+	}
+	if err := installService(opts); err != nil {
+		fatal("Failed to install service", "name", *name, "error", err)
+	}
+}
+
+// newServiceOptions describes a service running this executable with the
+// sort flags args from the current directory, so relative paths keep working
+func newServiceOptions(name, user string, watchdog time.Duration, args []string) (serviceOptions, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return serviceOptions{}, err
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return serviceOptions{}, err
+	}
+	watching := false
+	for _, arg := range args {
+		flagName := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		if strings.HasPrefix(arg, "-") && (flagName == "watch" || flagName == "tether") {
+			watching = true
+		}
+	}
+	if !watching {
+		args = append([]string{"-watch"}, args...)
+	}
+	if user == "" && os.Geteuid() > 0 {
+		user = os.Getenv("USER")
+	}
+	return serviceOptions{
+		Name:        name,
+		Description: "GoPicSort photo watcher",
+		Executable:  exe,
+		WorkDir:     wd,
+		User:        user,
+		Watchdog:    watchdog,
+		Args:        args,
+	}, nil
+}
+
+// systemdQuote quotes an argument for a systemd unit file, which expands
+// specifiers starting with % and variables starting with $
+func systemdQuote(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	arg = strings.ReplaceAll(arg, "$", "$$")
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\;") {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+// writeSystemdUnit writes a unit running the watch as a Type=notify service,
+// which restarts it when it fails or its watchdog fires and gives the file
+// being sorted time to finish when stopped
+func writeSystemdUnit(w io.Writer, opts serviceOptions) error {
+	return systemdUnitTemplate.Execute(w, opts)
+}
+
+var systemdUnitTemplate = template.Must(template.New("unit").Funcs(template.FuncMap{
+	"quote":  systemdQuote,
+	"escape": func(s string) string { return strings.ReplaceAll(s, "%", "%%") },
+	"seconds": func(d time.Duration) int {
+		return int(d.Seconds())
+	},
+}).Parse(`[Unit]
+Description={{.Description}}
+Wants=network-online.target
+After=network-online.target local-fs.target
+
+[Service]
+Type=notify
+NotifyAccess=main
+ExecStart={{quote .Executable}}{{range .Args}} {{quote .}}{{end}}
+WorkingDirectory={{escape .WorkDir}}
+{{if .User}}User={{.User}}
+{{end}}Restart=on-failure
+RestartSec=10
+{{if .Watchdog}}WatchdogSec={{seconds .Watchdog}}
+{{end}}KillSignal=SIGTERM
+TimeoutStopSec=300
+
+[Install]
+WantedBy=multi-user.target
+`))
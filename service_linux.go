@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// systemdUnitDir is where installed units are written
+const systemdUnitDir = "/etc/systemd/system"
+
+// installService writes a systemd unit for the watch, then enables and
+// starts it
+func installService(opts serviceOptions) error {
+	path := filepath.Join(systemdUnitDir, opts.Name+".service")
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeSystemdUnit(file, opts); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	slog.Info("Wrote systemd unit", "path", path)
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	if err := systemctl("enable", "--now", opts.Name+".service"); err != nil {
+		return err
+	}
+	slog.Info("Service installed and started", "name", opts.Name, "status", "systemctl status "+opts.Name)
+	return nil
+}
+
+// uninstallService stops and disables the service and removes its unit
+func uninstallService(name string) error {
+	path := filepath.Join(systemdUnitDir, name+".service")
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	if err := systemctl("disable", "--now", name+".service"); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	slog.Info("Service removed", "name", name)
+	return systemctl("daemon-reload")
+}
+
+// systemctl runs systemctl with args
+func systemctl(args ...string) error {
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// runAsService is only used by the Windows service manager
+func runAsService(name string, args []string) error {
+	return fmt.Errorf("'service run' is started by the Windows service manager; systemd runs the watch directly")
+}
@@ -0,0 +1,23 @@
+//go:build !linux && !windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// installService is not supported on this platform
+func installService(opts serviceOptions) error {
+	return fmt.Errorf("installing a service is not supported on %s, run the watch with your system's service manager", runtime.GOOS)
+}
+
+// uninstallService is not supported on this platform
+func uninstallService(name string) error {
+	return fmt.Errorf("installing a service is not supported on %s", runtime.GOOS)
+}
+
+// runAsService is not supported on this platform
+func runAsService(name string, args []string) error {
+	return fmt.Errorf("running as a service is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// installService registers the watch with the Windows service manager as
+// an automatically started service that restarts when it fails, registers
+// it as an event log source, and starts it
+func installService(opts serviceOptions) error {
+	if opts.User != "" {
+		return fmt.Errorf("-user is not supported on Windows, the service runs as LocalSystem; change the account in services.msc")
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(opts.Name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", opts.Name)
+	}
+
+	// Services have no console, so the watch logs to the event log unless
+	// told otherwise
+	args := opts.Args
+	logged := false
+	for _, arg := range args {
+		name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		if strings.HasPrefix(arg, "-") && (name == "log-format" || name == "log-file" || name == "plain") {
+			logged = true
+		}
+	}
+	if !logged {
+		args = append(args, "-log-format", "eventlog")
+	}
+	args = append([]string{"service", "run", "-name", opts.Name, "-dir", opts.WorkDir, "--"}, args...)
+
+	s, err := m.CreateService(opts.Name, opts.Executable, mgr.Config{
+		DisplayName: "GoPicSort (" + opts.Name + ")",
+		Description: opts.Description,
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := s.SetRecoveryActions([]mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 10 * time.Second}}, uint32((24 * time.Hour).Seconds())); err != nil {
+		slog.Warn("Could not set the service to restart on failure", "error", err)
+	}
+	if err := eventlog.InstallAsEventCreate(opts.Name, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("failed to register event log source: %v", err)
+	}
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("service installed but could not be started: %v", err)
+	}
+	slog.Info("Service installed and started", "name", opts.Name)
+	return nil
+}
+
+// uninstallService stops the service, waiting for the file being sorted, and
+// removes it and its event log source
+func uninstallService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", name)
+	}
+	defer s.Close()
+	if status, err := s.Control(svc.Stop); err == nil {
+		deadline := time.Now().Add(5 * time.Minute)
+		for status.State != svc.Stopped && time.Now().Before(deadline) {
+			time.Sleep(500 * time.Millisecond)
+			if status, err = s.Query(); err != nil {
+				break
+			}
+		}
+	}
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	if err := eventlog.Remove(name); err != nil {
+		slog.Warn("Could not remove event log source", "name", name, "error", err)
+	}
+	slog.Info("Service removed", "name", name)
+	return nil
+}
+
+// runAsService runs the watch with the sort flags args under the Windows
+// service manager
+func runAsService(name string, args []string) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !isService {
+		return fmt.Errorf("'service run' is started by the Windows service manager, run the sort command directly instead")
+	}
+	eventLogSource = name
+	return svc.Run(name, &watchService{args: args})
+}
+
+// watchService runs the sort command as a Windows service
+type watchService struct {
+	args []string
+}
+
+func (w *watchService) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serviceContext = ctx
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		os.Args = append(os.Args[:1:1], w.args...)
+		runSort(false)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-done:
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				// The watch stops after the file being sorted
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32((5 * time.Minute).Milliseconds())}
+				cancel()
+			}
+		}
+	}
+}
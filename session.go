@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Policies accepted by -on-conflict for another run writing to the same library
+const (
+	conflictWarn = "warn"
+	conflictWait = "wait"
+	conflictFail = "fail"
+)
+
+// sessionHeartbeat is how often an active run refreshes its session file;
+// sessions not refreshed for sessionStale are left over from crashed runs
+const (
+	sessionHeartbeat = time.Minute
+	sessionStale     = 5 * time.Minute
+)
+
+// session announces a run that is writing to a library, so runs on other
+// machines sharing the library can notice each other
+type session struct {
+	RunID     string    `json:"run_id"`
+	Machine   string    `json:"machine"`
+	PID       int       `json:"pid"`
+	Command   string    `json:"command"`
+	Started   time.Time `json:"started"`
+	Heartbeat time.Time `json:"heartbeat"`
+}
+
+// validateConflictPolicy checks the -on-conflict flag value
+func validateConflictPolicy(policy string) error {
+	switch policy {
+	case conflictWarn, conflictWait, conflictFail:
+		return nil
+	default:
+		return fmt.Errorf("invalid -on-conflict policy %q, expected 'warn', 'wait', or 'fail'", policy)
+	}
+}
+
+// sessionDir is where active runs keep their session files
+func sessionDir(destDir string) string {
+	return filepath.Join(destDir, stateDirName, "active")
+}
+
+// claimSession registers this run as writing to the destination and takes
+// the library's lock, so only one run writes to a library at a time. When
+// another run holds the lock, "wait" blocks until it is released, "fail"
+// returns an error, and "warn" logs it and carries on without the lock. The
+// returned function releases the lock and removes the session when the run
+// ends.
+func (s *sorter) claimSession(policy string) (func(), error) {
+	own := session{
+		RunID:   s.runID,
+		Machine: machineName(),
+		PID:     os.Getpid(),
+		Command: s.command,
+		Started: s.started,
+	}
+	path := filepath.Join(sessionDir(s.destDir), s.runID+".json")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	locked := false
+	refresh := func() error {
+		own.Heartbeat = time.Now()
+		if locked {
+			refreshLock(s.destDir, own)
+		}
+		return writeJSONFile(path, own)
+	}
+	if err := refresh(); err != nil {
+		return nil, err
+	}
+
+	for {
+		holder, err := tryLock(s.destDir, own)
+		if err != nil {
+			os.Remove(path)
+			return nil, err
+		}
+		if holder == nil {
+			locked = true
+			break
+		}
+		slog.Warn("Another run is writing to this library", "run", holder.RunID, "machine", holder.Machine, "pid", holder.PID, "started", holder.Started.Format(time.RFC3339))
+		if policy == conflictFail {
+			os.Remove(path)
+			return nil, fmt.Errorf("%s is locked by run %s on %s", s.destDir, holder.RunID, holder.Machine)
+		}
+		if policy != conflictWait {
+			break
+		}
+		slog.Info("Waiting for the other run to finish", "run", holder.RunID)
+		time.Sleep(sessionHeartbeat / 4)
+		if err := refresh(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Keep the session and lock fresh while the run is going
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(sessionHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := refresh(); err != nil {
+					slog.Warn("Could not refresh session", "error", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		if locked {
+			releaseLock(s.destDir, own.RunID)
+		}
+		os.Remove(path)
+	}, nil
+}
+
+// lockPath returns the lock file of a library
+func lockPath(destDir string) string {
+	return filepath.Join(destDir, stateDirName, "lock")
+}
+
+// tryLock creates the library's lock file for a run. If another run holds
+// the lock, that run's session is returned. A lock left by a crashed run,
+// whose process is gone or whose heartbeat stopped, is removed first.
+func tryLock(destDir string, own session) (*session, error) {
+	path := lockPath(destDir)
+	for attempt := 0; attempt < 2; attempt++ {
+		data, err := json.Marshal(own)
+		if err != nil {
+			return nil, err
+		}
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = file.Write(data)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			return nil, err
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		holder, ok := readLock(path)
+		if !ok {
+			// A lock being written by another run reads as empty; let it finish
+			time.Sleep(100 * time.Millisecond)
+			if holder, ok = readLock(path); !ok {
+				holder = session{Heartbeat: time.Now()}
+			}
+		}
+		stale := time.Since(holder.Heartbeat) > sessionStale ||
+			(holder.Machine == own.Machine && holder.PID != 0 && !processAlive(holder.PID))
+		if !stale {
+			return &holder, nil
+		}
+		slog.Warn("Removing stale lock left by a run that is no longer running", "run", holder.RunID, "machine", holder.Machine, "pid", holder.PID)
+		// Only remove the lock that was found stale, not one another run
+		// has taken over in the meantime
+		if current, ok := readLock(path); ok && current.RunID == holder.RunID {
+			os.Remove(path)
+		}
+	}
+	holder, _ := readLock(path)
+	return &holder, nil
+}
+
+// readLock reads the session of the run holding a lock
+func readLock(path string) (session, bool) {
+	var holder session
+	data, err := os.ReadFile(path)
+	if err != nil || json.Unmarshal(data, &holder) != nil {
+		return session{}, false
+	}
+	return holder, true
+}
+
+// refreshLock updates the heartbeat in a lock this run still holds
+func refreshLock(destDir string, own session) {
+	if holder, ok := readLock(lockPath(destDir)); ok && holder.RunID == own.RunID {
+		if err := writeJSONFile(lockPath(destDir), own); err != nil {
+			slog.Warn("Could not refresh lock", "error", err)
+		}
+	}
+}
+
+// releaseLock removes a lock this run holds
+func releaseLock(destDir, runID string) {
+	if holder, ok := readLock(lockPath(destDir)); ok && holder.RunID == runID {
+		os.Remove(lockPath(destDir))
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestSessionLockHeldByOneRun(t *testing.T) {
+	lib := t.TempDir()
+	first := newTestSorter(t, localFS, lib)
+	release, err := first.claimSession(conflictFail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := newTestSorter(t, localFS, lib)
+	if _, err := second.claimSession(conflictFail); err == nil {
+		t.Fatal("a second run took the lock of a library being written to")
+	}
+	warned, err := second.claimSession(conflictWarn)
+	if err != nil {
+		t.Fatalf("-on-conflict warn did not carry on: %v", err)
+	}
+	warned()
+
+	release()
+	again, err := second.claimSession(conflictFail)
+	if err != nil {
+		t.Fatalf("the lock was not released: %v", err)
+	}
+	again()
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+)
+
+// SFTP version 3 packet types and flags used by the client
+const (
+	sftpInit    = 1
+	sftpVersion = 2
+	sftpOpen    = 3
+	sftpClose   = 4
+	sftpWrite   = 6
+	sftpRemove  = 13
+	sftpMkdir   = 14
+	sftpStat    = 17
+	sftpRename  = 18
+	sftpStatus  = 101
+	sftpHandle  = 102
+	sftpAttrs   = 105
+
+	sftpOK     = 0
+	sftpNoSuch = 2
+
+	sftpAttrSize = 0x01
+
+	sftpOpenWrite    = 0x02
+	sftpOpenCreate   = 0x08
+	sftpOpenTruncate = 0x10
+)
+
+// sftpChunkSize and sftpWindow bound each write and the writes in flight
+const (
+	sftpChunkSize = 32 << 10
+	sftpWindow    = 16
+	sftpPoolSize  = 4
+)
+
+// errSFTPNoSuchFile is the status returned for missing paths
+var errSFTPNoSuchFile = errors.New("no such file")
+
+// sftpStorage writes to a server over SFTP. Connections are made with the
+// system ssh client, so ~/.ssh/config, keys, and the agent apply, and are
+// kept in a pool for reuse between files.
+type sftpStorage struct {
+	args []string // ssh arguments selecting the host
+	host string
+	root string
+	pool chan *sftpConn
+
+	mu   sync.Mutex
+	dirs map[string]bool // directories known to exist
+}
+
+// newSFTPStorage parses an sftp://[user@]host[:port]/path destination. A
+// path starting with /~/ is relative to the remote home directory.
+func newSFTPStorage(dest string) (*sftpStorage, error) {
+	u, err := url.Parse(dest)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid SFTP destination %q, expected sftp://[user@]host[:port]/path", dest)
+	}
+	st := &sftpStorage{
+		args: []string{"-o", "BatchMode=yes"},
+		root: path.Clean(u.Path),
+		pool: make(chan *sftpConn, sftpPoolSize),
+		dirs: make(map[string]bool),
+	}
+	if u.Port() != "" {
+		st.args = append(st.args, "-p", u.Port())
+	}
+	// ssh would take a user or host starting with a dash for an option,
+	// such as -oProxyCommand running a command
+	host := u.Hostname()
+	if strings.HasPrefix(host, "-") || u.User != nil && strings.HasPrefix(u.User.Username(), "-") {
+		return nil, fmt.Errorf("invalid SFTP destination %q, the user and host cannot start with '-'", dest)
+	}
+	if u.User != nil {
+		host = u.User.Username() + "@" + host
+	}
+	st.host = host
+	st.args = append(st.args, "-s", "--", host, "sftp")
+	if st.root == "/~" || st.root == "." {
+		st.root = "."
+	} else if len(st.root) > 3 && st.root[:3] == "/~/" {
+		st.root = st.root[3:]
+	}
+
+	// Connect once up front so configuration errors show before sorting
+	conn, err := st.conn()
+	if err != nil {
+		return nil, err
+	}
+	st.release(conn, nil)
+	return st, nil
+}
+
+func (st *sftpStorage) Location(name string) string {
+	p := path.Join(st.root, name)
+	if !strings.HasPrefix(p, "/") {
+		p = "/~/" + p
+	}
+	return "sftp://" + st.host + p
+}
+
+func (st *sftpStorage) Stat(name string) (size int64, exists bool, err error) {
+	conn, err := st.conn()
+	if err != nil {
+		return 0, false, err
+	}
+	defer func() { st.release(conn, err) }()
+
+	size, err = conn.stat(path.Join(st.root, name))
+	if err == errSFTPNoSuchFile {
+		return 0, false, nil
+	}
+	return size, err == nil, err
+}
+
+// Put writes the file under a temporary name and renames it into place, so
+// an interrupted transfer never leaves a partial file under the final name
+func (st *sftpStorage) Put(name, src string) (err error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	conn, err := st.conn()
+	if err != nil {
+		return err
+	}
+	defer func() { st.release(conn, err) }()
+
+	target := path.Join(st.root, name)
+	if err := st.mkdirAll(conn, path.Dir(target)); err != nil {
+		return err
+	}
+	temp := path.Join(path.Dir(target), "."+path.Base(target)+partialSuffix)
+	if err := conn.upload(temp, throttled(file)); err != nil {
+		conn.remove(temp)
+		return err
+	}
+	if err := conn.rename(temp, target); err != nil {
+		conn.remove(temp)
+		return err
+	}
+	return nil
+}
+
+// Close ends the pooled connections
+func (st *sftpStorage) Close() error {
+	for {
+		select {
+		case conn := <-st.pool:
+			conn.close()
+		default:
+			return nil
+		}
+	}
+}
+
+// mkdirAll creates dir and its parents, remembering which exist
+func (st *sftpStorage) mkdirAll(conn *sftpConn, dir string) error {
+	st.mu.Lock()
+	known := st.dirs[dir]
+	st.mu.Unlock()
+	if known || dir == "." || dir == "/" {
+		return nil
+	}
+	if _, err := conn.stat(dir); err != nil {
+		if err != errSFTPNoSuchFile {
+			return err
+		}
+		if err := st.mkdirAll(conn, path.Dir(dir)); err != nil {
+			return err
+		}
+		// Another connection may have created it in the meantime
+		if err := conn.mkdir(dir); err != nil {
+			if _, statErr := conn.stat(dir); statErr != nil {
+				return fmt.Errorf("mkdir %s: %v", dir, err)
+			}
+		}
+	}
+	st.mu.Lock()
+	st.dirs[dir] = true
+	st.mu.Unlock()
+	return nil
+}
+
+// conn takes a connection from the pool or opens a new one
+func (st *sftpStorage) conn() (*sftpConn, error) {
+	select {
+	case conn := <-st.pool:
+		return conn, nil
+	default:
+		return dialSFTP(st.args)
+	}
+}
+
+// release returns a healthy connection to the pool; connections that saw an
+// error are closed so the next attempt starts fresh
+func (st *sftpStorage) release(conn *sftpConn, err error) {
+	if err != nil && err != errSFTPNoSuchFile {
+		conn.close()
+		return
+	}
+	select {
+	case st.pool <- conn:
+	default:
+		conn.close()
+	}
+}
+
+// sftpConn is one SFTP session over an ssh subprocess
+type sftpConn struct {
+	cmd    *exec.Cmd
+	in     io.WriteCloser
+	out    *bufio.Reader
+	nextID uint32
+}
+
+// dialSFTP starts ssh with the sftp subsystem and negotiates version 3
+func dialSFTP(args []string) (*sftpConn, error) {
+	cmd := exec.Command("ssh", args...)
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ssh: %v", err)
+	}
+	conn := &sftpConn{cmd: cmd, in: in, out: bufio.NewReaderSize(out, 64<<10)}
+
+	var version []byte
+	version = binary.BigEndian.AppendUint32(version, 3)
+	if err := conn.send(sftpInit, version); err != nil {
+		conn.close()
+		return nil, err
+	}
+	kind, _, err := conn.receive()
+	if err != nil || kind != sftpVersion {
+		conn.close()
+		return nil, fmt.Errorf("SFTP handshake failed: %v", err)
+	}
+	return conn, nil
+}
+
+func (c *sftpConn) close() {
+	c.in.Close()
+	c.cmd.Wait()
+}
+
+// send writes one packet: length, type, and payload
+func (c *sftpConn) send(kind byte, payload []byte) error {
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header, uint32(len(payload)+1))
+	header[4] = kind
+	if _, err := c.in.Write(header); err != nil {
+		return err
+	}
+	_, err := c.in.Write(payload)
+	return err
+}
+
+// receive reads one packet and returns its type and payload after the type
+func (c *sftpConn) receive() (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(c.out, header); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length < 1 || length > 1<<20 {
+		return 0, nil, fmt.Errorf("invalid SFTP packet length %d", length)
+	}
+	payload := make([]byte, length-1)
+	if _, err := io.ReadFull(c.out, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[4], payload, nil
+}
+
+// request sends a packet with a fresh request id followed by the given fields
+func (c *sftpConn) request(kind byte, fields ...any) error {
+	c.nextID++
+	buf := binary.BigEndian.AppendUint32(nil, c.nextID)
+	for _, field := range fields {
+		switch v := field.(type) {
+		case string:
+			buf = binary.BigEndian.AppendUint32(buf, uint32(len(v)))
+			buf = append(buf, v...)
+		case []byte:
+			buf = binary.BigEndian.AppendUint32(buf, uint32(len(v)))
+			buf = append(buf, v...)
+		case uint32:
+			buf = binary.BigEndian.AppendUint32(buf, v)
+		case uint64:
+			buf = binary.BigEndian.AppendUint64(buf, v)
+		}
+	}
+	return c.send(kind, buf)
+}
+
+// status reads a response and converts a status packet into an error. Other
+// packet types are returned to the caller with the request id removed.
+func (c *sftpConn) status() (byte, []byte, error) {
+	kind, payload, err := c.receive()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(payload) < 4 {
+		return 0, nil, fmt.Errorf("short SFTP response")
+	}
+	payload = payload[4:]
+	if kind != sftpStatus {
+		return kind, payload, nil
+	}
+	if len(payload) < 4 {
+		return 0, nil, fmt.Errorf("short SFTP status")
+	}
+	switch code := binary.BigEndian.Uint32(payload); code {
+	case sftpOK:
+		return kind, nil, nil
+	case sftpNoSuch:
+		return kind, nil, errSFTPNoSuchFile
+	default:
+		message := ""
+		if len(payload) >= 8 {
+			n := binary.BigEndian.Uint32(payload[4:])
+			if int(n) <= len(payload)-8 {
+				message = string(payload[8 : 8+n])
+			}
+		}
+		return kind, nil, fmt.Errorf("SFTP error %d: %s", code, message)
+	}
+}
+
+// stat returns the size of p, or -1 if the server does not report it
+func (c *sftpConn) stat(p string) (int64, error) {
+	if err := c.request(sftpStat, p); err != nil {
+		return 0, err
+	}
+	kind, attrs, err := c.status()
+	if err != nil {
+		return 0, err
+	}
+	if kind != sftpAttrs {
+		return 0, fmt.Errorf("unexpected SFTP response %d to stat", kind)
+	}
+	// The attributes start with their flags; the size comes first if present
+	if len(attrs) >= 12 && binary.BigEndian.Uint32(attrs)&sftpAttrSize != 0 {
+		return int64(binary.BigEndian.Uint64(attrs[4:])), nil
+	}
+	return -1, nil
+}
+
+func (c *sftpConn) mkdir(p string) error {
+	if err := c.request(sftpMkdir, p, uint32(0)); err != nil {
+		return err
+	}
+	_, _, err := c.status()
+	return err
+}
+
+func (c *sftpConn) rename(from, to string) error {
+	if err := c.request(sftpRename, from, to); err != nil {
+		return err
+	}
+	_, _, err := c.status()
+	return err
+}
+
+func (c *sftpConn) remove(p string) error {
+	if err := c.request(sftpRemove, p); err != nil {
+		return err
+	}
+	_, _, err := c.status()
+	return err
+}
+
+// upload creates p and writes r to it, keeping several writes in flight
+func (c *sftpConn) upload(p string, r io.Reader) error {
+	if err := c.request(sftpOpen, p, uint32(sftpOpenWrite|sftpOpenCreate|sftpOpenTruncate), uint32(0)); err != nil {
+		return err
+	}
+	kind, payload, err := c.status()
+	if err != nil {
+		return err
+	}
+	if kind != sftpHandle || len(payload) < 4 || int(binary.BigEndian.Uint32(payload)) > len(payload)-4 {
+		return fmt.Errorf("unexpected SFTP response %d to open", kind)
+	}
+	handle := payload[4 : 4+binary.BigEndian.Uint32(payload)]
+
+	buf := make([]byte, sftpChunkSize)
+	var offset uint64
+	pending := 0
+	var writeErr error
+	for writeErr == nil {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := c.request(sftpWrite, handle, offset, buf[:n]); err != nil {
+				return err
+			}
+			offset += uint64(n)
+			pending++
+			if pending == sftpWindow {
+				_, _, writeErr = c.status()
+				pending--
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			writeErr = err
+		}
+	}
+	// Collect the remaining write acknowledgements
+	for ; pending > 0; pending-- {
+		if _, _, err := c.status(); err != nil && writeErr == nil {
+			writeErr = err
+		}
+	}
+
+	if err := c.request(sftpClose, handle); err != nil {
+		return err
+	}
+	if _, _, err := c.status(); err != nil && writeErr == nil {
+		writeErr = err
+	}
+	return writeErr
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSFTPRootEnv makes the test binary serve SFTP on stdin and stdout for
+// the files below the folder it names, standing in for ssh
+const fakeSFTPRootEnv = "GOPICSORT_FAKE_SFTP_ROOT"
+
+// serveFakeSFTP answers the SFTP requests sftpConn sends, on the local files
+// below root, until the connection closes
+func serveFakeSFTP(root string, r io.Reader, w io.Writer) {
+	in, out := bufio.NewReader(r), bufio.NewWriter(w)
+	handles := make(map[string]*os.File)
+	local := func(p string) string { return filepath.Join(root, filepath.FromSlash(p)) }
+	reply := func(kind byte, payload []byte) {
+		binary.Write(out, binary.BigEndian, uint32(len(payload)+1))
+		out.WriteByte(kind)
+		out.Write(payload)
+		out.Flush()
+	}
+	for {
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(in, header); err != nil {
+			return
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header)-1)
+		if _, err := io.ReadFull(in, payload); err != nil {
+			return
+		}
+		if header[4] == sftpInit {
+			reply(sftpVersion, binary.BigEndian.AppendUint32(nil, 3))
+			continue
+		}
+		id, fields := payload[:4], payload[4:]
+		next := func() []byte {
+			n := binary.BigEndian.Uint32(fields)
+			field := fields[4 : 4+n]
+			fields = fields[4+n:]
+			return field
+		}
+		status := func(err error) {
+			code := uint32(sftpOK)
+			if os.IsNotExist(err) {
+				code = sftpNoSuch
+			} else if err != nil {
+				code = 4 // SSH_FX_FAILURE
+			}
+			reply(sftpStatus, binary.BigEndian.AppendUint32(append([]byte(nil), id...), code))
+		}
+		switch header[4] {
+		case sftpStat:
+			info, err := os.Stat(local(string(next())))
+			if err != nil {
+				status(err)
+				continue
+			}
+			attrs := binary.BigEndian.AppendUint32(append([]byte(nil), id...), sftpAttrSize)
+			reply(sftpAttrs, binary.BigEndian.AppendUint64(attrs, uint64(info.Size())))
+		case sftpMkdir:
+			status(os.Mkdir(local(string(next())), 0755))
+		case sftpOpen:
+			name := string(next())
+			file, err := os.Create(local(name))
+			if err != nil {
+				status(err)
+				continue
+			}
+			handles[name] = file
+			handle := binary.BigEndian.AppendUint32(append([]byte(nil), id...), uint32(len(name)))
+			reply(sftpHandle, append(handle, name...))
+		case sftpWrite:
+			file := handles[string(next())]
+			offset := binary.BigEndian.Uint64(fields)
+			fields = fields[8:]
+			_, err := file.WriteAt(next(), int64(offset))
+			status(err)
+		case sftpClose:
+			name := string(next())
+			status(handles[name].Close())
+			delete(handles, name)
+		case sftpRename:
+			from, to := local(string(next())), local(string(next()))
+			// SFTP version 3 servers do not replace existing files
+			if _, err := os.Stat(to); err == nil {
+				status(os.ErrExist)
+				continue
+			}
+			status(os.Rename(from, to))
+		case sftpRemove:
+			status(os.Remove(local(string(next()))))
+		default:
+			status(os.ErrInvalid)
+		}
+	}
+}
+
+// useFakeSSH puts an ssh on the PATH that serves SFTP from root, and returns
+// the file it records its arguments in
+func useFakeSSH(t *testing.T, root string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ssh is a shell script")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := t.TempDir()
+	argsLog := filepath.Join(bin, "args")
+	script := "#!/bin/sh\necho \"$@\" >> '" + argsLog + "'\nexec '" + executable + "'\n"
+	if err := os.WriteFile(filepath.Join(bin, "ssh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv(fakeSFTPRootEnv, root)
+	return argsLog
+}
+
+func TestSFTPUploadsSortedFiles(t *testing.T) {
+	src, remote := t.TempDir(), t.TempDir()
+	argsLog := useFakeSSH(t, remote)
+	writeTestFile(t, filepath.Join(src, "IMG_0001.jpg"), testJPEG(time.Date(2021, time.May, 6, 7, 8, 9, 0, time.Local), "a"))
+	writeTestFile(t, filepath.Join(remote, "photos", "2021", "05", "IMG_0002.jpg"), []byte("stored"))
+	writeTestFile(t, filepath.Join(src, "IMG_0002.jpg"), testJPEG(time.Date(2021, time.May, 7, 7, 8, 9, 0, time.Local), "b"))
+
+	st, err := newSFTPStorage("sftp://photos@nas:2222/photos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	s := newTestSorter(t, localFS, "", src)
+	s.store = st
+	if err := s.run(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(remote, "photos", "2021", "05", "IMG_0001.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(testJPEG(time.Date(2021, time.May, 6, 7, 8, 9, 0, time.Local), "a")) {
+		t.Error("uploaded file differs from the source")
+	}
+	// A different file under a taken name is not replaced
+	if s.counts[outcomeSorted] != 1 || s.failures() != 1 {
+		t.Errorf("counts are %v, want one sorted and one failed file", s.counts)
+	}
+	if got := listFiles(t, remote); strings.Join(got, " ") != "photos/2021/05/IMG_0001.jpg photos/2021/05/IMG_0002.jpg" {
+		t.Errorf("remote holds %v", got)
+	}
+
+	args, err := os.ReadFile(argsLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line, _, _ := strings.Cut(string(args), "\n"); !strings.HasSuffix(line, "-p 2222 -s -- photos@nas sftp") {
+		t.Errorf("ssh ran with %q, want the host after --", line)
+	}
+}
+
+func TestSFTPRejectsOptionLikeHosts(t *testing.T) {
+	for _, dest := range []string{
+		"sftp://-oProxyCommand=id/photos",
+		"sftp://-oProxyCommand=touch%20pwned@nas/photos",
+		"sftp://-p2222/photos",
+	} {
+		_, err := newSFTPStorage(dest)
+		if err == nil || !strings.Contains(err.Error(), "cannot start with '-'") {
+			t.Errorf("newSFTPStorage(%q) = %v, want the option-like host rejected", dest, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+)
+
+// slateDetector recognises slate photos (a QR code or marker card shot at
+// the start of each set) and tracks the name of the current set. Photos are
+// assigned to the most recent slate in processing order, which follows the
+// camera's file numbering.
+type slateDetector struct {
+	decoder   []string // command printing the QR code text, e.g. "zbarimg --raw -q"
+	reference uint64   // difference hash of the marker image
+	useImage  bool
+	threshold int
+	sandbox   *decoderSandbox
+	current   string
+	sets      int
+}
+
+// newSlateDetector creates a detector from the -slate-decoder and
+// -slate-image flags; at least one must be set
+func newSlateDetector(decoder, markerImage string, threshold int, sandbox *decoderSandbox) (*slateDetector, error) {
+	d := &slateDetector{decoder: strings.Fields(decoder), threshold: threshold, sandbox: sandbox}
+	if markerImage != "" {
+		hash, err := sandbox.differenceHash(markerImage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read slate image %s: %v", markerImage, err)
+		}
+		d.reference = hash
+		d.useImage = true
+	}
+	return d, nil
+}
+
+// observe checks whether the photo is a slate, starting a new set if so, and
+// returns the name of the set the photo belongs to ("" before the first slate)
+func (d *slateDetector) observe(path string) string {
+	if len(d.decoder) > 0 {
+		if text := d.decode(path); text != "" {
+			d.sets++
+			d.current = sanitizeFolderName(text)
+			slog.Info("Slate starts new set", "path", path, "set", d.current)
+			return d.current
+		}
+	}
+	if d.useImage {
+		if hash, err := d.sandbox.differenceHash(path); err == nil && hashDistance(hash, d.reference) <= d.threshold {
+			d.sets++
+			d.current = fmt.Sprintf("Set %02d", d.sets)
+			slog.Info("Slate starts new set", "path", path, "set", d.current)
+		}
+	}
+	return d.current
+}
+
+// decode runs the external QR decoder and returns the first line of its output
+func (d *slateDetector) decode(path string) string {
+	out, err := exec.Command(d.decoder[0], append(d.decoder[1:], path)...).Output()
+	if err != nil {
+		// zbarimg exits non-zero when no code is found
+		return ""
+	}
+	line, _, _ := strings.Cut(string(bytes.TrimSpace(out)), "\n")
+	// zbarimg without --raw prefixes the symbology, e.g. "QR-Code:"
+	line = strings.TrimPrefix(line, "QR-Code:")
+	return strings.TrimSpace(line)
+}
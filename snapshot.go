@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Snapshot backends accepted by -snapshot
+const (
+	snapshotNone  = ""
+	snapshotBtrfs = "btrfs"
+	snapshotZFS   = "zfs"
+)
+
+// validateSnapshotMode checks the -snapshot flag value
+func validateSnapshotMode(mode string) error {
+	switch mode {
+	case snapshotNone, snapshotBtrfs, snapshotZFS:
+		return nil
+	default:
+		return fmt.Errorf("invalid -snapshot backend %q, expected 'btrfs' or 'zfs'", mode)
+	}
+}
+
+// snapshotName returns the snapshot name used for a run
+func snapshotName(runID string) string {
+	return "gopicsort-" + runID
+}
+
+// takeSnapshot creates a read-only snapshot of the destination after a run
+// and returns the snapshot's full name. For btrfs the destination must be a
+// subvolume; snapshots go to snapshotDir (default: .snapshots next to the
+// destination). For ZFS the dataset containing the destination is snapshotted.
+func takeSnapshot(mode, destDir, snapshotDir, runID string) (string, error) {
+	name := snapshotName(runID)
+	switch mode {
+	case snapshotBtrfs:
+		if snapshotDir == "" {
+			abs, err := filepath.Abs(destDir)
+			if err != nil {
+				return "", err
+			}
+			snapshotDir = filepath.Join(filepath.Dir(abs), ".snapshots")
+		}
+		if err := os.MkdirAll(snapshotDir, 0755); err != nil {
+			return "", err
+		}
+		target := filepath.Join(snapshotDir, name)
+		if _, err := runCommand("btrfs", "subvolume", "snapshot", "-r", destDir, target); err != nil {
+			return "", err
+		}
+		return target, nil
+
+	case snapshotZFS:
+		out, err := runCommand("zfs", "list", "-H", "-o", "name", destDir)
+		if err != nil {
+			return "", err
+		}
+		dataset := strings.TrimSpace(out)
+		if dataset == "" {
+			return "", fmt.Errorf("no ZFS dataset found for %s", destDir)
+		}
+		target := dataset + "@" + name
+		if _, err := runCommand("zfs", "snapshot", target); err != nil {
+			return "", err
+		}
+		return target, nil
+	}
+	return "", fmt.Errorf("unknown snapshot backend %q", mode)
+}
+
+// runCommand runs a command and returns its standard output, including
+// standard error in the returned error on failure
+func runCommand(name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %v: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLength is how many leading bytes are read to detect a file's format
+const sniffLength = 32
+
+// tiffBasedRaw lists RAW formats that are TIFF containers and therefore sniff as TIFF
+var tiffBasedRaw = map[string]bool{
+	".cr2": true, ".nef": true, ".dng": true, ".arw": true, ".pef": true,
+	".srw": true, ".raw": true, ".tiff": true,
+}
+
+// sniffFormat reads the first bytes of a file and returns the extension of
+// the format they identify (e.g., ".jpg"), or "" if the format is unknown
+func sniffFormat(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	header := make([]byte, sniffLength)
+	n, _ := io.ReadFull(file, header)
+	return detectFormat(header[:n])
+}
+
+// detectFormat identifies a format from its magic bytes
+func detectFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return ".jpg"
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return ".png"
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return ".gif"
+	case bytes.HasPrefix(header, []byte("FUJIFILMCCD-RAW")):
+		return ".raf"
+	case bytes.HasPrefix(header, []byte("IIRO")), bytes.HasPrefix(header, []byte("IIRS")), bytes.HasPrefix(header, []byte("MMOR")):
+		return ".orf"
+	case bytes.HasPrefix(header, []byte("IIU\x00")):
+		return ".rw2"
+	case bytes.HasPrefix(header, []byte("II*\x00")) && len(header) >= 10 && string(header[8:10]) == "CR":
+		return ".cr2"
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		return ".tiff"
+	case len(header) >= 12 && string(header[0:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		return ".webp"
+	case bytes.HasPrefix(header, []byte("BM")) && len(header) >= 14:
+		return ".bmp"
+	case len(header) >= 12 && string(header[4:8]) == "ftyp":
+		switch string(header[8:12]) {
+		case "heic", "heix", "heim", "heis", "hevc", "hevx":
+			return ".heic"
+		case "mif1", "msf1":
+			return ".heif"
+		case "avif", "avis":
+			return ".avif"
+		case "crx ":
+			return ".cr3"
+		case "qt  ":
+			return ".mov"
+		default:
+			return ".mp4"
+		}
+	}
+	return ""
+}
+
+// sameFormat reports whether a file's extension agrees with its sniffed format
+func sameFormat(ext, sniffed string) bool {
+	if canonicalExt(ext) == canonicalExt(sniffed) {
+		return true
+	}
+	// TIFF-based RAW files cannot be told apart by their first bytes
+	return sniffed == ".tiff" && tiffBasedRaw[canonicalExt(ext)]
+}
+
+// fileExt returns the lower-case extension used to classify a file. With
+// -sniff, the format detected from the file's contents wins over a missing
+// or wrong extension.
+func (s *sorter) fileExt(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if !s.sniff {
+		return ext
+	}
+	sniffed := sniffFormat(path)
+	if sniffed == "" || sameFormat(ext, sniffed) {
+		return ext
+	}
+	return sniffed
+}
@@ -0,0 +1,1050 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// fat32MaxFileSize is the largest file FAT32 can store, 4 GB minus one byte
+const fat32MaxFileSize = 1<<32 - 1
+
+// sorter holds the configuration and state of a sort run
+type sorter struct {
+	sourceDirs []string
+	// fileList holds the files given with -files-from, sorted after the
+	// source directories
+	fileList []string
+	// unreadableListed remembers listed files already reported as unreadable
+	unreadableListed map[string]bool
+	destDir          string
+	store            storage
+	// fsys holds the sources and a local destination
+	fsys         fileSystem
+	moveFiles    bool
+	pruneEmpty   bool
+	linkMode     string
+	formats      []string
+	excludes     []string
+	skipHidden   bool
+	maxDepth     int
+	dateSources  []string
+	noDate       string
+	minSize      int64
+	maxSize      int64
+	sniff        bool
+	fixExt       bool
+	validate     string
+	sandbox      *decoderSandbox
+	after        time.Time
+	before       time.Time
+	timeOffset   time.Duration
+	assumeTZ     *time.Location
+	writeExif    bool
+	touchExif    bool
+	previews     bool
+	previewSize  int
+	stripPrivate bool
+
+	// splitMotionPhotos sorts the video of a motion photo as an MP4 file of
+	// its own next to the still
+	splitMotionPhotos bool
+
+	// conversions maps source extensions to the format -convert turns them
+	// into with converter; keepOriginal also sorts the original
+	conversions    map[string]string
+	converter      heicConverter
+	convertQuality int
+	keepOriginal   bool
+
+	// symlinks is the -symlinks policy for links found in the sources.
+	// Preserved links are recorded in links with their absolute targets,
+	// and warnedLinks remembers the links already warned about.
+	symlinks    string
+	links       map[string]string
+	warnedLinks map[string]bool
+
+	// unicodeForm is the -unicode normalization form for destination names
+	unicodeForm string
+
+	// recordings holds the capture times of first chapters of GoPro
+	// recordings by folder and file name, for their later chapters
+	recordings map[string]time.Time
+
+	// screenshotsDir receives screenshots and downloaded images when set
+	screenshotsDir string
+	// messagingDir receives WhatsApp and Telegram media when set
+	messagingDir string
+	// routes send some formats to other libraries than destDir
+	routes []formatRoute
+	// takeout finds Google Takeout JSON sidecars when -takeout is set
+	takeout takeoutSidecars
+
+	// Largest file the destination file system can store, 0 if unlimited.
+	// Larger files go to overflowDir, or are skipped.
+	maxFileSize int64
+	overflowDir string
+
+	personFilter  []string
+	cameraFilter  []string
+	excludeCamera []string
+	peopleView    string
+	views         map[string]string
+	labeler       classifier
+	labels        *labelIndex
+	labelFilter   []string
+	excludeLabels []string
+	slates        *slateDetector
+
+	// Star ratings and keywords select files, and picksDir and rejectsDir
+	// receive files rated pickRating or more and rejected files when set
+	minRating       int
+	keywordFilter   []string
+	excludeKeywords []string
+	picksDir        string
+	pickRating      int
+	rejectsDir      string
+
+	normalizeExt bool
+	layout       string
+	rename       *template.Template
+	shoot        string
+	backupDir    string
+	lockMode     string
+
+	// mirrors receive a copy of every sorted file, and outOfSync lists the
+	// files, relative to the library, each mirror could not take
+	mirrors   []string
+	outOfSync map[string][]string
+
+	// manifest holds the hashes and labels of the files written in the run,
+	// keyed like the catalog, when -manifest or -classifier is set
+	useManifest bool
+	manifest    map[string]catalogEntry
+
+	resumable bool
+	retryWait time.Duration
+
+	// spaceCheck is the -space-check mode; empty skips the check
+	spaceCheck string
+
+	// keepAlbums appends each file's source folder name to its date folder
+	keepAlbums bool
+
+	// With eventGap set, photos are grouped into event folders by
+	// detectEvents before the run
+	eventGap    time.Duration
+	eventAlbums map[string]eventAlbum
+
+	// With sequenceGap set, bursts and timelapses of at least sequenceMin
+	// photos are found by detectSequences and get a folder each
+	sequenceGap time.Duration
+	sequenceMin int
+	sequences   map[string]string
+
+	// Files that fail to read or cannot be read within fileTimeout are skipped
+	fileTimeout time.Duration
+	// fileLimit paces files to -max-files-per-sec
+	fileLimit *rateLimiter
+
+	quarantineCorrupt bool
+	quarantineUndated bool
+	dedupe            bool
+	index             *contentIndex
+	// cache keeps EXIF dates and hashes between runs when -metadata-cache is set
+	cache *metadataCache
+
+	snapshotMode string
+	snapshotDir  string
+
+	// Retention catalogs of the destination and, when moving, of the source
+	retention    *retentionCatalog
+	sourceHolds  []*retentionCatalog
+	retainUntil  time.Time
+	retainReason string
+
+	// Outcome of every file, and the files that need following up
+	counts   map[string]int
+	problems []fileProblem
+	// duplicates skipped by -dedupe, and where -report writes the run report
+	duplicates []fileDuplicate
+	reportPath string
+	// metrics is served by -metrics-addr in watch mode, nil otherwise
+	metrics *watchMetrics
+	// control is driven by -control-addr in watch mode, nil otherwise
+	control *watchControl
+	// notify is told when the run, or a batch in watch mode, finishes
+	notify *notifier
+	// plan receives the transfers instead of making them with "plan"
+	plan *sortPlan
+
+	// Run identification for the history
+	command string
+	runID   string
+	started time.Time
+
+	// seed makes sampling decisions reproducible; it is recorded in the
+	// history so a run can be repeated with -seed
+	seed          int64
+	samplePercent float64
+
+	// Directories that files were moved out of, candidates for -prune-empty
+	movedFrom map[string]bool
+	// Files transferred during this run, in processing order, and the
+	// source of each destination path
+	transfers []transfer
+	sortedTo  map[string]string
+	// Month folders to lock read-only when the run completes, and whether
+	// each was locked before the run
+	toLock map[string]bool
+}
+
+// newSorter returns a sorter for a run of command from sources into the
+// local library at destDir, with the defaults of a plain sort: the default
+// date sources, files without a capture date skipped, and the folder layout
+// recorded for the library by adopt
+func newSorter(command, destDir string, sources ...string) (*sorter, error) {
+	dateSources, err := parseDateSources(defaultDateSources)
+	if err != nil {
+		return nil, err
+	}
+	layout, err := libraryLayout(destDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read library configuration: %v", err)
+	}
+	return &sorter{
+		fsys:        localFS,
+		sourceDirs:  sources,
+		destDir:     destDir,
+		dateSources: dateSources,
+		noDate:      noDateSkip,
+		layout:      layout,
+		command:     command,
+		runID:       newRunID(),
+		started:     time.Now(),
+		movedFrom:   make(map[string]bool),
+	}, nil
+}
+
+// transfer records a file that was sorted into the destination
+type transfer struct {
+	source string
+	dest   string
+}
+
+// run sorts every file in the source directory once
+func (s *sorter) run() error {
+	if err := s.checkSpace(); err != nil {
+		return err
+	}
+	if s.eventGap > 0 {
+		if err := s.detectEvents(); err != nil {
+			return err
+		}
+	}
+	if s.sequenceGap > 0 {
+		if err := s.detectSequences(); err != nil {
+			return err
+		}
+	}
+	if err := s.walkSource(s.sortFile); err != nil {
+		return err
+	}
+	s.finish()
+	return nil
+}
+
+// sortFile processes one file, recording a failure to sort it instead of
+// ending the run
+func (s *sorter) sortFile(path string, info os.FileInfo) error {
+	s.fileLimit.wait(1)
+	events.emit(streamEvent{Event: streamScanned, Path: path, Size: info.Size()})
+	sorted := s.counts[outcomeSorted]
+	if err := s.processFile(path, info); err != nil {
+		slog.Error("Could not sort file", "path", path, "error", err)
+		events.emit(streamEvent{Event: streamError, Path: path, Error: err.Error()})
+		s.tally(outcomeFailed, path)
+		s.problems[len(s.problems)-1].Error = err.Error()
+	}
+	if s.counts[outcomeSorted] > sorted {
+		s.metrics.copied(info.Size())
+	}
+	return nil
+}
+
+// finish performs the cleanup at the end of a run
+func (s *sorter) finish() {
+	// Clean up source directories emptied by the move
+	if s.pruneEmpty {
+		for _, dir := range s.sourceDirs {
+			pruneEmptyDirs(dir, s.movedFrom)
+		}
+	}
+
+	// List the files that were not sorted, by reason
+	s.printSummary()
+	s.printMirrorSummary()
+	if s.reportPath != "" {
+		if err := s.writeReport(s.reportPath); err != nil {
+			slog.Error("Could not write report", "path", s.reportPath, "error", err)
+		} else {
+			slog.Info("Wrote report", "path", s.reportPath)
+		}
+	}
+
+	// Record retention for the files sorted in this run
+	if !s.retainUntil.IsZero() {
+		if err := s.retention.save(); err != nil {
+			slog.Error("Could not record retention", "error", err)
+		}
+	}
+
+	// Save the hashes of the files written for scrub
+	if err := s.saveManifest(); err != nil {
+		slog.Error("Could not write manifest", "error", err)
+	}
+
+	// Keep the dates and hashes read in the run for the next one
+	if err := s.cache.save(s.sourceDirs); err != nil {
+		slog.Warn("Could not save metadata cache", "path", s.cache.path, "error", err)
+	}
+
+	// Lock the month folders written to, including previously locked ones.
+	// After failed files, only the folders locked before the run are locked
+	// again, so the files can be sorted by running again.
+	failed := s.failures() > 0
+	for dir, wasLocked := range s.toLock {
+		if failed && !wasLocked {
+			slog.Warn("Not locking folder after failed files", "path", dir)
+			continue
+		}
+		if err := lockFolder(dir, s.lockMode == lockImmutable); err != nil {
+			slog.Warn("Could not lock folder", "path", dir, "error", err)
+			continue
+		}
+		slog.Info("Locked", "path", dir)
+	}
+	s.toLock = nil
+
+	// Snapshot the destination and record the run in the library's history
+	record := runRecord{
+		RunID:    s.runID,
+		Machine:  machineName(),
+		Command:  s.command,
+		Started:  s.started,
+		Finished: time.Now(),
+		Source:   strings.Join(s.sourceDirs, ","),
+		Dest:     s.destDir,
+		Files:    len(s.transfers),
+		Seed:     s.seed,
+	}
+	if s.snapshotMode != snapshotNone && failed {
+		slog.Warn("Not creating a snapshot after failed files")
+	} else if s.snapshotMode != snapshotNone {
+		name, err := takeSnapshot(s.snapshotMode, s.destDir, s.snapshotDir, s.runID)
+		if err != nil {
+			slog.Error("Could not snapshot destination", "error", err)
+		} else {
+			record.Snapshot = name
+			slog.Info("Created snapshot", "name", name)
+		}
+	}
+	// Remote destinations have no state folder
+	if s.store != nil {
+		return
+	}
+	if err := appendRunHistory(s.destDir, record); err != nil {
+		slog.Warn("Could not record run history", "error", err)
+	}
+}
+
+// prepareMonthFolder unlocks a month folder locked by an earlier run so new
+// files can be added, and remembers which folders to lock again and whether
+// they were locked before
+func (s *sorter) prepareMonthFolder(dir string) error {
+	if s.toLock == nil {
+		s.toLock = make(map[string]bool)
+	}
+	if isLocked(dir) {
+		if err := unlockFolder(dir); err != nil {
+			return fmt.Errorf("failed to unlock %s: %v", dir, err)
+		}
+		s.toLock[dir] = true
+	}
+	if _, ok := s.toLock[dir]; !ok && s.lockMode != lockNone {
+		s.toLock[dir] = false
+	}
+	return nil
+}
+
+// walkSource calls fn for every candidate file in the source directories
+// and the file list, applying the exclude, hidden, and format filters. A source inside another
+// source is only walked once.
+func (s *sorter) walkSource(fn func(path string, info os.FileInfo) error) error {
+	for i, dir := range s.sourceDirs {
+		nested := false
+		for j, other := range s.sourceDirs {
+			if isWithin(other, dir) || (j < i && filepath.Clean(other) == filepath.Clean(dir)) {
+				nested = true
+			}
+		}
+		if nested {
+			continue
+		}
+		if err := s.walkDir(dir, fn); err != nil {
+			return err
+		}
+	}
+	return s.walkFileList(fn)
+}
+
+// walkDir walks one source directory for walkSource
+func (s *sorter) walkDir(root string, fn func(path string, info os.FileInfo) error) error {
+	// A source given as a link is walked like the folder it points to
+	dir := root
+	if info, err := s.fsys.Lstat(root); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if real, err := filepath.EvalSymlinks(root); err == nil {
+			dir = real
+		}
+	}
+	var walked []string
+	return s.walkTree(root, dir, root, fn, &walked)
+}
+
+// walkTree walks dir, which is either the source root or a folder linked
+// from below it at shown. Files in a linked folder are reported under the
+// link, as if the folder were part of the source.
+func (s *sorter) walkTree(root, dir, shown string, fn func(path string, info os.FileInfo) error, walked *[]string) error {
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		if real, err = filepath.Abs(real); err == nil {
+			*walked = append(*walked, real)
+		}
+	}
+	return walkFileSystem(s.fsys, dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if dir != shown {
+			rel, _ := filepath.Rel(dir, path)
+			path = filepath.Join(shown, rel)
+		}
+
+		// Apply exclude patterns and hidden/system filtering relative to the source root
+		if path != root {
+			rel, _ := filepath.Rel(root, path)
+			if (s.skipHidden && isHiddenOrSystem(info.Name())) || matchesExclude(filepath.ToSlash(rel), s.excludes) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			// Folders at -max-depth are listed but not entered
+			if s.maxDepth > 0 && info.IsDir() && strings.Count(filepath.ToSlash(rel), "/")+1 >= s.maxDepth {
+				return filepath.SkipDir
+			}
+		}
+
+		// Symbolic links are skipped, followed, or preserved as -symlinks says
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, targetInfo, ok := s.resolveSymlink(path, *walked)
+			if !ok {
+				return nil
+			}
+			if targetInfo.IsDir() {
+				return s.walkTree(root, target, path, fn, walked)
+			}
+			if s.symlinks == symlinkPreserve {
+				if s.links == nil {
+					s.links = make(map[string]string)
+				}
+				s.links[path] = target
+			}
+			info = targetInfo
+		}
+
+		// Skip directories, and GoPicSort's own folders when the source
+		// contains the destination
+		if info.IsDir() {
+			if s.store == nil && filepath.Dir(path) == filepath.Clean(s.destDir) {
+				switch filepath.Base(path) {
+				case stateDirName, quarantineDirName, previewsDirName, unsortedDirName, trashDirName, duplicatesDirName:
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+
+		ext := s.fileExt(path)
+
+		// Check if the file is an image and matches the format filter (if any)
+		if !isValidFileFormat(ext, s.formats) {
+			return nil
+		}
+		if !s.sizeInRange(info) {
+			slog.Debug("Skipping: outside the size limits", "path", path, "size", info.Size())
+			return nil
+		}
+		if !s.sampled(path, info) {
+			return nil
+		}
+
+		return fn(path, info)
+	})
+}
+
+// sourceRelDir returns the folder of a source file relative to the source
+// directory it was walked from, or "" for files at the top of a source. When
+// re-sorting a library in place, the date folders the file is already in are
+// not repeated.
+func (s *sorter) sourceRelDir(path string) string {
+	// Nested sources are walked from the outermost one
+	source, rel := "", ""
+	for _, dir := range s.sourceDirs {
+		if !isWithin(dir, path) {
+			continue
+		}
+		if candidate, _ := filepath.Rel(dir, filepath.Dir(path)); source == "" || len(candidate) > len(rel) {
+			source, rel = dir, candidate
+		}
+	}
+	if rel == "." {
+		return ""
+	}
+	if s.store == nil && source != "" && sameDir(source, s.destDir) {
+		if _, ok := parseLayoutFolder(s.layout, filepath.Join(rel, "x")); ok {
+			parts := strings.Split(filepath.ToSlash(rel), "/")
+			return filepath.Join(parts[layoutFolderDepth(s.layout):]...)
+		}
+	}
+	return rel
+}
+
+// processFile sorts a single file into the destination
+func (s *sorter) processFile(path string, info os.FileInfo) error {
+	// Never move files a source library holds under retention
+	if s.moveFiles {
+		for _, holds := range s.sourceHolds {
+			if err := holds.check(path); err != nil {
+				slog.Warn("Not moving file", "path", path, "error", err)
+				s.tally(outcomeHeld, path)
+				return nil
+			}
+		}
+	}
+
+	// Skip files on a failing disk whose reads hang instead of stalling the run
+	if s.fileTimeout > 0 {
+		if err := readWithin(path, s.fileTimeout); err != nil {
+			slog.Error("Could not read file", "path", path, "error", err)
+			s.tally(outcomeUnreadable, path)
+			return nil
+		}
+	}
+
+	// Check image integrity before anything else
+	if s.validate != validateNone {
+		if err := s.sandbox.checkIntegrity(path, s.validate); err != nil {
+			return s.handleCorrupt(path, err)
+		}
+	}
+
+	// Skip files whose contents are already in the destination
+	if s.dedupe {
+		if s.index == nil {
+			index, err := newContentIndex(s.fsys, s.destDir)
+			if err != nil {
+				return fmt.Errorf("failed to index destination: %v", err)
+			}
+			index.cache, index.stills = s.cache, s.splitMotionPhotos
+			s.index = index
+		}
+		existing, err := s.index.find(path, info.Size())
+		if err != nil {
+			slog.Warn("Could not check for duplicates", "path", path, "error", err)
+		} else if existing != "" {
+			slog.Info("Skipping duplicate", "path", path, "existing", existing)
+			s.duplicates = append(s.duplicates, fileDuplicate{path: path, existing: existing})
+			s.tally(outcomeDuplicate, path)
+			return nil
+		}
+	}
+
+	// Get the capture date from the first -date-sources that has one
+	date, dateSource, err := s.captureDate(path, info)
+	fromExif := dateSource == dateSourceExif
+
+	// Messaging apps strip the metadata of media sent through them, so the
+	// day the chat received a file, in its name, stands in for the capture
+	// date; a modification time there is only when the file was saved
+	chatApp, chatDate, chatDated := messagingMedia(path)
+	if chatDated && (err != nil || dateSource == dateSourceMtime) {
+		slog.Debug("Using date from messaging file name", "path", path, "app", chatApp)
+		date, err = chatDate, nil
+	}
+
+	// Screenshots and saved images go into their own tree, dated by their
+	// file name when they carry no EXIF date
+	nonPhoto := ""
+	if s.screenshotsDir != "" {
+		nonPhoto = detectNonPhoto(s.fsys, path)
+		if nonPhoto != "" && err != nil {
+			if named, ok := dateFromName(filepath.Base(path)); ok {
+				date, err = named, nil
+			}
+		}
+	}
+	// With -no-date unsorted, files without a capture date are transferred
+	// like any other, into the unsorted folder
+	undated := false
+	if err != nil {
+		// A failed decode may mean a damaged file rather than missing metadata
+		if s.quarantineCorrupt && s.validate == validateNone {
+			if reason := s.sandbox.checkIntegrity(path, validateHeader); reason != nil {
+				return s.handleCorrupt(path, reason)
+			}
+		}
+		if s.quarantineUndated {
+			if err := s.quarantine(path, fmt.Errorf("no capture date: %v", err)); err != nil {
+				slog.Error("Could not quarantine file", "path", path, "error", err)
+			}
+			return nil
+		}
+		switch s.noDate {
+		case noDateUnsorted:
+			undated = true
+		case noDateFail:
+			return fmt.Errorf("no capture date: %v", err)
+		default:
+			slog.Warn("Could not get date", "path", path, "error", err)
+			s.tally(outcomeUndated, path)
+			return nil
+		}
+	}
+	captured := date
+	date = s.adjustTime(date)
+
+	// Skip photos outside the requested date range
+	if !undated && !inDateRange(date, s.after, s.before) {
+		s.tally(outcomeFiltered, path)
+		return nil
+	}
+
+	// Only sort files from the cameras asked for, by EXIF make and model
+	if len(s.cameraFilter) > 0 || len(s.excludeCamera) > 0 {
+		cameras := cameraNames(s.fsys, path)
+		if len(s.cameraFilter) > 0 && !matchesAnyPattern(cameras, s.cameraFilter) {
+			s.tally(outcomeFiltered, path)
+			return nil
+		}
+		if matchesAnyPattern(cameras, s.excludeCamera) {
+			s.tally(outcomeFiltered, path)
+			return nil
+		}
+	}
+
+	// Ratings and keywords given in Lightroom or Bridge select files, and
+	// may send picks and rejects to folders of their own
+	ratingRoot := ""
+	if s.minRating > 0 || len(s.keywordFilter) > 0 || len(s.excludeKeywords) > 0 || s.picksDir != "" || s.rejectsDir != "" {
+		packet := readXMP(path)
+		x, err := decodeExif(s.fsys, path)
+		if err != nil {
+			x = nil
+		}
+		keywords := fileKeywords(packet, x)
+		if len(s.keywordFilter) > 0 && !matchesAnyPattern(keywords, s.keywordFilter) || matchesAnyPattern(keywords, s.excludeKeywords) {
+			s.tally(outcomeFiltered, path)
+			return nil
+		}
+		rating, _ := fileRating(packet, x)
+		switch {
+		case rating == ratingRejected && s.rejectsDir != "":
+			ratingRoot = s.rejectsDir
+		case rating < s.minRating:
+			s.tally(outcomeFiltered, path)
+			return nil
+		case s.picksDir != "" && rating >= s.pickRating:
+			ratingRoot = s.picksDir
+		}
+	}
+
+	// Read people tagged in face-region metadata when filtering or building views
+	var people []string
+	if len(s.personFilter) > 0 || s.peopleView != "" {
+		people = parseXMPPeople(readXMP(path))
+		if len(s.personFilter) > 0 && !matchesAnyPattern(people, s.personFilter) {
+			s.tally(outcomeFiltered, path)
+			return nil
+		}
+	}
+
+	// Ask the external classifier for labels and apply the label filters; a
+	// file the classifier failed on cannot be told apart from one without
+	// the labels asked for, so it fails
+	var labels []string
+	if s.labeler != nil {
+		if labels, err = s.classify(path, info); err != nil {
+			return fmt.Errorf("failed to classify %s: %v", path, err)
+		}
+		if len(labels) > 0 {
+			slog.Debug("Classified", "path", path, "labels", labels)
+		}
+		if len(s.labelFilter) > 0 && !matchesAnyPattern(labels, s.labelFilter) {
+			s.tally(outcomeFiltered, path)
+			return nil
+		}
+		if matchesAnyPattern(labels, s.excludeLabels) {
+			s.tally(outcomeFiltered, path)
+			return nil
+		}
+	}
+
+	// Create destination directory structure in the library's layout, yyyy/mm/ by default
+	root := s.routeFor(path)
+	if ratingRoot != "" {
+		root = ratingRoot
+	}
+	if chatApp != "" && s.messagingDir != "" {
+		root = s.messagingDir
+		slog.Debug("Sorting messaging media separately", "path", path, "app", chatApp)
+	} else if nonPhoto != "" {
+		root = s.screenshotsDir
+		slog.Info("Detected non-photo", "path", path, "reason", nonPhoto)
+	}
+	// Check the file size before copying, rather than failing mid-copy on a
+	// FAT32 stick
+	if s.maxFileSize > 0 && info.Size() > s.maxFileSize {
+		if s.overflowDir == "" {
+			slog.Error("File too large for destination file system", "path", path, "size", info.Size(), "limit", s.maxFileSize)
+			s.tally(outcomeTooLarge, path)
+			return nil
+		}
+		root = s.overflowDir
+		slog.Info("File too large for destination file system, using overflow destination", "path", path, "size", info.Size())
+	}
+	// Chapters of a GoPro recording stay in the folder of the first chapter.
+	// Files without a capture date keep their folder below the source in the
+	// unsorted folder, for sorting by hand.
+	var yearMonth string
+	if undated {
+		yearMonth = filepath.Join(root, unsortedDirName, s.normalizeName(s.sourceRelDir(path)))
+	} else {
+		yearMonth = filepath.Join(root, s.folderFor(s.recordingDate(path, date)))
+		if s.store == nil && s.plan == nil {
+			if err := s.prepareMonthFolder(yearMonth); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Photos of one event share a folder named after its first day. An event
+	// running into the next month gets a folder of the same name there, so
+	// every file stays in the month it was taken.
+	if event, ok := s.eventAlbums[path]; ok {
+		yearMonth = filepath.Join(yearMonth, event.name)
+	}
+
+	// Layouts ending in {{.RelDir}} keep the file's folder below the source
+	if keepsRelDir(s.layout) && !undated {
+		yearMonth = filepath.Join(yearMonth, s.normalizeName(s.sourceRelDir(path)))
+	}
+
+	// Curated source folders such as "Wedding/" survive as album folders
+	if s.keepAlbums && !undated {
+		if album := s.albumName(path); album != "" {
+			yearMonth = filepath.Join(yearMonth, s.normalizeName(album))
+		}
+	}
+
+	// Photos following a slate go into a folder named after the set: yyyy/mm/set/
+	if s.slates != nil && !undated {
+		if set := s.slates.observe(path); set != "" {
+			yearMonth = filepath.Join(yearMonth, s.normalizeName(set))
+		}
+	}
+
+	// Bursts and timelapses get a folder of their own instead of flooding the month
+	if sequence, ok := s.sequences[path]; ok {
+		yearMonth = filepath.Join(yearMonth, sequence)
+	}
+
+	// Destination file path
+	name := s.normalizeName(windowsSafeName(filepath.Base(path)))
+	if s.fixExt {
+		// Give files with a wrong or missing extension the one matching their contents
+		ext := filepath.Ext(name)
+		if sniffed := sniffFormat(path); sniffed != "" && !sameFormat(ext, sniffed) {
+			name = strings.TrimSuffix(name, ext) + sniffed
+			slog.Info("Correcting extension", "path", path, "ext", sniffed)
+		}
+	}
+	if s.normalizeExt {
+		ext := filepath.Ext(name)
+		name = strings.TrimSuffix(name, ext) + canonicalExt(ext)
+	}
+	if s.rename != nil && !undated {
+		if name, err = s.renderName(path, name, date); err != nil {
+			return fmt.Errorf("failed to rename %s: %v", path, err)
+		}
+	}
+	// Formats such as HEIC are converted to a widely supported one;
+	// preserved links stay links to the original
+	var original string
+	convert := s.conversions[canonicalExt(filepath.Ext(name))]
+	if _, linked := s.links[path]; convert != "" && !linked {
+		original = name
+		name = strings.TrimSuffix(name, filepath.Ext(name)) + convert
+	} else {
+		convert = ""
+	}
+	// Names already in the destination are reused in whichever Unicode
+	// form they were written
+	yearMonth = s.existingForm(yearMonth)
+	destPath := s.existingForm(filepath.Join(yearMonth, name))
+	if s.rename != nil && !undated {
+		if destPath, err = s.uniqueName(path, yearMonth, name, info); err != nil {
+			return err
+		}
+	}
+
+	// Only JPEGs can be stripped of private metadata, and other files must
+	// not reach the destination with it
+	if s.stripPrivate && canonicalExt(filepath.Ext(destPath)) != ".jpg" {
+		return fmt.Errorf("cannot strip private metadata from %s files, only from JPEGs", filepath.Ext(destPath))
+	}
+
+	// A plan records the transfer instead of making it
+	if s.plan != nil {
+		return s.planTransfer(path, destPath, info)
+	}
+
+	// Upload to a remote destination instead of writing a local file
+	if s.store != nil {
+		return s.upload(path, destPath)
+	}
+	if err := s.fsys.MkdirAll(longPath(yearMonth), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %v", yearMonth, err)
+	}
+
+	// When re-sorting in place, files already in the right folder are left
+	// alone rather than copied or moved onto themselves
+	if destInfo, err := s.fsys.Stat(destPath); err == nil && sameFile(info, destInfo) {
+		slog.Debug("Already in place", "path", path)
+		s.tally(outcomeExisting, path)
+		return nil
+	}
+
+	// Files from different sources, such as two cards that both start at
+	// IMG_0001, can map to the same name; the first one wins
+	if other, ok := s.sortedTo[destPath]; ok && other != path {
+		slog.Warn("Skipping: another source file was already sorted to this name", "path", path, "other", other, "dest", destPath)
+		s.tally(outcomeConflict, path)
+		return nil
+	}
+
+	// Copy or move the file; existing files are left alone
+	outcome := outcomeSorted
+	if _, err := s.fsys.Stat(destPath); err == nil {
+		outcome = outcomeExisting
+	}
+	op := opCopy
+	target, preserved := s.links[path]
+	if preserved {
+		if err := preserveSymlink(target, destPath); err != nil {
+			return fmt.Errorf("failed to link %s to %s: %v", path, destPath, err)
+		}
+		if s.moveFiles {
+			if err := s.fsys.Remove(path); err != nil {
+				return fmt.Errorf("failed to remove moved link %s: %v", path, err)
+			}
+			s.movedFrom[filepath.Dir(path)] = true
+		}
+		slog.Info("Linked", "source", path, "dest", destPath, "target", target)
+		op = opLink
+	} else if convert != "" {
+		if err := s.convert(path, destPath, filepath.Join(yearMonth, original)); err != nil {
+			return err
+		}
+		op = opConvert
+	} else if s.moveFiles {
+		move := moveFile
+		if s.symlinks == symlinkFollow && isSymlink(path) {
+			move = moveLinkedFile
+		}
+		if err := move(s.fsys, path, destPath); err != nil {
+			return fmt.Errorf("failed to move %s to %s: %v", path, destPath, err)
+		}
+		slog.Info("Moved", "source", path, "dest", destPath)
+		s.movedFrom[filepath.Dir(path)] = true
+		op = opMove
+	} else if s.linkMode != "" {
+		if err := linkFile(s.fsys, path, destPath, s.linkMode); err != nil {
+			return fmt.Errorf("failed to link %s to %s: %v", path, destPath, err)
+		}
+		slog.Info("Linked", "source", path, "dest", destPath)
+		op = opLink
+	} else if s.resumable {
+		if err := s.copyResumable(path, destPath); err != nil {
+			return fmt.Errorf("failed to copy %s to %s: %v", path, destPath, err)
+		}
+		slog.Info("Copied", "source", path, "dest", destPath)
+	} else {
+		if err := copyFile(s.fsys, path, destPath); err != nil {
+			return fmt.Errorf("failed to copy %s to %s: %v", path, destPath, err)
+		}
+		slog.Info("Copied", "source", path, "dest", destPath)
+	}
+
+	// Copies for sharing must not give away where or with what camera the
+	// photo was taken; a copy that cannot be stripped is removed again
+	if s.stripPrivate && outcome == outcomeSorted {
+		if err := stripPrivateMetadata(destPath); err != nil {
+			if !s.moveFiles {
+				s.fsys.Remove(destPath)
+			}
+			return fmt.Errorf("failed to strip private metadata from %s: %v", destPath, err)
+		}
+		slog.Debug("Stripped private metadata", "path", destPath)
+	}
+	// Take the video out of a motion photo, leaving the still in its place
+	splitVideo := ""
+	if s.splitMotionPhotos && outcome == outcomeSorted && !preserved {
+		if video, err := splitMotionPhoto(destPath); err != nil {
+			slog.Warn("Could not split motion photo", "path", destPath, "error", err)
+		} else if video != "" {
+			slog.Info("Split motion photo", "path", destPath, "video", video)
+			splitVideo = video
+		}
+	}
+	s.transfers = append(s.transfers, transfer{source: path, dest: destPath})
+	s.transferSidecar(path, destPath)
+	if outcome == outcomeSorted {
+		events.emit(streamEvent{Event: streamCopied, Path: path, Size: info.Size(), Op: op, Dest: destPath})
+	}
+	if undated && outcome == outcomeSorted {
+		slog.Warn("No capture date, put into unsorted folder", "path", path, "dest", destPath)
+		s.tally(outcomeUnsorted, path)
+	} else {
+		s.tally(outcome, path)
+	}
+	if s.sortedTo == nil {
+		s.sortedTo = make(map[string]string)
+	}
+	s.sortedTo[destPath] = path
+
+	// Record a corrected capture date in the sorted copy; a file that was
+	// already at the destination is not this run's to change
+	if s.writeExif && !undated && outcome == outcomeSorted && (!fromExif || date.Format(exifDateFormat) != captured.Format(exifDateFormat)) {
+		if s.linkMode == linkHard || preserved {
+			slog.Warn("Not writing EXIF date into a link shared with the source", "path", destPath)
+		} else if err := s.retention.check(destPath); err != nil {
+			slog.Warn("Not writing EXIF date", "path", destPath, "error", err)
+		} else if err := writeExifDate(destPath, date); err != nil {
+			slog.Warn("Could not write EXIF date", "path", destPath, "error", err)
+		} else {
+			slog.Info("Wrote EXIF date", "path", destPath, "date", date.Format(exifDateFormat))
+		}
+	}
+
+	// Let file browsers show the sorted copy at its capture time
+	if s.touchExif && !undated && outcome == outcomeSorted {
+		if s.linkMode == linkHard || preserved {
+			slog.Warn("Not setting the capture time on a link shared with the source", "path", destPath)
+		} else if err := touchCaptureTime(destPath, date); err != nil {
+			slog.Warn("Could not set file time", "path", destPath, "error", err)
+		}
+	}
+	// Record the contents as written so scrub can detect bit rot later, and
+	// the labels so later runs need not classify the file again
+	if (s.useManifest || s.labeler != nil) && outcome == outcomeSorted && !preserved {
+		s.recordManifest(destPath, labels)
+		if original != "" && s.keepOriginal {
+			s.recordManifest(filepath.Join(yearMonth, original), labels)
+		}
+		if splitVideo != "" {
+			s.recordManifest(splitVideo, nil)
+		}
+	}
+	if s.index != nil && outcome == outcomeSorted {
+		size := info.Size()
+		if convert != "" || splitVideo != "" {
+			if destInfo, err := s.fsys.Stat(destPath); err == nil {
+				size = destInfo.Size()
+			}
+		}
+		s.index.add(destPath, size)
+		if splitVideo != "" {
+			if videoInfo, err := s.fsys.Stat(splitVideo); err == nil {
+				s.index.add(splitVideo, videoInfo.Size())
+			}
+		}
+	}
+	if !s.retainUntil.IsZero() && outcome == outcomeSorted {
+		s.retention.hold(destPath, retentionEntry{Until: s.retainUntil, Reason: s.retainReason, Set: time.Now(), RunID: s.runID})
+	}
+
+	// Write the paired backup copy with the same layout
+	if s.backupDir != "" {
+		if err := s.backup(destPath); err != nil {
+			slog.Warn("Could not write backup copy", "path", destPath, "error", err)
+		}
+		if splitVideo != "" {
+			if err := s.backup(splitVideo); err != nil {
+				slog.Warn("Could not write backup copy", "path", splitVideo, "error", err)
+			}
+		}
+	}
+
+	// Copy the file, and a kept original, to the mirrored destinations
+	if len(s.mirrors) > 0 {
+		s.mirrorFile(destPath)
+		if original != "" && s.keepOriginal {
+			s.mirrorFile(filepath.Join(yearMonth, original))
+		}
+		if splitVideo != "" {
+			s.mirrorFile(splitVideo)
+		}
+	}
+
+	// Decode the image once now so galleries need not decode RAWs later
+	if s.previews {
+		s.writePreview(destPath)
+	}
+
+	// Link the sorted photo into the per-person view
+	if s.peopleView != "" && len(people) > 0 {
+		if err := linkPeopleView(s.peopleView, destPath, people); err != nil {
+			slog.Warn("Could not link into people view", "path", destPath, "error", err)
+		}
+	}
+
+	// Link the sorted photo into the camera, lens, and location views
+	if len(s.views) > 0 {
+		if err := s.linkViews(destPath); err != nil {
+			slog.Warn("Could not link into views", "path", destPath, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// backup copies a sorted file to the same relative location below the backup directory
+func (s *sorter) backup(destPath string) error {
+	rel, err := filepath.Rel(s.destDir, destPath)
+	if err != nil {
+		return err
+	}
+	backupPath := filepath.Join(s.backupDir, rel)
+	if err := os.MkdirAll(filepath.Dir(backupPath), 0755); err != nil {
+		return err
+	}
+	if err := copyFile(s.fsys, destPath, backupPath); err != nil {
+		return err
+	}
+	slog.Debug("Backed up", "source", destPath, "dest", backupPath)
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// The SFTP tests start the test binary as their ssh
+	if root := os.Getenv(fakeSFTPRootEnv); root != "" {
+		serveFakeSFTP(root, os.Stdin, os.Stdout)
+		os.Exit(0)
+	}
+	// Runs log every file; keep the test output to the failures
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// testJPEG returns a minimal JPEG whose EXIF holds date as its capture date;
+// extra bytes make files with the same date differ
+func testJPEG(date time.Time, extra string) []byte {
+	data := []byte{0xFF, 0xD8}
+	data = append(data, exifDateSegment([]byte(date.Format(exifDateFormat)+"\x00"))...)
+	data = append(data, 0xFF, 0xFE, 0x00, byte(2+len(extra)))
+	data = append(data, extra...)
+	return append(data, 0xFF, 0xD9)
+}
+
+// writeTestFile writes a file on the local disk, creating its folder
+func writeTestFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestSorter returns a sorter with the defaults of a plain sort run from
+// sources into dest on fsys
+func newTestSorter(t *testing.T, fsys fileSystem, dest string, sources ...string) *sorter {
+	t.Helper()
+	s, err := newSorter("sort", dest, sources...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.fsys = fsys
+	s.spaceCheck = spaceCheckOff
+	return s
+}
+
+// listFiles returns the regular files below root, relative to it
+func listFiles(t *testing.T, root string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == stateDirName {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() {
+			rel, _ := filepath.Rel(root, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestInPlaceResortLeavesMovedDuplicates(t *testing.T) {
+	lib := t.TempDir()
+	date := time.Date(2021, time.May, 6, 7, 8, 9, 0, time.Local)
+	photo := testJPEG(date, "a")
+	writeTestFile(t, filepath.Join(lib, "2021", "05", "a.jpg"), photo)
+	writeTestFile(t, filepath.Join(lib, "2021", "05", "copy.jpg"), photo)
+	// The copy is newer, so the original is the one kept
+	for name, mtime := range map[string]time.Time{"a.jpg": date, "copy.jpg": date.Add(time.Hour)} {
+		if err := os.Chtimes(filepath.Join(lib, "2021", "05", name), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// dedupe -policy move
+	groups, err := findDuplicates(lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 {
+		t.Fatalf("found %d duplicate groups, want 1", len(groups))
+	}
+	_, extras := oldestCopy(groups[0])
+	for _, extra := range extras {
+		if err := moveDuplicate(lib, extra); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := newTestSorter(t, localFS, lib, lib)
+	s.moveFiles = true
+	if err := s.run(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"2021/05/a.jpg", "duplicates/2021/05/copy.jpg"}
+	got := listFiles(t, lib)
+	if len(got) != len(want) {
+		t.Fatalf("library holds %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("library holds %v, want %v", got, want)
+		}
+	}
+}
+
+func TestUndatedFilesTransferredLikeOthers(t *testing.T) {
+	src, lib, backup, mirror := t.TempDir(), t.TempDir(), t.TempDir(), t.TempDir()
+	writeTestFile(t, filepath.Join(src, "card", "DSC_0001.JPEG"), []byte("no date"))
+	s := newTestSorter(t, localFS, lib, src)
+	s.dateSources = []string{dateSourceFilename}
+	s.noDate = noDateUnsorted
+	s.normalizeExt = true
+	s.backupDir = backup
+	s.mirrors = []string{mirror}
+	s.useManifest = true
+	if err := s.run(); err != nil {
+		t.Fatal(err)
+	}
+	if s.counts[outcomeUnsorted] != 1 {
+		t.Fatalf("counts are %v, want one unsorted file", s.counts)
+	}
+	for _, root := range []string{lib, backup, mirror} {
+		if got := listFiles(t, root); len(got) != 1 || got[0] != "unsorted/card/DSC_0001.jpg" {
+			t.Errorf("%s holds %v, want unsorted/card/DSC_0001.jpg", root, got)
+		}
+	}
+	catalog, err := loadCatalog(lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := catalog["unsorted/card/DSC_0001.jpg"]; !ok {
+		t.Errorf("manifest misses the unsorted file: %v", catalog)
+	}
+}
+
+func TestExistingFilesLeftUnchanged(t *testing.T) {
+	src, lib := t.TempDir(), t.TempDir()
+	exifDate := time.Date(2020, time.January, 2, 3, 4, 5, 0, time.Local)
+	photo := testJPEG(exifDate, "a")
+	// Dated by name, so -write-exif would record the name's date
+	writeTestFile(t, filepath.Join(src, "IMG_20210506_070809.jpg"), photo)
+	writeTestFile(t, filepath.Join(src, "IMG_20210507_070809.jpg"), testJPEG(exifDate, "b"))
+	existing := filepath.Join(lib, "2021", "05", "IMG_20210506_070809.jpg")
+	writeTestFile(t, existing, photo)
+
+	s := newTestSorter(t, localFS, lib, src)
+	s.dateSources = []string{dateSourceFilename}
+	s.writeExif = true
+	s.retainUntil = time.Now().AddDate(1, 0, 0)
+	retention, err := loadRetention(lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.retention = retention
+	if err := s.run(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(photo) {
+		t.Error("the run rewrote a file that was already in the library")
+	}
+	if _, held := retention.Files["2021/05/IMG_20210506_070809.jpg"]; held {
+		t.Error("the run put a file it did not write under retention")
+	}
+	if _, held := retention.Files["2021/05/IMG_20210507_070809.jpg"]; !held {
+		t.Errorf("the sorted file is not under retention: %v", retention.Files)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// statsBucket counts files and their total size
+type statsBucket struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+func (b *statsBucket) add(size int64) {
+	b.Files++
+	b.Bytes += size
+}
+
+// libraryStats summarizes the contents of a sorted library
+type libraryStats struct {
+	Total   statsBucket             `json:"total"`
+	Months  map[string]*statsBucket `json:"months"` // "yyyy-mm"
+	Years   map[string]*statsBucket `json:"years"`
+	Cameras map[string]*statsBucket `json:"cameras"`
+	Formats map[string]*statsBucket `json:"formats"`
+	// Unsorted counts files outside the folders of the library's layout
+	Unsorted statsBucket `json:"unsorted"`
+}
+
+// runStats implements the "stats" subcommand, which prints how the photos of
+// a sorted library are distributed over months, cameras, and formats
+func runStats(args []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	destDir := fs.String("dest", "", "Sorted photo library")
+	top := fs.Int("top", 10, "Number of cameras to list (0 lists all)")
+	logOpts := addLogFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s stats -dest DIR [options]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	parseInterspersed(fs, args)
+	if *destDir == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := logOpts.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	stats, err := collectStats(*destDir)
+	if err != nil {
+		fatal("Failed to scan library", "error", err)
+	}
+	stats.print(os.Stdout, *top)
+}
+
+// collectStats walks a library and reads the camera of every photo. The
+// month comes from the folder a file is in, since that is what the import
+// produced.
+func collectStats(destDir string) (*libraryStats, error) {
+	stats := &libraryStats{
+		Months:  make(map[string]*statsBucket),
+		Years:   make(map[string]*statsBucket),
+		Cameras: make(map[string]*statsBucket),
+		Formats: make(map[string]*statsBucket),
+	}
+	bucket := func(m map[string]*statsBucket, key string) *statsBucket {
+		if m[key] == nil {
+			m[key] = &statsBucket{}
+		}
+		return m[key]
+	}
+
+	layout, err := libraryLayout(destDir)
+	if err != nil {
+		return nil, err
+	}
+	err = filepath.Walk(destDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == stateDirName || info.Name() == previewsDirName {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := filepath.Ext(path)
+		if !isImageFile(ext) {
+			return nil
+		}
+
+		size := info.Size()
+		stats.Total.add(size)
+		bucket(stats.Formats, strings.TrimPrefix(canonicalExt(ext), ".")).add(size)
+
+		rel, _ := filepath.Rel(destDir, path)
+		if date, ok := parseLayoutFolder(layout, rel); ok {
+			bucket(stats.Years, date.Format("2006")).add(size)
+			if layoutHasMonth(layout) {
+				bucket(stats.Months, date.Format("2006-01")).add(size)
+			}
+		} else {
+			stats.Unsorted.add(size)
+		}
+
+		camera := "(unknown)"
+		if x, err := decodeExif(localFS, path); err == nil {
+			if name := cameraName(x); name != "" {
+				camera = name
+			}
+		}
+		bucket(stats.Cameras, camera).add(size)
+		return nil
+	})
+	return stats, err
+}
+
+// print writes the summary as tables, with a bar per month so gaps in an
+// import stand out
+func (st *libraryStats) print(out io.Writer, top int) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Files:\t%d\n", st.Total.Files)
+	fmt.Fprintf(w, "Size:\t%s\n", formatSize(st.Total.Bytes))
+	if st.Unsorted.Files > 0 {
+		fmt.Fprintf(w, "Outside layout folders:\t%d (%s)\n", st.Unsorted.Files, formatSize(st.Unsorted.Bytes))
+	}
+	w.Flush()
+
+	// Months are listed from the first to the last, including empty ones
+	if len(st.Months) > 0 {
+		keys := sortedKeys(st.Months)
+		most := 0
+		for _, b := range st.Months {
+			most = max(most, b.Files)
+		}
+		fmt.Fprintln(out)
+		w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "MONTH\tFILES\tSIZE\t")
+		var year, month int
+		fmt.Sscanf(keys[0], "%d-%d", &year, &month)
+		for {
+			key := fmt.Sprintf("%04d-%02d", year, month)
+			b := st.Months[key]
+			if b == nil {
+				b = &statsBucket{}
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", key, b.Files, formatSize(b.Bytes), statsBar(b.Files, most))
+			if key == keys[len(keys)-1] {
+				break
+			}
+			if month++; month > 12 {
+				year, month = year+1, 1
+			}
+		}
+		w.Flush()
+
+		fmt.Fprintln(out)
+		w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "YEAR\tFILES\tSIZE")
+		for _, key := range sortedKeys(st.Years) {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", key, st.Years[key].Files, formatSize(st.Years[key].Bytes))
+		}
+		w.Flush()
+	}
+
+	for _, table := range []struct {
+		title  string
+		counts map[string]*statsBucket
+		limit  int
+	}{{"CAMERA", st.Cameras, top}, {"FORMAT", st.Formats, 0}} {
+		if len(table.counts) == 0 {
+			continue
+		}
+		keys := sortedKeys(table.counts)
+		sort.SliceStable(keys, func(i, j int) bool {
+			return table.counts[keys[i]].Files > table.counts[keys[j]].Files
+		})
+		if table.limit > 0 && len(keys) > table.limit {
+			keys = keys[:table.limit]
+		}
+		fmt.Fprintln(out)
+		w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "%s\tFILES\tSIZE\n", table.title)
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", key, table.counts[key].Files, formatSize(table.counts[key].Bytes))
+		}
+		w.Flush()
+	}
+}
+
+// statsBar draws a bar of up to 40 characters, or nothing in -plain mode
+func statsBar(n, most int) string {
+	if plainOutput || most == 0 {
+		return ""
+	}
+	width := n * 40 / most
+	if width == 0 && n > 0 {
+		width = 1
+	}
+	return strings.Repeat("#", width)
+}
+
+// sortedKeys returns the keys of a map in ascending order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatSize formats a byte count with a binary unit, e.g. "1.5 GB"
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// storage is a destination that is not a local directory. Names are
+// slash-separated paths relative to the destination root.
+type storage interface {
+	// Stat reports whether a file is already stored under name, and its size
+	Stat(name string) (size int64, exists bool, err error)
+	// Put uploads the local file src as name
+	Put(name, src string) error
+	// Location describes where name is stored, for logging
+	Location(name string) string
+	// Close releases connections when the run ends
+	Close() error
+}
+
+// uploadAttempts is how often a failed upload is tried before giving up
+const uploadAttempts = 3
+
+// remoteSchemes lists the URL schemes of the supported remote destinations
+var remoteSchemes = []string{"s3", "sftp", "webdav", "webdavs"}
+
+// isRemoteDest reports whether a -dest value names a remote destination
+func isRemoteDest(dest string) bool {
+	for _, scheme := range remoteSchemes {
+		if strings.HasPrefix(dest, scheme+"://") {
+			return true
+		}
+	}
+	return false
+}
+
+// newStorage connects to a remote destination by its URL scheme
+func newStorage(dest, s3Endpoint string) (storage, error) {
+	switch {
+	case strings.HasPrefix(dest, "s3://"):
+		return newS3Storage(dest, s3Endpoint)
+	case strings.HasPrefix(dest, "sftp://"):
+		return newSFTPStorage(dest)
+	default:
+		return newWebDAVStorage(dest)
+	}
+}
+
+// remoteIncompatible lists the sort flags that need a local destination
+var remoteIncompatible = []string{
+	"link", "resumable", "lock", "snapshot", "backup", "people-view", "write-exif",
+	"touch-exif", "dedupe", "quarantine", "recover", "retain-until", "overflow",
+	"previews", "convert", "strip-private", "view", "manifest", "split-motion-photos",
+}
+
+// upload stores a sorted file in the remote destination, skipping names that
+// already exist like local copies do
+func (s *sorter) upload(path, name string) error {
+	name = filepath.ToSlash(name)
+	size, exists, err := s.store.Stat(name)
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %v", s.store.Location(name), err)
+	}
+	if exists {
+		// Remote files are not downloaded to compare them, so one of another
+		// size is all that tells a different file under the name
+		info, err := s.fsys.Stat(path)
+		if err != nil {
+			return err
+		}
+		if size >= 0 && info.Size() != size {
+			return fmt.Errorf("a different file is already stored as %s", s.store.Location(name))
+		}
+		slog.Info("Skipping: file already exists at destination", "path", s.store.Location(name))
+		s.tally(outcomeExisting, path)
+		return nil
+	}
+
+	// Retry transient failures such as dropped connections
+	for attempt := 1; ; attempt++ {
+		err = s.store.Put(name, path)
+		if err == nil {
+			break
+		}
+		if attempt == uploadAttempts {
+			return fmt.Errorf("failed to upload %s to %s: %v", path, s.store.Location(name), err)
+		}
+		slog.Warn("Upload failed, retrying", "path", path, "attempt", attempt, "error", err)
+		time.Sleep(time.Duration(attempt) * 2 * time.Second)
+	}
+
+	if s.moveFiles {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove %s after upload: %v", path, err)
+		}
+		slog.Info("Moved", "source", path, "dest", s.store.Location(name))
+		s.movedFrom[filepath.Dir(path)] = true
+	} else {
+		slog.Info("Uploaded", "source", path, "dest", s.store.Location(name))
+	}
+	s.transfers = append(s.transfers, transfer{source: path, dest: s.store.Location(name)})
+	events.emit(streamEvent{Event: streamCopied, Path: path, Op: opUpload, Dest: s.store.Location(name)})
+	s.tally(outcomeSorted, path)
+	return nil
+}
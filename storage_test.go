@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// fakeStorage keeps uploaded files in memory, by name
+type fakeStorage struct {
+	files map[string][]byte
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{files: make(map[string][]byte)}
+}
+
+func (st *fakeStorage) Stat(name string) (int64, bool, error) {
+	data, ok := st.files[name]
+	return int64(len(data)), ok, nil
+}
+
+func (st *fakeStorage) Put(name, src string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	st.files[name] = data
+	return nil
+}
+
+func (st *fakeStorage) Location(name string) string { return "fake://" + name }
+func (st *fakeStorage) Close() error                { return nil }
+
+// names returns the stored names, sorted
+func (st *fakeStorage) names() []string {
+	var names []string
+	for name := range st.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newStoreSorter returns a sorter uploading files from src, dated by their
+// names, to st
+func newStoreSorter(t *testing.T, st storage, src string) *sorter {
+	t.Helper()
+	s := newTestSorter(t, localFS, "", src)
+	s.dateSources = []string{dateSourceFilename}
+	s.store = st
+	return s
+}
+
+func TestUploadSkipsStoredFiles(t *testing.T) {
+	src := t.TempDir()
+	writeTestFile(t, filepath.Join(src, "IMG_20210506_070809.jpg"), []byte("photo"))
+	writeTestFile(t, filepath.Join(src, "IMG_20210507_070809.jpg"), []byte("other photo"))
+	st := newFakeStorage()
+	st.files["2021/05/IMG_20210506_070809.jpg"] = []byte("photo")
+	st.files["2021/05/IMG_20210507_070809.jpg"] = []byte("a different photo")
+	s := newStoreSorter(t, st, src)
+	if err := s.run(); err != nil {
+		t.Fatal(err)
+	}
+	if s.counts[outcomeExisting] != 1 || s.counts[outcomeFailed] != 1 {
+		t.Errorf("counts are %v, want one existing and one failed file", s.counts)
+	}
+	if string(st.files["2021/05/IMG_20210507_070809.jpg"]) != "a different photo" {
+		t.Error("upload replaced a different file stored under the name")
+	}
+}
+
+func TestRenamedUploadsGetUniqueNames(t *testing.T) {
+	src := t.TempDir()
+	writeTestFile(t, filepath.Join(src, "a", "IMG_20210506_070809.jpg"), []byte("first"))
+	writeTestFile(t, filepath.Join(src, "b", "IMG_20210506_070809.jpg"), []byte("second shot"))
+	st := newFakeStorage()
+	st.files["2021/05/shot.jpg"] = []byte("already stored")
+	s := newStoreSorter(t, st, src)
+	rename, err := parseRenameTemplate("shot{{.Ext}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.rename = rename
+	if err := s.run(); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(st.names(), " ")
+	if want := "2021/05/shot.jpg 2021/05/shot_1.jpg 2021/05/shot_2.jpg"; got != want {
+		t.Errorf("stored files are %s, want %s", got, want)
+	}
+	if string(st.files["2021/05/shot.jpg"]) != "already stored" {
+		t.Error("upload replaced a file stored under the rendered name")
+	}
+}
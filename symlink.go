@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// Symlink policies accepted by -symlinks
+const (
+	symlinkSkip     = "skip"
+	symlinkFollow   = "follow"
+	symlinkPreserve = "preserve"
+)
+
+// validateSymlinkPolicy checks the -symlinks flag value
+func validateSymlinkPolicy(policy string) error {
+	switch policy {
+	case symlinkSkip, symlinkFollow, symlinkPreserve:
+		return nil
+	default:
+		return fmt.Errorf("invalid -symlinks policy %q, expected 'skip', 'follow', or 'preserve'", policy)
+	}
+}
+
+// resolveSymlink applies the -symlinks policy to a link found while walking
+// a source. It returns the link's absolute target and the target's info, or
+// false if the link is skipped. A linked folder is only followed if it is
+// not part of a folder walked already, and contains none, so a link back up
+// the tree cannot make the walk run forever.
+func (s *sorter) resolveSymlink(path string, walked []string) (string, os.FileInfo, bool) {
+	// Links are skipped unless asked otherwise, also by subcommands without -symlinks
+	if s.symlinks != symlinkFollow && s.symlinks != symlinkPreserve {
+		s.warnSymlink(path, "Skipping symbolic link, use -symlinks follow or preserve to sort it")
+		return "", nil, false
+	}
+	target, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		target, err = filepath.Abs(target)
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = s.fsys.Stat(target)
+	}
+	if err != nil {
+		s.warnSymlink(path, "Skipping broken symbolic link", "error", err)
+		return "", nil, false
+	}
+	if info.IsDir() {
+		if s.symlinks != symlinkFollow {
+			s.warnSymlink(path, "Skipping linked folder, only linked files are preserved")
+			return "", nil, false
+		}
+		for _, dir := range walked {
+			if dir == target || isWithin(dir, target) || isWithin(target, dir) {
+				s.warnSymlink(path, "Skipping linked folder that loops back into folders already walked", "target", target)
+				return "", nil, false
+			}
+		}
+	}
+	return target, info, true
+}
+
+// warnSymlink logs a warning about a link once, as sources are walked more
+// than once, e.g. by the space check and on every poll of -watch
+func (s *sorter) warnSymlink(path, msg string, args ...any) {
+	if s.warnedLinks[path] {
+		return
+	}
+	if s.warnedLinks == nil {
+		s.warnedLinks = make(map[string]bool)
+	}
+	s.warnedLinks[path] = true
+	slog.Warn(msg, append([]any{"path", path}, args...)...)
+}
+
+// preserveSymlink creates a link at dst to the absolute target of a linked
+// source file, instead of copying the file's contents
+func preserveSymlink(target, dst string) error {
+	dst = longPath(dst)
+
+	// Check if destination file already exists
+	if _, err := os.Lstat(dst); err == nil {
+		// File exists, don't overwrite
+		slog.Info("Skipping: file already exists at destination", "path", dst)
+		return nil
+	}
+	return os.Symlink(target, dst)
+}
+
+// isSymlink reports whether path is a symbolic link
+func isSymlink(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
+
+// moveLinkedFile moves a followed link: the file it points to is copied to
+// dst and the link is removed, leaving the file itself where it was
+func moveLinkedFile(fsys fileSystem, src, dst string) error {
+	if err := copyFile(fsys, src, dst); err != nil {
+		return err
+	}
+	return fsys.Remove(longPath(src))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// systemdNotifier reports the state of a watch to systemd over the
+// sd_notify protocol when it runs as a unit with Type=notify: readiness,
+// a status line, shutdown, and watchdog keep-alives
+type systemdNotifier struct {
+	conn     net.Conn
+	watchdog time.Duration
+
+	mu sync.Mutex
+	// busySince is when the file being sorted was started, zero while the
+	// watch waits for the next poll
+	busySince time.Time
+}
+
+// newSystemdNotifier connects to the socket in $NOTIFY_SOCKET. It returns
+// nil when not started by systemd or when the socket cannot be reached.
+func newSystemdNotifier() *systemdNotifier {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// A leading @ names a socket in the abstract namespace
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		slog.Warn("Could not connect to systemd", "socket", socket, "error", err)
+		return nil
+	}
+	n := &systemdNotifier{conn: conn}
+	// The watchdog is meant for this process when WATCHDOG_PID is unset or ours
+	if pid := os.Getenv("WATCHDOG_PID"); pid == "" || pid == strconv.Itoa(os.Getpid()) {
+		if usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64); err == nil && usec > 0 {
+			n.watchdog = time.Duration(usec) * time.Microsecond
+		}
+	}
+	return n
+}
+
+// send writes a state notification; n may be nil when not run by systemd
+func (n *systemdNotifier) send(state string) {
+	if n == nil {
+		return
+	}
+	if _, err := n.conn.Write([]byte(state)); err != nil {
+		slog.Debug("Could not notify systemd", "state", state, "error", err)
+	}
+}
+
+// ready tells systemd that the watch is running
+func (n *systemdNotifier) ready() {
+	n.send("READY=1\nSTATUS=Watching for new files")
+}
+
+// status sets the line shown by systemctl status
+func (n *systemdNotifier) status(line string) {
+	n.send("STATUS=" + line)
+}
+
+// stopping tells systemd that the watch is shutting down
+func (n *systemdNotifier) stopping() {
+	n.send("STOPPING=1\nSTATUS=Stopping")
+}
+
+// busy records that a file is being sorted, or with false that the watch is
+// idle until the next poll
+func (n *systemdNotifier) busy(busy bool) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if busy {
+		n.busySince = time.Now()
+	} else {
+		n.busySince = time.Time{}
+	}
+}
+
+// runWatchdog sends keep-alives at half the watchdog interval until ctx is
+// done. They stop while a single file takes longer than the interval, so
+// systemd restarts a watch stuck on a hung disk or network mount.
+func (n *systemdNotifier) runWatchdog(ctx context.Context) {
+	if n == nil || n.watchdog == 0 {
+		return
+	}
+	ticker := time.NewTicker(n.watchdog / 2)
+	defer ticker.Stop()
+	warned := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		n.mu.Lock()
+		stuck := !n.busySince.IsZero() && time.Since(n.busySince) > n.watchdog
+		n.mu.Unlock()
+		if stuck {
+			if !warned {
+				slog.Warn("Sorting a file is taking longer than the systemd watchdog interval", "interval", n.watchdog)
+				warned = true
+			}
+			continue
+		}
+		warned = false
+		n.send("WATCHDOG=1")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// takeoutMetadata is the part of a Google Takeout JSON sidecar used for sorting
+type takeoutMetadata struct {
+	PhotoTakenTime struct {
+		Timestamp string `json:"timestamp"`
+	} `json:"photoTakenTime"`
+}
+
+// takeoutDuplicatePattern matches Takeout's names for files with the same
+// name in one album, "IMG_1234(1).jpg", whose sidecar is "IMG_1234.jpg(1).json"
+var takeoutDuplicatePattern = regexp.MustCompile(`^(.*)\((\d+)\)(\.[^.]*)$`)
+
+// takeoutMinPrefix is the shortest sidecar stem accepted as a truncated
+// name; Takeout cuts long sidecar names to about 46 characters
+const takeoutMinPrefix = 40
+
+// takeoutSidecars caches the JSON files of each source directory
+type takeoutSidecars map[string][]string
+
+// find returns the sidecar for a Takeout file, or "" if there is none.
+// Besides "name.ext.json" it handles the newer
+// "name.ext.supplemental-metadata.json", names truncated by Takeout, edited
+// copies ("name-edited.ext"), and numbered duplicates.
+func (c takeoutSidecars) find(path string) string {
+	dir, base := filepath.Split(path)
+	names, ok := c[dir]
+	if !ok {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if strings.EqualFold(filepath.Ext(entry.Name()), ".json") {
+				names = append(names, entry.Name())
+			}
+		}
+		c[dir] = names
+	}
+	if len(names) == 0 {
+		return ""
+	}
+
+	ext := filepath.Ext(base)
+	base = strings.TrimSuffix(strings.TrimSuffix(base, ext), "-edited") + ext
+	var exact []string
+	if m := takeoutDuplicatePattern.FindStringSubmatch(base); m != nil {
+		original := m[1] + m[3]
+		exact = []string{original + "(" + m[2] + ")", original + ".supplemental-metadata(" + m[2] + ")"}
+	}
+	full := base + ".supplemental-metadata"
+
+	for _, name := range names {
+		stem := name[:len(name)-len(".json")]
+		for _, candidate := range exact {
+			if stem == candidate {
+				return filepath.Join(dir, name)
+			}
+		}
+		if exact != nil {
+			continue
+		}
+		if strings.HasPrefix(full, stem) && (len(stem) >= len(base) || len(stem) >= takeoutMinPrefix) {
+			return filepath.Join(dir, name)
+		}
+	}
+	return ""
+}
+
+// takeoutDate reads the capture time from a Takeout sidecar
+func takeoutDate(sidecar string) (time.Time, bool) {
+	data, err := os.ReadFile(sidecar)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var meta takeoutMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return time.Time{}, false
+	}
+	seconds, err := strconv.ParseInt(meta.PhotoTakenTime.Timestamp, 10, 64)
+	if err != nil || seconds <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0).In(time.Local), true
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// throttleChunk is the most a throttled reader reads at once, so a large
+// file is paced smoothly instead of in one burst followed by a long pause
+const throttleChunk = 256 << 10
+
+// bandwidthLimit paces reads of file contents for copies and uploads, set
+// from -max-bandwidth; nil means unlimited
+var bandwidthLimit *rateLimiter
+
+// rateLimiter spaces out units of work, such as bytes or files, to an
+// average rate per second. Each request waits for its turn and then moves
+// the next turn on by the time the request's units take at the rate.
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate float64
+	next time.Time
+}
+
+// newRateLimiter returns a limiter for rate units per second, or nil if
+// rate is not positive
+func newRateLimiter(rate float64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: rate}
+}
+
+// wait blocks until n more units may be used; l may be nil for no limit
+func (l *rateLimiter) wait(n int64) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	l.mu.Unlock()
+	time.Sleep(time.Until(start))
+}
+
+// throttledReader paces reads through a rate limiter
+type throttledReader struct {
+	r     io.Reader
+	limit *rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := t.r.Read(p)
+	t.limit.wait(int64(n))
+	return n, err
+}
+
+// throttled limits reads from r to -max-bandwidth
+func throttled(r io.Reader) io.Reader {
+	if bandwidthLimit == nil {
+		return r
+	}
+	return &throttledReader{r: r, limit: bandwidthLimit}
+}
+
+// parseBandwidth parses a -max-bandwidth value such as "50MB/s", "1.5G", or
+// "800k" into bytes per second, with binary units like formatSize
+func parseBandwidth(value string) (float64, error) {
+	text := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "/S")
+	n, err := parseBytes(text)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid -max-bandwidth %q, expected a rate such as '50MB/s'", value)
+	}
+	return n, nil
+}
+
+// parseBytes parses an amount of data such as "50MB", "1.5G", or "800k"
+// into bytes, with binary units like formatSize
+func parseBytes(value string) (float64, error) {
+	text := strings.ToUpper(strings.TrimSpace(value))
+	text = strings.TrimSuffix(strings.TrimSuffix(text, "IB"), "B")
+	multiplier := 1.0
+	if text != "" {
+		if i := strings.IndexByte("KMGT", text[len(text)-1]); i >= 0 {
+			multiplier = float64(int64(1) << (10 * (i + 1)))
+			text = text[:len(text)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,13 @@
+package main
+
+import "time"
+
+// adjustTime corrects a capture time for cameras with a wrong clock. The
+// wall-clock reading is first interpreted in assumeTZ (when set) and
+// converted to the local time zone used for folders, then timeOffset is added.
+func (s *sorter) adjustTime(date time.Time) time.Time {
+	if s.assumeTZ != nil {
+		date = time.Date(date.Year(), date.Month(), date.Day(), date.Hour(), date.Minute(), date.Second(), date.Nanosecond(), s.assumeTZ).In(time.Local)
+	}
+	return date.Add(s.timeOffset)
+}
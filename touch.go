@@ -0,0 +1,18 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// touchCaptureTime sets the modification time of a sorted file, and its
+// creation time where the platform allows, to the capture time, so file
+// browsers and backup tools order photos by when they were taken. On macOS
+// the creation time follows an earlier modification time by itself.
+func touchCaptureTime(path string, date time.Time) error {
+	path = longPath(path)
+	if err := os.Chtimes(path, date, date); err != nil {
+		return err
+	}
+	return setCreationTime(path, date)
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "time"
+
+// setCreationTime does nothing, the creation time cannot be set here
+func setCreationTime(path string, date time.Time) error {
+	return nil
+}
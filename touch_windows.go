@@ -0,0 +1,21 @@
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// setCreationTime sets the creation time of a file
+func setCreationTime(path string, date time.Time) error {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	handle, err := syscall.CreateFile(name, syscall.FILE_WRITE_ATTRIBUTES, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE, nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(handle)
+	created := syscall.NsecToFiletime(date.UnixNano())
+	return syscall.SetFileTime(handle, &created, nil, nil)
+}
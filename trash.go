@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Modes accepted by -trash for files removed by destructive operations
+const (
+	trashFolder = "folder"
+	trashOS     = "os"
+	trashOff    = "off"
+)
+
+// trashDirName is the default trash folder, at the top of the library
+const trashDirName = ".gopicsort-trash"
+
+// defaultTrashDays is how long the trash folder keeps removed files
+const defaultTrashDays = 30
+
+// trashCan receives the files removed by a run instead of deleting them.
+// The trash folder has one folder per run, holding each file under its path
+// within the library.
+type trashCan struct {
+	mode  string
+	dir   string
+	root  string
+	runID string
+}
+
+// validateTrashMode checks the -trash flag value
+func validateTrashMode(mode string) error {
+	switch mode {
+	case trashFolder, trashOS, trashOff:
+		return nil
+	default:
+		return fmt.Errorf("invalid -trash mode %q, expected 'folder', 'os', or 'off'", mode)
+	}
+}
+
+// newTrashCan returns the trash of a run removing files from root. With the
+// trash folder, run folders older than days are emptied first; days <= 0
+// keeps them until they are removed by hand.
+func newTrashCan(mode, dir, root, runID string, days int) *trashCan {
+	if dir == "" {
+		dir = filepath.Join(root, trashDirName)
+	}
+	t := &trashCan{mode: mode, dir: dir, root: root, runID: runID}
+	if mode == trashFolder && days > 0 {
+		t.purge(time.Now().AddDate(0, 0, -days))
+	}
+	return t
+}
+
+// discard removes a file by moving it to the trash, or deletes it when the
+// trash is off
+func (t *trashCan) discard(path string) error {
+	switch t.mode {
+	case trashOff:
+		return os.Remove(path)
+	case trashOS:
+		return moveToOSTrash(path)
+	}
+	rel, err := filepath.Rel(t.root, path)
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(t.dir, t.runID, rel)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(path, target); err == nil {
+		return nil
+	}
+	// The trash folder may be on another file system
+	if err := copyFile(localFS, path, target); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// purge deletes the run folders of the trash folder last changed before cutoff
+func (t *trashCan) purge(cutoff time.Time) {
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !entry.IsDir() || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(t.dir, entry.Name())); err != nil {
+			slog.Warn("Could not empty trash", "path", filepath.Join(t.dir, entry.Name()), "error", err)
+			continue
+		}
+		slog.Info("Emptied trash", "run", entry.Name())
+	}
+}
+
+// mountRoot returns the top directory of the file system holding path
+func mountRoot(path string) string {
+	dir := filepath.Dir(path)
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir || !sameFileSystem(dir, parent) {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// uniqueTrashName returns a name for base that is not taken in dir, adding
+// a number before the extension as file managers do
+func uniqueTrashName(dir, base string) string {
+	ext := filepath.Ext(base)
+	stem := base[:len(base)-len(ext)]
+	name := base
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(filepath.Join(dir, name)); os.IsNotExist(err) {
+			return name
+		}
+		name = fmt.Sprintf("%s %d%s", stem, i, ext)
+	}
+}
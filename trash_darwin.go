@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// moveToOSTrash moves a file to the Finder trash: ~/.Trash for files on the
+// startup volume, otherwise the .Trashes folder of the file's volume
+func moveToOSTrash(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	trash := filepath.Join(home, ".Trash")
+	if !sameFileSystem(filepath.Dir(path), home) {
+		trash = filepath.Join(mountRoot(path), ".Trashes", fmt.Sprint(os.Getuid()))
+	}
+	if err := os.MkdirAll(trash, 0700); err != nil {
+		return err
+	}
+	return os.Rename(path, filepath.Join(trash, uniqueTrashName(trash, filepath.Base(path))))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// moveToOSTrash moves a file to the desktop trash following the freedesktop
+// trash specification: the home trash for files on the home file system,
+// otherwise the .Trash-UID folder at the top of the file's file system. The
+// .trashinfo file written next to it lets file managers restore the file.
+func moveToOSTrash(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	// The data folder may not exist yet, its closest existing parent tells
+	// which file system it will be on
+	home := dataHome
+	for _, err := os.Stat(home); os.IsNotExist(err) && filepath.Dir(home) != home; _, err = os.Stat(home) {
+		home = filepath.Dir(home)
+	}
+	trash := filepath.Join(dataHome, "Trash")
+	if !sameFileSystem(filepath.Dir(path), home) {
+		trash = filepath.Join(mountRoot(path), fmt.Sprintf(".Trash-%d", os.Getuid()))
+	}
+	files, info := filepath.Join(trash, "files"), filepath.Join(trash, "info")
+	if err := os.MkdirAll(files, 0700); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(info, 0700); err != nil {
+		return err
+	}
+
+	// The info file is created first and exclusively, which claims the name
+	var name string
+	var infoFile *os.File
+	for {
+		name = uniqueTrashName(files, filepath.Base(path))
+		infoFile, err = os.OpenFile(filepath.Join(info, name+".trashinfo"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+	}
+	infoPath := infoFile.Name()
+	_, err = fmt.Fprintf(infoFile, "[Trash Info]\nPath=%s\nDeletionDate=%s\n", (&url.URL{Path: path}).EscapedPath(), time.Now().Format("2006-01-02T15:04:05"))
+	if cerr := infoFile.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(path, filepath.Join(files, name))
+	}
+	if err != nil {
+		os.Remove(infoPath)
+		return err
+	}
+	return nil
+}
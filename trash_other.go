@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// moveToOSTrash is not supported on this platform
+func moveToOSTrash(path string) error {
+	return fmt.Errorf("the system trash is not supported on %s, use -trash folder", runtime.GOOS)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Unicode normalization forms accepted by -unicode
+const (
+	unicodeNFC = "nfc"
+	unicodeNFD = "nfd"
+	unicodeOff = "off"
+)
+
+// validateUnicodeForm checks the -unicode flag value
+func validateUnicodeForm(form string) error {
+	switch form {
+	case unicodeNFC, unicodeNFD, unicodeOff:
+		return nil
+	default:
+		return fmt.Errorf("invalid -unicode form %q, expected 'nfc', 'nfd', or 'off'", form)
+	}
+}
+
+// normalizeName converts a file or folder name to the -unicode form. macOS
+// writes accented letters decomposed (NFD, "e" followed by a combining
+// accent) while Linux and Windows usually keep them composed (NFC), so the
+// same name can arrive in either form.
+func (s *sorter) normalizeName(name string) string {
+	switch s.unicodeForm {
+	case unicodeNFC:
+		return norm.NFC.String(name)
+	case unicodeNFD:
+		return norm.NFD.String(name)
+	}
+	return name
+}
+
+// existingForm returns path with each missing name below the destination
+// replaced by an existing name that differs only in its Unicode form, so a
+// file or folder written from another platform is found rather than
+// duplicated. Names that exist as given, ASCII names, and paths on a remote
+// destination are returned unchanged.
+func (s *sorter) existingForm(path string) string {
+	if s.store != nil || s.unicodeForm == unicodeOff {
+		return path
+	}
+	root := s.destDir
+	if !isWithin(root, path) {
+		root = filepath.Dir(path)
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return path
+	}
+
+	current := root
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i, part := range parts {
+		candidate := filepath.Join(current, part)
+		if _, err := os.Lstat(candidate); err == nil || isASCII(part) {
+			current = candidate
+			continue
+		}
+		match := ""
+		if entries, err := os.ReadDir(current); err == nil {
+			for _, entry := range entries {
+				if norm.NFC.String(entry.Name()) == norm.NFC.String(part) {
+					match = entry.Name()
+					break
+				}
+			}
+		}
+		if match == "" {
+			// Nothing further down can exist either
+			return filepath.Join(append([]string{current}, parts[i:]...)...)
+		}
+		current = filepath.Join(current, match)
+	}
+	return current
+}
+
+// isASCII reports whether name has only ASCII characters, which have a
+// single Unicode form
+func isASCII(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxUploadSize bounds a single upload request
+const maxUploadSize = 4 << 30
+
+// uploadCookie remembers the token after the first visit with ?token=
+const uploadCookie = "gopicsort_token"
+
+// uploadServer accepts photos over HTTP and drops them into the watched
+// source directory, where the watch loop sorts them like any other file
+type uploadServer struct {
+	incoming string
+	token    string
+}
+
+// startUploadServer serves the upload page and endpoint in the background
+func startUploadServer(addr, incoming, token string) error {
+	u := &uploadServer{incoming: incoming, token: token}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", u.handleIndex)
+	mux.HandleFunc("/manifest.webmanifest", u.handleManifest)
+	mux.HandleFunc("/upload", u.handleUpload)
+
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 30 * time.Second}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("Upload endpoint listening", "addr", listener.Addr().String())
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("Upload endpoint stopped", "error", err)
+		}
+	}()
+	return nil
+}
+
+// authorized checks the token from the Authorization header, the token query
+// parameter, or the cookie set on a previous visit
+func (u *uploadServer) authorized(r *http.Request) bool {
+	return hasToken(r, u.token, uploadCookie)
+}
+
+// remember stores the token in a long-lived cookie so the installed web app
+// and share-sheet uploads stay authenticated
+func (u *uploadServer) remember(w http.ResponseWriter, r *http.Request) {
+	rememberToken(w, r, u.token, uploadCookie)
+}
+
+// hasToken reports whether a request carries token in its Authorization
+// header, its token query parameter, or the named cookie
+func hasToken(r *http.Request, token, cookie string) bool {
+	candidates := []string{r.URL.Query().Get("token")}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		candidates = append(candidates, strings.TrimPrefix(auth, "Bearer "))
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		candidates = append(candidates, password)
+	}
+	if c, err := r.Cookie(cookie); err == nil {
+		candidates = append(candidates, c.Value)
+	}
+	for _, candidate := range candidates {
+		if candidate != "" && subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// rememberToken sets the named cookie to token after a visit with ?token=,
+// so later requests from the browser are authenticated
+func rememberToken(w http.ResponseWriter, r *http.Request, token, cookie string) {
+	if r.URL.Query().Get("token") == "" {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   365 * 24 * 60 * 60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   r.TLS != nil,
+	})
+}
+
+func (u *uploadServer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if !u.authorized(r) {
+		http.Error(w, "Open this page with ?token=... to sign in", http.StatusUnauthorized)
+		return
+	}
+	u.remember(w, r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	uploadPage.Execute(w, nil)
+}
+
+func (u *uploadServer) handleManifest(w http.ResponseWriter, r *http.Request) {
+	// The manifest makes the page installable and registers it as a share target
+	manifest := map[string]any{
+		"name":       "GoPicSort Upload",
+		"short_name": "Photos",
+		"start_url":  "/",
+		"display":    "standalone",
+		"share_target": map[string]any{
+			"action":  "/upload",
+			"method":  "POST",
+			"enctype": "multipart/form-data",
+			"params": map[string]any{
+				"files": []map[string]any{{"name": "files", "accept": []string{"image/*", "video/*"}}},
+			},
+		},
+	}
+	w.Header().Set("Content-Type", "application/manifest+json")
+	json.NewEncoder(w).Encode(manifest)
+}
+
+func (u *uploadServer) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !u.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var saved []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if part.FileName() == "" {
+			continue
+		}
+		name, err := u.save(part)
+		part.Close()
+		if err != nil {
+			slog.Error("Upload failed", "file", part.FileName(), "error", err)
+			http.Error(w, "upload failed", http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Received upload", "file", name, "remote", r.RemoteAddr)
+		saved = append(saved, name)
+	}
+
+	// Share-sheet and form posts come from a browser; send them back to the page
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Redirect(w, r, fmt.Sprintf("/?uploaded=%d", len(saved)), http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"uploaded": saved})
+}
+
+// save writes an uploaded file into the incoming directory. The data is
+// written under a temporary name first so the watcher never sees a partial file.
+func (u *uploadServer) save(part *multipart.Part) (string, error) {
+	name := sanitizeFolderName(filepath.Base(part.FileName()))
+	temp, err := os.CreateTemp(u.incoming, ".upload-*.part")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(temp, part); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return "", err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return "", err
+	}
+
+	// Never overwrite an earlier upload with the same name, even one saved
+	// at the same moment by another request
+	defer os.Remove(temp.Name())
+	ext := filepath.Ext(name)
+	target := filepath.Join(u.incoming, name)
+	for i := 1; ; i++ {
+		err := claimName(temp.Name(), target)
+		if err == nil {
+			return filepath.Base(target), nil
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+		target = filepath.Join(u.incoming, fmt.Sprintf("%s_%d%s", strings.TrimSuffix(name, ext), i, ext))
+	}
+}
+
+// claimName gives the file at temp the name target unless target exists,
+// failing with an error for which os.IsExist is true. A hard link claims the
+// name in one step; on file systems without them, an empty file created
+// exclusively holds the name until temp is renamed over it. temp is left
+// for the caller to remove.
+func claimName(temp, target string) error {
+	err := os.Link(temp, target)
+	if err == nil || os.IsExist(err) {
+		return err
+	}
+	placeholder, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	placeholder.Close()
+	if err := os.Rename(temp, target); err != nil {
+		os.Remove(target)
+		return err
+	}
+	return nil
+}
+
+var uploadPage = template.Must(template.New("upload").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<link rel="manifest" href="/manifest.webmanifest">
+<title>Upload Photos</title>
+<style>
+body { font-family: sans-serif; max-width: 480px; margin: 2em auto; padding: 0 1em; }
+input[type=file], button { display: block; width: 100%; margin: 1em 0; font-size: 1.2em; }
+button { padding: 0.8em; }
+</style>
+</head>
+<body>
+<h1>Upload Photos</h1>
+<form method="post" action="/upload" enctype="multipart/form-data">
+<input type="file" name="files" accept="image/*,video/*" multiple>
+<button type="submit">Upload</button>
+</form>
+<p id="status"></p>
+<script>
+var n = new URLSearchParams(location.search).get("uploaded");
+if (n !== null) document.getElementById("status").textContent = n + " file(s) uploaded.";
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// postUpload sends files to the upload endpoint as one multipart request
+func postUpload(t *testing.T, u *uploadServer, files map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, data := range files {
+		part, err := form.CreateFormFile("files", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(data))
+	}
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload?token="+u.token, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	u.handleUpload(rec, req)
+	return rec
+}
+
+func TestUploadKeepsEveryFileWithTheSameName(t *testing.T) {
+	u := &uploadServer{incoming: t.TempDir(), token: "s3cret"}
+	const uploads = 8
+	var wg sync.WaitGroup
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			postUpload(t, u, map[string]string{"IMG_0001.jpg": fmt.Sprint("photo ", i)})
+		}(i)
+	}
+	wg.Wait()
+
+	entries, err := os.ReadDir(u.incoming)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(u.incoming, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[string(data)] = true
+	}
+	if len(entries) != uploads || len(seen) != uploads {
+		t.Errorf("incoming holds %d files with %d different contents, want %d of each", len(entries), len(seen), uploads)
+	}
+}
+
+func TestUploadRequiresToken(t *testing.T) {
+	u := &uploadServer{incoming: t.TempDir(), token: "s3cret"}
+	req := httptest.NewRequest(http.MethodPost, "/upload", nil)
+	rec := httptest.NewRecorder()
+	u.handleUpload(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
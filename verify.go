@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runVerify implements the "verify" subcommand, which re-reads the capture
+// date of every file in a library and reports files in the wrong month
+// folder, for example ones sorted by modification time by an old version.
+// With -repair they are moved into the right folder.
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	destDir := fs.String("dest", "", "Sorted photo library to verify")
+	repair := fs.Bool("repair", false, "Move misplaced files into the month folder of their capture date")
+	screenshots := fs.String("screenshots", "", "Separate screenshots tree inside -dest to skip")
+	timeOffset := fs.Duration("time-offset", 0, "Time offset used when the library was sorted")
+	assumeTZ := fs.String("assume-tz", "", "Camera time zone used when the library was sorted")
+	dateTagOrder := fs.String("date-tags", defaultDateTags, "EXIF date tag order used when the library was sorted")
+	logOpts := addLogFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s verify -dest DIR [-repair]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	parseInterspersed(fs, args)
+	if *destDir == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := logOpts.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	s := newLibrarySorter(*destDir, *screenshots, *timeOffset, *assumeTZ, *dateTagOrder)
+	issues, plan, err := s.lintLayout()
+	if err != nil {
+		fatal("Failed to verify library", "error", err)
+	}
+	misplaced := make(map[string]bool)
+	for _, issue := range issues {
+		if issue.Kind == lintMisplaced {
+			slog.Warn("Misplaced file", "path", issue.Path, "detail", issue.Detail)
+			misplaced[issue.Path] = true
+		}
+	}
+	if !*repair {
+		slog.Info("Verification finished", "misplaced", len(misplaced))
+		if len(misplaced) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if s.retention, err = loadRetention(*destDir); err != nil {
+		fatal("Failed to read retention catalog", "error", err)
+	}
+	s.command = "verify"
+	s.runID = newRunID()
+	s.started = time.Now()
+	s.movedFrom = make(map[string]bool)
+	moved := 0
+	for _, move := range plan.Moves {
+		// Only files in the wrong month are repaired; lint reports the rest
+		if !misplaced[move.Source] {
+			continue
+		}
+		if err := s.applyPlanMove(move); err != nil {
+			slog.Warn("Could not repair", "path", move.Source, "error", err)
+			continue
+		}
+		moved++
+	}
+	// Month folders emptied by the repair are removed
+	pruneEmptyDirs(*destDir, s.movedFrom)
+	s.finish()
+	slog.Info("Verification finished", "misplaced", len(misplaced), "repaired", moved)
+	if moved < len(misplaced) {
+		os.Exit(1)
+	}
+}
+
+// applyPlanMove performs one move of a fix plan inside the library. Files
+// under retention, in locked folders, or whose target exists are left alone.
+func (s *sorter) applyPlanMove(move planMove) error {
+	source := filepath.Join(s.destDir, move.Source)
+	dest := filepath.Join(s.destDir, move.Dest)
+	if err := s.retention.check(source); err != nil {
+		return err
+	}
+	if isLocked(filepath.Dir(source)) || isLocked(filepath.Dir(dest)) {
+		return fmt.Errorf("folder is locked, run 'gopicsort unlock' first")
+	}
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("%s already exists", move.Dest)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(source, dest); err != nil {
+		return err
+	}
+	slog.Info("Moved", "source", source, "dest", dest, "reason", move.Reason)
+	s.transfers = append(s.transfers, transfer{source: source, dest: dest})
+	s.movedFrom[filepath.Dir(source)] = true
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Build information, set by release builds with
+// -ldflags "-X main.version=1.4.0 -X main.commit=abc1234 -X main.buildDate=2024-05-01T12:00:00Z".
+// Builds without them fall back to what the Go toolchain recorded.
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+// optionalTools lists the external programs some features run, for
+// reporting which of them a machine has
+var optionalTools = []string{"heif-convert", "sips", "magick", "gphoto2", "btrfs", "zfs", "chattr", "chflags"}
+
+// buildInfo describes a build of GoPicSort for the "version" subcommand
+type buildInfo struct {
+	Version            string          `json:"version"`
+	Commit             string          `json:"commit,omitempty"`
+	Modified           bool            `json:"modified,omitempty"`
+	BuildDate          string          `json:"build_date,omitempty"`
+	GoVersion          string          `json:"go_version"`
+	Platform           string          `json:"platform"`
+	ImageFormats       []string        `json:"image_formats"`
+	VideoFormats       []string        `json:"video_formats"`
+	RemoteDestinations []string        `json:"remote_destinations"`
+	Tools              map[string]bool `json:"tools"`
+}
+
+// currentBuildInfo collects the build information of the running binary
+func currentBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:            version,
+		Commit:             commit,
+		BuildDate:          buildDate,
+		GoVersion:          runtime.Version(),
+		Platform:           runtime.GOOS + "/" + runtime.GOARCH,
+		ImageFormats:       imageFormats,
+		VideoFormats:       videoFormats,
+		RemoteDestinations: remoteSchemes,
+		Tools:              make(map[string]bool),
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && build.Main.Version != "(devel)" {
+			info.Version = build.Main.Version
+		}
+		// Without -ldflags, the commit and its time come from version control
+		if info.Commit == "" {
+			for _, setting := range build.Settings {
+				switch setting.Key {
+				case "vcs.revision":
+					info.Commit = setting.Value
+				case "vcs.time":
+					if info.BuildDate == "" {
+						info.BuildDate = setting.Value
+					}
+				case "vcs.modified":
+					info.Modified = setting.Value == "true"
+				}
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	for _, tool := range optionalTools {
+		_, err := exec.LookPath(tool)
+		info.Tools[tool] = err == nil
+	}
+	return info
+}
+
+// runVersion implements the "version" subcommand, which prints the version,
+// commit, and build date with the formats and features of the build, for
+// bug reports
+func runVersion(args []string) {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print the build information as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s version [-json]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	parseInterspersed(fs, args)
+
+	info := currentBuildInfo()
+	if *asJSON {
+		data, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			fatal("Failed to encode build information", "error", err)
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	fmt.Printf("gopicsort %s\n", info.Version)
+	if info.Commit != "" {
+		modified := ""
+		if info.Modified {
+			modified = " (modified)"
+		}
+		fmt.Printf("commit:  %s%s\n", info.Commit, modified)
+	}
+	if info.BuildDate != "" {
+		fmt.Printf("built:   %s\n", info.BuildDate)
+	}
+	fmt.Printf("go:      %s %s\n", info.GoVersion, info.Platform)
+	fmt.Printf("images:  %s\n", strings.Join(info.ImageFormats, " "))
+	fmt.Printf("videos:  %s (with -videos)\n", strings.Join(info.VideoFormats, " "))
+	fmt.Printf("remote:  %s\n", strings.Join(info.RemoteDestinations, " "))
+	var tools []string
+	for _, tool := range optionalTools {
+		state := "missing"
+		if info.Tools[tool] {
+			state = "found"
+		}
+		tools = append(tools, tool+" ("+state+")")
+	}
+	fmt.Printf("tools:   %s\n", strings.Join(tools, ", "))
+}
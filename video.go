@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// includeVideos is set by -videos to sort videos along with photos
+var includeVideos bool
+
+// errNoVideoDate is returned for videos without a recording time
+var errNoVideoDate = errors.New("no recording time in video")
+
+// quickTimeEpoch is the start of QuickTime and MP4 time stamps, 1904-01-01,
+// in Unix time
+const quickTimeEpoch = -2082844800
+
+// videoFormats lists the canonical extensions of the video formats sorted
+// by -videos
+var videoFormats = []string{".mp4", ".mov", ".m4v", ".mts"}
+
+// isVideoFile reports whether an extension is a video format sorted by -videos
+func isVideoFile(ext string) bool {
+	return slices.Contains(videoFormats, canonicalExt(ext))
+}
+
+// videoCreationTime reads the recording time from the movie header (mvhd)
+// of an MP4 or QuickTime file. The format says it is UTC, but many cameras,
+// GoPros among them, write their local clock time; like EXIF dates it is
+// taken as local time, so -assume-tz UTC converts times from phones that
+// follow the format.
+func videoCreationTime(path string) (time.Time, error) {
+	if canonicalExt(filepath.Ext(path)) == ".mts" {
+		return avchdRecordingTime(path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+
+	moov, err := seekBox(file, "moov", -1)
+	if err != nil {
+		return time.Time{}, errNoVideoDate
+	}
+	if _, err := seekBox(file, "mvhd", moov); err != nil {
+		return time.Time{}, errNoVideoDate
+	}
+	// mvhd is a full box: version, flags, then the creation time, 32 bits
+	// wide in version 0 and 64 bits in version 1
+	head := make([]byte, 12)
+	if _, err := io.ReadFull(file, head); err != nil {
+		return time.Time{}, errNoVideoDate
+	}
+	var seconds uint64
+	if head[0] == 1 {
+		seconds = binary.BigEndian.Uint64(head[4:12])
+	} else {
+		seconds = uint64(binary.BigEndian.Uint32(head[4:8]))
+	}
+	if seconds == 0 {
+		return time.Time{}, errNoVideoDate
+	}
+	t := time.Unix(int64(seconds)+quickTimeEpoch, 0).UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local), nil
+}
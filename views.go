@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Dimensions of the -view symlink trees
+const (
+	viewCamera   = "camera"
+	viewLens     = "lens"
+	viewLocation = "location"
+)
+
+// viewValues returns the folder a photo belongs in for each view dimension,
+// from its EXIF metadata; dimensions the photo has no value for are absent
+var viewValues = map[string]func(x *exif.Exif) string{
+	viewCamera: cameraName,
+	viewLens: func(x *exif.Exif) string {
+		return exifString(x, exif.LensModel)
+	},
+	viewLocation: func(x *exif.Exif) string {
+		lat, long, err := x.LatLong()
+		if err != nil {
+			return ""
+		}
+		// Rounded to one decimal place (~10 km) like the yearbook's locations
+		return fmt.Sprintf("%.1f, %.1f", lat, long)
+	},
+}
+
+// parseViews parses -view values such as "camera=/photos/by-camera" into a
+// map from dimension to view directory
+func parseViews(specs []string) (map[string]string, error) {
+	views := make(map[string]string)
+	for _, spec := range specs {
+		dimension, dir, ok := strings.Cut(spec, "=")
+		dimension = strings.ToLower(strings.TrimSpace(dimension))
+		if _, known := viewValues[dimension]; !ok || !known || dir == "" {
+			return nil, fmt.Errorf("invalid -view %q, expected DIMENSION=DIR with DIMENSION one of %s", spec, strings.Join(viewDimensions(), ", "))
+		}
+		views[dimension] = dir
+	}
+	return views, nil
+}
+
+// viewDimensions lists the supported view dimensions
+func viewDimensions() []string {
+	dimensions := make([]string, 0, len(viewValues))
+	for dimension := range viewValues {
+		dimensions = append(dimensions, dimension)
+	}
+	sort.Strings(dimensions)
+	return dimensions
+}
+
+// linkViews links a sorted file into every -view tree it has a value for,
+// at its path in the library below the value's folder, e.g.
+// "by-camera/Canon EOS R5/2023/07/IMG_0001.JPG". Links are relative, so
+// they keep working when the library and views are moved or mounted
+// elsewhere together.
+func (s *sorter) linkViews(destPath string) error {
+	rel, err := filepath.Rel(s.destDir, destPath)
+	if err != nil || !isWithin(s.destDir, destPath) {
+		return nil
+	}
+	x, err := decodeExif(s.fsys, destPath)
+	if err != nil {
+		return nil
+	}
+	target, err := filepath.Abs(destPath)
+	if err != nil {
+		return err
+	}
+	for dimension, viewDir := range s.views {
+		value := viewValues[dimension](x)
+		if value == "" {
+			continue
+		}
+		link := filepath.Join(viewDir, s.normalizeName(sanitizeFolderName(value)), rel)
+		if _, err := os.Lstat(link); err == nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %v", filepath.Dir(link), err)
+		}
+		linkDir, err := filepath.Abs(filepath.Dir(link))
+		if err != nil {
+			return err
+		}
+		relTarget, err := filepath.Rel(linkDir, target)
+		if err != nil {
+			relTarget = target
+		}
+		if err := os.Symlink(relTarget, link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
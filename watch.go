@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// tetherPollInterval is the default poll interval in -tether mode, short
+// enough that each frame lands in the library well under a second after
+// the tethering software finishes writing it
+const tetherPollInterval = 200 * time.Millisecond
+
+// fileState identifies a version of a file seen while polling
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// watch processes existing files and then polls the source directory for new
+// ones until interrupted. A file is processed once its size and modification
+// time are unchanged between two polls, so files still being written by a
+// camera or tethering software are not picked up half-finished. On SIGTERM
+// or an interrupt it stops after the file being sorted. Under systemd it
+// reports readiness and status and keeps the unit's watchdog fed.
+func (s *sorter) watch(interval time.Duration) error {
+	ctx, stop := signal.NotifyContext(serviceContext, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("Watching for new files", "sources", s.sourceDirs, "interval", interval)
+	systemd := newSystemdNotifier()
+	go systemd.runWatchdog(ctx)
+	systemd.ready()
+	pending := make(map[string]fileState)
+	done := make(map[string]fileState)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		seen := make(map[string]bool)
+		before := make(map[string]int, len(s.counts))
+		for outcome, count := range s.counts {
+			before[outcome] = count
+		}
+		problems := len(s.problems)
+		// Problem files retried through the control API are sorted again
+		for _, path := range s.control.retries() {
+			delete(done, path)
+		}
+		err := s.walkSource(func(path string, info os.FileInfo) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			seen[path] = true
+			if s.control.isPaused() {
+				// Leave the file for the first poll after resuming
+				return nil
+			}
+			state := fileState{size: info.Size(), modTime: info.ModTime()}
+			if done[path] == state {
+				return nil
+			}
+			if previous, ok := pending[path]; !ok || previous != state {
+				// New or still changing, check again on the next poll
+				pending[path] = state
+				return nil
+			}
+			delete(pending, path)
+			done[path] = state
+			s.control.sorting(path)
+			defer s.control.sorting("")
+			systemd.busy(true)
+			defer systemd.busy(false)
+			nt, nd, np := len(s.transfers), len(s.duplicates), len(s.problems)
+			err := s.sortFile(path, info)
+			s.control.sorted(s.counts, s.destDir, s.transfers[nt:], s.duplicates[nd:], s.problems[np:])
+			return err
+		})
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+
+		// Forget files that disappeared, e.g. because they were moved
+		for path := range pending {
+			if !seen[path] {
+				delete(pending, path)
+			}
+		}
+		for path := range done {
+			if !seen[path] {
+				delete(done, path)
+			}
+		}
+		s.metrics.polled(len(pending))
+		s.control.polled(len(pending))
+		s.notifyBatch(before, problems)
+		systemd.status(fmt.Sprintf("Watching: %d sorted, %d failed, %d waiting", s.counts[outcomeSorted], s.failures(), len(pending)))
+
+		if ctx.Err() == nil {
+			select {
+			case <-ctx.Done():
+			case <-ticker.C:
+				continue
+			case <-s.control.triggered():
+				continue
+			}
+		}
+		slog.Info("Stopping watch")
+		systemd.stopping()
+		s.finish()
+		return nil
+	}
+}
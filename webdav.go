@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webdavStorage writes to a WebDAV share (Nextcloud, NAS file services,
+// Apache mod_dav, ...). The HTTP client keeps connections alive between files.
+type webdavStorage struct {
+	base     *url.URL
+	user     string
+	password string
+	client   *http.Client
+
+	mu   sync.Mutex
+	dirs map[string]bool // collections known to exist
+}
+
+// newWebDAVStorage parses a webdav://host/path (HTTP) or webdavs://host/path
+// (HTTPS) destination. The user may be given in the URL; the password is read
+// from $GOPICSORT_WEBDAV_PASSWORD unless the URL contains one.
+func newWebDAVStorage(dest string) (*webdavStorage, error) {
+	u, err := url.Parse(dest)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid WebDAV destination %q, expected webdav(s)://[user@]host/path", dest)
+	}
+	st := &webdavStorage{
+		password: os.Getenv("GOPICSORT_WEBDAV_PASSWORD"),
+		client:   &http.Client{Timeout: 30 * time.Minute},
+		dirs:     make(map[string]bool),
+	}
+	if u.User != nil {
+		st.user = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			st.password = password
+		}
+	}
+	st.base = &url.URL{Scheme: "http", Host: u.Host, Path: strings.TrimSuffix(u.Path, "/")}
+	if u.Scheme == "webdavs" {
+		st.base.Scheme = "https"
+	}
+	return st, nil
+}
+
+// url returns the address of a name below the destination
+func (st *webdavStorage) url(name string) string {
+	u := *st.base
+	u.Path = u.Path + "/" + name
+	return u.String()
+}
+
+func (st *webdavStorage) Location(name string) string {
+	return st.url(name)
+}
+
+func (st *webdavStorage) Stat(name string) (int64, bool, error) {
+	resp, err := st.do(http.MethodHead, st.url(name), nil, -1)
+	if err != nil {
+		return 0, false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.ContentLength, true, nil
+	case http.StatusNotFound:
+		return 0, false, nil
+	default:
+		return 0, false, fmt.Errorf("HEAD %s: %s", st.url(name), resp.Status)
+	}
+}
+
+// Put creates the parent collections and uploads the file. If-None-Match
+// asks the server not to replace a file created in the meantime.
+func (st *webdavStorage) Put(name, src string) error {
+	if err := st.mkcolAll(path.Dir(st.base.Path + "/" + name)); err != nil {
+		return err
+	}
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	resp, err := st.do(http.MethodPut, st.url(name), throttled(file), info.Size())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("PUT %s: %s %s", st.url(name), resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+func (st *webdavStorage) Close() error {
+	st.client.CloseIdleConnections()
+	return nil
+}
+
+// mkcolAll creates the collection at a server path and its parents,
+// including the destination itself, remembering which exist
+func (st *webdavStorage) mkcolAll(dir string) error {
+	if dir == "." || dir == "/" || dir == "" {
+		return nil
+	}
+	st.mu.Lock()
+	known := st.dirs[dir]
+	st.mu.Unlock()
+	if known {
+		return nil
+	}
+	if err := st.mkcolAll(path.Dir(dir)); err != nil {
+		return err
+	}
+
+	u := *st.base
+	u.Path = dir + "/"
+	resp, err := st.do("MKCOL", u.String(), nil, -1)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	// 405 Method Not Allowed means the collection already exists
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+		return fmt.Errorf("MKCOL %s: %s", u.String(), resp.Status)
+	}
+	st.mu.Lock()
+	st.dirs[dir] = true
+	st.mu.Unlock()
+	return nil
+}
+
+// do sends an authenticated request; size is the body length, or -1 for none
+func (st *webdavStorage) do(method, target string, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if size >= 0 {
+		req.ContentLength = size
+		req.Header.Set("If-None-Match", "*")
+	}
+	if st.user != "" || st.password != "" {
+		req.SetBasicAuth(st.user, st.password)
+	}
+	return st.client.Do(req)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeWebDAV is a share keeping files and collections in memory
+type fakeWebDAV struct {
+	mu          sync.Mutex
+	files       map[string][]byte
+	collections map[string]bool
+}
+
+func (f *fakeWebDAV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, password, _ := r.BasicAuth(); user != "photos" || password != "s3cret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := r.URL.Path
+	switch r.Method {
+	case "MKCOL":
+		dir := strings.TrimSuffix(p, "/")
+		if f.collections[dir] {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if parent := dir[:strings.LastIndex(dir, "/")]; parent != "" && !f.collections[parent] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.collections[dir] = true
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodHead:
+		data, ok := f.files[p]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	case http.MethodPut:
+		if !f.collections[p[:strings.LastIndex(p, "/")]] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if _, ok := f.files[p]; ok && r.Header.Get("If-None-Match") == "*" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.files[p] = data
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestWebDAVUploadsSortedFiles(t *testing.T) {
+	t.Setenv("GOPICSORT_WEBDAV_PASSWORD", "s3cret")
+	share := &fakeWebDAV{files: make(map[string][]byte), collections: make(map[string]bool)}
+	server := httptest.NewServer(share)
+	defer server.Close()
+	st, err := newWebDAVStorage("webdav://photos@" + strings.TrimPrefix(server.URL, "http://") + "/dav/library")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	src := t.TempDir()
+	writeTestFile(t, filepath.Join(src, "IMG_20210506_070809.jpg"), []byte("photo"))
+	writeTestFile(t, filepath.Join(src, "trip", "IMG_20221224_180000.jpg"), []byte("december"))
+	s := newStoreSorter(t, st, src)
+	if err := s.run(); err != nil {
+		t.Fatal(err)
+	}
+	if s.counts[outcomeSorted] != 2 || s.failures() != 0 {
+		t.Errorf("counts are %v, want two sorted files", s.counts)
+	}
+	if got := string(share.files["/dav/library/2022/12/IMG_20221224_180000.jpg"]); got != "december" {
+		t.Errorf("file holds %q, want %q", got, "december")
+	}
+
+	// Sorting again finds the files in place
+	again := newStoreSorter(t, st, src)
+	if err := again.run(); err != nil {
+		t.Fatal(err)
+	}
+	if again.counts[outcomeExisting] != 2 {
+		t.Errorf("second run counts are %v, want two existing files", again.counts)
+	}
+}
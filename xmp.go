@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxXMPScan bounds how much of a file is searched for an embedded XMP packet
+const maxXMPScan = 8 << 20
+
+// XMP namespaces understood by the metadata parsers
+const (
+	nsRDF         = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsMWGRegions  = "http://www.metadataworkinggroup.com/schemas/regions/"
+	nsMPReg       = "http://ns.microsoft.com/photo/1.2/t/Region#"
+	nsIptc4xmpExt = "http://iptc.org/std/Iptc4xmpExt/2008-02-29/"
+	nsXMP         = "http://ns.adobe.com/xap/1.0/"
+	nsPhotoshop   = "http://ns.adobe.com/photoshop/1.0/"
+	nsXMPExif     = "http://ns.adobe.com/exif/1.0/"
+	nsDC          = "http://purl.org/dc/elements/1.1/"
+)
+
+// xmpDateProperties are the XMP capture date properties, most reliable first
+var xmpDateProperties = []xml.Name{
+	{Space: nsXMPExif, Local: "DateTimeOriginal"},
+	{Space: nsPhotoshop, Local: "DateCreated"},
+	{Space: nsXMP, Local: "CreateDate"},
+}
+
+// xmpDateLayouts are the forms of the XMP date type, from full timestamps
+// down to a year alone
+var xmpDateLayouts = []string{
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// readXMP returns the XMP packet for a file, preferring a sidecar
+// (photo.xmp or photo.jpg.xmp) over metadata embedded in the file itself.
+// It returns nil if no XMP is found.
+func readXMP(path string) []byte {
+	ext := filepath.Ext(path)
+	for _, sidecar := range []string{strings.TrimSuffix(path, ext) + ".xmp", path + ".xmp"} {
+		if data, err := os.ReadFile(sidecar); err == nil {
+			return extractXMPPacket(data)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxXMPScan))
+	if err != nil {
+		return nil
+	}
+	return extractXMPPacket(data)
+}
+
+// extractXMPPacket locates the x:xmpmeta element within arbitrary bytes
+func extractXMPPacket(data []byte) []byte {
+	start := bytes.Index(data, []byte("<x:xmpmeta"))
+	if start < 0 {
+		return nil
+	}
+	end := bytes.Index(data[start:], []byte("</x:xmpmeta>"))
+	if end < 0 {
+		return nil
+	}
+	return data[start : start+end+len("</x:xmpmeta>")]
+}
+
+// parseXMPPeople extracts the names of people tagged in an XMP packet from
+// MWG face regions (used by Lightroom, Apple Photos, and digiKam), Microsoft
+// Photo/Picasa regions, and IPTC PersonInImage. Names are deduplicated and
+// returned sorted.
+func parseXMPPeople(packet []byte) []string {
+	if packet == nil {
+		return nil
+	}
+
+	names := make(map[string]bool)
+	decoder := xml.NewDecoder(bytes.NewReader(packet))
+	var stack []xml.Name
+
+	// The MWG region currently being read: regions are the rdf:li items of
+	// mwg-rs:RegionList, with Name and Type given as attributes or elements
+	regionDepth := 0
+	regionIsFace, regionName := true, ""
+
+	inside := func(space, local string) bool {
+		for _, n := range stack {
+			if n.Space == space && n.Local == local {
+				return true
+			}
+		}
+		return false
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			n := len(stack)
+			stack = append(stack, t.Name)
+			if regionDepth == 0 && t.Name.Space == nsRDF && t.Name.Local == "li" && n >= 2 &&
+				stack[n-2].Space == nsMWGRegions && stack[n-2].Local == "RegionList" {
+				regionDepth = len(stack)
+				regionIsFace, regionName = true, ""
+			}
+			for _, attr := range t.Attr {
+				switch {
+				case regionDepth > 0 && attr.Name.Space == nsMWGRegions && attr.Name.Local == "Type":
+					regionIsFace = attr.Value == "Face"
+				case regionDepth > 0 && attr.Name.Space == nsMWGRegions && attr.Name.Local == "Name":
+					regionName = strings.TrimSpace(attr.Value)
+				case attr.Name.Space == nsMPReg && attr.Name.Local == "PersonDisplayName":
+					names[strings.TrimSpace(attr.Value)] = true
+				}
+			}
+		case xml.EndElement:
+			if regionDepth > 0 && len(stack) == regionDepth {
+				if regionIsFace && regionName != "" {
+					names[regionName] = true
+				}
+				regionDepth = 0
+			}
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			text := strings.TrimSpace(string(t))
+			if text == "" || len(stack) == 0 {
+				continue
+			}
+			current := stack[len(stack)-1]
+			switch {
+			case regionDepth > 0 && current.Space == nsMWGRegions && current.Local == "Name":
+				regionName = text
+			case regionDepth > 0 && current.Space == nsMWGRegions && current.Local == "Type":
+				regionIsFace = text == "Face"
+			case current.Space == nsMPReg && current.Local == "PersonDisplayName":
+				names[text] = true
+			case current.Space == nsRDF && current.Local == "li" && inside(nsIptc4xmpExt, "PersonInImage"):
+				names[text] = true
+			}
+		}
+	}
+
+	delete(names, "")
+	people := make([]string, 0, len(names))
+	for name := range names {
+		people = append(people, name)
+	}
+	sort.Strings(people)
+	return people
+}
+
+// getXMPDate returns the capture date recorded in a file's XMP sidecar or
+// embedded XMP, for RAW files and edited TIFFs that carry no EXIF date
+func getXMPDate(path string) (time.Time, bool) {
+	return parseXMPDate(readXMP(path))
+}
+
+// parseXMPDate extracts the capture date from an XMP packet, from
+// exif:DateTimeOriginal, photoshop:DateCreated, or xmp:CreateDate, given as
+// attributes or elements. Like EXIF dates, the camera's wall clock time is
+// kept and any time zone is dropped.
+func parseXMPDate(packet []byte) (time.Time, bool) {
+	if packet == nil {
+		return time.Time{}, false
+	}
+
+	values := make(map[xml.Name]string)
+	decoder := xml.NewDecoder(bytes.NewReader(packet))
+	var current xml.Name
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			current = t.Name
+			for _, attr := range t.Attr {
+				if values[attr.Name] == "" {
+					values[attr.Name] = strings.TrimSpace(attr.Value)
+				}
+			}
+		case xml.EndElement:
+			current = xml.Name{}
+		case xml.CharData:
+			if text := strings.TrimSpace(string(t)); text != "" && values[current] == "" {
+				values[current] = text
+			}
+		}
+	}
+
+	for _, property := range xmpDateProperties {
+		value := values[property]
+		if value == "" {
+			continue
+		}
+		for _, layout := range xmpDateLayouts {
+			if date, err := time.Parse(layout, value); err == nil {
+				return time.Date(date.Year(), date.Month(), date.Day(), date.Hour(), date.Minute(), date.Second(), date.Nanosecond(), time.Local), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"html/template"
+	"image/color"
+	"image/jpeg"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// yearbook holds the summary of one year of a sorted library
+type yearbook struct {
+	Year       int
+	Total      int
+	Months     [12]int
+	Cameras    []countEntry
+	Locations  []countEntry
+	Thumbnails []yearbookThumb
+	Generated  time.Time
+}
+
+// countEntry is a labelled counter used for ranked lists
+type countEntry struct {
+	Label string
+	Count int
+}
+
+// yearbookThumb is an embedded EXIF thumbnail selected for the yearbook
+type yearbookThumb struct {
+	Month int
+	Name  string
+	JPEG  []byte
+}
+
+// DataURI returns the thumbnail encoded for inline use in HTML
+func (t yearbookThumb) DataURI() template.URL {
+	return template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(t.JPEG))
+}
+
+// runYearbook implements the "yearbook" subcommand
+func runYearbook(args []string) {
+	fs := flag.NewFlagSet("yearbook", flag.ExitOnError)
+	destDir := fs.String("dest", "", "Sorted photo library to summarize")
+	output := fs.String("o", "", "Output file (default yearbook-YEAR.html or .pdf)")
+	outFormat := fs.String("output-format", "html", "Output format: html or pdf")
+	top := fs.Int("top", 10, "Number of cameras and locations to list")
+	thumbs := fs.Int("thumbs", 12, "Maximum number of thumbnails to include")
+	logOpts := addLogFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s yearbook -dest DIR [options] YEAR\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	positional := parseInterspersed(fs, args)
+	if *destDir == "" || len(positional) != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := logOpts.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	year, err := strconv.Atoi(positional[0])
+	if err != nil || year < 1 {
+		fatal("Invalid year", "year", positional[0])
+	}
+
+	format := strings.ToLower(*outFormat)
+	if format != "html" && format != "pdf" {
+		fatal("Unsupported output format", "format", *outFormat)
+	}
+	if *output == "" {
+		*output = fmt.Sprintf("yearbook-%d.%s", year, format)
+	}
+
+	book, err := buildYearbook(*destDir, year, *top, *thumbs)
+	if err != nil {
+		fatal("Failed to build yearbook", "error", err)
+	}
+
+	var data []byte
+	if format == "pdf" {
+		data = renderYearbookPDF(book)
+	} else {
+		data, err = renderYearbookHTML(book)
+		if err != nil {
+			fatal("Failed to render yearbook", "error", err)
+		}
+	}
+
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		fatal("Failed to write yearbook", "error", err)
+	}
+	slog.Info("Wrote yearbook", "year", year, "photos", book.Total, "path", *output)
+}
+
+// parseInterspersed parses flags that may appear before or after positional
+// arguments and returns the positional arguments
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// buildYearbook scans a sorted library for the photos of a year and collects
+// the summary. Photos are dated by their capture date, or failing that by
+// their folder in the library's layout, so any -layout or preset works.
+func buildYearbook(destDir string, year, top, maxThumbs int) (*yearbook, error) {
+	layout, err := libraryLayout(destDir)
+	if err != nil {
+		return nil, err
+	}
+
+	book := &yearbook{Year: year, Generated: time.Now()}
+	cameras := make(map[string]int)
+	locations := make(map[string]int)
+	var thumbs [12][]yearbookThumb
+
+	err = filepath.Walk(destDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if filepath.Dir(path) == filepath.Clean(destDir) {
+				switch info.Name() {
+				case stateDirName, quarantineDirName, duplicatesDirName, trashDirName, previewsDirName, unsortedDirName:
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if !isImageFile(strings.ToLower(filepath.Ext(path))) {
+			return nil
+		}
+
+		x, err := decodeExif(localFS, path)
+		if err != nil {
+			x = nil
+		}
+		rel, _ := filepath.Rel(destDir, path)
+		date, month, ok := yearbookDate(x, layout, rel)
+		if !ok || date.Year() != year {
+			return nil
+		}
+
+		book.Total++
+		if month > 0 {
+			book.Months[month-1]++
+		}
+		if x == nil {
+			return nil
+		}
+
+		if camera := cameraName(x); camera != "" {
+			cameras[camera]++
+		}
+		if lat, long, err := x.LatLong(); err == nil {
+			// Round to one decimal place (~10 km) so nearby shots group together
+			locations[fmt.Sprintf("%.1f, %.1f", lat, long)]++
+		}
+		if month > 0 && len(thumbs[month-1]) < maxThumbs {
+			if data := exifThumbnail(x); data != nil {
+				thumbs[month-1] = append(thumbs[month-1], yearbookThumb{Month: month, Name: filepath.Base(path), JPEG: data})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if book.Total == 0 {
+		return nil, fmt.Errorf("no photos from %d in %s", year, destDir)
+	}
+
+	book.Cameras = topCounts(cameras, top)
+	book.Locations = topCounts(locations, top)
+	book.Thumbnails = selectThumbnails(thumbs, maxThumbs)
+	return book, nil
+}
+
+// yearbookDate returns the capture date of a photo and its month, or
+// without one the date of its folder in layout; the month is 0 when the
+// layout has no month folders
+func yearbookDate(x *exif.Exif, layout, rel string) (time.Time, int, bool) {
+	if x != nil {
+		if date, err := x.DateTime(); err == nil {
+			return date, int(date.Month()), true
+		}
+	}
+	date, ok := parseLayoutFolder(layout, rel)
+	if !ok {
+		return time.Time{}, 0, false
+	}
+	if !layoutHasMonth(layout) {
+		return date, 0, true
+	}
+	return date, int(date.Month()), true
+}
+
+// cameraName combines the EXIF Make and Model tags into a display name
+func cameraName(x *exif.Exif) string {
+	maker := exifString(x, exif.Make)
+	model := exifString(x, exif.Model)
+	if maker != "" && strings.HasPrefix(strings.ToLower(model), strings.ToLower(maker)) {
+		return model
+	}
+	return strings.TrimSpace(maker + " " + model)
+}
+
+// exifThumbnail returns the embedded JPEG thumbnail, or nil if it is absent or invalid
+func exifThumbnail(x *exif.Exif) (data []byte) {
+	// goexif slices Raw without bounds checks, so guard against bad offsets
+	defer func() {
+		if recover() != nil {
+			data = nil
+		}
+	}()
+
+	data, err := x.JpegThumbnail()
+	if err != nil {
+		return nil
+	}
+	if _, err := jpeg.DecodeConfig(bytes.NewReader(data)); err != nil {
+		return nil
+	}
+	return append([]byte(nil), data...)
+}
+
+// topCounts sorts the counters by frequency and returns at most n entries
+func topCounts(counts map[string]int, n int) []countEntry {
+	entries := make([]countEntry, 0, len(counts))
+	for label, count := range counts {
+		entries = append(entries, countEntry{Label: label, Count: count})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Label < entries[j].Label
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// selectThumbnails picks thumbnails round-robin across months so the whole
+// year is represented
+func selectThumbnails(perMonth [12][]yearbookThumb, max int) []yearbookThumb {
+	var selected []yearbookThumb
+	for round := 0; len(selected) < max; round++ {
+		added := false
+		for month := range perMonth {
+			if round < len(perMonth[month]) && len(selected) < max {
+				selected = append(selected, perMonth[month][round])
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		return selected[i].Month < selected[j].Month
+	})
+	return selected
+}
+
+// MonthName returns the English name of a 1-based month
+func (b *yearbook) MonthName(month int) string {
+	return time.Month(month).String()
+}
+
+// MonthRows returns the per-month counts with names for rendering
+func (b *yearbook) MonthRows() []countEntry {
+	rows := make([]countEntry, 12)
+	for i, count := range b.Months {
+		rows[i] = countEntry{Label: time.Month(i + 1).String(), Count: count}
+	}
+	return rows
+}
+
+// Percent returns count as a percentage of the busiest month, for bar widths
+func (b *yearbook) Percent(count int) int {
+	max := 0
+	for _, c := range b.Months {
+		if c > max {
+			max = c
+		}
+	}
+	if max == 0 {
+		return 0
+	}
+	return count * 100 / max
+}
+
+var yearbookTemplate = template.Must(template.New("yearbook").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Year}} in Photos</title>
+<style>
+body { font-family: sans-serif; max-width: 960px; margin: 2em auto; color: #222; }
+h1 { font-size: 2.5em; margin-bottom: 0; }
+table { border-collapse: collapse; }
+td { padding: 2px 8px; }
+.bar { background: #4a90d9; height: 1em; }
+.thumbs { display: flex; flex-wrap: wrap; gap: 8px; }
+.thumbs figure { margin: 0; text-align: center; font-size: 0.8em; }
+.thumbs img { max-width: 160px; max-height: 120px; }
+</style>
+</head>
+<body>
+<h1>{{.Year}} in Photos</h1>
+<p>{{.Total}} photos</p>
+
+<h2>By Month</h2>
+<table>
+{{range .MonthRows}}<tr><td>{{.Label}}</td><td>{{.Count}}</td><td style="width: 400px"><div class="bar" style="width: {{$.Percent .Count}}%"></div></td></tr>
+{{end}}</table>
+
+{{if .Cameras}}<h2>Cameras</h2>
+<table>
+{{range .Cameras}}<tr><td>{{.Label}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Locations}}<h2>Top Locations</h2>
+<table>
+{{range .Locations}}<tr><td>{{.Label}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Thumbnails}}<h2>Highlights</h2>
+<div class="thumbs">
+{{range .Thumbnails}}<figure><img src="{{.DataURI}}" alt="{{.Name}}"><figcaption>{{$.MonthName .Month}}</figcaption></figure>
+{{end}}</div>
+{{end}}
+<p><small>Generated by GoPicSort on {{.Generated.Format "2006-01-02"}}</small></p>
+</body>
+</html>
+`))
+
+// renderYearbookHTML renders the yearbook as a self-contained HTML page
+func renderYearbookHTML(book *yearbook) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := yearbookTemplate.Execute(&buf, book); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderYearbookPDF renders the yearbook as a simple PDF document
+func renderYearbookPDF(book *yearbook) []byte {
+	lines := []string{fmt.Sprintf("%d in Photos", book.Year), "", fmt.Sprintf("%d photos", book.Total), "", "By Month"}
+	for _, row := range book.MonthRows() {
+		lines = append(lines, fmt.Sprintf("  %-10s %6d", row.Label, row.Count))
+	}
+	if len(book.Cameras) > 0 {
+		lines = append(lines, "", "Cameras")
+		for _, entry := range book.Cameras {
+			lines = append(lines, fmt.Sprintf("  %6d  %s", entry.Count, entry.Label))
+		}
+	}
+	if len(book.Locations) > 0 {
+		lines = append(lines, "", "Top Locations")
+		for _, entry := range book.Locations {
+			lines = append(lines, fmt.Sprintf("  %6d  %s", entry.Count, entry.Label))
+		}
+	}
+
+	doc := newPDFDocument()
+	for start := 0; start < len(lines); start += pdfLinesPerPage {
+		end := start + pdfLinesPerPage
+		if end > len(lines) {
+			end = len(lines)
+		}
+		doc.addTextPage(lines[start:end])
+	}
+	for start := 0; start < len(book.Thumbnails); start += pdfThumbsPerPage {
+		end := start + pdfThumbsPerPage
+		if end > len(book.Thumbnails) {
+			end = len(book.Thumbnails)
+		}
+		doc.addImagePage(book.Thumbnails[start:end])
+	}
+	return doc.bytes()
+}
+
+const (
+	pdfPageWidth     = 595 // A4 in points
+	pdfPageHeight    = 842
+	pdfLinesPerPage  = 60
+	pdfThumbsPerPage = 12
+)
+
+// pdfDocument is a minimal PDF writer supporting text lines and JPEG images
+type pdfDocument struct {
+	objects [][]byte
+	pages   []int
+}
+
+func newPDFDocument() *pdfDocument {
+	doc := &pdfDocument{}
+	doc.add(nil) // 1: catalog, written last
+	doc.add(nil) // 2: page tree, written last
+	doc.add([]byte("<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>"))
+	return doc
+}
+
+// add appends an object and returns its object number
+func (d *pdfDocument) add(body []byte) int {
+	d.objects = append(d.objects, body)
+	return len(d.objects)
+}
+
+// addStream appends a stream object with the given dictionary entries
+func (d *pdfDocument) addStream(dict string, data []byte) int {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<< %s /Length %d >>\nstream\n", dict, len(data))
+	buf.Write(data)
+	buf.WriteString("\nendstream")
+	return d.add(buf.Bytes())
+}
+
+// addPage appends a page with the given content stream and resources
+func (d *pdfDocument) addPage(content []byte, resources string) {
+	contentObj := d.addStream("", content)
+	page := fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Contents %d 0 R /Resources << /Font << /F1 3 0 R >> %s >> >>",
+		pdfPageWidth, pdfPageHeight, contentObj, resources)
+	d.pages = append(d.pages, d.add([]byte(page)))
+}
+
+// addTextPage appends a page of monospaced text lines
+func (d *pdfDocument) addTextPage(lines []string) {
+	var content bytes.Buffer
+	content.WriteString("BT /F1 11 Tf 13 TL 50 800 Td\n")
+	for _, line := range lines {
+		fmt.Fprintf(&content, "(%s) '\n", pdfEscape(line))
+	}
+	content.WriteString("ET")
+	d.addPage(content.Bytes(), "")
+}
+
+// addImagePage appends a page with a grid of thumbnails and captions
+func (d *pdfDocument) addImagePage(thumbs []yearbookThumb) {
+	const cols, cellWidth, cellHeight = 3, 160, 150
+	var content, xobjects bytes.Buffer
+	for i, thumb := range thumbs {
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(thumb.JPEG))
+		if err != nil || cfg.Width == 0 || cfg.Height == 0 {
+			continue
+		}
+		colorSpace := "/DeviceRGB"
+		switch cfg.ColorModel {
+		case color.GrayModel:
+			colorSpace = "/DeviceGray"
+		case color.CMYKModel:
+			colorSpace = "/DeviceCMYK /Decode [1 0 1 0 1 0 1 0]"
+		}
+		imageObj := d.addStream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode",
+			cfg.Width, cfg.Height, colorSpace), thumb.JPEG)
+		fmt.Fprintf(&xobjects, "/Im%d %d 0 R ", i, imageObj)
+
+		// Scale to fit the cell while keeping the aspect ratio
+		scale := float64(cellWidth-10) / float64(cfg.Width)
+		if s := float64(cellHeight-30) / float64(cfg.Height); s < scale {
+			scale = s
+		}
+		w, h := float64(cfg.Width)*scale, float64(cfg.Height)*scale
+		x := 50 + float64((i%cols)*(cellWidth+10))
+		y := float64(pdfPageHeight-60-(i/cols+1)*(cellHeight+20)) + 20
+		fmt.Fprintf(&content, "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n", w, h, x, y, i)
+		fmt.Fprintf(&content, "BT /F1 9 Tf %.2f %.2f Td (%s) Tj ET\n", x, y-12, pdfEscape(time.Month(thumb.Month).String()))
+	}
+	d.addPage(content.Bytes(), "/XObject << "+xobjects.String()+">>")
+}
+
+// bytes serializes the document with its cross-reference table
+func (d *pdfDocument) bytes() []byte {
+	kids := make([]string, len(d.pages))
+	for i, page := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", page)
+	}
+	d.objects[0] = []byte("<< /Type /Catalog /Pages 2 0 R >>")
+	d.objects[1] = []byte(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(d.objects))
+	for i, obj := range d.objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n", i+1)
+		buf.Write(obj)
+		buf.WriteString("\nendobj\n")
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(d.objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(d.objects)+1, xref)
+	return buf.Bytes()
+}
+
+// pdfEscape escapes a string for use in a PDF literal, replacing non-ASCII characters
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r < 32 || r > 126:
+			b.WriteRune('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
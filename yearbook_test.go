@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestYearbookFollowsLibraryLayout(t *testing.T) {
+	lib := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(lib, stateDirName), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeJSONFile(libraryConfigPath(lib), libraryConfig{Layout: "2006/2006-01-02"}); err != nil {
+		t.Fatal(err)
+	}
+	may := time.Date(2021, time.May, 6, 7, 8, 9, 0, time.Local)
+	// Dated by EXIF, whatever the folder says
+	writeTestFile(t, filepath.Join(lib, "2021", "2021-05-06", "a.jpg"), testJPEG(may, "a"))
+	writeTestFile(t, filepath.Join(lib, "2021", "2021-05-06 Beach", "b.jpg"), testJPEG(may, "b"))
+	writeTestFile(t, filepath.Join(lib, "2021", "misfiled", "c.jpg"), testJPEG(may, "c"))
+	// Dated by the layout folder
+	writeTestFile(t, filepath.Join(lib, "2021", "2021-11-30", "d.jpg"), []byte("no exif"))
+	// Other years and GoPicSort's folders do not count
+	writeTestFile(t, filepath.Join(lib, "2020", "2020-05-06", "e.jpg"), []byte("no exif"))
+	writeTestFile(t, filepath.Join(lib, duplicatesDirName, "2021", "2021-05-06", "a.jpg"), testJPEG(may, "a"))
+	writeTestFile(t, filepath.Join(lib, unsortedDirName, "f.jpg"), []byte("no exif"))
+
+	book, err := buildYearbook(lib, 2021, 10, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Total != 4 {
+		t.Errorf("yearbook has %d photos, want 4", book.Total)
+	}
+	if book.Months[time.May-1] != 3 || book.Months[time.November-1] != 1 {
+		t.Errorf("months are %v, want 3 in May and 1 in November", book.Months)
+	}
+}
+
+func TestYearbookWithoutMonthFolders(t *testing.T) {
+	lib := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(lib, stateDirName), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeJSONFile(libraryConfigPath(lib), libraryConfig{Layout: "2006"}); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(lib, "2021", "a.jpg"), []byte("no exif"))
+	book, err := buildYearbook(lib, 2021, 10, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Total != 1 || book.Months != [12]int{} {
+		t.Errorf("yearbook has %d photos in months %v, want 1 in none", book.Total, book.Months)
+	}
+	if _, err := buildYearbook(lib, 2019, 10, 12); err == nil {
+		t.Error("built a yearbook for a year without photos")
+	}
+}